@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"strings"
 
 	"github.com/gochan-org/gochan/cmd/gochan-migration/internal/common"
 	"github.com/gochan-org/gochan/pkg/config"
@@ -11,11 +12,175 @@ import (
 	"github.com/gochan-org/gochan/pkg/gcutil"
 )
 
+// Database versions, each adding to the schema of the version before it. Every step of MigrateDB checks whether
+// its change has already been made, so a database from any earlier version is brought up to date in one run
+//
+//	2: wordfilters.board_dirs
+//	3: threads.is_archived and threads.archived_at
+//	4: full-text post search index
+//	5: ip_ban.range_start and ip_ban.range_end, wider ip_ban.ip
+//	6: post_references
+//	7: staff_roles and staff_role_capabilities, staff.role_id
+//	8: staff TOTP columns, staff_recovery_codes, staff_roles.require_totp
+//	9: login_attempts, session IP, user agent, and CSRF token columns
+//	10: staff_actions
+//	11: report_categories, reports.category_id
+//	12: warnings
+//	13: ban_presets
+//	14: staff.all_boards
+//	15: posts.ip_key
+//	16: posts.last_modified
 const (
 	// if the database version is less than this, it is assumed to be out of date, and the schema needs to be adjusted
-	latestDatabaseVersion = 2
+	latestDatabaseVersion = 16
 )
 
+// dbColumn is a column added to an existing table after the initial version 1 schema
+type dbColumn struct {
+	table      string // table name without the prefix
+	column     string
+	definition string
+	// SQLite doesn't allow non-constant defaults (like CURRENT_TIMESTAMP) when adding a column. If set,
+	// this is used instead of definition
+	sqliteDefinition string
+}
+
+var (
+	// newTables are created if they don't already exist. They can use the {serial pk} and {fk to serial}
+	// macros from initdb_master.sql
+	newTables = []string{
+		`CREATE TABLE IF NOT EXISTS DBPREFIXpost_references(
+			post_id {fk to serial} NOT NULL,
+			referenced_post_id {fk to serial} NOT NULL,
+			CONSTRAINT post_references_post_id_fk FOREIGN KEY(post_id) REFERENCES DBPREFIXposts(id) ON DELETE CASCADE,
+			CONSTRAINT post_references_referenced_post_id_fk FOREIGN KEY(referenced_post_id) REFERENCES DBPREFIXposts(id) ON DELETE CASCADE,
+			CONSTRAINT post_references_unique UNIQUE(post_id, referenced_post_id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS DBPREFIXstaff_roles(
+			id {serial pk},
+			name VARCHAR(45) NOT NULL,
+			CONSTRAINT staff_roles_name_unique UNIQUE(name)
+		)`,
+		`CREATE TABLE IF NOT EXISTS DBPREFIXstaff_role_capabilities(
+			role_id {fk to serial} NOT NULL,
+			capability VARCHAR(45) NOT NULL,
+			CONSTRAINT staff_role_capabilities_role_id_fk FOREIGN KEY(role_id) REFERENCES DBPREFIXstaff_roles(id) ON DELETE CASCADE,
+			CONSTRAINT staff_role_capabilities_unique UNIQUE(role_id, capability)
+		)`,
+		`CREATE TABLE IF NOT EXISTS DBPREFIXstaff_recovery_codes(
+			id {serial pk},
+			staff_id {fk to serial} NOT NULL,
+			code_checksum VARCHAR(120) NOT NULL,
+			CONSTRAINT staff_recovery_codes_staff_id_fk FOREIGN KEY(staff_id) REFERENCES DBPREFIXstaff(id) ON DELETE CASCADE
+		)`,
+		`CREATE TABLE IF NOT EXISTS DBPREFIXlogin_attempts(
+			id {serial pk},
+			ip VARCHAR(45) NOT NULL,
+			username VARCHAR(45) NOT NULL,
+			attempted_on TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS DBPREFIXstaff_actions(
+			id {serial pk},
+			staff_id {fk to serial},
+			staff_username VARCHAR(45) NOT NULL,
+			action VARCHAR(45) NOT NULL,
+			target_type VARCHAR(20) NOT NULL,
+			target_id BIGINT NOT NULL DEFAULT 0,
+			board_id {fk to serial},
+			before_details TEXT NOT NULL,
+			after_details TEXT NOT NULL,
+			performed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			CONSTRAINT staff_actions_staff_id_fk FOREIGN KEY(staff_id) REFERENCES DBPREFIXstaff(id) ON DELETE SET NULL,
+			CONSTRAINT staff_actions_board_id_fk FOREIGN KEY(board_id) REFERENCES DBPREFIXboards(id) ON DELETE SET NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS DBPREFIXreport_categories(
+			id {serial pk},
+			board_id {fk to serial},
+			name VARCHAR(45) NOT NULL,
+			description VARCHAR(255) NOT NULL DEFAULT '',
+			position SMALLINT NOT NULL DEFAULT 0,
+			CONSTRAINT report_categories_board_id_fk FOREIGN KEY(board_id) REFERENCES DBPREFIXboards(id) ON DELETE CASCADE
+		)`,
+		`CREATE TABLE IF NOT EXISTS DBPREFIXwarnings(
+			id {serial pk},
+			staff_id {fk to serial} NOT NULL,
+			board_id {fk to serial},
+			post_id {fk to serial},
+			copy_post_text TEXT NOT NULL,
+			ip VARCHAR(45) NOT NULL,
+			message TEXT NOT NULL,
+			staff_note VARCHAR(255) NOT NULL,
+			issued_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			is_acknowledged BOOL NOT NULL DEFAULT FALSE,
+			acknowledged_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			CONSTRAINT warnings_staff_id_fk FOREIGN KEY(staff_id) REFERENCES DBPREFIXstaff(id),
+			CONSTRAINT warnings_board_id_fk FOREIGN KEY(board_id) REFERENCES DBPREFIXboards(id) ON DELETE CASCADE,
+			CONSTRAINT warnings_post_id_fk FOREIGN KEY(post_id) REFERENCES DBPREFIXposts(id) ON DELETE SET NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS DBPREFIXban_presets(
+			id {serial pk},
+			name VARCHAR(45) NOT NULL,
+			duration VARCHAR(45) NOT NULL DEFAULT '',
+			permanent BOOL NOT NULL DEFAULT FALSE,
+			message TEXT NOT NULL,
+			is_thread_ban BOOL NOT NULL DEFAULT FALSE,
+			can_appeal BOOL NOT NULL DEFAULT TRUE,
+			appeal_wait VARCHAR(45) NOT NULL DEFAULT '',
+			is_global BOOL NOT NULL DEFAULT FALSE,
+			escalate_to_id {fk to serial},
+			CONSTRAINT ban_presets_name_unique UNIQUE(name),
+			CONSTRAINT ban_presets_escalate_to_id_fk FOREIGN KEY(escalate_to_id) REFERENCES DBPREFIXban_presets(id) ON DELETE SET NULL
+		)`,
+	}
+
+	// serialPKMacros are the driver-specific replacements for {serial pk}, matching build_initdb.py
+	serialPKMacros = map[string]string{
+		"mysql":    "BIGINT NOT NULL AUTO_INCREMENT UNIQUE PRIMARY KEY",
+		"postgres": "BIGSERIAL PRIMARY KEY",
+		"sqlite3":  "INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL",
+	}
+
+	// newColumns are added to the table if they don't already exist
+	newColumns = []dbColumn{
+		{table: "threads", column: "archived_at", definition: "TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP",
+			sqliteDefinition: "TIMESTAMP NOT NULL DEFAULT '1970-01-01 00:00:00'"},
+		{table: "threads", column: "is_archived", definition: "BOOL NOT NULL DEFAULT FALSE"},
+		{table: "ip_ban", column: "range_start", definition: "VARCHAR(32) NOT NULL DEFAULT ''"},
+		{table: "ip_ban", column: "range_end", definition: "VARCHAR(32) NOT NULL DEFAULT ''"},
+		{table: "staff", column: "role_id", definition: "BIGINT"},
+		{table: "staff", column: "totp_secret", definition: "VARCHAR(64) NOT NULL DEFAULT ''"},
+		{table: "staff", column: "totp_enabled", definition: "BOOL NOT NULL DEFAULT FALSE"},
+		{table: "staff", column: "totp_last_step", definition: "BIGINT NOT NULL DEFAULT 0"},
+		{table: "staff_roles", column: "require_totp", definition: "BOOL NOT NULL DEFAULT FALSE"},
+		{table: "sessions", column: "created_on", definition: "TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP",
+			sqliteDefinition: "TIMESTAMP NOT NULL DEFAULT '1970-01-01 00:00:00'"},
+		{table: "sessions", column: "ip", definition: "VARCHAR(45) NOT NULL DEFAULT ''"},
+		{table: "sessions", column: "user_agent", definition: "VARCHAR(255) NOT NULL DEFAULT ''"},
+		{table: "sessions", column: "csrf_token", definition: "VARCHAR(64) NOT NULL DEFAULT ''"},
+		{table: "reports", column: "category_id", definition: "BIGINT"},
+		{table: "posts", column: "ip_key", definition: "VARCHAR(32) NOT NULL DEFAULT ''"},
+		{table: "posts", column: "last_modified", definition: "TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP"},
+	}
+
+	// newIndexes are created if they don't already exist
+	newIndexes = []dbIndex{
+		{table: "ip_ban", name: "ip_ban_range_index", columns: "range_start, range_end"},
+		{table: "post_references", name: "post_references_referenced_index", columns: "referenced_post_id"},
+		{table: "login_attempts", name: "login_attempts_ip_index", columns: "ip"},
+		{table: "login_attempts", name: "login_attempts_username_index", columns: "username"},
+		{table: "staff_actions", name: "staff_actions_board_id_index", columns: "board_id"},
+		{table: "warnings", name: "warnings_ip_index", columns: "ip"},
+		{table: "posts", name: "posts_ip_key_index", columns: "ip_key"},
+	}
+)
+
+// dbIndex is an index added to an existing table after the initial version 1 schema
+type dbIndex struct {
+	table   string // table name without the prefix
+	name    string
+	columns string
+}
+
 type GCDatabaseUpdater struct {
 	options *common.MigrationOptions
 	db      *gcsql.GCDB
@@ -148,6 +313,31 @@ func (dbu *GCDatabaseUpdater) MigrateDB() (bool, error) {
 		}
 	}
 
+	if err = dbu.addNewTables(tx); err != nil {
+		return false, err
+	}
+	if err = dbu.addNewColumns(tx); err != nil {
+		return false, err
+	}
+	if err = dbu.addNewIndexes(tx); err != nil {
+		return false, err
+	}
+	if err = dbu.addSearchIndex(tx); err != nil {
+		return false, err
+	}
+	if err = dbu.updateIPBanRanges(tx); err != nil {
+		return false, err
+	}
+	if err = dbu.updatePostIPKeys(tx); err != nil {
+		return false, err
+	}
+	if err = dbu.addStaffRoles(tx); err != nil {
+		return false, err
+	}
+	if err = dbu.addStaffAllBoards(tx); err != nil {
+		return false, err
+	}
+
 	query = `UPDATE DBPREFIXdatabase_version SET version = ? WHERE component = 'gochan'`
 	_, err = dbu.db.ExecTxSQL(tx, query, latestDatabaseVersion)
 	if err != nil {
@@ -156,6 +346,274 @@ func (dbu *GCDatabaseUpdater) MigrateDB() (bool, error) {
 	return false, tx.Commit()
 }
 
+func (dbu *GCDatabaseUpdater) columnExists(tx *sql.Tx, table string, column string) (bool, error) {
+	var query string
+	criticalConfig := config.GetSystemCriticalConfig()
+	switch criticalConfig.DBtype {
+	case "mysql":
+		query = `SELECT COUNT(*) FROM information_schema.COLUMNS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?`
+	case "postgres":
+		query = `SELECT COUNT(*) FROM information_schema.columns WHERE table_name = ? AND column_name = ?`
+	case "sqlite3":
+		query = `SELECT COUNT(*) FROM PRAGMA_TABLE_INFO(?) WHERE name = ?`
+	default:
+		return false, gcsql.ErrUnsupportedDB
+	}
+	var numColumns int
+	err := dbu.db.QueryRowTxSQL(tx, query, []any{criticalConfig.DBprefix + table, column}, []any{&numColumns})
+	return numColumns > 0, err
+}
+
+// addNewTables creates the tables in newTables if they don't already exist
+func (dbu *GCDatabaseUpdater) addNewTables(tx *sql.Tx) error {
+	dbType := config.GetSystemCriticalConfig().DBtype
+	macros := strings.NewReplacer(
+		"{serial pk}", serialPKMacros[dbType],
+		"{fk to serial}", "BIGINT",
+	)
+	for _, query := range newTables {
+		if _, err := dbu.db.ExecTxSQL(tx, macros.Replace(query)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addNewColumns adds any columns in newColumns that aren't already in the database
+func (dbu *GCDatabaseUpdater) addNewColumns(tx *sql.Tx) error {
+	dbType := config.GetSystemCriticalConfig().DBtype
+	for _, col := range newColumns {
+		exists, err := dbu.columnExists(tx, col.table, col.column)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		definition := col.definition
+		if dbType == "sqlite3" && col.sqliteDefinition != "" {
+			definition = col.sqliteDefinition
+		}
+		query := `ALTER TABLE DBPREFIX` + col.table + ` ADD COLUMN ` + col.column + ` ` + definition
+		if _, err = dbu.db.ExecTxSQL(tx, query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (dbu *GCDatabaseUpdater) indexExists(tx *sql.Tx, table string, index string) (bool, error) {
+	var query string
+	criticalConfig := config.GetSystemCriticalConfig()
+	switch criticalConfig.DBtype {
+	case "mysql":
+		query = `SELECT COUNT(*) FROM information_schema.STATISTICS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND INDEX_NAME = ?`
+	case "postgres":
+		query = `SELECT COUNT(*) FROM pg_indexes WHERE tablename = ? AND indexname = ?`
+	case "sqlite3":
+		query = `SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND tbl_name = ? AND name = ?`
+	default:
+		return false, gcsql.ErrUnsupportedDB
+	}
+	var numIndexes int
+	err := dbu.db.QueryRowTxSQL(tx, query, []any{criticalConfig.DBprefix + table, index}, []any{&numIndexes})
+	return numIndexes > 0, err
+}
+
+// addNewIndexes creates any indexes in newIndexes that aren't already in the database
+func (dbu *GCDatabaseUpdater) addNewIndexes(tx *sql.Tx) error {
+	for _, index := range newIndexes {
+		exists, err := dbu.indexExists(tx, index.table, index.name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		query := `CREATE INDEX ` + index.name + ` ON DBPREFIX` + index.table + `(` + index.columns + `)`
+		if _, err = dbu.db.ExecTxSQL(tx, query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addSearchIndex creates the full-text index used for searching posts if it doesn't already exist. The SQLite
+// FTS5 table is created by gochan at startup if FTS5 is available
+func (dbu *GCDatabaseUpdater) addSearchIndex(tx *sql.Tx) error {
+	var query string
+	switch config.GetSystemCriticalConfig().DBtype {
+	case "mysql":
+		query = `CREATE FULLTEXT INDEX posts_search_index ON DBPREFIXposts(subject, message_raw, name, tripcode)`
+	case "postgres":
+		query = `CREATE INDEX posts_search_index ON DBPREFIXposts
+		USING GIN(to_tsvector('simple', subject || ' ' || message_raw || ' ' || name || ' ' || tripcode))`
+	default:
+		return nil
+	}
+	exists, err := dbu.indexExists(tx, "posts", "posts_search_index")
+	if err != nil || exists {
+		return err
+	}
+	_, err = dbu.db.ExecTxSQL(tx, query)
+	return err
+}
+
+// updateIPBanRanges widens the ip column of the ip_ban table so that it can hold IPv6 ranges, and sets the
+// range_start and range_end columns of bans created before range bans were supported
+func (dbu *GCDatabaseUpdater) updateIPBanRanges(tx *sql.Tx) error {
+	var query string
+	switch config.GetSystemCriticalConfig().DBtype {
+	case "mysql":
+		query = `ALTER TABLE DBPREFIXip_ban MODIFY ip VARCHAR(90) NOT NULL`
+	case "postgres":
+		query = `ALTER TABLE DBPREFIXip_ban ALTER COLUMN ip TYPE VARCHAR(90)`
+	}
+	if query != "" {
+		// SQLite doesn't enforce VARCHAR lengths
+		if _, err := dbu.db.ExecTxSQL(tx, query); err != nil {
+			return err
+		}
+	}
+
+	stmt, err := dbu.db.PrepareSQL(`SELECT id, ip FROM DBPREFIXip_ban WHERE range_start = ''`, tx)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	bans := map[int]string{}
+	for rows.Next() {
+		var id int
+		var ip string
+		if err = rows.Scan(&id, &ip); err != nil {
+			return err
+		}
+		bans[id] = ip
+	}
+	if err = rows.Close(); err != nil {
+		return err
+	}
+	for id, ip := range bans {
+		rangeStart, rangeEnd, err := gcsql.IPRangeKeys(ip)
+		if err != nil {
+			gcutil.LogWarning().Err(err).
+				Int("banID", id).
+				Str("ip", ip).
+				Msg("Unable to parse banned IP, ban will not be enforced")
+			continue
+		}
+		if _, err = dbu.db.ExecTxSQL(tx, `UPDATE DBPREFIXip_ban SET range_start = ?, range_end = ? WHERE id = ?`,
+			rangeStart, rangeEnd, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updatePostIPKeys sets the ip_key column of posts made before it was added, so that they can be selected
+// by IP range
+func (dbu *GCDatabaseUpdater) updatePostIPKeys(tx *sql.Tx) error {
+	stmt, err := dbu.db.PrepareSQL(`SELECT DISTINCT ip FROM DBPREFIXposts WHERE ip_key = ''`, tx)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var ips []string
+	for rows.Next() {
+		var ip string
+		if err = rows.Scan(&ip); err != nil {
+			return err
+		}
+		ips = append(ips, ip)
+	}
+	if err = rows.Close(); err != nil {
+		return err
+	}
+	for _, ip := range ips {
+		ipKey, err := gcsql.PostIPKey(ip)
+		if err != nil {
+			gcutil.LogWarning().Err(err).
+				Str("ip", ip).
+				Msg("Unable to parse post IP, its posts will not be found by IP range")
+			continue
+		}
+		if _, err = dbu.db.ExecTxSQL(tx, `UPDATE DBPREFIXposts SET ip_key = ? WHERE ip = ?`, ipKey, ip); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addStaffRoles creates the default staff roles if there aren't any yet, and gives staff accounts from
+// versions that used ranks the role matching their rank
+func (dbu *GCDatabaseUpdater) addStaffRoles(tx *sql.Tx) error {
+	var numRoles int
+	if err := dbu.db.QueryRowTxSQL(tx, `SELECT COUNT(*) FROM DBPREFIXstaff_roles`, nil, []any{&numRoles}); err != nil {
+		return err
+	}
+	if numRoles > 0 {
+		return nil
+	}
+	hasRanks, err := dbu.columnExists(tx, "staff", "global_rank")
+	if err != nil {
+		return err
+	}
+	for r, role := range gcsql.DefaultRoles {
+		if _, err = dbu.db.ExecTxSQL(tx, `INSERT INTO DBPREFIXstaff_roles(name) VALUES(?)`, role.Name); err != nil {
+			return err
+		}
+		var roleID int
+		if err = dbu.db.QueryRowTxSQL(tx, `SELECT id FROM DBPREFIXstaff_roles WHERE name = ?`,
+			[]any{role.Name}, []any{&roleID}); err != nil {
+			return err
+		}
+		for _, capability := range gcsql.DefaultRoleCapabilities(r) {
+			if _, err = dbu.db.ExecTxSQL(tx,
+				`INSERT INTO DBPREFIXstaff_role_capabilities(role_id, capability) VALUES(?,?)`,
+				roleID, capability); err != nil {
+				return err
+			}
+		}
+		if !hasRanks {
+			continue
+		}
+		if _, err = dbu.db.ExecTxSQL(tx,
+			`UPDATE DBPREFIXstaff SET role_id = ? WHERE role_id IS NULL AND global_rank = ?`,
+			roleID, r+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addStaffAllBoards adds the all_boards column to the staff table if it doesn't exist. Staff who weren't
+// assigned to any boards could moderate all of them before the column was added, so they keep that access
+func (dbu *GCDatabaseUpdater) addStaffAllBoards(tx *sql.Tx) error {
+	exists, err := dbu.columnExists(tx, "staff", "all_boards")
+	if err != nil || exists {
+		return err
+	}
+	if _, err = dbu.db.ExecTxSQL(tx,
+		`ALTER TABLE DBPREFIXstaff ADD COLUMN all_boards BOOL NOT NULL DEFAULT FALSE`); err != nil {
+		return err
+	}
+	_, err = dbu.db.ExecTxSQL(tx, `UPDATE DBPREFIXstaff SET all_boards = TRUE
+		WHERE id NOT IN (SELECT staff_id FROM DBPREFIXboard_staff)`)
+	return err
+}
+
 func (dbu *GCDatabaseUpdater) MigrateBoards() error {
 	return gcutil.ErrNotImplemented
 }
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/uptrace/bunrouter"
+
+	"github.com/gochan-org/gochan/pkg/building"
+	"github.com/gochan-org/gochan/pkg/config"
+	"github.com/gochan-org/gochan/pkg/gcsql"
+	"github.com/gochan-org/gochan/pkg/gcutil"
+	"github.com/gochan-org/gochan/pkg/server"
+	"github.com/gochan-org/gochan/pkg/server/serverutil"
+)
+
+const (
+	defaultAPIRecentPosts = 20
+	maxAPIRecentPosts     = 100
+)
+
+// registerAPIRoutes sets up the read-only JSON API. Responses use the same format as the static JSON
+// files created when boards and threads are built, but they are read directly from the database
+func registerAPIRoutes(router *bunrouter.Router) {
+	router.GET(config.WebPath("/api/v1/boards"), bunrouter.HTTPHandlerFunc(apiBoards))
+	router.GET(config.WebPath("/api/v1/recent"), bunrouter.HTTPHandlerFunc(apiRecentPosts))
+	router.GET(config.WebPath("/api/v1/board/:board/catalog"), bunrouter.HTTPHandlerFunc(apiCatalog))
+	router.GET(config.WebPath("/api/v1/board/:board/page/:page"), bunrouter.HTTPHandlerFunc(apiBoardPage))
+	router.GET(config.WebPath("/api/v1/board/:board/thread/:thread"), bunrouter.HTTPHandlerFunc(apiThread))
+	router.GET(config.WebPath("/api/v1/board/:board/post/:post"), bunrouter.HTTPHandlerFunc(apiPost))
+}
+
+func serveAPIJSON(writer http.ResponseWriter, request *http.Request, data interface{}) {
+	writer.Header().Set("Content-Type", "application/json")
+	ba, err := json.Marshal(data)
+	if err != nil {
+		gcutil.LogError(err).Caller().
+			Str("path", request.URL.Path).Send()
+		serveAPIError(writer, http.StatusInternalServerError, "Unable to marshal JSON response", nil)
+		return
+	}
+	serverutil.MinifyWriter(writer, ba, "application/json")
+}
+
+func serveAPIError(writer http.ResponseWriter, status int, errStr string, data map[string]interface{}) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	server.ServeError(writer, errStr, true, data)
+}
+
+// serveAPICachedJSON serves the data as JSON with ETag and Last-Modified headers, or a 304 status if the
+// client's cached copy is still valid. The ETag is a hash of the response, so that any change (including edits
+// and deletions) changes it, and lastModified is the most recent time a post included in the response was made,
+// edited, or deleted
+func serveAPICachedJSON(writer http.ResponseWriter, request *http.Request, lastModified time.Time, data interface{}) {
+	ba, err := json.Marshal(data)
+	if err != nil {
+		gcutil.LogError(err).Caller().
+			Str("path", request.URL.Path).Send()
+		serveAPIError(writer, http.StatusInternalServerError, "Unable to marshal JSON response", nil)
+		return
+	}
+	lastModified = lastModified.UTC().Truncate(time.Second)
+	hash := fnv.New64a()
+	hash.Write(ba)
+	etag := fmt.Sprintf(`"%x-%x"`, lastModified.Unix(), hash.Sum64())
+	writer.Header().Set("ETag", etag)
+	writer.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+	writer.Header().Set("Cache-Control", "no-cache")
+
+	notModified := false
+	if match := request.Header.Get("If-None-Match"); match != "" {
+		notModified = match == etag || match == "*"
+	} else if since, err := http.ParseTime(request.Header.Get("If-Modified-Since")); err == nil {
+		notModified = !lastModified.After(since)
+	}
+	if notModified {
+		writer.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	serverutil.MinifyWriter(writer, ba, "application/json")
+}
+
+// apiGetBoard gets the board from the request's :board parameter, serving an error if it doesn't exist
+func apiGetBoard(writer http.ResponseWriter, request *http.Request) *gcsql.Board {
+	boardDir := bunrouter.ParamsFromContext(request.Context()).ByName("board")
+	board, err := gcsql.GetBoardFromDir(boardDir)
+	if errors.Is(err, sql.ErrNoRows) {
+		serveAPIError(writer, http.StatusNotFound, "Board does not exist", map[string]interface{}{
+			"board": boardDir,
+		})
+		return nil
+	} else if err != nil {
+		gcutil.LogError(err).Caller().
+			Str("board", boardDir).Send()
+		serveAPIError(writer, http.StatusInternalServerError, "Unable to get board info", map[string]interface{}{
+			"board": boardDir,
+		})
+		return nil
+	}
+	return board
+}
+
+// handles requests to /api/v1/boards
+func apiBoards(writer http.ResponseWriter, request *http.Request) {
+	serveAPIJSON(writer, request, building.GetBoardListJSON())
+}
+
+// handles requests to /api/v1/recent, optionally filtered by the board and limit query parameters
+func apiRecentPosts(writer http.ResponseWriter, request *http.Request) {
+	var boardID int
+	var err error
+	if boardDir := request.FormValue("board"); boardDir != "" {
+		if boardID, err = gcsql.GetBoardIDFromDir(boardDir); err != nil {
+			serveAPIError(writer, http.StatusNotFound, "Board does not exist", map[string]interface{}{
+				"board": boardDir,
+			})
+			return
+		}
+	}
+	limit, err := strconv.Atoi(request.FormValue("limit"))
+	if err != nil || limit < 1 {
+		limit = defaultAPIRecentPosts
+	} else if limit > maxAPIRecentPosts {
+		limit = maxAPIRecentPosts
+	}
+	posts, err := building.GetRecentPosts(boardID, limit)
+	if err != nil {
+		gcutil.LogError(err).Caller().
+			Int("boardID", boardID).Send()
+		serveAPIError(writer, http.StatusInternalServerError, "Unable to get recent posts", nil)
+		return
+	}
+	if posts == nil {
+		posts = []building.Post{}
+	}
+	serveAPIJSON(writer, request, map[string]interface{}{
+		"posts": posts,
+	})
+}
+
+// handles requests to /api/v1/board/:board/catalog
+func apiCatalog(writer http.ResponseWriter, request *http.Request) {
+	board := apiGetBoard(writer, request)
+	if board == nil {
+		return
+	}
+	lastModified, err := gcsql.GetBoardLastModified(board.ID)
+	if err != nil {
+		gcutil.LogError(err).Caller().
+			Str("board", board.Dir).Send()
+		serveAPIError(writer, http.StatusInternalServerError, "Unable to get board threads", nil)
+		return
+	}
+	pages, err := building.GetBoardCatalog(board)
+	if err != nil {
+		serveAPIError(writer, http.StatusInternalServerError, "Unable to get board catalog", nil)
+		return
+	}
+	if pages == nil {
+		pages = []building.CatalogPage{}
+	}
+	serveAPICachedJSON(writer, request, lastModified, pages)
+}
+
+// handles requests to /api/v1/board/:board/page/:page
+func apiBoardPage(writer http.ResponseWriter, request *http.Request) {
+	board := apiGetBoard(writer, request)
+	if board == nil {
+		return
+	}
+	pageStr := bunrouter.ParamsFromContext(request.Context()).ByName("page")
+	pageNum, err := strconv.Atoi(pageStr)
+	if err != nil || pageNum < 1 {
+		serveAPIError(writer, http.StatusBadRequest, "Invalid page number", map[string]interface{}{
+			"page": pageStr,
+		})
+		return
+	}
+	lastModified, err := gcsql.GetBoardLastModified(board.ID)
+	if err != nil {
+		gcutil.LogError(err).Caller().
+			Str("board", board.Dir).Send()
+		serveAPIError(writer, http.StatusInternalServerError, "Unable to get board threads", nil)
+		return
+	}
+	pages, err := building.GetBoardCatalog(board)
+	if err != nil {
+		serveAPIError(writer, http.StatusInternalServerError, "Unable to get board pages", nil)
+		return
+	}
+	if pageNum > len(pages) {
+		if pageNum == 1 {
+			// empty board
+			serveAPICachedJSON(writer, request, lastModified,
+				building.CatalogPage{PageNum: 1, Threads: []building.CatalogThread{}})
+			return
+		}
+		serveAPIError(writer, http.StatusNotFound, "Page does not exist", map[string]interface{}{
+			"page": pageNum,
+		})
+		return
+	}
+	serveAPICachedJSON(writer, request, lastModified, pages[pageNum-1])
+}
+
+// handles requests to /api/v1/board/:board/thread/:thread, where :thread is the ID of the top post
+func apiThread(writer http.ResponseWriter, request *http.Request) {
+	board := apiGetBoard(writer, request)
+	if board == nil {
+		return
+	}
+	opStr := bunrouter.ParamsFromContext(request.Context()).ByName("thread")
+	opID, err := strconv.Atoi(opStr)
+	if err != nil {
+		serveAPIError(writer, http.StatusBadRequest, "Invalid thread ID", map[string]interface{}{
+			"thread": opStr,
+		})
+		return
+	}
+	thread, err := gcsql.GetPostThread(opID)
+	if errors.Is(err, gcsql.ErrThreadDoesNotExist) || (err == nil && (thread.BoardID != board.ID || thread.IsDeleted)) {
+		serveAPIError(writer, http.StatusNotFound, "Thread does not exist", map[string]interface{}{
+			"thread": opID,
+		})
+		return
+	} else if err != nil {
+		gcutil.LogError(err).Caller().
+			Int("opID", opID).Send()
+		serveAPIError(writer, http.StatusInternalServerError, "Unable to get thread info", nil)
+		return
+	}
+	posts, err := building.GetThreadPosts(thread)
+	if err != nil {
+		gcutil.LogError(err).Caller().
+			Int("threadID", thread.ID).Send()
+		serveAPIError(writer, http.StatusInternalServerError, "Unable to get thread posts", nil)
+		return
+	}
+	if len(posts) == 0 || posts[0].ID != opID {
+		serveAPIError(writer, http.StatusNotFound, "Thread does not exist", map[string]interface{}{
+			"thread": opID,
+		})
+		return
+	}
+	lastModified, err := gcsql.GetThreadLastModified(thread.ID)
+	if err != nil {
+		gcutil.LogError(err).Caller().
+			Int("threadID", thread.ID).Send()
+		serveAPIError(writer, http.StatusInternalServerError, "Unable to get thread info", nil)
+		return
+	}
+	serveAPICachedJSON(writer, request, lastModified, map[string]interface{}{
+		"posts": posts,
+	})
+}
+
+// handles requests to /api/v1/board/:board/post/:post
+func apiPost(writer http.ResponseWriter, request *http.Request) {
+	board := apiGetBoard(writer, request)
+	if board == nil {
+		return
+	}
+	postStr := bunrouter.ParamsFromContext(request.Context()).ByName("post")
+	postID, err := strconv.Atoi(postStr)
+	if err != nil {
+		serveAPIError(writer, http.StatusBadRequest, "Invalid post ID", map[string]interface{}{
+			"post": postStr,
+		})
+		return
+	}
+	post, err := building.GetBuildablePost(postID, board.ID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && post.BoardDir != board.Dir) {
+		serveAPIError(writer, http.StatusNotFound, "Post does not exist", map[string]interface{}{
+			"post": postID,
+		})
+		return
+	} else if err != nil {
+		gcutil.LogError(err).Caller().
+			Int("postID", postID).Send()
+		serveAPIError(writer, http.StatusInternalServerError, "Unable to get post", nil)
+		return
+	}
+	lastModified, err := gcsql.GetPostLastModified(postID)
+	if err != nil {
+		gcutil.LogError(err).Caller().
+			Int("postID", postID).Send()
+		serveAPIError(writer, http.StatusInternalServerError, "Unable to get post", nil)
+		return
+	}
+	serveAPICachedJSON(writer, request, lastModified, post)
+}
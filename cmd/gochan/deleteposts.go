@@ -12,6 +12,7 @@ import (
 
 	"github.com/gochan-org/gochan/pkg/building"
 	"github.com/gochan-org/gochan/pkg/config"
+	"github.com/gochan-org/gochan/pkg/events"
 	"github.com/gochan-org/gochan/pkg/gcsql"
 	"github.com/gochan-org/gochan/pkg/gcutil"
 	"github.com/gochan-org/gochan/pkg/manage"
@@ -34,7 +35,6 @@ func deletePosts(checkedPosts []int, writer http.ResponseWriter, request *http.R
 	defer errEv.Discard()
 	password := request.FormValue("password")
 	passwordMD5 := gcutil.Md5Sum(password)
-	rank := manage.GetStaffRank(request)
 	fileOnly := request.FormValue("fileonly") == "on"
 	wantsJSON := serverutil.IsRequestingJSON(request)
 	if wantsJSON {
@@ -52,6 +52,7 @@ func deletePosts(checkedPosts []int, writer http.ResponseWriter, request *http.R
 		return
 	}
 	errEv.Int("boardid", boardid)
+	canDelete := manage.StaffCanOnBoard(request, gcsql.CapPostDelete, boardid)
 	board, err := gcsql.GetBoardFromID(boardid)
 	if err != nil {
 		server.ServeError(writer, "Invalid form data: "+err.Error(), wantsJSON, map[string]interface{}{
@@ -62,7 +63,7 @@ func deletePosts(checkedPosts []int, writer http.ResponseWriter, request *http.R
 		return
 	}
 
-	if password == "" && rank == 0 {
+	if password == "" && !canDelete {
 		server.ServeError(writer, "Password required for post deletion", wantsJSON, nil)
 		return
 	}
@@ -86,7 +87,14 @@ func deletePosts(checkedPosts []int, writer http.ResponseWriter, request *http.R
 			return
 		}
 
-		if passwordMD5 != post.Password && rank == 0 {
+		canDeletePost := canDelete
+		if canDeletePost {
+			// staff assigned to specific boards can't get around it by submitting the ID of one of their boards
+			if postBoardID, err := post.GetBoardID(); err != nil || postBoardID != board.ID {
+				canDeletePost = manage.StaffCanOnBoard(request, gcsql.CapPostDelete, postBoardID)
+			}
+		}
+		if passwordMD5 != post.Password && !canDeletePost {
 			server.ServeError(writer, fmt.Sprintf("Incorrect password for #%d", post.ID), wantsJSON, map[string]interface{}{
 				"postid":  post.ID,
 				"boardid": board.ID,
@@ -98,14 +106,6 @@ func deletePosts(checkedPosts []int, writer http.ResponseWriter, request *http.R
 			if deletePostUpload(post, board, writer, request, errEv) {
 				return
 			}
-			if err = building.BuildBoardPages(board); err != nil {
-				errEv.Err(err).Caller().Send()
-				server.ServeError(writer, "Unable to build board pages for /"+board.Dir+"/: "+err.Error(), wantsJSON, map[string]interface{}{
-					"boardDir": board.Dir,
-				})
-				return
-			}
-
 			var opPost *gcsql.Post
 			if post.IsTopPost {
 				opPost = post
@@ -120,15 +120,9 @@ func deletePosts(checkedPosts []int, writer http.ResponseWriter, request *http.R
 					return
 				}
 			}
-			if building.BuildThreadPages(opPost); err != nil {
-				errEv.Err(err).Caller().
-					Int("postid", post.ID).
-					Msg("Unable to build thread pages")
-				server.ServeError(writer, "Unable to get board info from post: "+err.Error(), wantsJSON, map[string]interface{}{
-					"postid": post.ID,
-				})
-				return
-			}
+			building.QueueThreadPages(board, opPost.ID)
+			building.QueueBoardPages(board)
+			building.QueueCatalog(board)
 		} else {
 			if post.IsTopPost {
 				rows, err := gcsql.QuerySQL(
@@ -193,10 +187,37 @@ func deletePosts(checkedPosts []int, writer http.ResponseWriter, request *http.R
 				os.Remove(threadIndexPath + ".html")
 				os.Remove(threadIndexPath + ".json")
 			} else {
-				building.BuildBoardPages(board)
+				topPostID, err := post.TopPostID()
+				if err != nil {
+					errEv.Err(err).Caller().
+						Int("postid", post.ID).
+						Msg("Unable to get top post ID")
+					server.ServeError(writer, "Unable to get thread info from post: "+err.Error(), wantsJSON, map[string]interface{}{
+						"postid": post.ID,
+					})
+					return
+				}
+				building.QueueThreadPages(board, topPostID)
 			}
-			building.BuildBoards(false, boardid)
+			building.QueueBoardPages(board)
+			building.QueueCatalog(board)
+			building.QueueFrontPage()
 		}
+		if canDeletePost {
+			logEntry := gcsql.ModLogEntry{
+				Action:     gcsql.ModLogPostDelete,
+				TargetType: gcsql.ModLogTargetPost,
+				TargetID:   post.ID,
+				BoardID:    &board.ID,
+			}
+			if fileOnly {
+				logEntry.Action = gcsql.ModLogFileDelete
+			} else if post.IsTopPost {
+				logEntry.TargetType = gcsql.ModLogTargetThread
+			}
+			manage.LogRequestStaffAction(request, logEntry, post, nil)
+		}
+		events.TriggerEvent("post-deleted", post, board, fileOnly)
 		gcutil.LogAccess(request).
 			Str("requestType", "deletePost").
 			Int("boardid", boardid).
@@ -250,7 +271,7 @@ func deleteUploads(uploads []upload) {
 
 func deletePostUpload(post *gcsql.Post, board *gcsql.Board, writer http.ResponseWriter, request *http.Request, errEv *zerolog.Event) bool {
 	documentRoot := config.GetSystemCriticalConfig().DocumentRoot
-	upload, err := post.GetUpload()
+	uploads, err := post.GetUploads()
 	wantsJSON := serverutil.IsRequestingJSON(request)
 	if err != nil {
 		errEv.Err(err).Caller().
@@ -260,7 +281,10 @@ func deletePostUpload(post *gcsql.Post, board *gcsql.Board, writer http.Response
 			wantsJSON, map[string]interface{}{"postid": post.ID})
 		return true
 	}
-	if upload != nil && upload.Filename != "deleted" {
+	for _, upload := range uploads {
+		if upload.Filename == "deleted" {
+			continue
+		}
 		filePath := path.Join(documentRoot, board.Dir, "src", upload.Filename)
 		if err = os.Remove(filePath); err != nil && !errors.Is(err, fs.ErrNotExist) {
 			errEv.Err(err).Caller().
@@ -295,6 +319,8 @@ func deletePostUpload(post *gcsql.Post, board *gcsql.Board, writer http.Response
 				return true
 			}
 		}
+	}
+	if len(uploads) > 0 {
 		// remove the upload from the database
 		if err = post.UnlinkUploads(true); err != nil {
 			errEv.Err(err).Caller().
@@ -35,8 +35,8 @@ func editPost(checkedPosts []int, editBtn string, doEdit string, writer http.Res
 			return
 		}
 
-		rank := manage.GetStaffRank(request)
-		if password == "" && rank == 0 {
+		canEdit := manage.StaffCan(request, gcsql.CapPostEdit)
+		if password == "" && !canEdit {
 			server.ServeErrorPage(writer, "Password required for post editing")
 			return
 		}
@@ -49,8 +49,11 @@ func editPost(checkedPosts []int, editBtn string, doEdit string, writer http.Res
 			return
 		}
 		errEv.Int("postID", post.ID)
+		if canEdit {
+			canEdit = staffCanOnPost(request, gcsql.CapPostEdit, post)
+		}
 
-		if post.Password != passwordMD5 && rank == 0 {
+		if post.Password != passwordMD5 && !canEdit {
 			server.ServeErrorPage(writer, "Wrong password")
 			return
 		}
@@ -74,7 +77,7 @@ func editPost(checkedPosts []int, editBtn string, doEdit string, writer http.Res
 			"systemCritical": config.GetSystemCriticalConfig(),
 			"siteConfig":     config.GetSiteConfig(),
 			"board":          board,
-			"boardConfig":    config.GetBoardConfig(""),
+			"boardConfig":    config.GetBoardConfig(board.Dir),
 			"password":       password,
 			"post":           post,
 			"referrer":       request.Referer(),
@@ -121,10 +124,10 @@ func editPost(checkedPosts []int, editBtn string, doEdit string, writer http.Res
 			return
 		}
 
-		rank := manage.GetStaffRank(request)
+		canEdit := staffCanOnPost(request, gcsql.CapPostEdit, post)
 		password := request.PostFormValue("password")
 		passwordMD5 := gcutil.Md5Sum(password)
-		if post.Password != passwordMD5 && rank == 0 {
+		if post.Password != passwordMD5 && !canEdit {
 			server.ServeError(writer, "Wrong password", wantsJSON, nil)
 			return
 		}
@@ -138,65 +141,81 @@ func editPost(checkedPosts []int, editBtn string, doEdit string, writer http.Res
 			return
 		}
 
+		logEntry := gcsql.ModLogEntry{
+			Action:     gcsql.ModLogPostEdit,
+			TargetType: gcsql.ModLogTargetPost,
+			TargetID:   post.ID,
+			BoardID:    &board.ID,
+		}
+		var logBefore, logAfter interface{}
 		if doEdit == "upload" {
-			oldUpload, err := post.GetUpload()
+			oldUploads, err := post.GetUploads()
 			if err != nil {
 				errEv.Err(err).Caller().Send()
 				server.ServeError(writer, err.Error(), wantsJSON, nil)
 				return
 			}
 
-			upload, gotErr := posting.AttachUploadFromRequest(request, writer, post, board)
+			uploads, gotErr := posting.AttachUploadsFromRequest(request, writer, post, board)
 			if gotErr {
-				// AttachUploadFromRequest handles error serving/logging
+				// AttachUploadsFromRequest handles error serving/logging
 				return
 			}
-			if upload == nil {
+			if len(uploads) == 0 {
 				server.ServeError(writer, "Missing upload replacement", wantsJSON, nil)
 				return
 			}
 			documentRoot := config.GetSystemCriticalConfig().DocumentRoot
-			var filePath, thumbPath, catalogThumbPath string
-			if oldUpload != nil {
-				filePath = path.Join(documentRoot, board.Dir, "src", oldUpload.Filename)
-				thumbPath = path.Join(documentRoot, board.Dir, "thumb", oldUpload.ThumbnailPath("thumb"))
-				catalogThumbPath = path.Join(documentRoot, board.Dir, "thumb", oldUpload.ThumbnailPath("catalog"))
+			if len(oldUploads) > 0 {
 				if err = post.UnlinkUploads(false); err != nil {
 					errEv.Err(err).Caller().Send()
 					server.ServeError(writer, "Error unlinking old upload from post: "+err.Error(), wantsJSON, nil)
 					return
 				}
-				if oldUpload.Filename != "deleted" {
-					os.Remove(filePath)
-					os.Remove(thumbPath)
+				for _, oldUpload := range oldUploads {
+					if oldUpload.Filename == "deleted" {
+						continue
+					}
+					os.Remove(path.Join(documentRoot, board.Dir, "src", oldUpload.Filename))
+					os.Remove(path.Join(documentRoot, board.Dir, "thumb", oldUpload.ThumbnailPath("thumb")))
 					if post.IsTopPost {
-						os.Remove(catalogThumbPath)
+						os.Remove(path.Join(documentRoot, board.Dir, "thumb", oldUpload.ThumbnailPath("catalog")))
 					}
 				}
 			}
 
-			if err = post.AttachFile(upload); err != nil {
-				errEv.Err(err).Caller().
-					Str("newFilename", upload.Filename).
-					Str("newOriginalFilename", upload.OriginalFilename).
-					Send()
-				server.ServeError(writer, "Error attaching new upload: "+err.Error(), wantsJSON, map[string]interface{}{
-					"filename": upload.OriginalFilename,
-				})
-				filePath = path.Join(documentRoot, board.Dir, "src", upload.Filename)
-				thumbPath = path.Join(documentRoot, board.Dir, "thumb", upload.ThumbnailPath("thumb"))
-				catalogThumbPath = path.Join(documentRoot, board.Dir, "thumb", upload.ThumbnailPath("catalog"))
-				os.Remove(filePath)
-				os.Remove(thumbPath)
-				if post.IsTopPost {
-					os.Remove(catalogThumbPath)
+			for u, upload := range uploads {
+				if err = post.AttachFile(upload); err != nil {
+					errEv.Err(err).Caller().
+						Str("newFilename", upload.Filename).
+						Str("newOriginalFilename", upload.OriginalFilename).
+						Send()
+					server.ServeError(writer, "Error attaching new upload: "+err.Error(), wantsJSON, map[string]interface{}{
+						"filename": upload.OriginalFilename,
+					})
+					for _, unattached := range uploads[u:] {
+						os.Remove(path.Join(documentRoot, board.Dir, "src", unattached.Filename))
+						os.Remove(path.Join(documentRoot, board.Dir, "thumb", unattached.ThumbnailPath("thumb")))
+						if post.IsTopPost {
+							os.Remove(path.Join(documentRoot, board.Dir, "thumb", unattached.ThumbnailPath("catalog")))
+						}
+					}
+					return
 				}
 			}
+			logBefore = oldUploads
+			logAfter = uploads
 		} else {
+			logBefore = map[string]string{
+				"email":   post.Email,
+				"subject": post.Subject,
+				"message": post.MessageRaw,
+			}
+			message, references := posting.FormatMessage(request.FormValue("editmsg"), board.Dir)
 			if err = post.UpdateContents(
 				request.FormValue("editemail"),
 				request.FormValue("editsubject"),
-				posting.FormatMessage(request.FormValue("editmsg"), board.Dir),
+				message,
 				request.FormValue("editmsg"),
 			); err != nil {
 				errEv.Err(err).Caller().
@@ -207,15 +226,47 @@ func editPost(checkedPosts []int, editBtn string, doEdit string, writer http.Res
 				})
 				return
 			}
+			if err = post.SetReferences(references); err != nil {
+				errEv.Err(err).Caller().
+					Int("postid", post.ID).
+					Msg("Unable to update post references")
+			}
+			posting.QueueReferencedThreads(references, 0)
+			logAfter = map[string]string{
+				"email":   request.FormValue("editemail"),
+				"subject": request.FormValue("editsubject"),
+				"message": request.FormValue("editmsg"),
+			}
 		}
-
-		if err = building.BuildBoards(false, boardid); err != nil {
-			server.ServeErrorPage(writer, "Error rebuilding boards: "+err.Error())
+		if canEdit {
+			manage.LogRequestStaffAction(request, logEntry, logBefore, logAfter)
 		}
-		if err = building.BuildFrontPage(); err != nil {
-			server.ServeErrorPage(writer, "Error rebuilding front page: "+err.Error())
+
+		topPostID := post.ID
+		if !post.IsTopPost {
+			if topPostID, err = post.TopPostID(); err != nil {
+				errEv.Err(err).Caller().
+					Int("postid", post.ID).
+					Msg("Unable to get top post ID")
+				server.ServeErrorPage(writer, "Unable to get thread information: "+err.Error())
+				return
+			}
 		}
+		building.QueueThreadPages(board, topPostID)
+		building.QueueBoardPages(board)
+		building.QueueCatalog(board)
+		building.QueueFrontPage()
 		http.Redirect(writer, request, post.WebPath(), http.StatusFound)
 		return
 	}
 }
+
+// staffCanOnPost returns true if the staff member referenced in the request is allowed to moderate the board
+// that the post is on and their role has the given capability
+func staffCanOnPost(request *http.Request, capability string, post *gcsql.Post) bool {
+	boardID, err := post.GetBoardID()
+	if err != nil {
+		return false
+	}
+	return manage.StaffCanOnBoard(request, capability, boardID)
+}
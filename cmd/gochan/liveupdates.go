@@ -0,0 +1,301 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/uptrace/bunrouter"
+
+	"github.com/gochan-org/gochan/pkg/building"
+	"github.com/gochan-org/gochan/pkg/config"
+	"github.com/gochan-org/gochan/pkg/events"
+	"github.com/gochan-org/gochan/pkg/gcsql"
+	"github.com/gochan-org/gochan/pkg/gcutil"
+)
+
+const (
+	// maxLiveUpdateClients is the maximum number of clients that can be connected to the event streams
+	// at the same time
+	maxLiveUpdateClients = 1024
+	// liveUpdateBufferSize is the number of messages that can be queued for a client before new messages
+	// are dropped
+	liveUpdateBufferSize = 32
+	liveUpdateKeepAlive  = 30 * time.Second
+	// liveUpdateRetry is the number of milliseconds the browser should wait before reconnecting
+	liveUpdateRetry = 5000
+)
+
+var (
+	liveUpdates = &liveUpdateHub{
+		clients: map[*liveUpdateClient]struct{}{},
+	}
+)
+
+type liveUpdateMessage struct {
+	event string
+	data  []byte
+}
+
+// liveUpdateClient is a connection to a board or thread event stream
+type liveUpdateClient struct {
+	boardID int
+	// threadID is 0 if the client is subscribed to the whole board
+	threadID int
+	messages chan liveUpdateMessage
+}
+
+// liveUpdateHub keeps track of the connected clients and sends them the events for their board or thread
+type liveUpdateHub struct {
+	lock    sync.RWMutex
+	clients map[*liveUpdateClient]struct{}
+}
+
+// subscribe adds a new client to the hub, or returns nil if there are too many clients connected
+func (h *liveUpdateHub) subscribe(boardID int, threadID int) *liveUpdateClient {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if len(h.clients) >= maxLiveUpdateClients {
+		return nil
+	}
+	client := &liveUpdateClient{
+		boardID:  boardID,
+		threadID: threadID,
+		messages: make(chan liveUpdateMessage, liveUpdateBufferSize),
+	}
+	h.clients[client] = struct{}{}
+	return client
+}
+
+func (h *liveUpdateHub) unsubscribe(client *liveUpdateClient) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	delete(h.clients, client)
+}
+
+// hasClients returns true if any clients are watching the board (or the given thread in the board),
+// so that the event data doesn't need to be looked up if nobody will receive it
+func (h *liveUpdateHub) hasClients(boardID int, threadID int) bool {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	for client := range h.clients {
+		if client.boardID == boardID && (client.threadID == 0 || client.threadID == threadID) {
+			return true
+		}
+	}
+	return false
+}
+
+// publish sends the event to the clients watching the board or the given thread in the board
+func (h *liveUpdateHub) publish(boardID int, threadID int, event string, data interface{}) {
+	ba, err := json.Marshal(data)
+	if err != nil {
+		gcutil.LogError(err).Caller().
+			Str("event", event).Send()
+		return
+	}
+	msg := liveUpdateMessage{event: event, data: ba}
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	for client := range h.clients {
+		if client.boardID != boardID || (client.threadID > 0 && client.threadID != threadID) {
+			continue
+		}
+		select {
+		case client.messages <- msg:
+		default:
+			// the client isn't keeping up, don't hold up the request that triggered the event
+		}
+	}
+}
+
+// registerLiveUpdateRoutes sets up the Server-Sent Events streams for threads and boards, and registers
+// the event handlers that feed them
+func registerLiveUpdateRoutes(router *bunrouter.Router) {
+	events.RegisterEvent([]string{"post-inserted", "post-deleted", "thread-updated"}, liveUpdateEventHandler)
+	router.GET(config.WebPath("/events/board/:board"), bunrouter.HTTPHandlerFunc(serveBoardEvents))
+	router.GET(config.WebPath("/events/thread/:board/:op"), bunrouter.HTTPHandlerFunc(serveThreadEvents))
+}
+
+// liveUpdateEventHandler converts post-inserted, post-deleted, and thread-updated events to messages sent
+// to the connected clients. Errors are logged instead of returned, since the events are triggered after
+// the changes are made
+func liveUpdateEventHandler(trigger string, data ...interface{}) error {
+	errEv := gcutil.LogError(nil).
+		Str("event", trigger)
+	defer errEv.Discard()
+	if len(data) < 2 {
+		errEv.Caller().Msg("Not enough event data")
+		return nil
+	}
+	board, ok := data[1].(*gcsql.Board)
+	if !ok {
+		errEv.Caller().Msg("Invalid board in event data")
+		return nil
+	}
+	errEv.Str("board", board.Dir)
+
+	switch trigger {
+	case "post-inserted":
+		post, ok := data[0].(*gcsql.Post)
+		if !ok {
+			errEv.Caller().Msg("Invalid post in event data")
+			return nil
+		}
+		if !liveUpdates.hasClients(board.ID, post.ThreadID) {
+			return nil
+		}
+		buildablePost, err := building.GetBuildablePost(post.ID, board.ID)
+		if err != nil {
+			errEv.Err(err).Caller().
+				Int("postID", post.ID).
+				Msg("Unable to get new post")
+			return nil
+		}
+		liveUpdates.publish(board.ID, post.ThreadID, "post", buildablePost)
+	case "post-deleted":
+		post, ok := data[0].(*gcsql.Post)
+		if !ok {
+			errEv.Caller().Msg("Invalid post in event data")
+			return nil
+		}
+		var fileOnly bool
+		if len(data) > 2 {
+			fileOnly, _ = data[2].(bool)
+		}
+		if !liveUpdates.hasClients(board.ID, post.ThreadID) {
+			return nil
+		}
+		opID, err := post.TopPostID()
+		if err != nil {
+			errEv.Err(err).Caller().
+				Int("postID", post.ID).
+				Msg("Unable to get deleted post's thread")
+			return nil
+		}
+		liveUpdates.publish(board.ID, post.ThreadID, "delete", map[string]interface{}{
+			"no":       post.ID,
+			"resto":    opID,
+			"fileonly": fileOnly,
+		})
+	case "thread-updated":
+		thread, ok := data[0].(*gcsql.Thread)
+		if !ok {
+			errEv.Caller().Msg("Invalid thread in event data")
+			return nil
+		}
+		if !liveUpdates.hasClients(board.ID, thread.ID) {
+			return nil
+		}
+		op, err := gcsql.GetThreadTopPost(thread.ID)
+		if err != nil {
+			errEv.Err(err).Caller().
+				Int("threadID", thread.ID).
+				Msg("Unable to get thread's top post")
+			return nil
+		}
+		liveUpdates.publish(board.ID, thread.ID, "thread", map[string]interface{}{
+			"no":       op.ID,
+			"locked":   thread.Locked,
+			"sticky":   thread.Stickied,
+			"anchored": thread.Anchored,
+			"cyclical": thread.Cyclical,
+		})
+	}
+	return nil
+}
+
+func serveBoardEvents(writer http.ResponseWriter, request *http.Request) {
+	boardDir := bunrouter.ParamsFromContext(request.Context()).ByName("board")
+	board, err := gcsql.GetBoardFromDir(boardDir)
+	if err != nil {
+		serveAPIError(writer, http.StatusNotFound, "Board not found", map[string]interface{}{
+			"board": boardDir,
+		})
+		return
+	}
+	streamLiveUpdates(writer, request, board.ID, 0)
+}
+
+func serveThreadEvents(writer http.ResponseWriter, request *http.Request) {
+	params := bunrouter.ParamsFromContext(request.Context())
+	boardDir := params.ByName("board")
+	board, err := gcsql.GetBoardFromDir(boardDir)
+	if err != nil {
+		serveAPIError(writer, http.StatusNotFound, "Board not found", map[string]interface{}{
+			"board": boardDir,
+		})
+		return
+	}
+	opID, err := strconv.Atoi(params.ByName("op"))
+	if err != nil {
+		serveAPIError(writer, http.StatusBadRequest, "Invalid thread", map[string]interface{}{
+			"board": boardDir,
+		})
+		return
+	}
+	post, err := gcsql.GetPostFromID(opID, true)
+	if err != nil || !post.IsTopPost {
+		serveAPIError(writer, http.StatusNotFound, "Thread not found", map[string]interface{}{
+			"board": boardDir,
+			"op":    opID,
+		})
+		return
+	}
+	thread, err := gcsql.GetPostThread(opID)
+	if err != nil || thread.BoardID != board.ID {
+		serveAPIError(writer, http.StatusNotFound, "Thread not found", map[string]interface{}{
+			"board": boardDir,
+			"op":    opID,
+		})
+		return
+	}
+	streamLiveUpdates(writer, request, board.ID, thread.ID)
+}
+
+// streamLiveUpdates sends the board or thread's events to the client as they happen until the client
+// disconnects
+func streamLiveUpdates(writer http.ResponseWriter, request *http.Request, boardID int, threadID int) {
+	flusher, ok := writer.(http.Flusher)
+	if !ok {
+		serveAPIError(writer, http.StatusNotImplemented, "Streaming is not supported by the server", nil)
+		return
+	}
+	client := liveUpdates.subscribe(boardID, threadID)
+	if client == nil {
+		serveAPIError(writer, http.StatusServiceUnavailable, "Too many clients connected, try again later", nil)
+		return
+	}
+	defer liveUpdates.unsubscribe(client)
+
+	writer.Header().Set("Content-Type", "text/event-stream")
+	writer.Header().Set("Cache-Control", "no-cache")
+	// prevent nginx from buffering the stream
+	writer.Header().Set("X-Accel-Buffering", "no")
+	writer.WriteHeader(http.StatusOK)
+	if _, err := fmt.Fprintf(writer, "retry: %d\n\n", liveUpdateRetry); err != nil {
+		return
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(liveUpdateKeepAlive)
+	defer keepAlive.Stop()
+	var err error
+	for {
+		select {
+		case <-request.Context().Done():
+			return
+		case msg := <-client.messages:
+			_, err = fmt.Fprintf(writer, "event: %s\ndata: %s\n\n", msg.event, msg.data)
+		case <-keepAlive.C:
+			_, err = fmt.Fprint(writer, ": keepalive\n\n")
+		}
+		if err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"strings"
 	"syscall"
 
+	"github.com/gochan-org/gochan/pkg/building"
 	"github.com/gochan-org/gochan/pkg/config"
 
 	"github.com/gochan-org/gochan/pkg/gcutil"
@@ -42,7 +43,8 @@ func main() {
 
 	err := gcplugin.LoadPlugins(systemCritical.Plugins)
 	if err != nil {
-		gcutil.LogFatal().Err(err).Msg("failed loading plugins")
+		// the plugins that failed to load are shown on the plugins manage page
+		fmt.Println("Failed loading one or more plugins:", err.Error())
 	}
 
 	if err = gcsql.ConnectToDB(
@@ -65,33 +67,54 @@ func main() {
 		fmt.Println("Failed initializing templates:", err.Error())
 		gcutil.LogFatal().Err(err).Send()
 	}
+	if err = gcplugin.InitPlugins(); err != nil {
+		fmt.Println("Failed initializing one or more Go plugins:", err.Error())
+	}
 
 	for _, board := range gcsql.AllBoards {
-		if _, err = board.DeleteOldThreads(); err != nil {
-			fmt.Printf("Error deleting old threads for board /%s/: %s\n", board.Dir, err)
+		if err = building.PruneOldThreads(&board); err != nil {
+			fmt.Printf("Error pruning old threads for board /%s/: %s\n", board.Dir, err)
 			gcutil.LogFatal().Err(err).Caller().
 				Str("board", board.Dir).
-				Msg("Failed deleting old threads")
+				Msg("Failed pruning old threads")
 		}
 	}
 
 	sc := make(chan os.Signal, 1)
 	signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
 	posting.InitPosting()
+	go reloadOnSIGHUP()
 	go initServer()
 	<-sc
 }
 
+// reloadOnSIGHUP reloads the Lua plugins and templates whenever gochan receives SIGHUP. Errors are logged and
+// shown on the plugins manage page instead of stopping the server
+func reloadOnSIGHUP() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		fmt.Println("Received SIGHUP, reloading plugins and templates")
+		pluginErr, templateErr := gcplugin.Reload()
+		if pluginErr != nil {
+			fmt.Println("Failed reloading one or more plugins:", pluginErr.Error())
+		}
+		if templateErr != nil {
+			fmt.Println("Failed reloading one or more templates:", templateErr.Error())
+		}
+	}
+}
+
 func parseCommandLine() {
 	var newstaff string
 	var delstaff string
 	var rebuild string
-	var rank int
+	var roleName string
 	var err error
 	flag.StringVar(&newstaff, "newstaff", "", "<newusername>:<newpassword>")
 	flag.StringVar(&delstaff, "delstaff", "", "<username>")
 	flag.StringVar(&rebuild, "rebuild", "", "accepted values are boards,front,js, or all")
-	flag.IntVar(&rank, "rank", 0, "New staff member rank, to be used with -newstaff or -delstaff")
+	flag.StringVar(&roleName, "role", "", "New staff member role (for example Janitor, Moderator, or Administrator), to be used with -newstaff")
 	flag.Parse()
 
 	rebuildFlag := buildNone
@@ -115,8 +138,18 @@ func parseCommandLine() {
 			flag.Usage()
 			os.Exit(1)
 		}
-		fmt.Printf("Creating new staff: %q, with password: %q and rank: %d from command line", arr[0], arr[1], rank)
-		if _, err = gcsql.NewStaff(arr[0], arr[1], rank); err != nil {
+		fmt.Printf("Creating new staff: %q, with password: %q and role: %q from command line", arr[0], arr[1], roleName)
+		role, err := gcsql.GetRoleByName(roleName)
+		if err != nil {
+			fmt.Printf("Failed getting role %q: %s\n", roleName, err.Error())
+			gcutil.LogFatal().
+				Str("staff", "add").
+				Str("source", "commandLine").
+				Str("role", roleName).
+				Err(err).
+				Msg("Failed getting staff role")
+		}
+		if _, err = gcsql.NewStaff(arr[0], arr[1], role); err != nil {
 			fmt.Printf("Failed creating new staff account for %q: %s\n", arr[0], err.Error())
 			gcutil.LogFatal().
 				Str("staff", "add").
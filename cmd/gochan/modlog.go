@@ -0,0 +1,63 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/gochan-org/gochan/pkg/config"
+	"github.com/gochan-org/gochan/pkg/gcsql"
+	"github.com/gochan-org/gochan/pkg/gctemplates"
+	"github.com/gochan-org/gochan/pkg/gcutil"
+	"github.com/gochan-org/gochan/pkg/server"
+	"github.com/gochan-org/gochan/pkg/server/serverutil"
+)
+
+const (
+	publicModLogEntries = 100
+)
+
+// handles requests to /modlog, showing the board's public moderation log if it is enabled in the board's
+// configuration
+func serveModLog(writer http.ResponseWriter, request *http.Request) {
+	errEv := gcutil.LogError(nil).
+		Str("IP", gcutil.GetRealIP(request))
+	defer errEv.Discard()
+
+	boardDir := request.FormValue("board")
+	board, err := gcsql.GetBoardFromDir(boardDir)
+	if errors.Is(err, sql.ErrNoRows) {
+		server.ServeErrorPage(writer, "Board does not exist")
+		return
+	} else if err != nil {
+		errEv.Err(err).Caller().
+			Str("board", boardDir).Send()
+		server.ServeErrorPage(writer, "Unable to get board info: "+err.Error())
+		return
+	}
+	boardConfig := config.GetBoardConfig(board.Dir)
+	if !boardConfig.PublicModLog {
+		server.ServeErrorPage(writer, "/"+board.Dir+"/ does not have a public moderation log")
+		return
+	}
+
+	entries, err := gcsql.GetPublicModLog(board.ID, publicModLogEntries)
+	if err != nil {
+		errEv.Err(err).Caller().
+			Str("board", board.Dir).Send()
+		server.ServeErrorPage(writer, "Unable to get moderation log: "+err.Error())
+		return
+	}
+	if err = serverutil.MinifyTemplate(gctemplates.ModLog, map[string]interface{}{
+		"boards":      gcsql.AllBoards,
+		"boardConfig": boardConfig,
+		"siteConfig":  config.GetSiteConfig(),
+		"pageTitle":   "/" + board.Dir + "/ moderation log",
+		"modBoard":    board,
+		"entries":     entries,
+	}, writer, "text/html"); err != nil {
+		errEv.Err(err).Caller().
+			Str("template", "modlog.html").Send()
+		server.ServeErrorPage(writer, "Error executing moderation log template: "+err.Error())
+	}
+}
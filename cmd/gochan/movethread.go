@@ -7,6 +7,7 @@ import (
 	"os"
 	"path"
 	"strconv"
+	"time"
 
 	"github.com/gochan-org/gochan/pkg/building"
 	"github.com/gochan-org/gochan/pkg/config"
@@ -18,6 +19,11 @@ import (
 	"github.com/gochan-org/gochan/pkg/server/serverutil"
 )
 
+const (
+	// movedThreadBuildTimeout is how long to wait for a moved thread's page to be built before redirecting to it
+	movedThreadBuildTimeout = 10 * time.Second
+)
+
 func moveThread(checkedPosts []int, moveBtn string, doMove string, writer http.ResponseWriter, request *http.Request) {
 	password := request.PostFormValue("password")
 	var passwordMD5 string
@@ -33,9 +39,9 @@ func moveThread(checkedPosts []int, moveBtn string, doMove string, writer http.R
 	}()
 	gcutil.LogStr("IP", gcutil.GetRealIP(request), errEv, infoEv)
 
-	rank := manage.GetStaffRank(request)
+	canMove := manage.StaffCan(request, gcsql.CapThreadMove)
 
-	if password == "" && rank == 0 {
+	if password == "" && !canMove {
 		errEv.Msg("Thread move request rejected, non-staff didn't provide a password")
 		writer.WriteHeader(http.StatusBadRequest)
 		server.ServeError(writer, "Password required for post moving", wantsJSON, nil)
@@ -172,7 +178,12 @@ func moveThread(checkedPosts []int, moveBtn string, doMove string, writer http.R
 			return
 		}
 
-		if passwordMD5 != post.Password && rank == 0 {
+		if canMove && (!staffCanOnPost(request, gcsql.CapThreadMove, post) ||
+			!manage.StaffCanOnBoard(request, gcsql.CapThreadMove, destBoardID)) {
+			// staff assigned to specific boards can only move threads between them
+			canMove = false
+		}
+		if passwordMD5 != post.Password && !canMove {
 			errEv.Msg("Wrong password")
 			server.ServeError(writer, "Wrong password", wantsJSON, nil)
 			return
@@ -186,6 +197,14 @@ func moveThread(checkedPosts []int, moveBtn string, doMove string, writer http.R
 			})
 			return
 		}
+		if canMove {
+			manage.LogRequestStaffAction(request, gcsql.ModLogEntry{
+				Action:     gcsql.ModLogThreadMove,
+				TargetType: gcsql.ModLogTargetThread,
+				TargetID:   post.ID,
+				BoardID:    &destBoardID,
+			}, map[string]string{"board": srcBoard.Dir}, map[string]string{"board": destBoard.Dir})
+		}
 
 		threadUploads, err := gcsql.GetThreadFiles(post)
 		if err != nil {
@@ -277,27 +296,14 @@ func moveThread(checkedPosts []int, moveBtn string, doMove string, writer http.R
 			return
 		}
 
-		if err = building.BuildThreadPages(post); err != nil {
-			writer.WriteHeader(http.StatusInternalServerError)
-			server.ServeError(writer, "Failed building thread page: "+err.Error(), wantsJSON, map[string]interface{}{
-				"postid": postID,
-			})
-			return
-		}
-		if err = building.BuildBoardPages(srcBoard); err != nil {
-			writer.WriteHeader(http.StatusInternalServerError)
-			server.ServeError(writer, "Failed building board page: "+err.Error(), wantsJSON, map[string]interface{}{
-				"srcBoardID": srcBoardID,
-			})
-			return
-		}
-		if err = building.BuildBoardPages(destBoard); err != nil {
-			writer.WriteHeader(http.StatusInternalServerError)
-			server.ServeError(writer, "Failed building destination board page: "+err.Error(), wantsJSON, map[string]interface{}{
-				"destBoardID": destBoardID,
-			})
-			return
-		}
+		threadJob := building.QueueThreadPages(destBoard, postID)
+		building.QueueBoardPages(srcBoard)
+		building.QueueCatalog(srcBoard)
+		building.QueueBoardPages(destBoard)
+		building.QueueCatalog(destBoard)
+		building.QueueFrontPage()
+		// the thread's page on the destination board doesn't exist until its job is finished
+		threadJob.Wait(movedThreadBuildTimeout)
 		if wantsJSON {
 			server.ServeJSON(writer, map[string]interface{}{
 				"status":    "success",
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/gochan-org/gochan/pkg/building"
+	"github.com/gochan-org/gochan/pkg/config"
+	"github.com/gochan-org/gochan/pkg/gctemplates"
+	"github.com/gochan-org/gochan/pkg/gcutil"
+	"github.com/gochan-org/gochan/pkg/server"
+	"github.com/gochan-org/gochan/pkg/server/serverutil"
+)
+
+// handles requests to /search
+func serveSearch(writer http.ResponseWriter, request *http.Request) {
+	errEv := gcutil.LogError(nil).
+		Str("IP", gcutil.GetRealIP(request))
+	defer errEv.Discard()
+
+	search, err := building.PostSearchFromRequest(request)
+	if errors.Is(err, sql.ErrNoRows) {
+		server.ServeErrorPage(writer, "Board does not exist")
+		return
+	} else if err != nil {
+		server.ServeErrorPage(writer, err.Error())
+		return
+	}
+	data, err := building.SearchTemplateData(request, search, config.WebPath("/search"), false)
+	if err != nil {
+		errEv.Err(err).Caller().
+			Str("query", search.Query).Send()
+		server.ServeErrorPage(writer, "Error searching posts: "+err.Error())
+		return
+	}
+	data["boardConfig"] = config.GetBoardConfig("")
+	data["siteConfig"] = config.GetSiteConfig()
+	data["pageTitle"] = "Search"
+	if err = serverutil.MinifyTemplate(gctemplates.Search, data, writer, "text/html"); err != nil {
+		errEv.Err(err).Caller().
+			Str("template", "search.html").Send()
+		server.ServeErrorPage(writer, "Error executing search page template: "+err.Error())
+	}
+}
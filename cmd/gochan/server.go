@@ -13,6 +13,7 @@ import (
 	"github.com/uptrace/bunrouter"
 
 	"github.com/gochan-org/gochan/pkg/config"
+	"github.com/gochan-org/gochan/pkg/gcplugin"
 	"github.com/gochan-org/gochan/pkg/gcutil"
 	"github.com/gochan-org/gochan/pkg/manage"
 	"github.com/gochan-org/gochan/pkg/posting"
@@ -58,8 +59,12 @@ func initServer() {
 	router.GET(config.WebPath("/util"), bunrouter.HTTPHandlerFunc(utilHandler))
 	router.POST(config.WebPath("/util"), bunrouter.HTTPHandlerFunc(utilHandler))
 	router.GET(config.WebPath("/util/banner"), bunrouter.HTTPHandlerFunc(randomBanner))
-	// Eventually plugins might be able to register new namespaces or they might be restricted to something
-	// like /plugin
+	router.GET(config.WebPath("/search"), bunrouter.HTTPHandlerFunc(serveSearch))
+	router.GET(config.WebPath("/modlog"), bunrouter.HTTPHandlerFunc(serveModLog))
+	registerAPIRoutes(router)
+	registerLiveUpdateRoutes(router)
+	// Go plugins' routes are restricted to /plugin/<name>/
+	gcplugin.RegisterPluginRoutes(router)
 
 	if systemCritical.UseFastCGI {
 		err = fcgi.Serve(listener, router)
@@ -31,12 +31,14 @@ function moveThread(id, _board) {
 }
 
 function reportPost(id, board) {
-	promptLightbox("", false, ($lb, reason) => {
-		if(reason == "" || reason === null) return;
+	let $lb = promptLightbox("", false, ($lb, reason) => {
+		let category = $lb.find("select#lightbox-report-category").val() || "0";
+		if((reason == "" || reason === null) && category == "0") return;
 		let xhrFields = {
 			board: board,
 			report_btn: "Report",
 			reason: reason,
+			category: category,
 			json: "1"
 		};
 		xhrFields[`check${id}`] = "on";
@@ -53,6 +55,9 @@ function reportPost(id, board) {
 			}
 		}, "json");
 	}, "Report post");
+	// the board's report categories, if it has any, are in the report box at the bottom of the page
+	$("select#report-category").clone().prop("id", "lightbox-report-category")
+		.insertBefore($lb.find("input#promptinput"));
 }
 
 function deletePostFile(id) {
@@ -172,6 +177,9 @@ function handleActions(action, postIDStr) {
 				alertLightbox(`Failed getting post IP: ${reason.statusText}`, "Error");
 			});
 			break;
+		case "Warn poster":
+			window.open(`${webroot}manage/warnings?postid=${postID}`);
+			break;
 		case "Ban filename":
 		case "Ban file checksum": {
 			let banType = (action == "Ban filename")?"filename":"checksum";
@@ -68,33 +68,36 @@ export function createPostElement(post, boardDir, elementClass = "inlinepostprev
 	if(post.sub != "")
 		$postInfo.prepend($("<span/>").prop({class:"subject"}).text(post.sub), " ");
 
-	if(post.filename != "" && post.filename != "deleted") {
-		let thumbFile = getThumbFilename(post.tim);
+	let files = [post, ...(post.extra_files || [])];
+	for(const file of files) {
+		if(file.filename == "" || file.filename == "deleted")
+			continue;
+		let thumbFile = getThumbFilename(file.tim);
 		$post.append(
 			$("<div/>").prop({class: "file-info"})
 				.append(
 					"File: ",
 					$("<a/>").prop({
-						href: webroot + boardDir + "/src/" + post.tim,
+						href: webroot + boardDir + "/src/" + file.tim,
 						target: "_blank"
-					}).text(post.tim),
-					` - (${formatFileSize(post.fsize)} , ${post.w}x${post.h}, `,
+					}).text(file.tim),
+					` - (${formatFileSize(file.fsize)} , ${file.w}x${file.h}, `,
 					$("<a/>").prop({
 						class: "file-orig",
-						href: webroot + boardDir + "/src/" + post.tim,
-						download: post.filename,
-					}).text(post.filename),
+						href: webroot + boardDir + "/src/" + file.tim,
+						download: file.filename,
+					}).text(file.filename),
 					")"
 				),
-			$("<a/>").prop({class: "upload-container", href: webroot + boardDir + "/src/" + post.tim})
+			$("<a/>").prop({class: "upload-container", href: webroot + boardDir + "/src/" + file.tim})
 				.append(
 					$("<img/>")
 						.prop({
 							class: "upload",
 							src: webroot + boardDir + "/thumb/" + thumbFile,
-							alt: webroot + boardDir + "/src/" + post.tim,
-							width: post.tn_w,
-							height: post.tn_h
+							alt: webroot + boardDir + "/src/" + file.tim,
+							width: file.tn_w,
+							height: file.tn_h
 						})
 				)	
 		);
@@ -105,6 +108,16 @@ export function createPostElement(post, boardDir, elementClass = "inlinepostprev
 			class: "post-text"
 		}).html(post.com)
 	);
+	if(post.replies && post.replies.length > 0) {
+		let $replies = $("<div/>").prop({class: "post-replies"}).text("Replies:");
+		for(const reply of post.replies) {
+			$replies.append(" ", $("<a/>").prop({
+				href: `${webroot}${reply.board}/res/${reply.resto}.html#${reply.no}`,
+				class: "postref"
+			}).text(reply.board == boardDir ? `>>${reply.no}` : `>>>/${reply.board}/${reply.no}`));
+		}
+		$post.append($replies);
+	}
 	return $post;
 }
 
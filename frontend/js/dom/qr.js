@@ -228,9 +228,11 @@ export function initQR() {
 			success: (data, _status, _jqXHR) => {
 				if(data.error) {
 					alertLightbox(data.error, "Error");
+					refreshBuiltinCaptcha();
 					return;
 				}
 				clearQR();
+				refreshBuiltinCaptcha();
 				let cooldown = (currentThread().thread > 0)?replyCooldown:threadCooldown;
 				setButtonTimeout("", cooldown);
 				updateThread().then(clearQR).then(() => {
@@ -247,16 +249,33 @@ export function initQR() {
 	});
 }
 
+const captchaResponseFields = [
+	"h-captcha-response",
+	"g-recaptcha-response",
+	"cf-turnstile-response",
+	"captcha-answer"
+];
+
 function copyCaptchaResponse($copyToForm) {
-	let $captchaResp = $("textarea[name=h-captcha-response]");
-	if($captchaResp.length > 0) {
-		$("<textarea/>").prop({
-			"name": "h-captcha-response"
-		}).val($("textarea[name=h-captcha-response]").val()).css("display", "none")
-		.appendTo($copyToForm);
+	for(const field of captchaResponseFields) {
+		let $captchaResp = $(`[name=${field}]`).not($copyToForm.find(`[name=${field}]`));
+		if($captchaResp.length > 0) {
+			$("<textarea/>").prop({
+				"name": field
+			}).val($captchaResp.first().val()).css("display", "none")
+			.appendTo($copyToForm);
+		}
 	}
 }
 
+function refreshBuiltinCaptcha() {
+	// builtin CAPTCHA challenges can only be used once
+	$("img.captcha-image").each((_i, img) => {
+		img.src = webroot + "captcha?image=1&t=" + Date.now();
+	});
+	$("input[name=captcha-answer]").val("");
+}
+
 function clearQR() {
 	if(!$qr) return;
 	$qr.find("input[name=postsubject]").val("");
@@ -11,6 +11,7 @@ import { getPageThread } from "./postinfo";
 import { prepareThumbnails, initPostPreviews } from "./postutil";
 import { addPostDropdown } from "./dom/postdropdown";
 import { initQR } from "./dom/qr";
+import { initLiveUpdates } from "./liveupdates";
 import { getBooleanStorageVal, getStorageVal } from "./storage";
 
 export function toTop() {
@@ -43,6 +44,7 @@ $(() => {
 		if(getBooleanStorageVal("useqr", true))
 			initQR();
 		initPostPreviews();
+		initLiveUpdates();
 	}
 	$("div.post, div.reply").each((i, elem) => {
 		addPostDropdown($(elem));
@@ -0,0 +1,109 @@
+/**
+ * @typedef { import("./types/gochan").ThreadPost } ThreadPost
+ */
+
+import $ from "jquery";
+
+import { currentThread } from "./postinfo";
+import { addThreadPost, updateThread } from "./postutil";
+import { getNumberStorageVal } from "./storage";
+
+/** @type {EventSource} */
+let eventSource = null;
+let pollInterval = null;
+
+/**
+ * Fall back to periodically checking the thread's JSON file if the browser or server doesn't
+ * support Server-Sent Events
+ */
+function startPolling() {
+	if(pollInterval !== null) return;
+	pollInterval = setInterval(updateThread, getNumberStorageVal("watcherseconds", 10) * 1000);
+}
+
+function onNewPost(e) {
+	/** @type {ThreadPost} */
+	let post = JSON.parse(e.data);
+	addThreadPost(post, currentThread().board);
+}
+
+function onDeletePost(e) {
+	let data = JSON.parse(e.data);
+	if(data.fileonly) {
+		let $post = $(`div#op${data.no}, div#reply${data.no}`);
+		$post.find("div.file-info").remove();
+		$post.find("a.upload-container").replaceWith(
+			$("<div/>").prop({class: "file-deleted-box"}).css("text-align", "center").text("File removed")
+		);
+		return;
+	}
+	if(data.no == data.resto) {
+		// the thread was deleted
+		closeLiveUpdates();
+		$(`div#${data.no}.thread`).before(
+			$("<div/>").prop({class: "section-title-block"}).text("This thread has been deleted")
+		);
+		return;
+	}
+	$(`div#replycontainer${data.no}`).remove();
+}
+
+function onThreadUpdate(e) {
+	let data = JSON.parse(e.data);
+	let $icons = $(`div#op${data.no} span.status-icons`).empty();
+	if(data.locked) {
+		$icons.append($("<img/>").prop({
+			src: `${webroot}static/lock.png`,
+			class: "locked-icon",
+			alt: "Thread locked",
+			title: "Thread locked"
+		}));
+	}
+	if(data.sticky) {
+		$icons.append($("<img/>").prop({
+			src: `${webroot}static/sticky.png`,
+			class: "sticky-icon",
+			alt: "Sticky",
+			title: "Sticky"
+		}));
+	}
+}
+
+export function closeLiveUpdates() {
+	if(eventSource !== null) {
+		eventSource.close();
+		eventSource = null;
+	}
+	if(pollInterval !== null) {
+		clearInterval(pollInterval);
+		pollInterval = null;
+	}
+}
+
+/**
+ * Listen for new posts, deletions, and thread changes in the current thread, using polling if
+ * Server-Sent Events aren't available
+ */
+export function initLiveUpdates() {
+	let thread = currentThread();
+	if(thread.thread === 0) return; // not in a thread
+	if(typeof EventSource === "undefined") {
+		startPolling();
+		return;
+	}
+	eventSource = new EventSource(`${webroot}events/thread/${thread.board}/${thread.thread}`);
+	eventSource.addEventListener("post", onNewPost);
+	eventSource.addEventListener("delete", onDeletePost);
+	eventSource.addEventListener("thread", onThreadUpdate);
+	eventSource.addEventListener("open", () => {
+		// catch up on anything that was missed while disconnected
+		updateThread();
+	});
+	eventSource.addEventListener("error", () => {
+		// the browser will try to reconnect by itself unless the server refused the connection
+		if(eventSource !== null && eventSource.readyState === EventSource.CLOSED) {
+			eventSource = null;
+			startPolling();
+		}
+	});
+}
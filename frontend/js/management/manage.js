@@ -16,8 +16,7 @@ import { isThreadLocked } from '../api/management';
  */
 const notAStaff = {
 	ID: 0,
-	Username: "",
-	Rank: 0
+	Username: ""
 };
 
 const reportsTextRE = /^Reports( \(\d+\))?/;
@@ -66,6 +65,9 @@ function setupManagementEvents() {
 		if(!dropdownHasItem(el, "Posts from this IP")) {
 			$el.append("<option>Posts from this IP</option>");
 		}
+		if(getAction("warnings") && !dropdownHasItem(el, "Warn poster")) {
+			$el.append("<option>Warn poster</option>");
+		}
 		let filenameOrig = $post.find("div.file-info a.file-orig").text();
 		if(filenameOrig != "" && !dropdownHasItem(el, "Ban filename")) {
 			$el.append(
@@ -77,6 +79,8 @@ function setupManagementEvents() {
 	$(document).on("postDropdownAdded", function(_e, data) {
 		if(!data.dropdown) return;
 		data.dropdown.append("<option>Posts from this IP</option>");
+		if(getAction("warnings"))
+			data.dropdown.append("<option>Warn poster</option>");
 	});
 }
 
@@ -127,7 +131,7 @@ export async function initStaff() {
 			console.error("Error getting actions list:", e);
 		}
 	}).then(getStaffInfo).then(info => {
-		if(info.Rank > 0) {
+		if(info.ID > 0) {
 			setupManagementEvents();
 		}
 		return info;
@@ -143,6 +147,9 @@ export async function getStaffInfo() {
 	return $.ajax({
 		method: "GET",
 		url: `${webroot}manage/staffinfo`,
+		data: {
+			json: 1
+		},
 		async: true,
 		cache: true,
 		dataType: "json"
@@ -152,10 +159,23 @@ export async function getStaffInfo() {
 		if(info.error)
 			return notAStaff;
 		staffInfo = info;
+		if(info.CSRFToken)
+			setupCSRFToken(info.CSRFToken);
 		return info;
 	});
 }
 
+/**
+ * Adds the staff member's CSRF token to POST requests so that manage actions will accept them
+ * @param {string} token the token of the staff member's login session
+ */
+function setupCSRFToken(token) {
+	$.ajaxPrefilter((options, _originalOptions, xhr) => {
+		if(!options.crossDomain && options.type.toUpperCase() === "POST")
+			xhr.setRequestHeader("X-CSRF-Token", token);
+	});
+}
+
 export async function getPostInfo(id) {
 	return $.ajax({
 		method: "GET",
@@ -228,11 +248,10 @@ function filterAction(action, perms) {
 /**
  * Creates a list of staff actions accessible to the user if they are logged in.
  * It is shown when the user clicks the Staff button
- * @param {StaffInfo} staff an object representing the staff's username and rank
+ * @param {StaffInfo} staff an object representing the staff's username and role
  */
 export function createStaffMenu(staff = staffInfo) {
-	let rank = staff.Rank;
-	if(rank === 0) return;
+	if(staff.ID === 0) return;
 	$staffMenu = $("<div/>").prop({
 		id: "staffmenu",
 		class: "dropdown-menu"
@@ -240,36 +259,36 @@ export function createStaffMenu(staff = staffInfo) {
 
 	$staffMenu.append(
 		menuItem(getAction("logout")),
-		menuItem(getAction("dashboard")));
+		menuItem(getAction("dashboard")),
+		menuItem({id: "staffinfo", title: "Account"}));
 
+	// staffActions only has the actions that the staff member's role can access
 	let janitorActions = staffActions.filter(val => filterAction(val, 1));
 	$staffMenu.append(menuItem("Janitorial", true));
 	for(const action of janitorActions) {
 		$staffMenu.append(menuItem(action));
 	}
 
-	if(rank >= 2) {
-		let modActions = staffActions.filter(val => filterAction(val, 2));
-		if(modActions.length > 0)
-			$staffMenu.append(menuItem("Moderation", true));
-		for(const action of modActions) {
-			$staffMenu.append(menuItem(action));
-		}
-		getReports().then(updateReports);
+	let modActions = staffActions.filter(val => filterAction(val, 2));
+	if(modActions.length > 0)
+		$staffMenu.append(menuItem("Moderation", true));
+	for(const action of modActions) {
+		$staffMenu.append(menuItem(action));
 	}
-	if(rank == 3) {
-		let adminActions = staffActions.filter(val => filterAction(val, 3));
-		if(adminActions.length > 0)
-			$staffMenu.append(menuItem("Administration", true));
-		for(const action of adminActions) {
-			$staffMenu.append(menuItem(action));
-		}
+	if(getAction("reports") !== undefined)
+		getReports().then(updateReports);
+
+	let adminActions = staffActions.filter(val => filterAction(val, 3));
+	if(adminActions.length > 0)
+		$staffMenu.append(menuItem("Administration", true));
+	for(const action of adminActions) {
+		$staffMenu.append(menuItem(action));
 	}
 	createStaffButton();
 }
 
 function createStaffButton() {
-	if($staffBtn !== null || staffInfo.Rank === 0)
+	if($staffBtn !== null || staffInfo.ID === 0)
 		return;
 	$staffBtn = new TopBarButton("Staff", () => {
 		$topbar.trigger("menuButtonClick", [$staffMenu, $(document).find($staffMenu).length == 0]);
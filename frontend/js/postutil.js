@@ -44,30 +44,40 @@ export function updateThreadJSON() {
 	});
 }
 
+/**
+ * Add the post to the thread it belongs to if it isn't already on the page
+ * @param {ThreadPost} post
+ * @param {string} board
+ * @returns {boolean} true if the post was added
+ */
+export function addThreadPost(post, board) {
+	let selector = "";
+	if(post.resto === 0 || post.resto == post.no)
+		selector += `div#op${post.no}`;
+	else
+		selector += `div#reply${post.no}`;
+	if($(selector).length > 0)
+		return false; // TODO: check for edits
+
+	let $post = createPostElement(post, board, "reply");
+	let $replyContainer = $("<div/>").prop({
+		id: `replycontainer${post.no}`,
+		class: "reply-container"
+	}).append($post);
+	$replyContainer.appendTo(`div#${post.resto}.thread`);
+	addPostDropdown($post);
+	prepareThumbnails($post);
+	initPostPreviews($post);
+	return true;
+}
+
 function updateThreadHTML() {
 	let thread = currentThread();
 	if(thread.thread === 0) return; // not in a thread
 	let numAdded = 0;
 	for(const post of currentThreadJSON.posts) {
-		let selector = "";
-		if(post.resto === 0 || post.resto == post.no)
-			selector += `div#op${post.no}`;
-		else
-			selector += `div#reply${post.no}`;
-		let elementExists = $(selector).length > 0;
-		if(elementExists)
-			continue; // TODO: check for edits
-		
-		let $post = createPostElement(post, thread.board, "reply");
-		let $replyContainer = $("<div/>").prop({
-			id: `replycontainer${post.no}`,
-			class: "reply-container"
-		}).append($post);
-		$replyContainer.appendTo(`div#${post.resto}.thread`);
-		addPostDropdown($post);
-		prepareThumbnails($post);
-		initPostPreviews($post);
-		numAdded++;
+		if(addThreadPost(post, thread.board))
+			numAdded++;
 	}
 	if(numAdded === 0) return;
 }
@@ -88,6 +88,26 @@ declare interface ThreadPost {
 	capcode: string;
 	time: string;
 	last_modified: string;
+	extra_files?: ThreadPostFile[];
+	replies?: ThreadPostReply[];
+}
+
+declare interface ThreadPostReply {
+	no: number;
+	board: string;
+	resto: number;
+}
+
+declare interface ThreadPostFile {
+	tim: string;
+	filename: string;
+	md5: string;
+	extension: string;
+	fsize: number;
+	w: number;
+	h: number;
+	tn_w: number;
+	tn_h: number;
 }
 
 /**
@@ -103,13 +123,24 @@ declare interface ThreadPost {
 	 */
 	Username: string;
 	/**
-	 * The staff member's rank.
-	 * 0 = not logged in.
-	 * 1 = janitor.
-	 * 2 = moderator.
-	 * 3 = administrator.
+	 * The staff member's role and the capabilities it has (like "ban.create"),
+	 * or undefined if they are not logged in
+	 */
+	Role?: StaffRole;
+	/**
+	 * True if the staff member has set up two-factor authentication
+	 */
+	TOTPEnabled?: boolean;
+	/**
+	 * The token of the staff member's login session, sent with POST requests to manage pages
 	 */
-	Rank: number;
+	CSRFToken?: string;
+}
+
+interface StaffRole {
+	ID: number;
+	Name: string;
+	Capabilities: string[];
 }
 
 /**
@@ -125,13 +156,17 @@ interface StaffAction {
 	 */
 	title: string;
 	/**
-	 * The permission level required to access the action.
-	 * 0 = accessible by anyone.
-	 * 1 = user needs to be a janitor or higher.
-	 * 2 = user needs to be a moderator or higher.
-	 * 3 = user needs to be an administrator.
+	 * The section of the staff menu the action is listed in.
+	 * 0 = accessible by anyone, not listed.
+	 * 1 = janitorial.
+	 * 2 = moderation.
+	 * 3 = administration.
 	 */
 	perms: number;
+	/**
+	 * The role capability required to access the action, if any
+	 */
+	capability?: string;
 	/**
 	 * The setting for how the request output is handled.
 	 * 0 = never JSON.
@@ -0,0 +1,251 @@
+package building
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/gochan-org/gochan/pkg/config"
+	"github.com/gochan-org/gochan/pkg/gcsql"
+	"github.com/gochan-org/gochan/pkg/gctemplates"
+	"github.com/gochan-org/gochan/pkg/gcutil"
+	"github.com/gochan-org/gochan/pkg/server/serverutil"
+)
+
+type archivedThread struct {
+	Post
+	ArchivedAt time.Time
+}
+
+// createArchiveDirs creates /board/arch/ and /board/arch/res/ if they don't already exist
+func createArchiveDirs(board *gcsql.Board) error {
+	for _, dir := range []string{board.AbsolutePath("arch"), board.AbsolutePath("arch", "res")} {
+		if err := os.MkdirAll(dir, config.GC_DIR_MODE); err != nil {
+			return fmt.Errorf(genericErrStr, dir, err.Error())
+		}
+		if err := config.TakeOwnership(dir); err != nil {
+			return fmt.Errorf(genericErrStr, dir, err.Error())
+		}
+	}
+	return nil
+}
+
+// PruneOldThreads archives or deletes (depending on the board's ArchiveOldThreads setting) threads that
+// exceed the board's MaxThreads limit, and deletes archived threads that are older than ArchiveRetentionDays.
+// If the archive was changed, it is rebuilt
+func PruneOldThreads(board *gcsql.Board) error {
+	errEv := gcutil.LogError(nil).
+		Str("boardDir", board.Dir).
+		Int("boardID", board.ID)
+	defer errEv.Discard()
+	archiveChanged, err := pruneOldThreads(board, errEv)
+	if err != nil || !archiveChanged {
+		return err
+	}
+	return BuildBoardArchive(board)
+}
+
+func pruneOldThreads(board *gcsql.Board, errEv *zerolog.Event) (bool, error) {
+	boardConfig := config.GetBoardConfig(board.Dir)
+	var archiveChanged bool
+	if boardConfig.ArchiveOldThreads {
+		archivedThreads, err := board.ArchiveOldThreads()
+		if err != nil {
+			errEv.Err(err).Caller().Msg("Unable to archive old threads")
+			return false, err
+		}
+		resDir := board.AbsolutePath("res")
+		for _, threadID := range archivedThreads {
+			op, err := gcsql.GetThreadTopPost(threadID)
+			if err != nil {
+				errEv.Err(err).Caller().
+					Int("threadID", threadID).
+					Msg("Unable to get archived thread's top post")
+				return false, err
+			}
+			os.Remove(path.Join(resDir, strconv.Itoa(op.ID)+".html"))
+			os.Remove(path.Join(resDir, strconv.Itoa(op.ID)+".json"))
+			if err = BuildThreadPages(op); err != nil {
+				return false, err
+			}
+		}
+		archiveChanged = len(archivedThreads) > 0
+	} else {
+		oldPosts, err := board.DeleteOldThreads()
+		if err != nil {
+			errEv.Err(err).Caller().Msg("Unable to delete old threads")
+			return false, err
+		}
+		if err = removePostFiles(board, oldPosts, "res", errEv); err != nil {
+			return false, err
+		}
+	}
+
+	if boardConfig.ArchiveRetentionDays > 0 {
+		purgeBefore := time.Now().AddDate(0, 0, -boardConfig.ArchiveRetentionDays)
+		purgedPosts, err := board.PurgeArchivedThreads(purgeBefore)
+		if err != nil {
+			errEv.Err(err).Caller().Msg("Unable to purge old archived threads")
+			return false, err
+		}
+		if err = removePostFiles(board, purgedPosts, path.Join("arch", "res"), errEv); err != nil {
+			return false, err
+		}
+		archiveChanged = archiveChanged || len(purgedPosts) > 0
+	}
+	return archiveChanged, nil
+}
+
+// removePostFiles deletes the uploads of the given (deleted) posts, and the thread pages of any top posts
+// in resDir
+func removePostFiles(board *gcsql.Board, postIDs []int, resDir string, errEv *zerolog.Event) error {
+	boardDir := board.AbsolutePath()
+	for _, postID := range postIDs {
+		post, err := gcsql.GetPostFromID(postID, false)
+		if err != nil {
+			errEv.Err(err).Caller().
+				Int("postID", postID).
+				Msg("Unable to get post")
+			return err
+		}
+		uploads, err := post.GetUploads()
+		if err != nil {
+			errEv.Err(err).Caller().
+				Int("postID", postID).
+				Msg("Unable to get post uploads")
+			return err
+		}
+		for _, upload := range uploads {
+			if err = removeUploadFiles(board, &upload, post.IsTopPost); err != nil {
+				errEv.Err(err).Caller().
+					Int("postID", postID).Send()
+				return err
+			}
+		}
+
+		if err = post.UnlinkUploads(false); err != nil {
+			errEv.Err(err).Caller().
+				Int("postID", postID).Send()
+			return err
+		}
+		if post.IsTopPost {
+			for _, ext := range []string{".html", ".json"} {
+				filePath := path.Join(boardDir, resDir, strconv.Itoa(post.ID)+ext)
+				if err = os.Remove(filePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+					errEv.Err(err).Caller().
+						Int("postID", postID).
+						Str("threadFile", filePath).Send()
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// removeUploadFiles deletes the upload's file and thumbnail(s) from the board directory, if they haven't
+// already been deleted
+func removeUploadFiles(board *gcsql.Board, upload *gcsql.Upload, isTopPost bool) error {
+	if upload.Filename == "deleted" {
+		return nil
+	}
+	boardDir := board.AbsolutePath()
+	filePaths := []string{
+		path.Join(boardDir, "src", upload.Filename),
+		path.Join(boardDir, "thumb", upload.ThumbnailPath("thumbnail")),
+	}
+	if isTopPost && board.EnableCatalog && upload.FileOrder == 0 {
+		filePaths = append(filePaths, path.Join(boardDir, "thumb", upload.ThumbnailPath("catalog")))
+	}
+	for _, filePath := range filePaths {
+		if err := os.Remove(filePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// BuildBoardArchive builds the board's archive index page (/board/arch/index.html) and the pages of the
+// archived threads
+func BuildBoardArchive(board *gcsql.Board) error {
+	errEv := gcutil.LogError(nil).
+		Str("building", "archive").
+		Str("boardDir", board.Dir).
+		Int("boardID", board.ID)
+	defer errEv.Discard()
+
+	threads, err := board.GetArchivedThreads()
+	if err != nil {
+		errEv.Err(err).Caller().Msg("Unable to get archived threads")
+		return fmt.Errorf("unable to get archived threads for /%s/: %s", board.Dir, err.Error())
+	}
+	boardConfig := config.GetBoardConfig(board.Dir)
+	if len(threads) == 0 && !boardConfig.ArchiveOldThreads {
+		// nothing to show and archiving isn't enabled
+		return nil
+	}
+	if err = gctemplates.InitTemplates("archive"); err != nil {
+		errEv.Err(err).Caller().Msg("Unable to initialize archive template")
+		return err
+	}
+	if err = createArchiveDirs(board); err != nil {
+		errEv.Err(err).Caller().Send()
+		return err
+	}
+
+	var archived []archivedThread
+	for _, thread := range threads {
+		op, err := gcsql.GetThreadTopPost(thread.ID)
+		if err != nil {
+			errEv.Err(err).Caller().
+				Int("threadID", thread.ID).
+				Msg("Unable to get archived thread's top post")
+			return err
+		}
+		if err = BuildThreadPages(op); err != nil {
+			return err
+		}
+		post, err := GetBuildablePost(op.ID, board.ID)
+		if err != nil {
+			errEv.Err(err).Caller().
+				Int("postID", op.ID).Send()
+			return err
+		}
+		archived = append(archived, archivedThread{
+			Post:       *post,
+			ArchivedAt: thread.ArchivedAt,
+		})
+	}
+
+	indexPath := board.AbsolutePath("arch", "index.html")
+	indexFile, err := createAtomicFile(indexPath)
+	if err != nil {
+		errEv.Err(err).Caller().Send()
+		return fmt.Errorf("failed opening /%s/arch/index.html: %s", board.Dir, err.Error())
+	}
+	defer indexFile.Close()
+	if err = config.TakeOwnershipOfFile(indexFile.File); err != nil {
+		errEv.Err(err).Caller().Send()
+		return fmt.Errorf("unable to take ownership of /%s/arch/index.html: %s", board.Dir, err.Error())
+	}
+	if err = serverutil.MinifyTemplate(gctemplates.BoardArchive, map[string]interface{}{
+		"boards":      gcsql.AllBoards,
+		"sections":    gcsql.AllSections,
+		"board":       board,
+		"boardConfig": boardConfig,
+		"threads":     archived,
+	}, indexFile, "text/html"); err != nil {
+		errEv.Err(err).Caller().Send()
+		return fmt.Errorf("failed building /%s/arch/index.html: %s", board.Dir, err.Error())
+	}
+	if err = indexFile.Commit(); err != nil {
+		errEv.Err(err).Caller().Send()
+		return fmt.Errorf("failed writing /%s/arch/index.html: %s", board.Dir, err.Error())
+	}
+	return nil
+}
@@ -8,6 +8,8 @@ import (
 	"path"
 	"strconv"
 
+	"github.com/rs/zerolog"
+
 	"github.com/gochan-org/gochan/pkg/config"
 	"github.com/gochan-org/gochan/pkg/gcsql"
 	"github.com/gochan-org/gochan/pkg/gctemplates"
@@ -26,7 +28,7 @@ var (
 	ErrNoBoardTitle = errors.New("board must have a title before it is built")
 )
 
-type boardJSON struct {
+type BoardJSON struct {
 	Dir             string `json:"board"`
 	Title           string `json:"title"`
 	Subtitle        string `json:"meta_description"`
@@ -47,31 +49,19 @@ func boolToInt(b bool) int {
 	return 0
 }
 
-// BuildBoardPages builds the front pages for the given board, and returns any error it encountered.
-func BuildBoardPages(board *gcsql.Board) error {
-	errEv := gcutil.LogError(nil).
-		Int("boardID", board.ID).
-		Str("boardDir", board.Dir)
-	defer errEv.Discard()
-	err := gctemplates.InitTemplates("boardpage")
-	if err != nil {
-		errEv.Err(err).Caller().Msg("unable to initialize boardpage template")
-		return err
-	}
-	var currentPageFile *os.File
-	var catalog boardCatalog
-	var catalogThreads []catalogThreadData
-
+// getCatalogThreads returns the board's threads with the OP and the replies shown on the board page
+func getCatalogThreads(board *gcsql.Board, errEv *zerolog.Event) ([]CatalogThread, error) {
+	var catalogThreads []CatalogThread
 	threads, err := board.GetThreads(true, true, true)
 	if err != nil {
 		errEv.Err(err).
 			Caller().Msg("Failed getting board threads")
-		return fmt.Errorf("error getting threads for /%s/: %s", board.Dir, err.Error())
+		return nil, fmt.Errorf("error getting threads for /%s/: %s", board.Dir, err.Error())
 	}
 	topPosts, err := getBoardTopPosts(board.ID)
 	if err != nil {
 		errEv.Err(err).Caller().Msg("Failed getting board threads")
-		return fmt.Errorf("error getting OP posts for /%s/: %s", board.Dir, err.Error())
+		return nil, fmt.Errorf("error getting OP posts for /%s/: %s", board.Dir, err.Error())
 	}
 	opMap := make(map[int]Post)
 	for _, post := range topPosts {
@@ -81,7 +71,7 @@ func BuildBoardPages(board *gcsql.Board) error {
 
 	postCfg := config.GetBoardConfig(board.Dir).PostConfig
 	for _, thread := range threads {
-		catalogThread := catalogThreadData{
+		catalogThread := CatalogThread{
 			Post:     opMap[thread.ID],
 			Locked:   boolToInt(thread.Locked),
 			Stickied: boolToInt(thread.Stickied),
@@ -90,7 +80,7 @@ func BuildBoardPages(board *gcsql.Board) error {
 		if catalogThread.Images, err = thread.GetReplyFileCount(); err != nil {
 			errEv.Err(err).
 				Caller().Msg("Failed getting file count")
-			return err
+			return nil, err
 		}
 
 		var maxRepliesOnBoardPage int
@@ -106,14 +96,14 @@ func BuildBoardPages(board *gcsql.Board) error {
 		if err != nil {
 			errEv.Err(err).
 				Caller().Msg("Failed getting reply count")
-			return errors.New("Error getting reply count: " + err.Error())
+			return nil, errors.New("Error getting reply count: " + err.Error())
 		}
 
-		catalogThread.Posts, err = getThreadPosts(&thread)
+		catalogThread.Posts, err = GetThreadPosts(&thread)
 		if err != nil {
 			errEv.Err(err).
 				Caller().Msg("Failed getting replies")
-			return errors.New("Failed getting replies: " + err.Error())
+			return nil, errors.New("Failed getting replies: " + err.Error())
 		}
 		if len(catalogThread.Posts) == 0 {
 			continue
@@ -128,7 +118,7 @@ func BuildBoardPages(board *gcsql.Board) error {
 		if err != nil {
 			errEv.Err(err).
 				Caller().Msg("Failed getting thread uploads")
-			return errors.New("Failed getting thread uploads: " + err.Error())
+			return nil, errors.New("Failed getting thread uploads: " + err.Error())
 		}
 
 		var imagesOnBoardPage int
@@ -147,18 +137,58 @@ func BuildBoardPages(board *gcsql.Board) error {
 		catalogThreads = append(catalogThreads, catalogThread)
 	}
 
+	return catalogThreads, nil
+}
+
+// GetBoardCatalog returns the board's threads split into pages, in the same format as catalog.json
+func GetBoardCatalog(board *gcsql.Board) ([]CatalogPage, error) {
+	errEv := gcutil.LogError(nil).
+		Int("boardID", board.ID).
+		Str("boardDir", board.Dir)
+	defer errEv.Discard()
+	catalogThreads, err := getCatalogThreads(board, errEv)
+	if err != nil {
+		return nil, err
+	}
+	var catalog boardCatalog
+	catalog.fillPages(config.GetBoardConfig(board.Dir).ThreadsPerPage, catalogThreads)
+	return catalog.pages, nil
+}
+
+// BuildBoardPages builds the front pages for the given board, and returns any error it encountered.
+func BuildBoardPages(board *gcsql.Board) error {
+	errEv := gcutil.LogError(nil).
+		Int("boardID", board.ID).
+		Str("boardDir", board.Dir)
+	defer errEv.Discard()
+	err := gctemplates.InitTemplates("boardpage")
+	if err != nil {
+		errEv.Err(err).Caller().Msg("unable to initialize boardpage template")
+		return err
+	}
+	var currentPageFile *atomicFile
+	var catalog boardCatalog
+
+	catalogThreads, err := getCatalogThreads(board, errEv)
+	if err != nil {
+		return err
+	}
+
 	criticalCfg := config.GetSystemCriticalConfig()
-	gcutil.DeleteMatchingFiles(path.Join(criticalCfg.DocumentRoot, board.Dir), "\\d.html$")
 
 	// If there are no posts on the board
-	var boardPageFile *os.File
+	var boardPageFile *atomicFile
 	boardConfig := config.GetBoardConfig(board.Dir)
-	if len(threads) == 0 {
+	reportCategories, err := gcsql.GetBoardReportCategories(board.ID)
+	if err != nil {
+		errEv.Err(err).Caller().Msg("Unable to get report categories")
+		return err
+	}
+	if len(catalogThreads) == 0 {
 		catalog.currentPage = 1
 
 		// Open 1.html for writing to the first page.
-		boardPageFile, err = os.OpenFile(path.Join(criticalCfg.DocumentRoot, board.Dir, "1.html"),
-			os.O_CREATE|os.O_RDWR|os.O_TRUNC, config.GC_FILE_MODE)
+		boardPageFile, err = createAtomicFile(path.Join(criticalCfg.DocumentRoot, board.Dir, "1.html"))
 		if err != nil {
 			errEv.Err(err).Caller().
 				Str("page", "board.html").
@@ -167,7 +197,7 @@ func BuildBoardPages(board *gcsql.Board) error {
 		}
 		defer boardPageFile.Close()
 
-		if err = config.TakeOwnershipOfFile(boardPageFile); err != nil {
+		if err = config.TakeOwnershipOfFile(boardPageFile.File); err != nil {
 			errEv.Err(err).Caller().
 				Msg("Unable to take ownership of board.html")
 			return fmt.Errorf("unable to take ownership of /%s/board.html: %s", board.Dir, err.Error())
@@ -176,32 +206,37 @@ func BuildBoardPages(board *gcsql.Board) error {
 		// packaging the board/section list, threads, and board info
 		captchaCfg := config.GetSiteConfig().Captcha
 		if err = serverutil.MinifyTemplate(gctemplates.BoardPage, map[string]interface{}{
-			"boards":      gcsql.AllBoards,
-			"sections":    gcsql.AllSections,
-			"threads":     threads,
-			"numPages":    1,
-			"currentPage": 1,
-			"board":       board,
-			"boardConfig": boardConfig,
-			"useCaptcha":  captchaCfg.UseCaptcha(),
-			"captcha":     captchaCfg,
+			"boards":           gcsql.AllBoards,
+			"sections":         gcsql.AllSections,
+			"threads":          catalogThreads,
+			"numPages":         1,
+			"currentPage":      1,
+			"board":            board,
+			"boardConfig":      boardConfig,
+			"reportCategories": reportCategories,
+			"useCaptcha":       captchaCfg.UseCaptcha(),
+			"captcha":          captchaCfg,
 		}, boardPageFile, "text/html"); err != nil {
 			errEv.Err(err).
 				Str("page", "board.html").
 				Caller().Msg("Failed building board")
 			return fmt.Errorf("failed building /%s/: %s", board.Dir, err.Error())
 		}
+		if err = boardPageFile.Commit(); err != nil {
+			errEv.Err(err).Caller().
+				Str("page", "board.html").Send()
+			return fmt.Errorf("failed writing /%s/board.html: %s", board.Dir, err.Error())
+		}
+		removeExtraBoardPages(board, 1)
 		return nil
 	}
 
 	// Create the archive pages.
 	catalog.fillPages(boardConfig.ThreadsPerPage, catalogThreads)
 
-	// Create array of page wrapper objects, and open the file.
-	var catalogPages boardCatalog
-
+	// Open the catalog JSON file.
 	// catalog JSON file is built with the pages because pages are recorded in the JSON file
-	catalogJSONFile, err := os.OpenFile(path.Join(criticalCfg.DocumentRoot, board.Dir, "catalog.json"), os.O_CREATE|os.O_RDWR|os.O_TRUNC, config.GC_FILE_MODE)
+	catalogJSONFile, err := createAtomicFile(path.Join(criticalCfg.DocumentRoot, board.Dir, "catalog.json"))
 	if err != nil {
 		errEv.Err(err).Caller().
 			Msg("Failed opening catalog.json")
@@ -209,7 +244,7 @@ func BuildBoardPages(board *gcsql.Board) error {
 	}
 	defer catalogJSONFile.Close()
 
-	if err = config.TakeOwnershipOfFile(catalogJSONFile); err != nil {
+	if err = config.TakeOwnershipOfFile(catalogJSONFile.File); err != nil {
 		errEv.Err(err).Caller().
 			Msg("Unable to take ownership of catalog.json")
 		return fmt.Errorf("unable to take ownership of /%s/catalog.json: %s", board.Dir, err.Error())
@@ -219,7 +254,7 @@ func BuildBoardPages(board *gcsql.Board) error {
 		var currentPageFilepath string
 		pageFilename := strconv.Itoa(catalog.currentPage) + ".html"
 		currentPageFilepath = path.Join(criticalCfg.DocumentRoot, board.Dir, pageFilename)
-		currentPageFile, err = os.OpenFile(currentPageFilepath, os.O_CREATE|os.O_RDWR|os.O_TRUNC, config.GC_FILE_MODE)
+		currentPageFile, err = createAtomicFile(currentPageFilepath)
 		if err != nil {
 			errEv.Err(err).Caller().
 				Str("page", pageFilename).
@@ -228,7 +263,7 @@ func BuildBoardPages(board *gcsql.Board) error {
 		}
 		defer currentPageFile.Close()
 
-		if err = config.TakeOwnershipOfFile(currentPageFile); err != nil {
+		if err = config.TakeOwnershipOfFile(currentPageFile.File); err != nil {
 			errEv.Err(err).Caller().
 				Str("page", pageFilename).
 				Msg("Unable to update file ownership")
@@ -237,21 +272,22 @@ func BuildBoardPages(board *gcsql.Board) error {
 
 		// Render the boardpage template
 		captchaCfg := config.GetSiteConfig().Captcha
-		numThreads := len(threads)
+		numThreads := len(catalogThreads)
 		numPages := numThreads / boardConfig.ThreadsPerPage
 		if (numThreads % boardConfig.ThreadsPerPage) > 0 {
 			numPages++
 		}
 		data := map[string]interface{}{
-			"boards":      gcsql.AllBoards,
-			"sections":    gcsql.AllSections,
-			"threads":     page.Threads,
-			"numPages":    numPages,
-			"currentPage": catalog.currentPage,
-			"board":       board,
-			"boardConfig": boardConfig,
-			"useCaptcha":  captchaCfg.UseCaptcha(),
-			"captcha":     captchaCfg,
+			"boards":           gcsql.AllBoards,
+			"sections":         gcsql.AllSections,
+			"threads":          page.Threads,
+			"numPages":         numPages,
+			"currentPage":      catalog.currentPage,
+			"board":            board,
+			"boardConfig":      boardConfig,
+			"reportCategories": reportCategories,
+			"useCaptcha":       captchaCfg.UseCaptcha(),
+			"captcha":          captchaCfg,
 		}
 		if catalog.currentPage > 1 {
 			data["prevPage"] = catalog.currentPage - 1
@@ -264,12 +300,13 @@ func BuildBoardPages(board *gcsql.Board) error {
 				Caller().Send()
 			return fmt.Errorf("failed building /%s/ boardpage: %s", board.Dir, err.Error())
 		}
-
-		// Collect up threads for this page.
-		page := catalogPage{}
-		page.PageNum = catalog.currentPage
-		catalogPages.pages = append(catalogPages.pages, page)
+		if err = currentPageFile.Commit(); err != nil {
+			errEv.Err(err).Caller().
+				Str("page", pageFilename).Send()
+			return fmt.Errorf("failed writing /%s/%s: %s", board.Dir, pageFilename, err.Error())
+		}
 	}
+	removeExtraBoardPages(board, catalog.currentPage)
 
 	var catalogJSON []byte
 	if catalogJSON, err = json.Marshal(catalog.pages); err != nil {
@@ -282,9 +319,24 @@ func BuildBoardPages(board *gcsql.Board) error {
 			Caller().Msg("Failed writing catalog.json")
 		return fmt.Errorf("failed writing /%s/catalog.json: %s", board.Dir, err.Error())
 	}
+	if err = catalogJSONFile.Commit(); err != nil {
+		errEv.Err(err).
+			Caller().Msg("Failed writing catalog.json")
+		return fmt.Errorf("failed writing /%s/catalog.json: %s", board.Dir, err.Error())
+	}
 	return nil
 }
 
+// removeExtraBoardPages deletes the board pages after numPages that were left over from when the
+// board had more threads
+func removeExtraBoardPages(board *gcsql.Board, numPages int) {
+	for p := numPages + 1; ; p++ {
+		if err := os.Remove(board.AbsolutePath(strconv.Itoa(p) + ".html")); err != nil {
+			return
+		}
+	}
+}
+
 // BuildBoards builds the specified board IDs, or all boards if no arguments are passed
 // it returns any errors that were encountered
 func BuildBoards(verbose bool, which ...int) error {
@@ -339,70 +391,6 @@ func buildBoard(board *gcsql.Board, force bool) error {
 		return ErrNoBoardTitle
 	}
 
-	oldPosts, err := board.DeleteOldThreads()
-	if err != nil {
-		errEv.Err(err).Caller().Msg("Unable to delete old threads")
-		return err
-	}
-	boardDir := path.Join(config.GetSystemCriticalConfig().DocumentRoot, board.Dir)
-	for _, postID := range oldPosts {
-		post, err := gcsql.GetPostFromID(postID, false)
-		if err != nil {
-			errEv.Err(err).Caller().
-				Int("postID", postID).
-				Msg("Unable to get post")
-			return err
-		}
-		upload, err := post.GetUpload()
-		if err != nil {
-			errEv.Err(err).Caller().
-				Int("postID", postID).
-				Msg("Unable to get post uploads")
-			return err
-		}
-		var filePath string
-		if upload != nil {
-			filePath = path.Join(boardDir, "src", upload.Filename)
-			if err = os.Remove(filePath); err != nil {
-				errEv.Err(err).Caller().
-					Int("postID", postID).
-					Str("upload", filePath).Send()
-				return err
-			}
-			filePath = path.Join(boardDir, "thumb", upload.ThumbnailPath("thumbnail"))
-			if err = os.Remove(filePath); err != nil {
-				errEv.Err(err).Caller().
-					Int("postID", postID).
-					Str("upload", filePath).Send()
-				return err
-			}
-			if post.IsTopPost && board.EnableCatalog {
-				filePath = path.Join(boardDir, "thumb", upload.ThumbnailPath("catalog"))
-				if err = os.Remove(filePath); err != nil {
-					errEv.Err(err).Caller().
-						Int("postID", postID).
-						Str("upload", filePath).Send()
-					return err
-				}
-			}
-		}
-
-		if err = post.UnlinkUploads(false); err != nil {
-			errEv.Err(err).Caller().
-				Int("postID", postID).Send()
-			return err
-		}
-		if post.IsTopPost {
-			filePath = path.Join(boardDir, "res", strconv.Itoa(post.ID)+".html")
-			if err = os.Remove(filePath); err != nil {
-				errEv.Err(err).Caller().
-					Int("postID", postID).
-					Str("threadFile", filePath).Send()
-				return err
-			}
-		}
-	}
-
 	dirPath := board.AbsolutePath()
 	resPath := board.AbsolutePath("res")
 	srcPath := board.AbsolutePath("src")
@@ -510,9 +498,15 @@ func buildBoard(board *gcsql.Board, force bool) error {
 		return fmt.Errorf(genericErrStr, thumbPath, err.Error())
 	}
 
+	if _, err = pruneOldThreads(board, errEv); err != nil {
+		return err
+	}
 	if err = BuildBoardPages(board); err != nil {
 		return err
 	}
+	if err = BuildBoardArchive(board); err != nil {
+		return err
+	}
 	if err = BuildThreads(true, board.ID, 0); err != nil {
 		errEv.Err(err).Caller().Send()
 		return err
@@ -539,7 +533,7 @@ func buildBoard(board *gcsql.Board, force bool) error {
 // BuildBoardListJSON generates a JSON file with info about the boards
 func BuildBoardListJSON() error {
 	boardsJsonPath := path.Join(config.GetSystemCriticalConfig().DocumentRoot, "boards.json")
-	boardListFile, err := os.OpenFile(boardsJsonPath, os.O_CREATE|os.O_RDWR|os.O_TRUNC, config.GC_FILE_MODE)
+	boardListFile, err := createAtomicFile(boardsJsonPath)
 	errEv := gcutil.LogError(nil).Str("building", "boards.json")
 	defer errEv.Discard()
 	if err != nil {
@@ -548,16 +542,35 @@ func BuildBoardListJSON() error {
 	}
 	defer boardListFile.Close()
 
-	if err = config.TakeOwnershipOfFile(boardListFile); err != nil {
+	if err = config.TakeOwnershipOfFile(boardListFile.File); err != nil {
 		errEv.Err(err).Caller().Send()
 		return errors.New("unable to update boards.json ownership: " + err.Error())
 	}
 
-	boardsMap := map[string][]boardJSON{
+	boardsJSON, err := json.Marshal(GetBoardListJSON())
+	if err != nil {
+		errEv.Err(err).Caller().Send()
+		return errors.New("Failed to create boards.json: " + err.Error())
+	}
+
+	if _, err = serverutil.MinifyWriter(boardListFile, boardsJSON, "application/json"); err != nil {
+		errEv.Err(err).Caller().Send()
+		return errors.New("Failed writing boards.json file: " + err.Error())
+	}
+	if err = boardListFile.Commit(); err != nil {
+		errEv.Err(err).Caller().Send()
+		return errors.New("Failed writing boards.json file: " + err.Error())
+	}
+	return nil
+}
+
+// GetBoardListJSON returns info about all of the boards, in the same format as boards.json
+func GetBoardListJSON() map[string][]BoardJSON {
+	boardsMap := map[string][]BoardJSON{
 		"boards": {},
 	}
 	for _, board := range gcsql.AllBoards {
-		boardsMap["boards"] = append(boardsMap["boards"], boardJSON{
+		boardsMap["boards"] = append(boardsMap["boards"], BoardJSON{
 			Dir:             board.Dir,
 			Title:           board.Title,
 			Subtitle:        board.Subtitle,
@@ -572,15 +585,5 @@ func BuildBoardListJSON() error {
 	}
 
 	// TODO: properly check if the board is in a hidden section
-	boardJSON, err := json.Marshal(boardsMap)
-	if err != nil {
-		errEv.Err(err).Caller().Send()
-		return errors.New("Failed to create boards.json: " + err.Error())
-	}
-
-	if _, err = serverutil.MinifyWriter(boardListFile, boardJSON, "application/json"); err != nil {
-		errEv.Err(err).Caller().Send()
-		return errors.New("Failed writing boards.json file: " + err.Error())
-	}
-	return nil
+	return boardsMap
 }
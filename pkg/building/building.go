@@ -4,7 +4,6 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"os"
 	"path"
 	"regexp"
 	"strconv"
@@ -103,16 +102,14 @@ func BuildFrontPage() error {
 		return errors.New("Error loading front page template: " + err.Error())
 	}
 	criticalCfg := config.GetSystemCriticalConfig()
-	os.Remove(path.Join(criticalCfg.DocumentRoot, "index.html"))
-
-	frontFile, err := os.OpenFile(path.Join(criticalCfg.DocumentRoot, "index.html"), os.O_CREATE|os.O_RDWR|os.O_TRUNC, config.GC_FILE_MODE)
+	frontFile, err := createAtomicFile(path.Join(criticalCfg.DocumentRoot, "index.html"))
 	if err != nil {
 		errEv.Err(err).Caller().Send()
 		return errors.New("Failed opening front page for writing: " + err.Error())
 	}
 	defer frontFile.Close()
 
-	if err = config.TakeOwnershipOfFile(frontFile); err != nil {
+	if err = config.TakeOwnershipOfFile(frontFile.File); err != nil {
 		errEv.Err(err).Caller().Send()
 		return errors.New("Failed setting file ownership for front page: " + err.Error())
 	}
@@ -135,6 +132,10 @@ func BuildFrontPage() error {
 		errEv.Err(err).Caller().Send()
 		return errors.New("Failed executing front page template: " + err.Error())
 	}
+	if err = frontFile.Commit(); err != nil {
+		errEv.Err(err).Caller().Send()
+		return errors.New("Failed writing front page: " + err.Error())
+	}
 	return nil
 }
 
@@ -175,14 +176,14 @@ func BuildJS() error {
 	boardCfg := config.GetBoardConfig("")
 	criticalCfg := config.GetSystemCriticalConfig()
 	constsJSPath := path.Join(criticalCfg.DocumentRoot, "js", "consts.js")
-	constsJSFile, err := os.OpenFile(constsJSPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, config.GC_FILE_MODE)
+	constsJSFile, err := createAtomicFile(constsJSPath)
 	if err != nil {
 		errEv.Err(err).Caller().Send()
 		return fmt.Errorf("error opening consts.js for writing: %s", err.Error())
 	}
 	defer constsJSFile.Close()
 
-	if err = config.TakeOwnershipOfFile(constsJSFile); err != nil {
+	if err = config.TakeOwnershipOfFile(constsJSFile.File); err != nil {
 		errEv.Err(err).Caller().Send()
 		return fmt.Errorf("unable to update file ownership for consts.js: %s", err.Error())
 	}
@@ -198,5 +199,9 @@ func BuildJS() error {
 		errEv.Err(err).Caller().Send()
 		return fmt.Errorf("error building consts.js: %s", err.Error())
 	}
+	if err = constsJSFile.Commit(); err != nil {
+		errEv.Err(err).Caller().Send()
+		return fmt.Errorf("error writing consts.js: %s", err.Error())
+	}
 	return nil
 }
@@ -2,7 +2,6 @@ package building
 
 import (
 	"fmt"
-	"os"
 	"path"
 	"time"
 
@@ -13,7 +12,7 @@ import (
 	"github.com/gochan-org/gochan/pkg/server/serverutil"
 )
 
-type catalogThreadData struct {
+type CatalogThread struct {
 	Post
 	Replies       int    `json:"replies"`
 	Images        int    `json:"images"`
@@ -25,28 +24,28 @@ type catalogThreadData struct {
 	uploads       []gcsql.Upload
 }
 
-type catalogPage struct {
-	PageNum int                 `json:"page"`
-	Threads []catalogThreadData `json:"threads"`
+type CatalogPage struct {
+	PageNum int             `json:"page"`
+	Threads []CatalogThread `json:"threads"`
 }
 
 type boardCatalog struct {
-	pages       []catalogPage // this array gets marshalled, not the boardCatalog object
+	pages       []CatalogPage // this array gets marshalled, not the boardCatalog object
 	numPages    int
 	currentPage int
 }
 
 // fillPages fills the catalog's pages array with pages of the specified size, with the remainder
 // on the last page
-func (catalog *boardCatalog) fillPages(threadsPerPage int, threads []catalogThreadData) {
-	catalog.pages = []catalogPage{} // clear the array if it isn't already
+func (catalog *boardCatalog) fillPages(threadsPerPage int, threads []CatalogThread) {
+	catalog.pages = []CatalogPage{} // clear the array if it isn't already
 	catalog.numPages = len(threads) / threadsPerPage
 	remainder := len(threads) % threadsPerPage
 	currentThreadIndex := 0
 	var i int
 	for i = 0; i < catalog.numPages; i++ {
 		catalog.pages = append(catalog.pages,
-			catalogPage{
+			CatalogPage{
 				PageNum: i + 1,
 				Threads: threads[currentThreadIndex : currentThreadIndex+threadsPerPage],
 			},
@@ -55,7 +54,7 @@ func (catalog *boardCatalog) fillPages(threadsPerPage int, threads []catalogThre
 	}
 	if remainder > 0 {
 		catalog.pages = append(catalog.pages,
-			catalogPage{
+			CatalogPage{
 				PageNum: i + 1,
 				Threads: threads[len(threads)-remainder:],
 			},
@@ -64,7 +63,7 @@ func (catalog *boardCatalog) fillPages(threadsPerPage int, threads []catalogThre
 }
 
 func getBoardTopPosts(boardID int) ([]Post, error) {
-	const query = postQueryBase + " AND is_top_post AND t.board_id = ? ORDER BY t.stickied DESC, last_bump DESC"
+	const query = postQueryBase + " AND is_top_post AND t.board_id = ? AND t.is_archived = FALSE ORDER BY t.stickied DESC, last_bump DESC"
 	rows, err := gcsql.QuerySQL(query, boardID)
 	if err != nil {
 		return nil, err
@@ -79,7 +78,7 @@ func getBoardTopPosts(boardID int) ([]Post, error) {
 			&post.LastModified, &post.ParentID, &lastBump, &post.Message, &post.MessageRaw, &post.BoardDir,
 			&post.OriginalFilename, &post.Filename, &post.Checksum, &post.Filesize,
 			&post.ThumbnailWidth, &post.ThumbnailHeight, &post.UploadWidth, &post.UploadHeight,
-			&post.thread.Locked, &post.thread.Stickied,
+			&post.thread.Locked, &post.thread.Stickied, &post.thread.IsArchived, &post.IsDeleted,
 		)
 		if err != nil {
 			return nil, err
@@ -87,7 +86,7 @@ func getBoardTopPosts(boardID int) ([]Post, error) {
 		post.IsTopPost = post.ParentID == 0 || post.ParentID == post.ID
 		posts = append(posts, post)
 	}
-	return posts, nil
+	return posts, attachExtraFiles(posts)
 }
 
 // BuildCatalog builds the catalog for a board with a given id
@@ -110,14 +109,14 @@ func BuildCatalog(boardID int) error {
 	errEv.Str("boardDir", board.Dir)
 	criticalCfg := config.GetSystemCriticalConfig()
 	catalogPath := path.Join(criticalCfg.DocumentRoot, board.Dir, "catalog.html")
-	catalogFile, err := os.OpenFile(catalogPath, os.O_CREATE|os.O_RDWR|os.O_TRUNC, config.GC_FILE_MODE)
+	catalogFile, err := createAtomicFile(catalogPath)
 	if err != nil {
 		errEv.Err(err).Caller().Send()
 		return fmt.Errorf("failed opening /%s/catalog.html: %s", board.Dir, err.Error())
 	}
 	defer catalogFile.Close()
 
-	if err = config.TakeOwnershipOfFile(catalogFile); err != nil {
+	if err = config.TakeOwnershipOfFile(catalogFile.File); err != nil {
 		errEv.Err(err).Caller().Send()
 		return fmt.Errorf("failed taking ownership of /%s/catalog.html: %s", board.Dir, err.Error())
 	}
@@ -139,5 +138,9 @@ func BuildCatalog(boardID int) error {
 		errEv.Err(err).Caller().Send()
 		return fmt.Errorf("failed building catalog for /%s/: %s", board.Dir, err.Error())
 	}
+	if err = catalogFile.Commit(); err != nil {
+		errEv.Err(err).Caller().Send()
+		return fmt.Errorf("failed writing /%s/catalog.html: %s", board.Dir, err.Error())
+	}
 	return nil
 }
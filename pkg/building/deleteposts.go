@@ -0,0 +1,129 @@
+package building
+
+import (
+	"errors"
+	"os"
+	"path"
+	"strconv"
+
+	"github.com/gochan-org/gochan/pkg/events"
+	"github.com/gochan-org/gochan/pkg/gcsql"
+	"github.com/gochan-org/gochan/pkg/gcutil"
+)
+
+// DeletePost deletes the post, or the whole thread if it is a top post, along with the uploads of the deleted
+// posts and the thread's pages. The board pages are not rebuilt, so that multiple posts can be deleted before
+// rebuilding them with BuildBoards
+func DeletePost(post *gcsql.Post, board *gcsql.Board) error {
+	errEv := gcutil.LogError(nil).
+		Int("postID", post.ID).
+		Str("boardDir", board.Dir)
+	defer errEv.Discard()
+
+	thread, err := gcsql.GetThread(post.ThreadID)
+	if err != nil {
+		errEv.Err(err).Caller().Msg("Unable to get thread info")
+		return err
+	}
+	postIDs := []int{post.ID}
+	if post.IsTopPost {
+		posts, err := thread.GetPosts(true, false, 0)
+		if err != nil {
+			errEv.Err(err).Caller().Msg("Unable to get thread replies")
+			return err
+		}
+		for _, reply := range posts {
+			postIDs = append(postIDs, reply.ID)
+		}
+	}
+	if err = post.Delete(); err != nil {
+		errEv.Err(err).Caller().Msg("Unable to delete post")
+		return err
+	}
+	resDir := "res"
+	if thread.IsArchived {
+		resDir = path.Join("arch", "res")
+	}
+	return removePostFiles(board, postIDs, resDir, errEv)
+}
+
+// PurgeIPPosts removes the posts (or only their uploads) selected by the purge, and creates the ban in the
+// same transaction if it isn't nil. The uploads and thread pages of removed posts are deleted from the disk,
+// and the affected boards and remaining threads are queued to be rebuilt once, after everything is removed.
+// It returns the posts that were removed
+func PurgeIPPosts(purge *gcsql.IPPurge, ban *gcsql.IPBan) ([]gcsql.PurgeablePost, error) {
+	errEv := gcutil.LogError(nil).
+		Str("ipRange", purge.IPRange)
+	defer errEv.Discard()
+
+	posts, err := gcsql.GetIPPurgePosts(purge)
+	if err != nil {
+		errEv.Err(err).Caller().Msg("Unable to get posts to purge")
+		return nil, err
+	}
+	removedUploads, err := gcsql.PurgePosts(posts, purge.FilesOnly, ban)
+	if err != nil {
+		errEv.Err(err).Caller().Msg("Unable to purge posts")
+		return nil, err
+	}
+
+	boards := make(map[int]*gcsql.Board)
+	deletedThreads := make(map[int]bool)
+	archiveChanged := make(map[int]bool)
+	for _, post := range posts {
+		board, ok := boards[post.BoardID]
+		if !ok {
+			if board, err = gcsql.GetBoardFromID(post.BoardID); err != nil {
+				errEv.Err(err).Caller().
+					Int("boardID", post.BoardID).
+					Msg("Unable to get board info")
+				return nil, err
+			}
+			boards[board.ID] = board
+		}
+		for _, upload := range removedUploads[post.ID] {
+			if err = removeUploadFiles(board, &upload, upload.PostID == post.TopPostID); err != nil {
+				errEv.Err(err).Caller().
+					Int("postID", upload.PostID).
+					Str("upload", upload.Filename).Send()
+			}
+		}
+		if post.IsTopPost && !purge.FilesOnly {
+			deletedThreads[post.ThreadID] = true
+			resDir := "res"
+			if post.ThreadArchived {
+				resDir = path.Join("arch", "res")
+				archiveChanged[board.ID] = true
+			}
+			for _, ext := range []string{".html", ".json"} {
+				filePath := path.Join(board.AbsolutePath(), resDir, strconv.Itoa(post.ID)+ext)
+				if err = os.Remove(filePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+					errEv.Err(err).Caller().
+						Str("threadFile", filePath).Send()
+				}
+			}
+		}
+		events.TriggerEvent("post-deleted", &post.Post, board, purge.FilesOnly)
+	}
+
+	queuedThreads := make(map[int]bool)
+	for _, post := range posts {
+		if deletedThreads[post.ThreadID] || queuedThreads[post.ThreadID] {
+			continue
+		}
+		queuedThreads[post.ThreadID] = true
+		QueueThreadPages(boards[post.BoardID], post.TopPostID)
+	}
+	for _, board := range boards {
+		QueueBoardPages(board)
+		QueueCatalog(board)
+		if archiveChanged[board.ID] {
+			if err = BuildBoardArchive(board); err != nil {
+				errEv.Err(err).Caller().
+					Str("boardDir", board.Dir).
+					Msg("Unable to rebuild board archive")
+			}
+		}
+	}
+	return posts, nil
+}
@@ -0,0 +1,54 @@
+package building
+
+import (
+	"os"
+	"path"
+
+	"github.com/gochan-org/gochan/pkg/config"
+)
+
+// atomicFile is written to a temporary file in the same directory as the destination, which is renamed to
+// the destination when it is committed so that the web server never serves a partially written page
+type atomicFile struct {
+	*os.File
+	dest      string
+	committed bool
+}
+
+// createAtomicFile creates a temporary file that replaces dest when Commit is called. The temporary file is
+// deleted if Close is called before Commit, so it is safe to defer Close after a successful call
+func createAtomicFile(dest string) (*atomicFile, error) {
+	file, err := os.CreateTemp(path.Dir(dest), "."+path.Base(dest)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	if err = file.Chmod(config.GC_FILE_MODE); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	return &atomicFile{File: file, dest: dest}, nil
+}
+
+// Commit closes the temporary file and moves it to the destination
+func (f *atomicFile) Commit() error {
+	if err := f.File.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), f.dest); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	f.committed = true
+	return nil
+}
+
+// Close closes and deletes the temporary file if it hasn't been committed
+func (f *atomicFile) Close() error {
+	if f.committed {
+		return nil
+	}
+	f.File.Close()
+	return os.Remove(f.Name())
+}
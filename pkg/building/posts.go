@@ -12,7 +12,8 @@ import (
 )
 
 const (
-	postQueryBase = `SELECT DBPREFIXposts.id, DBPREFIXposts.thread_id, ip, name, tripcode, email, subject, created_on, created_on as last_modified,
+	// postQuerySelect selects posts with the columns used by Post, without any conditions
+	postQuerySelect = `SELECT DBPREFIXposts.id, DBPREFIXposts.thread_id, ip, name, tripcode, email, subject, created_on, DBPREFIXposts.last_modified,
 	p.id AS parent_id, t.last_bump as last_bump,
 	message, message_raw,
 	(SELECT dir FROM DBPREFIXboards WHERE id = t.board_id LIMIT 1) AS dir,
@@ -25,16 +26,18 @@ const (
 	coalesce(DBPREFIXfiles.width,0) AS width,
 	coalesce(DBPREFIXfiles.height,0) AS height,
 	t.locked as locked,
-	t.stickied as stickied
+	t.stickied as stickied,
+	t.is_archived as is_archived,
+	DBPREFIXposts.is_deleted as is_deleted
 	FROM DBPREFIXposts
-	LEFT JOIN DBPREFIXfiles ON DBPREFIXfiles.post_id = DBPREFIXposts.id AND is_deleted = FALSE
+	LEFT JOIN DBPREFIXfiles ON DBPREFIXfiles.post_id = DBPREFIXposts.id AND DBPREFIXfiles.file_order = 0 AND is_deleted = FALSE
 	LEFT JOIN (
-		SELECT id, board_id, last_bump, locked, stickied FROM DBPREFIXthreads
+		SELECT id, board_id, last_bump, locked, stickied, is_archived FROM DBPREFIXthreads
 	) t ON t.id = DBPREFIXposts.thread_id
 	INNER JOIN (
 		SELECT id, thread_id FROM DBPREFIXposts WHERE is_top_post
-	) p on p.thread_id = DBPREFIXposts.thread_id
-	WHERE is_deleted = FALSE `
+	) p on p.thread_id = DBPREFIXposts.thread_id `
+	postQueryBase = postQuerySelect + "WHERE is_deleted = FALSE "
 )
 
 func truncateString(msg string, limit int, ellipsis bool) string {
@@ -47,6 +50,51 @@ func truncateString(msg string, limit int, ellipsis bool) string {
 	return msg
 }
 
+// PostFile represents an additional upload attached to a post. The first upload is stored in the
+// Post fields to stay compatible with the single-upload JSON format
+type PostFile struct {
+	Filename         string `json:"tim"`
+	OriginalFilename string `json:"filename"`
+	Checksum         string `json:"md5"`
+	Extension        string `json:"extension"`
+	Filesize         int    `json:"fsize"`
+	UploadWidth      int    `json:"w"`
+	UploadHeight     int    `json:"h"`
+	ThumbnailWidth   int    `json:"tn_w"`
+	ThumbnailHeight  int    `json:"tn_h"`
+	boardDir         string
+}
+
+func (f PostFile) ThumbnailPath() string {
+	if f.Filename == "" {
+		return ""
+	}
+	return config.WebPath(f.boardDir, "thumb", gcutil.GetThumbnailPath("reply", f.Filename))
+}
+
+func (f PostFile) UploadPath() string {
+	if f.Filename == "" {
+		return ""
+	}
+	return config.WebPath(f.boardDir, "src", f.Filename)
+}
+
+// PostReply is a post that links to another post, shown in the linked post's list of replies
+type PostReply struct {
+	ID         int    `json:"no"`
+	BoardDir   string `json:"board"`
+	TopPostID  int    `json:"resto"`
+	IsArchived bool   `json:"-"`
+}
+
+// WebPath returns the path to the reply in its thread
+func (r PostReply) WebPath() string {
+	if r.IsArchived {
+		return config.WebPath(r.BoardDir, "arch", "res", strconv.Itoa(r.TopPostID)+".html") + "#" + strconv.Itoa(r.ID)
+	}
+	return config.WebPath(r.BoardDir, "res", strconv.Itoa(r.TopPostID)+".html") + "#" + strconv.Itoa(r.ID)
+}
+
 type Post struct {
 	ID               int           `json:"no"`
 	ParentID         int           `json:"resto"`
@@ -72,6 +120,9 @@ type Post struct {
 	Capcode          string        `json:"capcode"`
 	Timestamp        time.Time     `json:"time"`
 	LastModified     string        `json:"last_modified"`
+	ExtraFiles       []PostFile    `json:"extra_files,omitempty"`
+	Replies          []PostReply   `json:"replies,omitempty"`
+	IsDeleted        bool          `json:"-"`
 	thread           gcsql.Thread
 }
 
@@ -92,6 +143,9 @@ func (p Post) ThreadPath() string {
 	if threadID == 0 {
 		threadID = p.ID
 	}
+	if p.thread.IsArchived {
+		return config.WebPath(p.BoardDir, "arch", "res", strconv.Itoa(threadID)+".html")
+	}
 	return config.WebPath(p.BoardDir, "res", strconv.Itoa(threadID)+".html")
 }
 
@@ -113,6 +167,26 @@ func (p Post) UploadPath() string {
 	return config.WebPath(p.BoardDir, "src", p.Filename)
 }
 
+// Files returns all of the post's uploads (if it has any), including the first one
+func (p Post) Files() []PostFile {
+	if p.Filename == "" {
+		return nil
+	}
+	files := []PostFile{{
+		Filename:         p.Filename,
+		OriginalFilename: p.OriginalFilename,
+		Checksum:         p.Checksum,
+		Extension:        p.Extension,
+		Filesize:         p.Filesize,
+		UploadWidth:      p.UploadWidth,
+		UploadHeight:     p.UploadHeight,
+		ThumbnailWidth:   p.ThumbnailWidth,
+		ThumbnailHeight:  p.ThumbnailHeight,
+		boardDir:         p.BoardDir,
+	}}
+	return append(files, p.ExtraFiles...)
+}
+
 func (p *Post) Locked() bool {
 	return p.thread.Locked
 }
@@ -121,23 +195,31 @@ func (p *Post) Stickied() bool {
 	return p.thread.Stickied
 }
 
+func (p *Post) Archived() bool {
+	return p.thread.IsArchived
+}
+
 func GetBuildablePost(id int, boardid int) (*Post, error) {
 	const query = postQueryBase + " AND DBPREFIXposts.id = ?"
 	var post Post
 	var lastBump time.Time
 	err := gcsql.QueryRowSQL(query, []interface{}{id}, []interface{}{
 		&post.ID, &post.thread.ID, &post.IP, &post.Name, &post.Tripcode, &post.Email, &post.Subject, &post.Timestamp,
-		&post.LastModified, &post.ParentID, lastBump, &post.Message, &post.MessageRaw, &post.BoardID, &post.BoardDir,
+		&post.LastModified, &post.ParentID, &lastBump, &post.Message, &post.MessageRaw, &post.BoardDir,
 		&post.OriginalFilename, &post.Filename, &post.Checksum, &post.Filesize,
 		&post.ThumbnailWidth, &post.ThumbnailHeight, &post.UploadWidth, &post.UploadHeight,
-		&post.thread.Locked, &post.thread.Stickied,
+		&post.thread.Locked, &post.thread.Stickied, &post.thread.IsArchived, &post.IsDeleted,
 	})
 	if err != nil {
 		return nil, err
 	}
 	post.IsTopPost = post.ParentID == 0
 	post.Extension = path.Ext(post.Filename)
-	return &post, nil
+	posts := []Post{post}
+	if err = attachPostDetails(posts); err != nil {
+		return nil, err
+	}
+	return &posts[0], nil
 }
 
 func GetBuildablePostsByIP(ip string, limit int) ([]Post, error) {
@@ -159,7 +241,7 @@ func GetBuildablePostsByIP(ip string, limit int) ([]Post, error) {
 			&post.LastModified, &post.ParentID, &lastBump, &post.Message, &post.MessageRaw, &post.BoardDir,
 			&post.OriginalFilename, &post.Filename, &post.Checksum, &post.Filesize,
 			&post.ThumbnailWidth, &post.ThumbnailHeight, &post.UploadWidth, &post.UploadHeight,
-			&post.thread.Locked, &post.thread.Stickied,
+			&post.thread.Locked, &post.thread.Stickied, &post.thread.IsArchived, &post.IsDeleted,
 		); err != nil {
 			return nil, err
 		}
@@ -167,10 +249,10 @@ func GetBuildablePostsByIP(ip string, limit int) ([]Post, error) {
 		post.Extension = path.Ext(post.Filename)
 		posts = append(posts, post)
 	}
-	return posts, nil
+	return posts, attachPostDetails(posts)
 }
 
-func getThreadPosts(thread *gcsql.Thread) ([]Post, error) {
+func GetThreadPosts(thread *gcsql.Thread) ([]Post, error) {
 	const query = postQueryBase + " AND DBPREFIXposts.thread_id = ? ORDER BY DBPREFIXposts.id ASC"
 	rows, err := gcsql.QuerySQL(query, thread.ID)
 	if err != nil {
@@ -186,7 +268,7 @@ func getThreadPosts(thread *gcsql.Thread) ([]Post, error) {
 			&post.LastModified, &post.ParentID, &lastBump, &post.Message, &post.MessageRaw, &post.BoardDir,
 			&post.OriginalFilename, &post.Filename, &post.Checksum, &post.Filesize,
 			&post.ThumbnailWidth, &post.ThumbnailHeight, &post.UploadWidth, &post.UploadHeight,
-			&post.thread.Locked, &post.thread.Stickied,
+			&post.thread.Locked, &post.thread.Stickied, &post.thread.IsArchived, &post.IsDeleted,
 		)
 		if err != nil {
 			return nil, err
@@ -194,7 +276,7 @@ func getThreadPosts(thread *gcsql.Thread) ([]Post, error) {
 		post.IsTopPost = post.ParentID == 0 || post.ParentID == post.ID
 		posts = append(posts, post)
 	}
-	return posts, nil
+	return posts, attachPostDetails(posts)
 }
 
 func GetRecentPosts(boardid int, limit int) ([]Post, error) {
@@ -202,7 +284,7 @@ func GetRecentPosts(boardid int, limit int) ([]Post, error) {
 	var args []interface{} = []interface{}{}
 
 	if boardid > 0 {
-		query += " WHERE t.board_id = ?"
+		query += " AND t.board_id = ?"
 		args = append(args, boardid)
 	}
 
@@ -216,20 +298,86 @@ func GetRecentPosts(boardid int, limit int) ([]Post, error) {
 	var lastBump time.Time
 	for rows.Next() {
 		var post Post
-		var threadID int
 		err = rows.Scan(
-			&post.ID, &threadID, &post.IP, &post.Name, &post.Tripcode, &post.Email, &post.Subject, &post.Timestamp,
+			&post.ID, &post.thread.ID, &post.IP, &post.Name, &post.Tripcode, &post.Email, &post.Subject, &post.Timestamp,
 			&post.LastModified, &post.ParentID, &lastBump, &post.Message, &post.MessageRaw, &post.BoardDir,
 			&post.OriginalFilename, &post.Filename, &post.Checksum, &post.Filesize,
 			&post.ThumbnailWidth, &post.ThumbnailHeight, &post.UploadWidth, &post.UploadHeight,
+			&post.thread.Locked, &post.thread.Stickied, &post.thread.IsArchived, &post.IsDeleted,
 		)
 		if err != nil {
 			return nil, err
 		}
-		if boardid == 0 || post.BoardID == boardid {
-			post.Extension = path.Ext(post.Filename)
-			posts = append(posts, post)
+		post.IsTopPost = post.ParentID == 0 || post.ParentID == post.ID
+		post.Extension = path.Ext(post.Filename)
+		posts = append(posts, post)
+	}
+	return posts, attachPostDetails(posts)
+}
+
+// attachPostDetails sets the extra uploads and replies of each of the posts
+func attachPostDetails(posts []Post) error {
+	if err := attachExtraFiles(posts); err != nil {
+		return err
+	}
+	return attachReplies(posts)
+}
+
+// attachReplies gets the posts that link to each of the posts and sets their Replies fields
+func attachReplies(posts []Post) error {
+	postIDs := make([]interface{}, len(posts))
+	for p, post := range posts {
+		postIDs[p] = post.ID
+	}
+	replies, err := gcsql.GetPostReplies(postIDs...)
+	if err != nil {
+		return err
+	}
+	for p := range posts {
+		for _, reply := range replies[posts[p].ID] {
+			posts[p].Replies = append(posts[p].Replies, PostReply{
+				ID:         reply.ID,
+				BoardDir:   reply.BoardDir,
+				TopPostID:  reply.TopPostID,
+				IsArchived: reply.IsArchived,
+			})
+		}
+	}
+	return nil
+}
+
+// attachExtraFiles gets any uploads after the first one for each of the posts and sets their
+// ExtraFiles fields
+func attachExtraFiles(posts []Post) error {
+	var postIDs []interface{}
+	for _, post := range posts {
+		if post.Filename != "" {
+			postIDs = append(postIDs, post.ID)
+		}
+	}
+	uploads, err := gcsql.GetPostsUploads(postIDs...)
+	if err != nil {
+		return err
+	}
+	for p := range posts {
+		for _, upload := range uploads[posts[p].ID] {
+			if upload.FileOrder == 0 {
+				// the first upload is already stored in the post fields
+				continue
+			}
+			posts[p].ExtraFiles = append(posts[p].ExtraFiles, PostFile{
+				Filename:         upload.Filename,
+				OriginalFilename: upload.OriginalFilename,
+				Checksum:         upload.Checksum,
+				Extension:        path.Ext(upload.Filename),
+				Filesize:         upload.FileSize,
+				UploadWidth:      upload.Width,
+				UploadHeight:     upload.Height,
+				ThumbnailWidth:   upload.ThumbnailWidth,
+				ThumbnailHeight:  upload.ThumbnailHeight,
+				boardDir:         posts[p].BoardDir,
+			})
 		}
 	}
-	return posts, nil
+	return nil
 }
@@ -0,0 +1,282 @@
+package building
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gochan-org/gochan/pkg/gcsql"
+	"github.com/gochan-org/gochan/pkg/gcutil"
+)
+
+const (
+	// maxFailedBuildJobs is the number of failed jobs kept for the build queue manage page
+	maxFailedBuildJobs = 50
+)
+
+// BuildJobType is the kind of page(s) rebuilt by a queued build job
+type BuildJobType string
+
+const (
+	// BoardPagesJob prunes old threads and rebuilds the board's pages
+	BoardPagesJob BuildJobType = "board"
+	// ThreadPagesJob rebuilds a thread's HTML and JSON pages
+	ThreadPagesJob BuildJobType = "thread"
+	// CatalogJob rebuilds the board's catalog if it is enabled
+	CatalogJob BuildJobType = "catalog"
+	// FrontPageJob rebuilds the front page
+	FrontPageJob BuildJobType = "front"
+	// FullBoardJob creates the board's directories if they don't exist and rebuilds all of its pages,
+	// including its threads, archive, and catalog
+	FullBoardJob BuildJobType = "fullboard"
+)
+
+var (
+	buildQueue = &buildJobQueue{
+		pendingMap: map[string]*BuildJob{},
+		wake:       make(chan struct{}, 1),
+	}
+)
+
+// BuildJob is a rebuild request in the build queue. Requests for a job that is already pending are
+// merged into the pending job
+type BuildJob struct {
+	Type     BuildJobType
+	BoardID  int    `json:",omitempty"`
+	BoardDir string `json:",omitempty"`
+	// TopPostID is the ID of the thread's top post for ThreadPagesJob
+	TopPostID int `json:",omitempty"`
+	Queued    time.Time
+	// Requests is the number of times the job was requested before it started
+	Requests int
+	Started  time.Time
+	Finished time.Time
+	Error    string `json:",omitempty"`
+	done     chan struct{}
+}
+
+func (job *BuildJob) key() string {
+	switch job.Type {
+	case ThreadPagesJob:
+		return fmt.Sprintf("%s:%d", job.Type, job.TopPostID)
+	case FrontPageJob:
+		return string(job.Type)
+	default:
+		return fmt.Sprintf("%s:%d", job.Type, job.BoardID)
+	}
+}
+
+// String returns a description of the job that can be shown to staff
+func (job *BuildJob) String() string {
+	switch job.Type {
+	case BoardPagesJob:
+		return fmt.Sprintf("/%s/ board pages", job.BoardDir)
+	case ThreadPagesJob:
+		return fmt.Sprintf("/%s/ thread #%d", job.BoardDir, job.TopPostID)
+	case CatalogJob:
+		return fmt.Sprintf("/%s/ catalog", job.BoardDir)
+	case FrontPageJob:
+		return "Front page"
+	case FullBoardJob:
+		return fmt.Sprintf("/%s/ board and threads", job.BoardDir)
+	}
+	return string(job.Type)
+}
+
+// Wait blocks until the job has finished or the timeout is reached, and returns true if the job finished
+func (job *BuildJob) Wait(timeout time.Duration) bool {
+	select {
+	case <-job.done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func (job *BuildJob) run() (err error) {
+	defer func() {
+		if a := recover(); a != nil {
+			err = fmt.Errorf("recovered from panic: %v", a)
+		}
+	}()
+	switch job.Type {
+	case BoardPagesJob:
+		var board *gcsql.Board
+		if board, err = gcsql.GetBoardFromID(job.BoardID); err != nil {
+			return err
+		}
+		if err = PruneOldThreads(board); err != nil {
+			return err
+		}
+		return BuildBoardPages(board)
+	case ThreadPagesJob:
+		var op *gcsql.Post
+		if op, err = gcsql.GetPostFromID(job.TopPostID, true); err != nil {
+			return err
+		}
+		return BuildThreadPages(op)
+	case CatalogJob:
+		var board *gcsql.Board
+		if board, err = gcsql.GetBoardFromID(job.BoardID); err != nil {
+			return err
+		}
+		if !board.EnableCatalog {
+			return nil
+		}
+		return BuildCatalog(board.ID)
+	case FrontPageJob:
+		return BuildFrontPage()
+	case FullBoardJob:
+		var board *gcsql.Board
+		if board, err = gcsql.GetBoardFromID(job.BoardID); err != nil {
+			return err
+		}
+		return buildBoard(board, true)
+	}
+	return fmt.Errorf("unrecognized build job type %q", job.Type)
+}
+
+// BuildQueueStatus is a snapshot of the build queue
+type BuildQueueStatus struct {
+	Pending []BuildJob
+	Running *BuildJob `json:",omitempty"`
+	Failed  []BuildJob
+}
+
+type buildJobQueue struct {
+	lock       sync.Mutex
+	pending    []*BuildJob
+	pendingMap map[string]*BuildJob
+	running    *BuildJob
+	failed     []BuildJob
+	wake       chan struct{}
+	startOnce  sync.Once
+}
+
+func (q *buildJobQueue) enqueue(job *BuildJob) *BuildJob {
+	q.startOnce.Do(func() {
+		go q.work()
+	})
+	q.lock.Lock()
+	key := job.key()
+	if pending, ok := q.pendingMap[key]; ok {
+		// the pending job hasn't started yet so it will include whatever changed
+		pending.Requests++
+		q.lock.Unlock()
+		return pending
+	}
+	job.Queued = time.Now()
+	job.Requests = 1
+	job.done = make(chan struct{})
+	q.pending = append(q.pending, job)
+	q.pendingMap[key] = job
+	q.lock.Unlock()
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+		// the worker has already been woken up
+	}
+	return job
+}
+
+// next removes the oldest pending job from the queue and marks it as running, or returns nil if the
+// queue is empty
+func (q *buildJobQueue) next() *BuildJob {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if len(q.pending) == 0 {
+		return nil
+	}
+	job := q.pending[0]
+	q.pending[0] = nil
+	q.pending = q.pending[1:]
+	delete(q.pendingMap, job.key())
+	job.Started = time.Now()
+	q.running = job
+	return job
+}
+
+func (q *buildJobQueue) finish(job *BuildJob, err error) {
+	q.lock.Lock()
+	job.Finished = time.Now()
+	q.running = nil
+	if err != nil {
+		job.Error = err.Error()
+		q.failed = append(q.failed, *job)
+		if len(q.failed) > maxFailedBuildJobs {
+			q.failed = q.failed[len(q.failed)-maxFailedBuildJobs:]
+		}
+	}
+	q.lock.Unlock()
+	close(job.done)
+}
+
+// work runs the queued jobs one at a time, so that pages rebuilt through the queue are never built by two
+// jobs at the same time. Page rebuilds after posting, deleting, editing, and moving posts are queued, so that
+// they don't race with each other
+func (q *buildJobQueue) work() {
+	for range q.wake {
+		for job := q.next(); job != nil; job = q.next() {
+			err := job.run()
+			if err != nil {
+				gcutil.LogError(err).
+					Str("buildJob", job.String()).
+					Msg("Build job failed")
+			}
+			q.finish(job, err)
+		}
+	}
+}
+
+func (q *buildJobQueue) status() BuildQueueStatus {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	status := BuildQueueStatus{
+		Pending: make([]BuildJob, len(q.pending)),
+		Failed:  make([]BuildJob, len(q.failed)),
+	}
+	for j, job := range q.pending {
+		status.Pending[j] = *job
+	}
+	if q.running != nil {
+		running := *q.running
+		status.Running = &running
+	}
+	// newest failures first
+	for j, job := range q.failed {
+		status.Failed[len(q.failed)-1-j] = job
+	}
+	return status
+}
+
+// QueueBoardPages adds a job to the build queue to prune old threads from the board and rebuild its pages
+func QueueBoardPages(board *gcsql.Board) *BuildJob {
+	return buildQueue.enqueue(&BuildJob{Type: BoardPagesJob, BoardID: board.ID, BoardDir: board.Dir})
+}
+
+// QueueThreadPages adds a job to the build queue to rebuild the thread with the given top post ID
+func QueueThreadPages(board *gcsql.Board, topPostID int) *BuildJob {
+	return buildQueue.enqueue(&BuildJob{Type: ThreadPagesJob, BoardID: board.ID, BoardDir: board.Dir, TopPostID: topPostID})
+}
+
+// QueueCatalog adds a job to the build queue to rebuild the board's catalog
+func QueueCatalog(board *gcsql.Board) *BuildJob {
+	return buildQueue.enqueue(&BuildJob{Type: CatalogJob, BoardID: board.ID, BoardDir: board.Dir})
+}
+
+// QueueFrontPage adds a job to the build queue to rebuild the front page
+func QueueFrontPage() *BuildJob {
+	return buildQueue.enqueue(&BuildJob{Type: FrontPageJob})
+}
+
+// QueueFullBoard adds a job to the build queue to create the board's directories if needed and rebuild all
+// of its pages and threads
+func QueueFullBoard(board *gcsql.Board) *BuildJob {
+	return buildQueue.enqueue(&BuildJob{Type: FullBoardJob, BoardID: board.ID, BoardDir: board.Dir})
+}
+
+// GetBuildQueueStatus returns the pending, running, and recently failed build jobs
+func GetBuildQueueStatus() BuildQueueStatus {
+	return buildQueue.status()
+}
@@ -0,0 +1,129 @@
+package building
+
+import (
+	"errors"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gochan-org/gochan/pkg/gcsql"
+)
+
+const (
+	searchDateLayout = "2006-01-02"
+	// SearchResultsPerPage is the maximum number of posts shown on a search results page
+	SearchResultsPerPage = 50
+)
+
+var (
+	ErrInvalidSearchDate = errors.New("invalid date, expected YYYY-MM-DD")
+)
+
+// PostSearchFromRequest creates a post search from the request's form values (q, board, after, before,
+// hasfile, and oponly). Deleted posts are not included
+func PostSearchFromRequest(request *http.Request) (*gcsql.PostSearch, error) {
+	search := &gcsql.PostSearch{
+		Query:   strings.TrimSpace(request.FormValue("q")),
+		HasFile: request.FormValue("hasfile") != "",
+		OPOnly:  request.FormValue("oponly") != "",
+	}
+	var err error
+	if boardDir := request.FormValue("board"); boardDir != "" {
+		if search.BoardID, err = gcsql.GetBoardIDFromDir(boardDir); err != nil {
+			return nil, err
+		}
+	}
+	if after := request.FormValue("after"); after != "" {
+		if search.After, err = time.Parse(searchDateLayout, after); err != nil {
+			return nil, ErrInvalidSearchDate
+		}
+	}
+	if before := request.FormValue("before"); before != "" {
+		if search.Before, err = time.Parse(searchDateLayout, before); err != nil {
+			return nil, ErrInvalidSearchDate
+		}
+		// include posts made on the given day
+		search.Before = search.Before.AddDate(0, 0, 1)
+	}
+	return search, nil
+}
+
+// SearchPosts returns up to limit posts matching the search, newest first, skipping the first offset results
+func SearchPosts(search *gcsql.PostSearch, limit int, offset int) ([]Post, error) {
+	conditions, params := search.Conditions()
+	query := postQuerySelect + "WHERE " + conditions + " ORDER BY DBPREFIXposts.id DESC LIMIT " +
+		strconv.Itoa(limit) + " OFFSET " + strconv.Itoa(offset)
+	rows, err := gcsql.QuerySQL(query, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var posts []Post
+	var lastBump time.Time
+	for rows.Next() {
+		var post Post
+		if err = rows.Scan(
+			&post.ID, &post.thread.ID, &post.IP, &post.Name, &post.Tripcode, &post.Email, &post.Subject, &post.Timestamp,
+			&post.LastModified, &post.ParentID, &lastBump, &post.Message, &post.MessageRaw, &post.BoardDir,
+			&post.OriginalFilename, &post.Filename, &post.Checksum, &post.Filesize,
+			&post.ThumbnailWidth, &post.ThumbnailHeight, &post.UploadWidth, &post.UploadHeight,
+			&post.thread.Locked, &post.thread.Stickied, &post.thread.IsArchived, &post.IsDeleted,
+		); err != nil {
+			return nil, err
+		}
+		post.IsTopPost = post.ParentID == 0 || post.ParentID == post.ID
+		post.Extension = path.Ext(post.Filename)
+		posts = append(posts, post)
+	}
+	return posts, attachPostDetails(posts)
+}
+
+// SearchTemplateData performs the search (if the request has any search values) and returns the data used
+// by search_results.html. searchAction is the path that the search form is submitted to
+func SearchTemplateData(request *http.Request, search *gcsql.PostSearch, searchAction string, staffView bool) (map[string]interface{}, error) {
+	data := map[string]interface{}{
+		"boards":       gcsql.AllBoards,
+		"searchAction": searchAction,
+		"staffView":    staffView,
+		"search":       search,
+		"query":        search.Query,
+		"boardDir":     request.FormValue("board"),
+		"after":        request.FormValue("after"),
+		"before":       request.FormValue("before"),
+	}
+	var searched bool
+	for _, field := range []string{"q", "board", "after", "before", "hasfile", "oponly", "deleted"} {
+		if request.FormValue(field) != "" {
+			searched = true
+			break
+		}
+	}
+	if !searched {
+		return data, nil
+	}
+
+	page, err := strconv.Atoi(request.FormValue("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	// get one extra post to check if there is another page
+	posts, err := SearchPosts(search, SearchResultsPerPage+1, (page-1)*SearchResultsPerPage)
+	if err != nil {
+		return nil, err
+	}
+	values := request.URL.Query()
+	if len(posts) > SearchResultsPerPage {
+		posts = posts[:SearchResultsPerPage]
+		values.Set("page", strconv.Itoa(page+1))
+		data["nextPage"] = searchAction + "?" + values.Encode()
+	}
+	if page > 1 {
+		values.Set("page", strconv.Itoa(page-1))
+		data["prevPage"] = searchAction + "?" + values.Encode()
+	}
+	data["searched"] = true
+	data["posts"] = posts
+	return data, nil
+}
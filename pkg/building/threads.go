@@ -4,7 +4,6 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"os"
 	"path"
 	"strconv"
 
@@ -57,7 +56,7 @@ func BuildThreadPages(op *gcsql.Post) error {
 		errEv.Err(err).Caller().Send()
 		return err
 	}
-	var threadPageFile *os.File
+	var threadPageFile *atomicFile
 
 	board, err := op.GetBoard()
 	if err != nil {
@@ -73,58 +72,73 @@ func BuildThreadPages(op *gcsql.Post) error {
 		return errors.New("unable to get thread info: " + err.Error())
 	}
 
-	posts, err := getThreadPosts(thread)
+	posts, err := GetThreadPosts(thread)
 	if err != nil {
 		errEv.Err(err).Caller().Send()
 		return errors.New("failed building thread: " + err.Error())
 	}
 	criticalCfg := config.GetSystemCriticalConfig()
-	os.Remove(path.Join(criticalCfg.DocumentRoot, board.Dir, "res", strconv.Itoa(op.ID)+".html"))
-	os.Remove(path.Join(criticalCfg.DocumentRoot, board.Dir, "res", strconv.Itoa(op.ID)+".json"))
-
-	threadPageFilepath := path.Join(criticalCfg.DocumentRoot, board.Dir, "res", strconv.Itoa(op.ID)+".html")
-	threadPageFile, err = os.OpenFile(threadPageFilepath, os.O_CREATE|os.O_RDWR|os.O_TRUNC, config.GC_FILE_MODE)
+	resDir := "res"
+	if thread.IsArchived {
+		// archived threads are moved to /board/arch/res/
+		resDir = path.Join("arch", "res")
+		if err = createArchiveDirs(board); err != nil {
+			errEv.Err(err).Caller().Send()
+			return err
+		}
+	}
+	threadPageFilepath := path.Join(criticalCfg.DocumentRoot, board.Dir, resDir, strconv.Itoa(op.ID)+".html")
+	threadPageFile, err = createAtomicFile(threadPageFilepath)
 	if err != nil {
 		errEv.Err(err).Caller().Send()
-		return fmt.Errorf("unable to open /%s/res/%d.html: %s", board.Dir, op.ID, err.Error())
+		return fmt.Errorf("unable to open /%s/%s/%d.html: %s", board.Dir, resDir, op.ID, err.Error())
 	}
 	defer threadPageFile.Close()
-	if err = config.TakeOwnershipOfFile(threadPageFile); err != nil {
+	if err = config.TakeOwnershipOfFile(threadPageFile.File); err != nil {
 		errEv.Err(err).Caller().Send()
-		return fmt.Errorf("unable to set file permissions for /%s/res/%d.html: %s", board.Dir, op.ID, err.Error())
+		return fmt.Errorf("unable to set file permissions for /%s/%s/%d.html: %s", board.Dir, resDir, op.ID, err.Error())
 	}
 	errEv.Int("op", posts[0].ID)
+	reportCategories, err := gcsql.GetBoardReportCategories(board.ID)
+	if err != nil {
+		errEv.Err(err).Caller().Msg("Unable to get report categories")
+		return errors.New("unable to get report categories: " + err.Error())
+	}
 
 	// render thread page
 	captchaCfg := config.GetSiteConfig().Captcha
 	if err = serverutil.MinifyTemplate(gctemplates.ThreadPage, map[string]interface{}{
-		"boards":      gcsql.AllBoards,
-		"board":       board,
-		"boardConfig": config.GetBoardConfig(board.Dir),
-		"sections":    gcsql.AllSections,
-		"posts":       posts[1:],
-		"op":          posts[0],
-		"thread":      thread,
-		"useCaptcha":  captchaCfg.UseCaptcha() && !captchaCfg.OnlyNeededForThreads,
-		"captcha":     captchaCfg,
+		"boards":           gcsql.AllBoards,
+		"board":            board,
+		"boardConfig":      config.GetBoardConfig(board.Dir),
+		"sections":         gcsql.AllSections,
+		"posts":            posts[1:],
+		"op":               posts[0],
+		"thread":           thread,
+		"reportCategories": reportCategories,
+		"useCaptcha":       captchaCfg.UseCaptcha() && !captchaCfg.OnlyNeededForThreads,
+		"captcha":          captchaCfg,
 	}, threadPageFile, "text/html"); err != nil {
 		errEv.Err(err).Caller().Send()
-		return fmt.Errorf("failed building /%s/res/%d threadpage: %s", board.Dir, posts[0].ID, err.Error())
+		return fmt.Errorf("failed building /%s/%s/%d threadpage: %s", board.Dir, resDir, posts[0].ID, err.Error())
+	}
+	if err = threadPageFile.Commit(); err != nil {
+		errEv.Err(err).Caller().Send()
+		return fmt.Errorf("failed writing /%s/%s/%d.html: %s", board.Dir, resDir, posts[0].ID, err.Error())
 	}
 
 	// Put together the thread JSON
-	threadJSONFile, err := os.OpenFile(
-		path.Join(criticalCfg.DocumentRoot, board.Dir, "res", strconv.Itoa(posts[0].ID)+".json"),
-		os.O_CREATE|os.O_RDWR|os.O_TRUNC, config.GC_FILE_MODE)
+	threadJSONFile, err := createAtomicFile(
+		path.Join(criticalCfg.DocumentRoot, board.Dir, resDir, strconv.Itoa(posts[0].ID)+".json"))
 	if err != nil {
 		errEv.Err(err).Caller().Send()
-		return fmt.Errorf("failed opening /%s/res/%d.json: %s", board.Dir, posts[0].ID, err.Error())
+		return fmt.Errorf("failed opening /%s/%s/%d.json: %s", board.Dir, resDir, posts[0].ID, err.Error())
 	}
 	defer threadJSONFile.Close()
 
-	if err = config.TakeOwnershipOfFile(threadJSONFile); err != nil {
+	if err = config.TakeOwnershipOfFile(threadJSONFile.File); err != nil {
 		errEv.Err(err).Caller().Send()
-		return fmt.Errorf("failed setting file permissions for /%s/res/%d.json: %s", board.Dir, posts[0].ID, err.Error())
+		return fmt.Errorf("failed setting file permissions for /%s/%s/%d.json: %s", board.Dir, resDir, posts[0].ID, err.Error())
 	}
 
 	threadMap := make(map[string][]Post)
@@ -138,7 +152,12 @@ func BuildThreadPages(op *gcsql.Post) error {
 	if _, err = threadJSONFile.Write(threadJSON); err != nil {
 		errEv.Err(err).
 			Caller().Send()
-		return fmt.Errorf("failed writing /%s/res/%d.json: %s", board.Dir, posts[0].ID, err.Error())
+		return fmt.Errorf("failed writing /%s/%s/%d.json: %s", board.Dir, resDir, posts[0].ID, err.Error())
+	}
+	if err = threadJSONFile.Commit(); err != nil {
+		errEv.Err(err).
+			Caller().Send()
+		return fmt.Errorf("failed writing /%s/%s/%d.json: %s", board.Dir, resDir, posts[0].ID, err.Error())
 	}
 	return nil
 }
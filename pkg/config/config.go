@@ -9,6 +9,7 @@ import (
 	"path"
 	"reflect"
 	"strings"
+	"time"
 
 	"github.com/gochan-org/gochan/pkg/gcutil"
 )
@@ -16,6 +17,13 @@ import (
 const (
 	randomStringSize = 16
 	cookieMaxAgeEx   = ` (example: "1 year 2 months 3 days 4 hours", or "1y2mo3d4h"`
+
+	// FormattingBBCode compiles BBCode in post messages into HTML
+	FormattingBBCode = "bbcode"
+	// FormattingMarkdown renders a subset of Markdown in post messages as HTML
+	FormattingMarkdown = "markdown"
+	// FormattingPlain only escapes post messages
+	FormattingPlain = "plain"
 	/* currentConfig = iota
 	oldConfig
 	invalidConfig */
@@ -25,17 +33,21 @@ var (
 	cfg      *GochanConfig
 	cfgPath  string
 	defaults = map[string]any{
-		"WebRoot": "/",
+		"WebRoot":       "/",
+		"PluginTimeout": "2s",
 		// SiteConfig
-		"FirstPage":       []string{"index.html", "firstrun.html", "1.html"},
-		"CookieMaxAge":    "1y",
-		"LockdownMessage": "This imageboard has temporarily disabled posting. We apologize for the inconvenience",
-		"SiteName":        "Gochan",
-		"MinifyHTML":      true,
-		"MinifyJS":        true,
-		"MaxRecentPosts":  12,
-		"EnableAppeals":   true,
-		"MaxLogDays":      14,
+		"FirstPage":        []string{"index.html", "firstrun.html", "1.html"},
+		"CookieMaxAge":     "1y",
+		"MaxLoginAttempts": 5,
+		"LoginLockout":     "15m",
+		"LockdownMessage":  "This imageboard has temporarily disabled posting. We apologize for the inconvenience",
+		"SiteName":         "Gochan",
+		"MinifyHTML":       true,
+		"MinifyJS":         true,
+		"MaxRecentPosts":   12,
+		"EnableAppeals":    true,
+		"MaxBanAppeals":    3,
+		"MaxLogDays":       14,
 
 		// BoardConfig
 		"DateTimeFormat": "Mon, January 02, 2006 3:04:05 PM",
@@ -61,6 +73,7 @@ var (
 		"ThumbHeightReply":   125,
 		"ThumbWidthCatalog":  50,
 		"ThumbHeightCatalog": 50,
+		"MaxUploadsPerPost":  1,
 	}
 
 	boardConfigs    = map[string]BoardConfig{}
@@ -106,6 +119,13 @@ func (gcfg *GochanConfig) ValidateValues() error {
 		gcfg.WebRoot = "/"
 		changed = true
 	}
+	if gcfg.PluginTimeout == "" {
+		gcfg.PluginTimeout = defaults["PluginTimeout"].(string)
+		changed = true
+	}
+	if _, err := time.ParseDuration(gcfg.PluginTimeout); err != nil {
+		return &InvalidValueError{Field: "PluginTimeout", Value: gcfg.PluginTimeout, Details: err.Error()}
+	}
 	if len(gcfg.FirstPage) == 0 {
 		gcfg.FirstPage = defaults["FirstPage"].([]string)
 		changed = true
@@ -121,6 +141,26 @@ func (gcfg *GochanConfig) ValidateValues() error {
 		return err
 	}
 
+	if gcfg.MaxLoginAttempts == 0 {
+		gcfg.MaxLoginAttempts = defaults["MaxLoginAttempts"].(int)
+		changed = true
+	}
+	if gcfg.LoginLockout == "" {
+		gcfg.LoginLockout = defaults["LoginLockout"].(string)
+		changed = true
+	}
+	_, err = gcutil.ParseDurationString(gcfg.LoginLockout)
+	if err == gcutil.ErrInvalidDurationString {
+		return &InvalidValueError{Field: "LoginLockout", Value: gcfg.LoginLockout, Details: err.Error() + cookieMaxAgeEx}
+	} else if err != nil {
+		return err
+	}
+
+	if gcfg.MaxBanAppeals == 0 {
+		gcfg.MaxBanAppeals = defaults["MaxBanAppeals"].(int)
+		changed = true
+	}
+
 	if gcfg.LockdownMessage == "" {
 		gcfg.LockdownMessage = defaults["LockdownMessage"].(string)
 	}
@@ -144,6 +184,14 @@ func (gcfg *GochanConfig) ValidateValues() error {
 	if len(gcfg.Styles) == 0 {
 		return &InvalidValueError{Field: "Styles", Value: gcfg.Styles}
 	}
+	switch gcfg.FormattingMode {
+	case "", FormattingBBCode, FormattingMarkdown, FormattingPlain:
+	default:
+		return &InvalidValueError{
+			Field:   "FormattingMode",
+			Value:   gcfg.FormattingMode,
+			Details: "valid values are " + strings.Join([]string{FormattingBBCode, FormattingMarkdown, FormattingPlain}, ", ")}
+	}
 	if gcfg.DefaultStyle == "" {
 		gcfg.DefaultStyle = gcfg.Styles[0].Filename
 		changed = true
@@ -182,6 +230,10 @@ func (gcfg *GochanConfig) ValidateValues() error {
 		gcfg.ThumbHeightCatalog = defaults["ThumbHeightCatalog"].(int)
 		changed = true
 	}
+	if gcfg.MaxUploadsPerPost == 0 {
+		gcfg.MaxUploadsPerPost = defaults["MaxUploadsPerPost"].(int)
+		changed = true
+	}
 	if gcfg.ThreadsPerPage == 0 {
 		gcfg.ThreadsPerPage = defaults["ThreadsPerPage"].(int)
 		changed = true
@@ -265,13 +317,15 @@ the server is running could have site breaking consequences. It should only be c
 file and restarting the server.
 */
 type SystemCriticalConfig struct {
-	ListenIP     string `critical:"true"`
-	Port         int    `critical:"true"`
-	UseFastCGI   bool   `critical:"true"`
-	DocumentRoot string `critical:"true"`
-	TemplateDir  string `critical:"true"`
-	LogDir       string `critical:"true"`
-	Plugins      []string
+	ListenIP        string `critical:"true"`
+	Port            int    `critical:"true"`
+	UseFastCGI      bool   `critical:"true"`
+	DocumentRoot    string `critical:"true"`
+	TemplateDir     string `critical:"true"`
+	LogDir          string `critical:"true"`
+	Plugins         []string
+	PluginTimeout   string              `description:"The longest a plugin's event handler or manage page can run before it is stopped (e.g. '500ms', '2s')"`
+	PluginLibraries map[string][]string `description:"Restricted Lua libraries (os, io) that each plugin is allowed to use, e.g. {\"plugins/myplugin.lua\": [\"os\"]}"`
 
 	SiteHeaderURL string
 	WebRoot       string `description:"The HTTP root appearing in the browser (e.g. '/', 'https://yoursite.net/', etc) that all internal links start with"`
@@ -293,11 +347,13 @@ type SystemCriticalConfig struct {
 // SiteConfig contains information about the site/community, e.g. the name of the site, the slogan (if set),
 // the first page to look for if a directory is requested, etc
 type SiteConfig struct {
-	FirstPage       []string
-	Username        string
-	CookieMaxAge    string `description:"The amount of time that session cookies will exist before they expire (ex: 1y2mo3d4h or 1 year 2 months 3 days 4 hours). Default is 1 year"`
-	Lockdown        bool   `description:"Disables posting."`
-	LockdownMessage string `description:"Message displayed when someone tries to post while the site is on lockdown."`
+	FirstPage        []string
+	Username         string
+	CookieMaxAge     string `description:"The amount of time that session cookies will exist before they expire (ex: 1y2mo3d4h or 1 year 2 months 3 days 4 hours). Default is 1 year"`
+	MaxLoginAttempts int    `description:"The number of failed staff logins from an IP address or for a username before logins are locked out. Default is 5"`
+	LoginLockout     string `description:"The amount of time that failed staff logins are counted for, and that logins are locked out for after MaxLoginAttempts is reached (ex: 15m or 1h). Default is 15 minutes"`
+	Lockdown         bool   `description:"Disables posting."`
+	LockdownMessage  string `description:"Message displayed when someone tries to post while the site is on lockdown."`
 
 	SiteName   string `description:"The name of the site that appears in the header of the front page."`
 	SiteSlogan string `description:"The text that appears below SiteName on the home page"`
@@ -307,6 +363,7 @@ type SiteConfig struct {
 	RecentPostsWithNoFile bool `description:"If checked, recent posts with no image/upload are shown on the front page (as well as those with images"`
 	Verbosity             int
 	EnableAppeals         bool
+	MaxBanAppeals         int `description:"The number of times a ban can be appealed. Set to -1 to allow unlimited appeals. Default is 3"`
 	MaxLogDays            int `description:"The maximum number of days to keep messages in the moderation/staff log file."`
 
 	MinifyHTML      bool   `description:"If checked, gochan will minify html files when building"`
@@ -318,13 +375,20 @@ type SiteConfig struct {
 }
 
 type CaptchaConfig struct {
-	Type                 string
+	Type                 string `description:"The CAPTCHA provider to use. Valid values are hcaptcha, recaptcha, turnstile, and builtin (a self-hosted image CAPTCHA that doesn't need a third party service)"`
 	OnlyNeededForThreads bool
 	SiteKey              string
 	AccountSecret        string
+	VerifyURL            string `description:"If set, CAPTCHA responses are submitted to this URL instead of the provider's default verification endpoint"`
+	Length               int    `description:"The number of characters in a builtin CAPTCHA challenge"`
+	MinutesExpire        int    `description:"The number of minutes a builtin CAPTCHA challenge is valid for"`
 }
 
+// UseCaptcha returns true if a CAPTCHA provider is configured. The builtin provider doesn't need a site key or secret
 func (cc *CaptchaConfig) UseCaptcha() bool {
+	if cc.Type == "builtin" {
+		return true
+	}
 	return cc.SiteKey != "" && cc.AccountSecret != ""
 }
 
@@ -363,6 +427,9 @@ type BoardConfig struct {
 	Cooldowns              BoardCooldowns
 	ThreadsPerPage         int
 	EnableGeoIP            bool
+	ArchiveOldThreads      bool `description:"If checked, threads that are pushed off the board by the MaxThreads limit are locked and moved to the board's archive instead of being deleted."`
+	ArchiveRetentionDays   int  `description:"The number of days archived threads are kept before they are deleted. If 0, archived threads are never deleted."`
+	PublicModLog           bool `description:"If checked, post, thread, and ban actions taken by staff on the board are listed at /modlog?board=<dir>, without the staff member's name or other details."`
 }
 
 type BoardListConfig struct {
@@ -384,6 +451,7 @@ type UploadConfig struct {
 	ThumbHeightReply      int  `description:"Same as ThumbWidth and ThumbHeight but for reply images."`
 	ThumbWidthCatalog     int  `description:"Same as ThumbWidth and ThumbHeight but for catalog images."`
 	ThumbHeightCatalog    int  `description:"Same as ThumbWidth and ThumbHeight but for catalog images."`
+	MaxUploadsPerPost     int  `description:"The maximum number of files that can be attached to a single post."`
 
 	// Sets what (if any) metadata to remove from uploaded images using exiftool.
 	// Valid values are "", "none" (has the same effect as ""), "exif", or "all" (for stripping all metadata)
@@ -409,6 +477,20 @@ type PostConfig struct {
 	ImagesOpenNewTab bool   `description:"If checked, thumbnails will open the respective image/video in a new tab instead of expanding them." `
 	NewTabOnOutlinks bool   `description:"If checked, links to external sites will open in a new tab."`
 	DisableBBcode    bool   `description:"If checked, gochan will not compile bbcode into HTML"`
+	FormattingMode   string `description:"How post messages are formatted. Valid values are bbcode, markdown, and plain. If it isn't set, bbcode is used unless DisableBBcode is checked"`
+}
+
+// GetFormattingMode returns the board's post formatting mode (FormattingBBCode, FormattingMarkdown, or
+// FormattingPlain), using DisableBBcode if FormattingMode isn't set
+func (pc *PostConfig) GetFormattingMode() string {
+	switch pc.FormattingMode {
+	case FormattingBBCode, FormattingMarkdown, FormattingPlain:
+		return pc.FormattingMode
+	}
+	if pc.DisableBBcode {
+		return FormattingPlain
+	}
+	return FormattingBBCode
 }
 
 func WriteConfig() error {
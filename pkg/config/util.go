@@ -142,23 +142,24 @@ func InitConfig(versionStr string) {
 		cfg = &GochanConfig{
 			testing: true,
 			SystemCriticalConfig: SystemCriticalConfig{
-				ListenIP:     "127.0.0.1",
-				Port:         8080,
-				UseFastCGI:   true,
-				DebugMode:    true,
-				DocumentRoot: "html",
-				TemplateDir:  "templates",
-				LogDir:       "",
-				DBtype:       "sqlite3",
-				DBhost:       "./testdata/gochantest.db",
-				DBname:       "gochan",
-				DBusername:   "gochan",
-				DBpassword:   "",
-				DBprefix:     "gc_",
-				SiteDomain:   "127.0.0.1",
-				WebRoot:      "/",
-				RandomSeed:   "abcd",
-				Version:      ParseVersion(versionStr),
+				ListenIP:      "127.0.0.1",
+				Port:          8080,
+				UseFastCGI:    true,
+				DebugMode:     true,
+				DocumentRoot:  "html",
+				TemplateDir:   "templates",
+				LogDir:        "",
+				DBtype:        "sqlite3",
+				DBhost:        "./testdata/gochantest.db",
+				DBname:        "gochan",
+				DBusername:    "gochan",
+				DBpassword:    "",
+				DBprefix:      "gc_",
+				SiteDomain:    "127.0.0.1",
+				WebRoot:       "/",
+				RandomSeed:    "abcd",
+				PluginTimeout: "2s",
+				Version:       ParseVersion(versionStr),
 			},
 			SiteConfig: SiteConfig{
 				Username:        "",
@@ -170,6 +171,7 @@ func InitConfig(versionStr string) {
 				MinifyHTML:      true,
 				MinifyJS:        true,
 				EnableAppeals:   true,
+				MaxBanAppeals:   3,
 				MaxLogDays:      14,
 				Verbosity:       1,
 
@@ -221,6 +223,7 @@ func InitConfig(versionStr string) {
 					ThumbHeightReply:   125,
 					ThumbWidthCatalog:  50,
 					ThumbHeightCatalog: 50,
+					MaxUploadsPerPost:  1,
 				},
 				DateTimeFormat: "Mon, January 02, 2006 3:04 PM",
 			},
@@ -374,3 +377,60 @@ func UpdateFromMap(m map[string]interface{}, validate bool) error {
 	}
 	return nil
 }
+
+// EditableField is a field of the site or default board configuration that can be changed from the config
+// manage page
+type EditableField struct {
+	Name        string
+	Kind        reflect.Kind
+	Value       interface{}
+	Description string
+	Default     string
+}
+
+// EditableFields returns the fields of the site and default board configuration that can be safely changed
+// while gochan is running. Fields that aren't a string, int, bool, or string slice (like Styles) are left out,
+// along with fields that are hidden by another field with the same name, since UpdateFromMap can't set them
+func EditableFields() []EditableField {
+	var fields []EditableField
+	seen := map[string]bool{}
+	gcfgValue := reflect.ValueOf(cfg).Elem()
+	var addFields func(structValue reflect.Value)
+	addFields = func(structValue reflect.Value) {
+		structType := structValue.Type()
+		for f := 0; f < structType.NumField(); f++ {
+			field := structType.Field(f)
+			fieldValue := structValue.Field(f)
+			if field.Anonymous && field.Type.Kind() == reflect.Struct {
+				addFields(fieldValue)
+				continue
+			}
+			if !field.IsExported() || seen[field.Name] || fieldIsCritical(field.Name) || field.Tag.Get("critical") == "true" {
+				continue
+			}
+			switch field.Type.Kind() {
+			case reflect.String, reflect.Int, reflect.Bool:
+			case reflect.Slice:
+				if field.Type.Elem().Kind() != reflect.String {
+					continue
+				}
+			default:
+				continue
+			}
+			if resolved := gcfgValue.FieldByName(field.Name); !resolved.IsValid() || resolved.Addr() != fieldValue.Addr() {
+				continue
+			}
+			seen[field.Name] = true
+			fields = append(fields, EditableField{
+				Name:        field.Name,
+				Kind:        field.Type.Kind(),
+				Value:       fieldValue.Interface(),
+				Description: field.Tag.Get("description"),
+				Default:     field.Tag.Get("default"),
+			})
+		}
+	}
+	addFields(reflect.ValueOf(&cfg.SiteConfig).Elem())
+	addFields(reflect.ValueOf(&cfg.BoardConfig).Elem())
+	return fields
+}
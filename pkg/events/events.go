@@ -1,51 +1,133 @@
 package events
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"strings"
+	"sync"
 
 	"github.com/gochan-org/gochan/pkg/gcutil"
 )
 
 var (
-	registeredEvents map[string][]EventHandler
-	testingMode      bool
+	// ErrHandlerPanic is wrapped by the error returned by TriggerEvent if one of the event's handlers panicked
+	ErrHandlerPanic = errors.New("event handler panicked")
+
+	registeredEvents map[string][]registeredHandler
+	// eventsLock protects registeredEvents, since plugins' handlers are unregistered and registered again
+	// when plugins are reloaded
+	eventsLock    sync.RWMutex
+	lastHandlerID int
+	testingMode   bool
 )
 
-type EventHandler func(string, ...interface{})
+// EventHandler is called with the trigger and data passed to TriggerEvent. Returning an error stops the
+// handlers registered after it from being called, and events triggered before something is done (like
+// post-pre-insert) use it to stop that from happening
+type EventHandler func(string, ...interface{}) error
+
+type registeredHandler struct {
+	id      int
+	handler EventHandler
+}
+
+// Handler is an event handler and the triggers it is registered to, used by ReplaceEvents
+type Handler struct {
+	Triggers []string
+	Handler  EventHandler
+}
 
 // RegisterEvent registers a new event handler to be called when any of the elements of triggers are passed
-// to TriggerEvent
-func RegisterEvent(triggers []string, handler func(trigger string, i ...interface{})) {
+// to TriggerEvent. It returns an ID that can be passed to UnregisterEvent to remove the handler
+func RegisterEvent(triggers []string, handler EventHandler) int {
+	eventsLock.Lock()
+	defer eventsLock.Unlock()
+	return registerHandler(triggers, handler)
+}
+
+// UnregisterEvent removes the event handler with the given ID (returned by RegisterEvent) from all of its
+// triggers
+func UnregisterEvent(id int) {
+	eventsLock.Lock()
+	defer eventsLock.Unlock()
+	unregisterHandlers([]int{id})
+}
+
+// ReplaceEvents unregisters the event handlers with the given IDs and registers handlers in one step, so that
+// events triggered while plugins are reloaded are handled by either the old or the new handlers. It returns
+// the IDs of the new handlers, in the same order as handlers
+func ReplaceEvents(ids []int, handlers []Handler) []int {
+	eventsLock.Lock()
+	defer eventsLock.Unlock()
+	unregisterHandlers(ids)
+	newIDs := make([]int, len(handlers))
+	for h, handler := range handlers {
+		newIDs[h] = registerHandler(handler.Triggers, handler.Handler)
+	}
+	return newIDs
+}
+
+// registerHandler registers the handler and returns its ID. eventsLock must be held by the caller
+func registerHandler(triggers []string, handler EventHandler) int {
+	lastHandlerID++
 	for _, t := range triggers {
-		registeredEvents[t] = append(registeredEvents[t], handler)
+		registeredEvents[t] = append(registeredEvents[t], registeredHandler{id: lastHandlerID, handler: handler})
+	}
+	return lastHandlerID
+}
+
+// unregisterHandlers removes the handlers with the given IDs. eventsLock must be held by the caller
+func unregisterHandlers(ids []int) {
+	if len(ids) == 0 {
+		return
+	}
+	remove := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		remove[id] = true
+	}
+	for t, handlers := range registeredEvents {
+		registered := make([]registeredHandler, 0, len(handlers))
+		for _, h := range handlers {
+			if !remove[h.id] {
+				registered = append(registered, h)
+			}
+		}
+		registeredEvents[t] = registered
 	}
 }
 
-// TriggerEvent triggers the event handler registered to trigger
-func TriggerEvent(trigger string, data ...interface{}) (handled bool, recovered bool) {
+// TriggerEvent calls the event handlers registered to trigger in the order they were registered, until one
+// of them returns an error, which is returned as err. If a handler panics, the remaining handlers aren't called
+// and err wraps ErrHandlerPanic, so that events triggered before something is done stop it like any other error
+func TriggerEvent(trigger string, data ...interface{}) (handled bool, err error) {
 	errEv := gcutil.LogError(nil).Caller(1)
 	defer func() {
 		if a := recover(); a != nil {
+			err = fmt.Errorf("%w while handling %s: %v", ErrHandlerPanic, trigger, a)
 			if !testingMode {
-				errEv.Err(fmt.Errorf("%s", a)).
+				errEv.Err(err).
 					Str("event", trigger).
 					Msg("Recovered from panic while handling event")
 			}
 			handled = true
-			recovered = true
 		}
 	}()
-	for _, handler := range registeredEvents[trigger] {
-		handler(trigger, data...)
+	// the handlers are called after unlocking so that they can register or trigger events themselves
+	eventsLock.RLock()
+	handlers := registeredEvents[trigger]
+	eventsLock.RUnlock()
+	for _, h := range handlers {
 		handled = true
+		if err = h.handler(trigger, data...); err != nil {
+			break
+		}
 	}
 	errEv.Discard()
 	return
 }
 
 func init() {
-	registeredEvents = map[string][]EventHandler{}
+	registeredEvents = map[string][]registeredHandler{}
 	testingMode = strings.HasSuffix(os.Args[0], ".test")
 }
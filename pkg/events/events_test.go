@@ -1,26 +1,31 @@
 package events
 
-import "testing"
+import (
+	"errors"
+	"testing"
+)
 
 func TestPanicRecover(t *testing.T) {
-	RegisterEvent([]string{"TestPanicRecoverEvt"}, func(tr string, i ...interface{}) {
+	RegisterEvent([]string{"TestPanicRecoverEvt"}, func(tr string, i ...interface{}) error {
 		t.Log("Testing panic recover")
 		t.Log(i[0])
+		return nil
 	})
-	handled, recovered := TriggerEvent("TestPanicRecoverEvt") // should panic
+	handled, err := TriggerEvent("TestPanicRecoverEvt") // should panic
 	if !handled {
 		t.Fatal("TriggerEvent for TestPanicRecoverEvt wasn't handled")
 	}
-	t.Log("TestPanicRecoverEvt recovered: ", recovered)
-	if !recovered {
-		t.Fatal("TestPanicRecoverEvt should have caused a panic and recovered from it")
+	t.Log("TestPanicRecoverEvt error: ", err)
+	if !errors.Is(err, ErrHandlerPanic) {
+		t.Fatal("TestPanicRecoverEvt should have caused a panic and returned it as an error")
 	}
 }
 
 func TestEventEditValue(t *testing.T) {
-	RegisterEvent([]string{"TestEventEditValue"}, func(tr string, i ...interface{}) {
+	RegisterEvent([]string{"TestEventEditValue"}, func(tr string, i ...interface{}) error {
 		p := i[0].(*int)
 		*p += 1
+		return nil
 	})
 	var a int
 	t.Logf("a before TestEventEditValue triggered: %d", a)
@@ -33,8 +38,9 @@ func TestEventEditValue(t *testing.T) {
 
 func TestMultipleEventTriggers(t *testing.T) {
 	triggered := map[string]bool{}
-	RegisterEvent([]string{"a", "b"}, func(tr string, i ...interface{}) {
+	RegisterEvent([]string{"a", "b"}, func(tr string, i ...interface{}) error {
 		triggered[tr] = true
+		return nil
 	})
 	TriggerEvent("a")
 	TriggerEvent("b")
@@ -47,3 +53,63 @@ func TestMultipleEventTriggers(t *testing.T) {
 		t.Fatal("b event not triggered")
 	}
 }
+
+func TestEventHandlerError(t *testing.T) {
+	rejected := errors.New("rejected by handler")
+	var calledAfter bool
+	RegisterEvent([]string{"TestEventHandlerError"}, func(tr string, i ...interface{}) error {
+		return rejected
+	})
+	RegisterEvent([]string{"TestEventHandlerError"}, func(tr string, i ...interface{}) error {
+		calledAfter = true
+		return nil
+	})
+	handled, err := TriggerEvent("TestEventHandlerError")
+	if !handled {
+		t.Fatal("expected the event to be handled")
+	}
+	if err != rejected {
+		t.Fatalf("expected the handler's error to be returned, got %v", err)
+	}
+	if calledAfter {
+		t.Fatal("handler registered after the one that returned an error was called")
+	}
+}
+
+func TestUnregisterEvent(t *testing.T) {
+	var calls int
+	id := RegisterEvent([]string{"TestUnregisterEventA", "TestUnregisterEventB"}, func(tr string, i ...interface{}) error {
+		calls++
+		return nil
+	})
+	TriggerEvent("TestUnregisterEventA")
+	UnregisterEvent(id)
+	handledA, _ := TriggerEvent("TestUnregisterEventA")
+	handledB, _ := TriggerEvent("TestUnregisterEventB")
+	if handledA || handledB || calls != 1 {
+		t.Fatalf("handler was called after being unregistered (calls: %d)", calls)
+	}
+}
+
+func TestReplaceEvents(t *testing.T) {
+	var called string
+	oldID := RegisterEvent([]string{"TestReplaceEvents"}, func(tr string, i ...interface{}) error {
+		called += "old"
+		return nil
+	})
+	newIDs := ReplaceEvents([]int{oldID}, []Handler{{
+		Triggers: []string{"TestReplaceEvents"},
+		Handler: func(tr string, i ...interface{}) error {
+			called += "new"
+			return nil
+		},
+	}})
+	TriggerEvent("TestReplaceEvents")
+	if called != "new" {
+		t.Fatalf(`expected only the new handler to be called ("new"), got %q`, called)
+	}
+	UnregisterEvent(newIDs[0])
+	if handled, _ := TriggerEvent("TestReplaceEvents"); handled {
+		t.Fatal("handler was called after being unregistered")
+	}
+}
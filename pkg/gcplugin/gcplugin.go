@@ -2,9 +2,13 @@ package gcplugin
 
 import (
 	"errors"
+	"fmt"
 	"html/template"
 	"io"
 	"net/http"
+	"path"
+	"strings"
+	"sync"
 
 	"github.com/gochan-org/gochan/pkg/config"
 	"github.com/gochan-org/gochan/pkg/events"
@@ -22,21 +26,30 @@ import (
 )
 
 var (
-	lState       *lua.LState
-	eventPlugins map[string][]*lua.LFunction
+	ErrTemplatePath = errors.New("plugins without access to io can only load templates in the template directory")
+
+	// plugins are the Lua plugins listed in the Plugins configuration field, in the order they were loaded
+	plugins     []*luaPlugin
+	pluginsLock sync.RWMutex
+	// reloadLock is held while plugins are being loaded, so that two reloads can't run at the same time
+	reloadLock sync.Mutex
 )
 
-func initLua() {
-	if lState == nil {
-		lState = lua.NewState()
-		registerLuaFunctions()
+// ClosePlugins closes the Lua states of all loaded plugins and closes the Go plugins
+func ClosePlugins() {
+	pluginsLock.RLock()
+	defer pluginsLock.RUnlock()
+	for _, plugin := range plugins {
+		plugin.close()
 	}
+	closeGoPlugins()
 }
 
-func ClosePlugins() {
-	if lState != nil {
-		lState.Close()
-	}
+// getLuaPlugins returns the currently loaded Lua plugins
+func getLuaPlugins() []*luaPlugin {
+	pluginsLock.RLock()
+	defer pluginsLock.RUnlock()
+	return plugins
 }
 
 func lvalueToInterface(l *lua.LState, v lua.LValue) interface{} {
@@ -62,57 +75,110 @@ func lvalueToInterface(l *lua.LState, v lua.LValue) interface{} {
 	return nil
 }
 
-func createLuaLogFunc(which string) lua.LGFunction {
+func createLuaLogFunc(plugin *luaPlugin, which string) lua.LGFunction {
 	return func(l *lua.LState) int {
 		switch which {
 		case "info":
-			l.Push(luar.New(l, gcutil.LogInfo()))
+			l.Push(luar.New(l, gcutil.LogInfo().Str("plugin", plugin.Name)))
 		case "warn":
-			l.Push(luar.New(l, gcutil.LogWarning()))
+			l.Push(luar.New(l, gcutil.LogWarning().Str("plugin", plugin.Name)))
 		case "error":
 			numArgs := l.GetTop()
 			if numArgs == 0 {
-				l.Push(luar.New(l, gcutil.LogError(nil)))
+				l.Push(luar.New(l, gcutil.LogError(nil).Str("plugin", plugin.Name)))
 			} else {
-				l.Push(luar.New(l, gcutil.LogError(errors.New(l.CheckString(-1)))))
+				l.Push(luar.New(l, gcutil.LogError(errors.New(l.CheckString(-1))).Str("plugin", plugin.Name)))
 			}
 		}
 		return 1
 	}
 }
 
-func luaEventRegisterHandlerAdapter(l *lua.LState, fn *lua.LFunction) events.EventHandler {
-	return func(trigger string, data ...interface{}) {
-		args := []lua.LValue{
-			luar.New(l, trigger),
+// luaEventRegisterHandlerAdapter wraps the Lua function in an event handler. If the function returns a string
+// or an error, it is returned by the handler (and by events.TriggerEvent). Errors in the function itself (including
+// timeouts) are logged, so that a broken plugin doesn't stop posting
+func luaEventRegisterHandlerAdapter(plugin *luaPlugin, fn *lua.LFunction) events.EventHandler {
+	return func(trigger string, data ...interface{}) error {
+		args := append([]interface{}{trigger}, data...)
+		ret, err := plugin.call("event "+trigger, fn, 1, args...)
+		if err != nil {
+			return nil
+		}
+		return lvalueToError(ret[0])
+	}
+}
+
+// lvalueToError returns the error returned by a Lua function, which may be an error message string or a Go
+// error. nil, false, and empty strings are treated as no error
+func lvalueToError(v lua.LValue) error {
+	switch val := v.(type) {
+	case lua.LString:
+		if val != "" {
+			return errors.New(string(val))
 		}
-		for _, i := range data {
-			args = append(args, luar.New(l, i))
+	case *lua.LUserData:
+		if err, ok := val.Value.(error); ok {
+			return err
 		}
-		l.CallByParam(lua.P{
-			Fn:      fn,
-			NRet:    0,
-			Protect: true,
-		}, args...)
 	}
+	return nil
 }
 
-func registerLuaFunctions() {
+// templatePathAllowed returns true if the template path (relative to the template directory and its override
+// directory) doesn't leave them
+func templatePathAllowed(tmplPath string) bool {
+	if tmplPath == "" || path.IsAbs(tmplPath) {
+		return false
+	}
+	cleaned := path.Clean(tmplPath)
+	return cleaned != ".." && !strings.HasPrefix(cleaned, "../")
+}
+
+// pluginSystemCriticalConfig returns a copy of the system critical configuration without the database credentials
+// and random seed, so that plugins can't read them or change the configuration used by gochan
+func pluginSystemCriticalConfig() *config.SystemCriticalConfig {
+	systemCritical := config.GetSystemCriticalConfig()
+	systemCritical.DBusername = ""
+	systemCritical.DBpassword = ""
+	systemCritical.RandomSeed = ""
+	systemCritical.Plugins = append([]string(nil), systemCritical.Plugins...)
+	pluginLibraries := make(map[string][]string, len(systemCritical.PluginLibraries))
+	for pluginPath, libraries := range systemCritical.PluginLibraries {
+		pluginLibraries[pluginPath] = append([]string(nil), libraries...)
+	}
+	systemCritical.PluginLibraries = pluginLibraries
+	if systemCritical.Version != nil {
+		version := *systemCritical.Version
+		systemCritical.Version = &version
+	}
+	return &systemCritical
+}
+
+// registerLuaFunctions adds the gochan functions available to plugins to the plugin's Lua state
+func registerLuaFunctions(plugin *luaPlugin) {
+	lState := plugin.state
 	luaFilePath.Preload(lState)
 	luaStrings.Preload(lState)
-	lState.Register("info_log", createLuaLogFunc("info"))
-	lState.Register("warn_log", createLuaLogFunc("warn"))
-	lState.Register("error_log", createLuaLogFunc("error"))
+	lState.PreloadModule("gochan", gochanModuleLoader(plugin))
+	lState.Register("info_log", createLuaLogFunc(plugin, "info"))
+	lState.Register("warn_log", createLuaLogFunc(plugin, "warn"))
+	lState.Register("error_log", createLuaLogFunc(plugin, "error"))
 	lState.Register("system_critical_config", func(l *lua.LState) int {
-		l.Push(luar.New(l, config.GetSystemCriticalConfig()))
+		l.Push(luar.New(l, pluginSystemCriticalConfig()))
 		return 1
 	})
 	lState.Register("site_config", func(l *lua.LState) int {
-		l.Push(luar.New(l, config.GetSiteConfig()))
+		siteConfig := *config.GetSiteConfig()
+		siteConfig.FirstPage = append([]string(nil), siteConfig.FirstPage...)
+		siteConfig.AkismetAPIKey = ""
+		siteConfig.Captcha.AccountSecret = ""
+		l.Push(luar.New(l, &siteConfig))
 		return 1
 	})
 	lState.Register("board_config", func(l *lua.LState) int {
-		l.Push(luar.New(l, config.GetBoardConfig(l.CheckString(1))))
+		boardConfig := *config.GetBoardConfig(l.CheckString(1))
+		boardConfig.AkismetAPIKey = ""
+		l.Push(luar.New(l, &boardConfig))
 		return 1
 	})
 
@@ -123,9 +189,17 @@ func registerLuaFunctions() {
 			triggers = append(triggers, val.String())
 		})
 		fn := l.CheckFunction(-1)
-		events.RegisterEvent(triggers, luaEventRegisterHandlerAdapter(l, fn))
+		handler := luaEventRegisterHandlerAdapter(plugin, fn)
+		if plugin.loading {
+			plugin.pendingHandlers = append(plugin.pendingHandlers, events.Handler{Triggers: triggers, Handler: handler})
+		} else {
+			plugin.addEventIDs(events.RegisterEvent(triggers, handler))
+		}
+		plugin.Events = append(plugin.Events, triggers...)
 		return 0
 	})
+	// handlers registered by the plugin calling event_trigger can't be called until it returns, since its state is
+	// in use, so they will time out
 	lState.Register("event_trigger", func(l *lua.LState) int {
 		trigger := l.CheckString(1)
 		numArgs := l.GetTop()
@@ -134,37 +208,53 @@ func registerLuaFunctions() {
 			v := l.CheckAny(i)
 			data = append(data, lvalueToInterface(l, v))
 		}
-		events.TriggerEvent(trigger, data...)
-		return 0
+		_, err := events.TriggerEvent(trigger, data...)
+		if err != nil {
+			l.Push(lua.LString(err.Error()))
+		} else {
+			l.Push(lua.LNil)
+		}
+		return 1
 	})
 	lState.Register("register_manage_page", func(l *lua.LState) int {
+		if !plugin.loading {
+			l.RaiseError("manage pages can only be registered while the plugin is being loaded")
+			return 0
+		}
 		actionID := l.CheckString(1)
 		actionTitle := l.CheckString(2)
 		actionPerms := l.CheckInt(3)
 		actionJSON := l.CheckInt(4)
 		fn := l.CheckFunction(5)
+		// the capability needed to access the page, optional for plugins written before roles were added
+		actionCapability := l.OptString(6, "")
 		actionHandler := func(writer http.ResponseWriter, request *http.Request, staff *gcsql.Staff, wantsJSON bool, infoEv *zerolog.Event, errEv *zerolog.Event) (output interface{}, err error) {
-			if err = l.CallByParam(lua.P{
-				Fn:      fn,
-				NRet:    2,
-				Protect: true,
-			}, luar.New(l, writer), luar.New(l, request), luar.New(l, staff), lua.LBool(wantsJSON), luar.New(l, infoEv), luar.New(l, errEv)); err != nil {
+			ret, err := plugin.call("manage page "+actionID, fn, 2, writer, request, staff, wantsJSON, infoEv, errEv)
+			if err != nil {
 				return "", err
 			}
-			out := lua.LVAsString(l.Get(-2))
-			errStr := lua.LVAsString(l.Get(-1))
+			out := lua.LVAsString(ret[0])
+			errStr := lua.LVAsString(ret[1])
 			if errStr != "" {
 				err = errors.New(errStr)
 			}
 			return out, err
 		}
-		manage.RegisterManagePage(actionID, actionTitle, actionPerms, actionJSON, actionHandler)
+		plugin.ManagePages = append(plugin.ManagePages, actionID)
+		plugin.pendingPages = append(plugin.pendingPages, manage.NewPluginManagePage(plugin.Path, actionID, actionTitle,
+			actionPerms, actionCapability, actionJSON, actionHandler))
 		return 0
 	})
 	lState.Register("load_template", func(l *lua.LState) int {
 		var tmplPaths []string
 		for i := 0; i < l.GetTop(); i++ {
-			tmplPaths = append(tmplPaths, l.CheckString(i+1))
+			tmplPath := l.CheckString(i + 1)
+			if !plugin.HasLibrary(lua.IoLibName) && !templatePathAllowed(tmplPath) {
+				l.Push(lua.LNil)
+				l.Push(luar.New(l, ErrTemplatePath))
+				return 2
+			}
+			tmplPaths = append(tmplPaths, tmplPath)
 		}
 		tmpl, err := gctemplates.LoadTemplate(tmplPaths...)
 		l.Push(luar.New(l, tmpl))
@@ -196,33 +286,90 @@ func registerLuaFunctions() {
 	lState.SetGlobal("_GOCHAN_VERSION", lua.LString(config.GetVersion().String()))
 }
 
-func registerEventFunction(name string, fn *lua.LFunction) {
-	switch name {
-	case "onStartup":
-		fallthrough
-	case "onPost":
-		fallthrough
-	case "onDelete":
-		eventPlugins[name] = append(eventPlugins[name], fn)
-	}
-}
-
+// LoadPlugins loads the Lua plugins at the given paths, each with its own Lua state, and replaces any Lua plugins
+// that were already loaded with them once they have all been loaded. Plugins that fail to load are logged and
+// shown on the plugins manage page, and the first error is returned after trying to load the rest
 func LoadPlugins(paths []string) error {
-	var err error
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+	var firstErr error
+	pluginLibraries := config.GetSystemCriticalConfig().PluginLibraries
+	loaded := make([]*luaPlugin, 0, len(paths))
 	for _, pluginPath := range paths {
-		initLua()
-		if err = lState.DoFile(pluginPath); err != nil {
-			return err
+		plugin := newLuaPlugin(pluginPath, pluginLibraries[pluginPath])
+		loaded = append(loaded, plugin)
+		if err := plugin.load(); err != nil && firstErr == nil {
+			firstErr = err
 		}
-		pluginTable := lState.NewTable()
-		pluginTable.ForEach(func(key, val lua.LValue) {
-			keyStr := key.String()
-			fn, ok := val.(*lua.LFunction)
-			if !ok {
-				return
+	}
+	oldPlugins := getLuaPlugins()
+	replacePlugins(oldPlugins, loaded)
+	for _, plugin := range oldPlugins {
+		plugin.close()
+	}
+	return firstErr
+}
+
+// ReloadPlugins loads the plugins in the Plugins configuration field again, replacing the loaded Lua plugins
+func ReloadPlugins() error {
+	return LoadPlugins(config.GetSystemCriticalConfig().Plugins)
+}
+
+// replacePlugins unregisters the event handlers and manage pages of oldPlugins and registers the ones that
+// newPlugins registered while loading in their place, then makes newPlugins the loaded plugins
+func replacePlugins(oldPlugins []*luaPlugin, newPlugins []*luaPlugin) {
+	var oldIDs []int
+	for _, plugin := range oldPlugins {
+		oldIDs = append(oldIDs, plugin.takeEventIDs()...)
+	}
+	var handlers []events.Handler
+	var pages []manage.Action
+	for _, plugin := range newPlugins {
+		handlers = append(handlers, plugin.pendingHandlers...)
+		pages = append(pages, plugin.pendingPages...)
+	}
+
+	newIDs := events.ReplaceEvents(oldIDs, handlers)
+	for _, plugin := range newPlugins {
+		plugin.addEventIDs(newIDs[:len(plugin.pendingHandlers)]...)
+		newIDs = newIDs[len(plugin.pendingHandlers):]
+		plugin.pendingHandlers = nil
+		plugin.pendingPages = nil
+	}
+	for _, page := range manage.SetPluginManagePages(pages) {
+		for _, plugin := range newPlugins {
+			if plugin.Path != page.Plugin() {
+				continue
 			}
-			registerEventFunction(keyStr, fn)
-		})
+			plugin.logError("manage page "+page.ID, fmt.Errorf("%w: %s", manage.ErrManagePageExists, page.ID))
+			for p, id := range plugin.ManagePages {
+				if id == page.ID {
+					plugin.ManagePages = append(plugin.ManagePages[:p], plugin.ManagePages[p+1:]...)
+					break
+				}
+			}
+		}
 	}
-	return nil
+
+	pluginsLock.Lock()
+	plugins = newPlugins
+	pluginsLock.Unlock()
+}
+
+// Reload reloads the Lua plugins and all of the templates (including ones in the override directory). Templates
+// that fail to parse keep their previous version, so that the site keeps working
+func Reload() (pluginErr error, templateErr error) {
+	pluginErr = ReloadPlugins()
+	if pluginErr != nil {
+		gcutil.LogError(pluginErr).Msg("Failed reloading one or more plugins")
+	}
+	templateErr = gctemplates.InitTemplates()
+	if templateErr != nil {
+		gcutil.LogError(templateErr).Msg("Failed reloading one or more templates")
+	}
+	gcutil.LogInfo().
+		Bool("pluginErrors", pluginErr != nil).
+		Bool("templateErrors", templateErr != nil).
+		Msg("Reloaded plugins and templates")
+	return pluginErr, templateErr
 }
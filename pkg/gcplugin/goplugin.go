@@ -0,0 +1,117 @@
+package gcplugin
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/gochan-org/gochan/pkg/config"
+	"github.com/gochan-org/gochan/pkg/events"
+	"github.com/gochan-org/gochan/pkg/gcutil"
+	"github.com/uptrace/bunrouter"
+)
+
+var (
+	ErrPluginNameInUse = errors.New("a plugin with that name has already been registered")
+
+	goPlugins []*goPlugin
+)
+
+// Plugin is a plugin written in Go and compiled into gochan. Packages providing a plugin should register it
+// with RegisterPlugin in their init function, so that it is enabled by importing the package
+type Plugin interface {
+	// Name identifies the plugin in the log and on the plugins manage page. The plugin's routes are under
+	// /plugin/<name>/
+	Name() string
+	// Init is called after the configuration, database, and templates are loaded. If it returns an error, the
+	// plugin's event handlers and routes aren't registered
+	Init() error
+	// Close is called when gochan exits
+	Close() error
+	// RegisterRoutes adds the plugin's HTTP handlers to the router group for /plugin/<name>/
+	RegisterRoutes(group *bunrouter.Group)
+	// EventHandlers returns the event handlers to register, mapped to the events that trigger them
+	EventHandlers() map[string]events.EventHandler
+}
+
+// goPlugin is a registered Go plugin and its status
+type goPlugin struct {
+	plugin      Plugin
+	InitError   string
+	initialized bool
+}
+
+// RegisterPlugin adds a Go plugin to be initialized when gochan starts
+func RegisterPlugin(plugin Plugin) {
+	goPlugins = append(goPlugins, &goPlugin{plugin: plugin})
+}
+
+// InitPlugins initializes the registered Go plugins and registers their event handlers. Plugins that fail to
+// initialize are logged and shown on the plugins manage page, and the first error is returned after trying to
+// initialize the rest
+func InitPlugins() error {
+	var firstErr error
+	names := map[string]bool{}
+	for _, gp := range goPlugins {
+		if gp.initialized {
+			continue
+		}
+		name := gp.plugin.Name()
+		err := ErrPluginNameInUse
+		if !names[name] {
+			err = gp.plugin.Init()
+		}
+		names[name] = true
+		if err != nil {
+			gp.InitError = err.Error()
+			gcutil.LogError(err).
+				Str("plugin", name).
+				Msg("Failed initializing plugin")
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed initializing plugin %q: %w", name, err)
+			}
+			continue
+		}
+		gp.InitError = ""
+		gp.initialized = true
+		for trigger, handler := range gp.plugin.EventHandlers() {
+			events.RegisterEvent([]string{trigger}, handler)
+		}
+		gcutil.LogInfo().Str("plugin", name).Msg("Initialized plugin")
+	}
+	return firstErr
+}
+
+// RegisterPluginRoutes adds the routes of the initialized Go plugins to the router, each under /plugin/<name>/
+func RegisterPluginRoutes(router *bunrouter.Router) {
+	for _, gp := range goPlugins {
+		if gp.initialized {
+			gp.plugin.RegisterRoutes(router.NewGroup(config.WebPath("/plugin", gp.plugin.Name())))
+		}
+	}
+}
+
+// closeGoPlugins calls Close on the initialized Go plugins and logs any errors
+func closeGoPlugins() {
+	for _, gp := range goPlugins {
+		if !gp.initialized {
+			continue
+		}
+		if err := gp.plugin.Close(); err != nil {
+			gcutil.LogError(err).
+				Str("plugin", gp.plugin.Name()).
+				Msg("Failed closing plugin")
+		}
+		gp.initialized = false
+	}
+}
+
+// eventNames returns the events that the plugin handles, sorted alphabetically
+func (gp *goPlugin) eventNames() []string {
+	var names []string
+	for trigger := range gp.plugin.EventHandlers() {
+		names = append(names, trigger)
+	}
+	sort.Strings(names)
+	return names
+}
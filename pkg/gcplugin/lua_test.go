@@ -1,10 +1,19 @@
 package gcplugin
 
 import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
 	"testing"
+	"time"
 
 	"github.com/gochan-org/gochan/pkg/config"
+	"github.com/gochan-org/gochan/pkg/events"
 	"github.com/gochan-org/gochan/pkg/gcsql"
+	"github.com/uptrace/bunrouter"
 	lua "github.com/yuin/gopher-lua"
 	luar "layeh.com/gopher-luar"
 )
@@ -28,13 +37,13 @@ end)
 event_trigger("newPost", "blah", 16, 3.14, true, nil)`
 )
 
-func initPluginTests() {
+func initPluginTests() *luaPlugin {
 	config.SetVersion("3.4.1")
-	initLua()
+	return newLuaPlugin("test.lua", nil)
 }
 
 func TestVersionFunction(t *testing.T) {
-	initPluginTests()
+	lState := initPluginTests().state
 	err := lState.DoString(versionStr)
 	if err != nil {
 		t.Fatal(err.Error())
@@ -46,7 +55,7 @@ func TestVersionFunction(t *testing.T) {
 }
 
 func TestStructPassing(t *testing.T) {
-	initPluginTests()
+	lState := initPluginTests().state
 	p := &gcsql.Post{
 		Name:       "Joe Poster",
 		Email:      "joeposter@gmail.com",
@@ -65,9 +74,314 @@ func TestStructPassing(t *testing.T) {
 }
 
 func TestEventPlugins(t *testing.T) {
-	initPluginTests()
+	lState := initPluginTests().state
 	err := lState.DoString(eventsTestingStr)
 	if err != nil {
 		t.Fatal(err.Error())
 	}
 }
+
+func TestEventModifyPost(t *testing.T) {
+	lState := initPluginTests().state
+	err := lState.DoString(`event_register({"message-pre-format"}, function(tr, post, board)
+	post.Name = "Modified name"
+	post.Subject = "[" .. board.Dir .. "] " .. post.Subject
+	post.MessageRaw = string.gsub(post.MessageRaw, "hello", "goodbye")
+end)`)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	post := &gcsql.Post{
+		Name:       "Joe Poster",
+		Subject:    "Subject",
+		MessageRaw: "hello world",
+	}
+	handled, err := events.TriggerEvent("message-pre-format", post, &gcsql.Board{Dir: "test"})
+	if !handled || err != nil {
+		t.Fatalf("unexpected TriggerEvent result, handled: %t, err: %v", handled, err)
+	}
+	if post.Name != "Modified name" || post.Subject != "[test] Subject" || post.MessageRaw != "goodbye world" {
+		t.Fatalf("post was not properly modified by plugin: %#v", post)
+	}
+}
+
+func TestEventRejectPost(t *testing.T) {
+	lState := initPluginTests().state
+	err := lState.DoString(`event_register({"post-pre-insert"}, function(tr, post, board, uploads)
+	if string.find(post.MessageRaw, "spam") ~= nil then
+		return "Your post looks like spam"
+	end
+end)`)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	_, err = events.TriggerEvent("post-pre-insert", &gcsql.Post{MessageRaw: "not a problem"}, &gcsql.Board{}, []*gcsql.Upload{})
+	if err != nil {
+		t.Fatalf("post was rejected: %s", err.Error())
+	}
+	_, err = events.TriggerEvent("post-pre-insert", &gcsql.Post{MessageRaw: "buy spam"}, &gcsql.Board{}, []*gcsql.Upload{})
+	if err == nil || err.Error() != "Your post looks like spam" {
+		t.Fatalf("expected post to be rejected with the plugin's error, got %v", err)
+	}
+}
+
+func TestEventHandlerLuaError(t *testing.T) {
+	lState := initPluginTests().state
+	err := lState.DoString(`event_register({"thread-created"}, function(tr, post, board)
+	error("something went wrong")
+end)`)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	// errors in the handler itself shouldn't stop the post from being made
+	handled, err := events.TriggerEvent("thread-created", &gcsql.Post{}, &gcsql.Board{})
+	if !handled || err != nil {
+		t.Fatalf("unexpected TriggerEvent result, handled: %t, err: %v", handled, err)
+	}
+}
+
+func TestSandbox(t *testing.T) {
+	lState := initPluginTests().state
+	modulePath := path.Join(t.TempDir(), "sandboxtest.lua")
+	if err := os.WriteFile(modulePath, []byte("return {}"), 0644); err != nil {
+		t.Fatal(err.Error())
+	}
+	lState.SetGlobal("module_path", lua.LString(modulePath))
+	err := lState.DoString(`package.path = module_path
+	return os.execute == nil and io == nil and dofile == nil and os.time() > 0 and
+		not pcall(require, "sandboxtest") and require("gochan") ~= nil`)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if lState.Get(-1) != lua.LTrue {
+		t.Fatal("restricted libraries are available to plugins without access to them")
+	}
+
+	lState = newLuaPlugin("granted.lua", []string{"os", "io"}).state
+	lState.SetGlobal("module_path", lua.LString(modulePath))
+	if err = lState.DoString(`package.path = module_path
+	return os.execute ~= nil and io.open ~= nil and dofile ~= nil and require("sandboxtest") ~= nil`); err != nil {
+		t.Fatal(err.Error())
+	}
+	if lState.Get(-1) != lua.LTrue {
+		t.Fatal("restricted libraries aren't available to plugins with access to them")
+	}
+}
+
+func TestTemplatePaths(t *testing.T) {
+	lState := initPluginTests().state
+	err := lState.DoString(`local outside, outsideErr = load_template("../../../etc/gochan/gochan.json")
+local absolute, absoluteErr = load_template("/etc/passwd")
+return outside == nil and outsideErr ~= nil and absolute == nil and absoluteErr ~= nil`)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if lState.Get(-1) != lua.LTrue {
+		t.Fatal("plugins without access to io can load templates outside of the template directory")
+	}
+	for _, tmplPath := range []string{"manage_plugins.html", "override/../plugin/page.html", "plugin/./page.html"} {
+		if !templatePathAllowed(tmplPath) {
+			t.Errorf("expected %q to be allowed", tmplPath)
+		}
+	}
+}
+
+func TestHandlerTimeout(t *testing.T) {
+	plugin := initPluginTests()
+	plugin.timeout = 50 * time.Millisecond
+	err := plugin.state.DoString(`event_register({"timeout-test"}, function(tr, post)
+	if post.Name == "loop" then
+		while true do end
+	end
+	post.Name = "Modified name"
+end)`)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	post := &gcsql.Post{Name: "loop"}
+	if _, err = events.TriggerEvent("timeout-test", post); err != nil {
+		t.Fatalf("unexpected TriggerEvent error: %s", err.Error())
+	}
+	pluginErrors := plugin.Errors()
+	if len(pluginErrors) != 1 || pluginErrors[0].Message != ErrPluginTimeout.Error() {
+		t.Fatalf("expected the timeout to be recorded as a plugin error, got %#v", pluginErrors)
+	}
+
+	// the plugin should still work after being stopped
+	post.Name = "Joe Poster"
+	if _, err = events.TriggerEvent("timeout-test", post); err != nil {
+		t.Fatalf("unexpected TriggerEvent error: %s", err.Error())
+	}
+	if post.Name != "Modified name" {
+		t.Fatal("post was not modified by plugin after timeout")
+	}
+}
+
+type testGoPlugin struct {
+	posts int
+}
+
+func (*testGoPlugin) Name() string {
+	return "testplugin"
+}
+
+func (*testGoPlugin) Init() error {
+	return nil
+}
+
+func (*testGoPlugin) Close() error {
+	return nil
+}
+
+func (tp *testGoPlugin) RegisterRoutes(group *bunrouter.Group) {
+	group.GET("/posts", bunrouter.HTTPHandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		fmt.Fprint(writer, tp.posts)
+	}))
+}
+
+func (tp *testGoPlugin) EventHandlers() map[string]events.EventHandler {
+	return map[string]events.EventHandler{
+		"go-plugin-test": func(trigger string, data ...interface{}) error {
+			tp.posts++
+			return nil
+		},
+	}
+}
+
+func TestGoPlugin(t *testing.T) {
+	plugin := &testGoPlugin{}
+	RegisterPlugin(plugin)
+	RegisterPlugin(&testGoPlugin{})
+	if err := InitPlugins(); !errors.Is(err, ErrPluginNameInUse) {
+		t.Fatalf("expected plugin with a duplicate name to fail initializing, got %v", err)
+	}
+	defer closeGoPlugins()
+
+	if _, err := events.TriggerEvent("go-plugin-test"); err != nil {
+		t.Fatal(err.Error())
+	}
+	if plugin.posts != 1 {
+		t.Fatalf("expected the plugin's event handler to be called once, got %d", plugin.posts)
+	}
+
+	router := bunrouter.New()
+	RegisterPluginRoutes(router)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/plugin/testplugin/posts", nil))
+	if recorder.Code != http.StatusOK || recorder.Body.String() != "1" {
+		t.Fatalf("unexpected response from plugin route: %d %q", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestReloadPlugins(t *testing.T) {
+	config.SetVersion("3.4.1")
+	pluginPath := path.Join(t.TempDir(), "reloadtest.lua")
+	writePlugin := func(version string) {
+		if err := os.WriteFile(pluginPath, []byte(`event_register({"reload-test"}, function(tr, post)
+	post.Name = post.Name .. "`+version+`"
+end)`), 0600); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	triggerReloadTest := func() string {
+		post := &gcsql.Post{}
+		if _, err := events.TriggerEvent("reload-test", post); err != nil {
+			t.Fatal(err.Error())
+		}
+		return post.Name
+	}
+	defer LoadPlugins(nil)
+
+	writePlugin("v1")
+	if err := LoadPlugins([]string{pluginPath}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if name := triggerReloadTest(); name != "v1" {
+		t.Fatalf(`expected "v1", got %q`, name)
+	}
+
+	writePlugin("v2")
+	if err := LoadPlugins([]string{pluginPath}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if name := triggerReloadTest(); name != "v2" {
+		t.Fatalf(`expected only the reloaded handler to be called ("v2"), got %q`, name)
+	}
+
+	// a plugin that fails to load shouldn't leave any of its handlers registered
+	if err := os.WriteFile(pluginPath, []byte(`event_register({"reload-test"}, function(tr, post)
+	post.Name = "v3"
+end)
+error("broken plugin")`), 0600); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := LoadPlugins([]string{pluginPath}); err == nil {
+		t.Fatal("expected an error from loading the broken plugin")
+	}
+	if name := triggerReloadTest(); name != "" {
+		t.Fatalf("expected no handlers to be called, got %q", name)
+	}
+	if luaPlugins := getLuaPlugins(); len(luaPlugins) != 1 || luaPlugins[0].LoadError == "" {
+		t.Fatal("expected the plugin's load error to be recorded")
+	}
+}
+
+func TestPluginManagePages(t *testing.T) {
+	config.SetVersion("3.4.1")
+	pluginPath := path.Join(t.TempDir(), "managepagetest.lua")
+	if err := os.WriteFile(pluginPath, []byte(`local function page() return "", "" end
+register_manage_page("plugins", "Not the plugins page", 3, 0, page)
+register_manage_page("managepagetest", "Test page", 3, 0, page)`), 0600); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer LoadPlugins(nil)
+
+	// reloading the plugin shouldn't reject the page it registered the last time it was loaded
+	for i := 0; i < 2; i++ {
+		if err := LoadPlugins([]string{pluginPath}); err != nil {
+			t.Fatal(err.Error())
+		}
+		plugin := getLuaPlugins()[0]
+		if len(plugin.ManagePages) != 1 || plugin.ManagePages[0] != "managepagetest" {
+			t.Fatalf(`expected only the "managepagetest" page to be registered, got %v`, plugin.ManagePages)
+		}
+		if pluginErrors := plugin.Errors(); len(pluginErrors) != 1 || pluginErrors[0].Source != "manage page plugins" {
+			t.Fatalf("expected the built-in plugins page to be rejected, got %v", pluginErrors)
+		}
+	}
+}
+
+func TestGochanModule(t *testing.T) {
+	lState := initPluginTests().state
+	lState.SetGlobal("posts", lState.NewFunction(func(l *lua.LState) int {
+		return pushResult(l, []gcsql.Post{{ID: 1, Name: "a"}, {ID: 2, Name: "b"}}, nil)
+	}))
+	err := lState.DoString(`local gochan = require("gochan")
+local names = ""
+local posts, err = posts()
+for _, post in ipairs(posts) do
+	names = names .. post.Name
+end
+local readOnly = not pcall(function() posts[1].Name = "c" end) and posts[1].Name == "a" and posts[1].Password == nil
+local ban, banErr = gochan.ban_ip({ip = "192.168.1.1", duration = "1d"})
+local attrErr = gochan.set_thread_attribute(1, "locked", true)
+return names, readOnly, err, ban, banErr, attrErr`)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if names := lState.Get(-6).String(); names != "ab" {
+		t.Fatalf(`expected posts to be converted to a table ("ab"), got %q`, names)
+	}
+	if lState.Get(-5) != lua.LTrue {
+		t.Fatal("expected the posts' fields to be read-only")
+	}
+	if lState.Get(-4) != lua.LNil || lState.Get(-3) != lua.LNil {
+		t.Fatal("expected no error and no ban to be returned")
+	}
+	if banErr := lState.Get(-2).String(); banErr != ErrBanStaffRequired.Error() {
+		t.Fatalf("expected ban without a staff member to fail, got %q", banErr)
+	}
+	if attrErr := lState.Get(-1).String(); attrErr != ErrThreadStaffRequired.Error() {
+		t.Fatalf("expected thread update without a staff member to fail, got %q", attrErr)
+	}
+}
@@ -0,0 +1,428 @@
+package gcplugin
+
+import (
+	"errors"
+	"html"
+	"time"
+
+	"github.com/gochan-org/gochan/pkg/building"
+	"github.com/gochan-org/gochan/pkg/gcsql"
+	"github.com/gochan-org/gochan/pkg/gcutil"
+	"github.com/gochan-org/gochan/pkg/manage"
+	lua "github.com/yuin/gopher-lua"
+)
+
+var (
+	ErrBanStaffRequired    = errors.New("the staff member issuing the ban must be set")
+	ErrThreadStaffRequired = errors.New("the staff member updating the thread must be set")
+)
+
+// gochanModuleLoader returns the loader of the module returned by require("gochan"), which gives plugins access
+// to posts, boards, threads, reports, and bans, and lets them create reports and bans, update threads, and
+// queue rebuilds of pages. Posts, boards, threads, reports, and bans are returned as read-only tables with the
+// same field names as the gcsql structs. Functions return nil and an error message (or just an error message)
+// if something goes wrong
+func gochanModuleLoader(plugin *luaPlugin) lua.LGFunction {
+	return func(l *lua.LState) int {
+		module := l.SetFuncs(l.NewTable(), map[string]lua.LGFunction{
+			"get_post": func(l *lua.LState) int {
+				post, err := gcsql.GetPostFromID(l.CheckInt(1), !l.OptBool(2, false))
+				return pushResult(l, post, err)
+			},
+			"get_posts_from_ip": func(l *lua.LState) int {
+				posts, err := gcsql.GetPostsFromIP(l.CheckString(1), l.OptInt(2, 20), true)
+				return pushResult(l, posts, err)
+			},
+			"get_board": func(l *lua.LState) int {
+				board, err := gcsql.GetBoardFromDir(l.CheckString(1))
+				return pushResult(l, board, err)
+			},
+			"get_board_from_id": func(l *lua.LState) int {
+				board, err := gcsql.GetBoardFromID(l.CheckInt(1))
+				return pushResult(l, board, err)
+			},
+			"get_thread": func(l *lua.LState) int {
+				thread, err := gcsql.GetThread(l.CheckInt(1))
+				return pushResult(l, thread, err)
+			},
+			"get_reports": func(l *lua.LState) int {
+				reports, err := gcsql.GetReports(l.OptBool(1, false))
+				return pushResult(l, reports, err)
+			},
+			"check_ip_ban": func(l *lua.LState) int {
+				ban, err := gcsql.CheckIPBan(l.CheckString(1), l.OptInt(2, 0))
+				return pushResult(l, ban, err)
+			},
+			"create_report": func(l *lua.LState) int {
+				// reports made by plugins don't have a reporter IP
+				report, err := gcsql.CreateReport(l.CheckInt(1), l.OptInt(3, 0), "", l.CheckString(2))
+				return pushResult(l, report, err)
+			},
+			"ban_ip": func(l *lua.LState) int {
+				ban, err := banFromTable(plugin, l.CheckTable(1))
+				return pushResult(l, ban, err)
+			},
+			"set_thread_attribute": func(l *lua.LState) int {
+				return pushError(l, setThreadAttribute(plugin, l.CheckInt(1), l.CheckString(2), l.CheckBool(3), l.OptString(4, "")))
+			},
+			"build_threads": func(l *lua.LState) int {
+				for i := 1; i <= l.GetTop(); i++ {
+					if err := queueThreadPages(l.CheckInt(i)); err != nil {
+						return pushError(l, err)
+					}
+				}
+				return pushError(l, nil)
+			},
+			"build_boards": func(l *lua.LState) int {
+				var boards []gcsql.Board
+				var err error
+				if l.GetTop() == 0 {
+					if boards, err = gcsql.GetAllBoards(false); err != nil {
+						return pushError(l, err)
+					}
+				}
+				for i := 1; i <= l.GetTop(); i++ {
+					board, err := gcsql.GetBoardFromID(l.CheckInt(i))
+					if err != nil {
+						return pushError(l, err)
+					}
+					boards = append(boards, *board)
+				}
+				for b := range boards {
+					building.QueueFullBoard(&boards[b])
+				}
+				return pushError(l, nil)
+			},
+		})
+		l.Push(module)
+		return 1
+	}
+}
+
+// queueThreadPages queues a rebuild of the pages of the thread with the given ID
+func queueThreadPages(threadID int) error {
+	thread, err := gcsql.GetThread(threadID)
+	if err != nil {
+		return err
+	}
+	board, err := gcsql.GetBoardFromID(thread.BoardID)
+	if err != nil {
+		return err
+	}
+	topPost, err := gcsql.GetThreadTopPost(threadID)
+	if err != nil {
+		return err
+	}
+	building.QueueThreadPages(board, topPost.ID)
+	return nil
+}
+
+// setThreadAttribute sets the locked, stickied, anchored, or cyclical attribute of the thread the same way the
+// thread attributes manage page does, with the change logged in the moderation log as being made by the staff
+// member with the given username
+func setThreadAttribute(plugin *luaPlugin, threadID int, attr string, value bool, staffName string) error {
+	if staffName == "" {
+		return ErrThreadStaffRequired
+	}
+	staff, err := gcsql.GetStaffByUsername(staffName, true)
+	if err != nil {
+		return err
+	}
+	thread, err := gcsql.GetThread(threadID)
+	if err != nil {
+		return err
+	}
+	board, err := gcsql.GetBoardFromID(thread.BoardID)
+	if err != nil {
+		return err
+	}
+	topPost, err := gcsql.GetThreadTopPost(threadID)
+	if err != nil {
+		return err
+	}
+	if err = manage.SetThreadAttribute(staff, thread, board, topPost.ID, attr, value); err != nil {
+		return err
+	}
+	gcutil.LogInfo().
+		Str("plugin", plugin.Name).
+		Str("staff", staff.Username).
+		Int("threadID", threadID).
+		Str("attribute", attr).
+		Bool("value", value).
+		Msg("Plugin updated thread attribute")
+	return nil
+}
+
+// pushResult pushes the value as a read-only table (or nil if there was an error) and the error message.
+// Slices are pushed as arrays of read-only tables so that they can be used with ipairs
+func pushResult(l *lua.LState, value interface{}, err error) int {
+	if err != nil {
+		l.Push(lua.LNil)
+		l.Push(lua.LString(err.Error()))
+		return 2
+	}
+	switch v := value.(type) {
+	case []gcsql.Post:
+		table := l.CreateTable(len(v), 0)
+		for p := range v {
+			table.Append(postTable(l, &v[p]))
+		}
+		l.Push(table)
+	case []gcsql.Report:
+		table := l.CreateTable(len(v), 0)
+		for r := range v {
+			table.Append(reportTable(l, &v[r]))
+		}
+		l.Push(table)
+	case *gcsql.Post:
+		l.Push(postTable(l, v))
+	case *gcsql.Board:
+		l.Push(boardTable(l, v))
+	case *gcsql.Thread:
+		l.Push(threadTable(l, v))
+	case *gcsql.Report:
+		l.Push(reportTable(l, v))
+	case *gcsql.IPBan:
+		l.Push(banTable(l, v))
+	default:
+		l.Push(lua.LNil)
+	}
+	l.Push(lua.LNil)
+	return 2
+}
+
+// readOnlyTable returns an empty table that gets its fields from a table with the given fields, and raises an
+// error if a field is set, so that plugins can't expect changes to the fields to be saved
+func readOnlyTable(l *lua.LState, fields map[string]lua.LValue) *lua.LTable {
+	values := l.CreateTable(0, len(fields))
+	for field, value := range fields {
+		values.RawSetString(field, value)
+	}
+	metatable := l.CreateTable(0, 3)
+	metatable.RawSetString("__index", values)
+	metatable.RawSetString("__newindex", l.NewFunction(func(l *lua.LState) int {
+		l.RaiseError("cannot set field %q of a read-only table", l.CheckString(2))
+		return 0
+	}))
+	metatable.RawSetString("__metatable", lua.LFalse)
+	table := l.NewTable()
+	l.SetMetatable(table, metatable)
+	return table
+}
+
+// luaTime returns the time as a Unix timestamp, or 0 if it isn't set
+func luaTime(t time.Time) lua.LNumber {
+	if t.IsZero() {
+		return 0
+	}
+	return lua.LNumber(t.Unix())
+}
+
+// luaIntPtr returns the value of i, or nil if i is nil
+func luaIntPtr(i *int) lua.LValue {
+	if i == nil {
+		return lua.LNil
+	}
+	return lua.LNumber(*i)
+}
+
+func postTable(l *lua.LState, post *gcsql.Post) lua.LValue {
+	if post == nil {
+		return lua.LNil
+	}
+	return readOnlyTable(l, map[string]lua.LValue{
+		"ID":              lua.LNumber(post.ID),
+		"ThreadID":        lua.LNumber(post.ThreadID),
+		"IsTopPost":       lua.LBool(post.IsTopPost),
+		"IP":              lua.LString(post.IP),
+		"CreatedOn":       luaTime(post.CreatedOn),
+		"Name":            lua.LString(post.Name),
+		"Tripcode":        lua.LString(post.Tripcode),
+		"IsRoleSignature": lua.LBool(post.IsRoleSignature),
+		"Email":           lua.LString(post.Email),
+		"Subject":         lua.LString(post.Subject),
+		"Message":         lua.LString(post.Message),
+		"MessageRaw":      lua.LString(post.MessageRaw),
+		"DeletedAt":       luaTime(post.DeletedAt),
+		"IsDeleted":       lua.LBool(post.IsDeleted),
+		"BannedMessage":   lua.LString(post.BannedMessage),
+	})
+}
+
+func boardTable(l *lua.LState, board *gcsql.Board) lua.LValue {
+	if board == nil {
+		return lua.LNil
+	}
+	return readOnlyTable(l, map[string]lua.LValue{
+		"ID":               lua.LNumber(board.ID),
+		"SectionID":        lua.LNumber(board.SectionID),
+		"URI":              lua.LString(board.URI),
+		"Dir":              lua.LString(board.Dir),
+		"NavbarPosition":   lua.LNumber(board.NavbarPosition),
+		"Title":            lua.LString(board.Title),
+		"Subtitle":         lua.LString(board.Subtitle),
+		"Description":      lua.LString(board.Description),
+		"MaxFilesize":      lua.LNumber(board.MaxFilesize),
+		"MaxThreads":       lua.LNumber(board.MaxThreads),
+		"DefaultStyle":     lua.LString(board.DefaultStyle),
+		"Locked":           lua.LBool(board.Locked),
+		"CreatedAt":        luaTime(board.CreatedAt),
+		"AnonymousName":    lua.LString(board.AnonymousName),
+		"ForceAnonymous":   lua.LBool(board.ForceAnonymous),
+		"AutosageAfter":    lua.LNumber(board.AutosageAfter),
+		"NoImagesAfter":    lua.LNumber(board.NoImagesAfter),
+		"MaxMessageLength": lua.LNumber(board.MaxMessageLength),
+		"MinMessageLength": lua.LNumber(board.MinMessageLength),
+		"AllowEmbeds":      lua.LBool(board.AllowEmbeds),
+		"RedirectToThread": lua.LBool(board.RedirectToThread),
+		"RequireFile":      lua.LBool(board.RequireFile),
+		"EnableCatalog":    lua.LBool(board.EnableCatalog),
+	})
+}
+
+func threadTable(l *lua.LState, thread *gcsql.Thread) lua.LValue {
+	if thread == nil {
+		return lua.LNil
+	}
+	return readOnlyTable(l, map[string]lua.LValue{
+		"ID":         lua.LNumber(thread.ID),
+		"BoardID":    lua.LNumber(thread.BoardID),
+		"Locked":     lua.LBool(thread.Locked),
+		"Stickied":   lua.LBool(thread.Stickied),
+		"Anchored":   lua.LBool(thread.Anchored),
+		"Cyclical":   lua.LBool(thread.Cyclical),
+		"LastBump":   luaTime(thread.LastBump),
+		"DeletedAt":  luaTime(thread.DeletedAt),
+		"IsDeleted":  lua.LBool(thread.IsDeleted),
+		"ArchivedAt": luaTime(thread.ArchivedAt),
+		"IsArchived": lua.LBool(thread.IsArchived),
+	})
+}
+
+func reportTable(l *lua.LState, report *gcsql.Report) lua.LValue {
+	if report == nil {
+		return lua.LNil
+	}
+	return readOnlyTable(l, map[string]lua.LValue{
+		"ID":               lua.LNumber(report.ID),
+		"HandledByStaffID": lua.LNumber(report.HandledByStaffID),
+		"PostID":           lua.LNumber(report.PostID),
+		"CategoryID":       lua.LNumber(report.CategoryID),
+		"IP":               lua.LString(report.IP),
+		"Reason":           lua.LString(report.Reason),
+		"IsCleared":        lua.LBool(report.IsCleared),
+	})
+}
+
+func banTable(l *lua.LState, ban *gcsql.IPBan) lua.LValue {
+	if ban == nil {
+		return lua.LNil
+	}
+	return readOnlyTable(l, map[string]lua.LValue{
+		"ID":              lua.LNumber(ban.ID),
+		"BoardID":         luaIntPtr(ban.BoardID),
+		"BannedForPostID": luaIntPtr(ban.BannedForPostID),
+		"CopyPostText":    lua.LString(ban.CopyPostText),
+		"IP":              lua.LString(ban.IP),
+		"IssuedAt":        luaTime(ban.IssuedAt),
+		"IsActive":        lua.LBool(ban.IsActive),
+		"IsThreadBan":     lua.LBool(ban.IsThreadBan),
+		"ExpiresAt":       luaTime(ban.ExpiresAt),
+		"StaffID":         lua.LNumber(ban.StaffID),
+		"AppealAt":        luaTime(ban.AppealAt),
+		"Permanent":       lua.LBool(ban.Permanent),
+		"StaffNote":       lua.LString(ban.StaffNote),
+		"Message":         lua.LString(ban.Message),
+		"CanAppeal":       lua.LBool(ban.CanAppeal),
+	})
+}
+
+// pushError pushes the error message, or nil if err is nil
+func pushError(l *lua.LState, err error) int {
+	if err != nil {
+		l.Push(lua.LString(err.Error()))
+	} else {
+		l.Push(lua.LNil)
+	}
+	return 1
+}
+
+// banFromTable creates an IP ban from the fields of a Lua table, which are the same as the ones on the bans
+// manage page: ip, staff (the username of the staff member issuing the ban), board (the board's directory,
+// all boards if not set), post (the ID of the post the IP is being banned for), duration, permanent,
+// appeal_wait, no_appeals, thread_ban, reason, and staff_note. The ban is added to the moderation log
+func banFromTable(plugin *luaPlugin, table *lua.LTable) (*gcsql.IPBan, error) {
+	staffName := lua.LVAsString(table.RawGetString("staff"))
+	if staffName == "" {
+		return nil, ErrBanStaffRequired
+	}
+	staff, err := gcsql.GetStaffByUsername(staffName, true)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	ban := &gcsql.IPBan{
+		IP: lua.LVAsString(table.RawGetString("ip")),
+	}
+	ban.StaffID = staff.ID
+	if boardDir := lua.LVAsString(table.RawGetString("board")); boardDir != "" {
+		boardID, err := gcsql.GetBoardIDFromDir(boardDir)
+		if err != nil {
+			return nil, err
+		}
+		ban.BoardID = &boardID
+	}
+	if postID := int(lua.LVAsNumber(table.RawGetString("post"))); postID > 0 {
+		post, err := gcsql.GetPostFromID(postID, true)
+		if err != nil {
+			return nil, err
+		}
+		ban.BannedForPostID = &post.ID
+		ban.CopyPostText = post.Message
+	}
+
+	ban.Permanent = lua.LVAsBool(table.RawGetString("permanent"))
+	if ban.Permanent {
+		ban.ExpiresAt = now
+	} else {
+		duration, err := gcutil.ParseDurationString(lua.LVAsString(table.RawGetString("duration")))
+		if err != nil {
+			return nil, err
+		}
+		ban.ExpiresAt = now.Add(duration)
+	}
+	ban.CanAppeal = !lua.LVAsBool(table.RawGetString("no_appeals"))
+	ban.AppealAt = now
+	if appealWaitStr := lua.LVAsString(table.RawGetString("appeal_wait")); ban.CanAppeal && appealWaitStr != "" {
+		appealWait, err := gcutil.ParseDurationString(appealWaitStr)
+		if err != nil {
+			return nil, err
+		}
+		ban.AppealAt = now.Add(appealWait)
+	}
+	ban.IsThreadBan = lua.LVAsBool(table.RawGetString("thread_ban"))
+	ban.Message = html.EscapeString(lua.LVAsString(table.RawGetString("reason")))
+	ban.StaffNote = html.EscapeString(lua.LVAsString(table.RawGetString("staff_note")))
+	if ban.StaffNote == "" {
+		ban.StaffNote = "Banned by the " + plugin.Name + " plugin"
+	}
+	ban.IsActive = true
+
+	if err = gcsql.NewIPBan(ban); err != nil {
+		return nil, err
+	}
+	gcutil.LogInfo().
+		Str("plugin", plugin.Name).
+		Str("staff", staff.Username).
+		Str("IP", ban.IP).
+		Int("banID", ban.ID).
+		Msg("Plugin created IP ban")
+	manage.LogStaffAction(staff, gcsql.ModLogEntry{
+		Action:     gcsql.ModLogBanCreate,
+		TargetType: gcsql.ModLogTargetBan,
+		TargetID:   ban.ID,
+		BoardID:    ban.BoardID,
+	}, nil, ban)
+	return ban, nil
+}
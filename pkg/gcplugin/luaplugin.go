@@ -0,0 +1,307 @@
+package gcplugin
+
+import (
+	"context"
+	"errors"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gochan-org/gochan/pkg/config"
+	"github.com/gochan-org/gochan/pkg/events"
+	"github.com/gochan-org/gochan/pkg/gcutil"
+	"github.com/gochan-org/gochan/pkg/manage"
+	lua "github.com/yuin/gopher-lua"
+	luar "layeh.com/gopher-luar"
+)
+
+const (
+	// maxPluginErrors is the number of recent errors kept for each plugin to be shown on the plugins page
+	maxPluginErrors = 20
+)
+
+var (
+	ErrPluginTimeout = errors.New("plugin took too long to run")
+	ErrPluginBusy    = errors.New("timed out waiting for the plugin to finish running another handler")
+	ErrPluginClosed  = errors.New("plugin is not loaded")
+
+	// restrictedLibraries are the standard Lua libraries that can access the filesystem or run programs,
+	// which plugins can only use if they are given access in the PluginLibraries configuration field
+	restrictedLibraries = map[string]lua.LGFunction{
+		lua.OsLibName:    lua.OpenOs,
+		lua.IoLibName:    lua.OpenIo,
+		lua.DebugLibName: lua.OpenDebug,
+	}
+	// safeOsFunctions are the functions in the os library that plugins can use without being given access
+	safeOsFunctions = []string{"clock", "date", "difftime", "time"}
+	// unsafeBaseFunctions are removed from the base library if the plugin doesn't have access to io
+	unsafeBaseFunctions = []string{"dofile", "loadfile"}
+	// preloadLoaderIndex is the index of the package.preload loader in package.loaders. Plugins without access
+	// to io can only require preloaded modules like gochan
+	preloadLoaderIndex = 1
+)
+
+// pluginError is an error in a plugin's event handler or manage page, or in loading the plugin
+type pluginError struct {
+	Timestamp time.Time
+	Source    string
+	Message   string
+}
+
+// luaPlugin is a Lua script listed in the Plugins configuration field. Each plugin has its own Lua state, which
+// can only be used by one goroutine at a time
+type luaPlugin struct {
+	Name string
+	Path string
+	// Libraries are the restricted libraries that the plugin can use
+	Libraries   []string
+	Events      []string
+	ManagePages []string
+	LoadError   string
+
+	// loading is true while load is running the plugin's script. Event handlers and manage pages registered while
+	// it is loading are registered along with those of the other plugins once they have all been loaded
+	loading         bool
+	pendingHandlers []events.Handler
+	pendingPages    []manage.Action
+	// eventIDs are the IDs of the plugin's registered event handlers, used to unregister them when it is replaced
+	eventIDs     []int
+	eventIDsLock sync.Mutex
+
+	state   *lua.LState
+	timeout time.Duration
+	// lock is held while the state is being used, since Lua states aren't safe for concurrent use
+	lock chan struct{}
+	// closed is set to 1 when the state is closed
+	closed int32
+
+	errorsLock sync.Mutex
+	errors     []pluginError
+}
+
+// newLuaPlugin creates a plugin with a new Lua state that can use the given restricted libraries
+func newLuaPlugin(pluginPath string, libraries []string) *luaPlugin {
+	systemCritical := config.GetSystemCriticalConfig()
+	timeout, err := time.ParseDuration(systemCritical.PluginTimeout)
+	if err != nil || timeout <= 0 {
+		// the timeout is validated when the configuration is loaded, but it may not be set in tests
+		timeout = 2 * time.Second
+	}
+	plugin := &luaPlugin{
+		Name:      strings.TrimSuffix(path.Base(pluginPath), path.Ext(pluginPath)),
+		Path:      pluginPath,
+		Libraries: libraries,
+		timeout:   timeout,
+		lock:      make(chan struct{}, 1),
+		state:     lua.NewState(lua.Options{SkipOpenLibs: true}),
+	}
+	plugin.openLibraries()
+	registerLuaFunctions(plugin)
+	return plugin
+}
+
+// HasLibrary returns true if the plugin has been given access to the restricted library
+func (p *luaPlugin) HasLibrary(name string) bool {
+	for _, lib := range p.Libraries {
+		if lib == name {
+			return true
+		}
+	}
+	return false
+}
+
+// openLibraries opens the standard Lua libraries that the plugin is allowed to use. Plugins without access to
+// os can still use its time functions, and plugins without access to io can only require preloaded modules
+func (p *luaPlugin) openLibraries() {
+	libraries := []struct {
+		name string
+		fn   lua.LGFunction
+	}{
+		{lua.LoadLibName, lua.OpenPackage},
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+		{lua.CoroutineLibName, lua.OpenCoroutine},
+		{lua.OsLibName, lua.OpenOs},
+	}
+	for name, fn := range restrictedLibraries {
+		if name != lua.OsLibName && p.HasLibrary(name) {
+			libraries = append(libraries, struct {
+				name string
+				fn   lua.LGFunction
+			}{name, fn})
+		}
+	}
+	for _, lib := range libraries {
+		p.state.Push(p.state.NewFunction(lib.fn))
+		p.state.Push(lua.LString(lib.name))
+		p.state.Call(1, 0)
+	}
+
+	if !p.HasLibrary(lua.OsLibName) {
+		osTable := p.state.GetGlobal(lua.OsLibName).(*lua.LTable)
+		safeOs := p.state.NewTable()
+		for _, fn := range safeOsFunctions {
+			safeOs.RawSetString(fn, osTable.RawGetString(fn))
+		}
+		p.state.SetGlobal(lua.OsLibName, safeOs)
+		p.state.GetField(p.state.Get(lua.RegistryIndex), "_LOADED").(*lua.LTable).RawSetString(lua.OsLibName, safeOs)
+	}
+	if !p.HasLibrary(lua.IoLibName) {
+		for _, fn := range unsafeBaseFunctions {
+			p.state.SetGlobal(fn, lua.LNil)
+		}
+		// require uses the loaders in the registry, which is the same table as package.loaders
+		packageTable := p.state.GetGlobal(lua.LoadLibName).(*lua.LTable)
+		loaders := p.state.GetField(p.state.Get(lua.RegistryIndex), "_LOADERS").(*lua.LTable)
+		for l := loaders.Len(); l > preloadLoaderIndex; l-- {
+			loaders.RawSetInt(l, lua.LNil)
+		}
+		packageTable.RawSetString("path", lua.LString(""))
+		packageTable.RawSetString("cpath", lua.LString(""))
+	}
+}
+
+// run calls fn with the plugin's Lua state once no other goroutine is using it. The state is given a context
+// that stops any Lua code that is still running after the plugin timeout
+func (p *luaPlugin) run(source string, fn func(l *lua.LState) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+	select {
+	case p.lock <- struct{}{}:
+	case <-ctx.Done():
+		return p.logError(source, ErrPluginBusy)
+	}
+	defer func() { <-p.lock }()
+	if atomic.LoadInt32(&p.closed) == 1 {
+		return ErrPluginClosed
+	}
+
+	p.state.SetContext(ctx)
+	defer p.state.RemoveContext()
+	err := fn(p.state)
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = ErrPluginTimeout
+	}
+	if err != nil {
+		return p.logError(source, err)
+	}
+	return nil
+}
+
+// call calls the Lua function with the given arguments and returns nRet values returned by it
+func (p *luaPlugin) call(source string, fn *lua.LFunction, nRet int, args ...interface{}) ([]lua.LValue, error) {
+	ret := make([]lua.LValue, nRet)
+	err := p.run(source, func(l *lua.LState) error {
+		lArgs := make([]lua.LValue, len(args))
+		for a, arg := range args {
+			lArgs[a] = luar.New(l, arg)
+		}
+		if err := l.CallByParam(lua.P{
+			Fn:      fn,
+			NRet:    nRet,
+			Protect: true,
+		}, lArgs...); err != nil {
+			return err
+		}
+		for r := range ret {
+			ret[r] = l.Get(r - nRet)
+		}
+		l.Pop(nRet)
+		return nil
+	})
+	return ret, err
+}
+
+// load runs the plugin's script, which registers its event handlers and manage pages
+func (p *luaPlugin) load() error {
+	err := p.run("load", func(l *lua.LState) error {
+		p.loading = true
+		defer func() { p.loading = false }()
+		return l.DoFile(p.Path)
+	})
+	if err != nil {
+		p.LoadError = err.Error()
+		p.pendingHandlers = nil
+		p.pendingPages = nil
+		p.ManagePages = nil
+		p.close()
+	}
+	return err
+}
+
+// addEventIDs adds the IDs of registered event handlers to the ones unregistered when the plugin is replaced
+func (p *luaPlugin) addEventIDs(ids ...int) {
+	p.eventIDsLock.Lock()
+	defer p.eventIDsLock.Unlock()
+	p.eventIDs = append(p.eventIDs, ids...)
+}
+
+// takeEventIDs returns the IDs of the plugin's registered event handlers so that they can be unregistered
+func (p *luaPlugin) takeEventIDs() []int {
+	p.eventIDsLock.Lock()
+	defer p.eventIDsLock.Unlock()
+	ids := p.eventIDs
+	p.eventIDs = nil
+	return ids
+}
+
+// logError logs the error and adds it to the plugin's recent errors, and returns it
+func (p *luaPlugin) logError(source string, err error) error {
+	gcutil.LogError(err).
+		Str("plugin", p.Name).
+		Str("source", source).
+		Msg("Error in plugin")
+	p.errorsLock.Lock()
+	defer p.errorsLock.Unlock()
+	p.errors = append(p.errors, pluginError{
+		Timestamp: time.Now(),
+		Source:    source,
+		Message:   err.Error(),
+	})
+	if len(p.errors) > maxPluginErrors {
+		p.errors = p.errors[len(p.errors)-maxPluginErrors:]
+	}
+	return err
+}
+
+// Errors returns the plugin's recent errors, newest first
+func (p *luaPlugin) Errors() []pluginError {
+	p.errorsLock.Lock()
+	defer p.errorsLock.Unlock()
+	errs := make([]pluginError, len(p.errors))
+	for e, err := range p.errors {
+		errs[len(errs)-e-1] = err
+	}
+	return errs
+}
+
+// Loaded returns true if the plugin's script ran successfully and it hasn't been closed
+func (p *luaPlugin) Loaded() bool {
+	return p.LoadError == "" && atomic.LoadInt32(&p.closed) == 0
+}
+
+// close closes the plugin's Lua state. Its event handlers and manage pages do nothing afterwards. If another
+// handler is still using the state after the plugin timeout, the state is closed once it finishes
+func (p *luaPlugin) close() {
+	select {
+	case p.lock <- struct{}{}:
+	case <-time.After(p.timeout):
+		p.logError("close", ErrPluginBusy)
+		if atomic.CompareAndSwapInt32(&p.closed, 0, 1) {
+			go func() {
+				p.lock <- struct{}{}
+				defer func() { <-p.lock }()
+				p.state.Close()
+			}()
+		}
+		return
+	}
+	defer func() { <-p.lock }()
+	if atomic.CompareAndSwapInt32(&p.closed, 0, 1) {
+		p.state.Close()
+	}
+}
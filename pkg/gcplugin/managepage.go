@@ -0,0 +1,123 @@
+package gcplugin
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gochan-org/gochan/pkg/config"
+	"github.com/gochan-org/gochan/pkg/gcsql"
+	"github.com/gochan-org/gochan/pkg/gctemplates"
+	"github.com/gochan-org/gochan/pkg/manage"
+	"github.com/gochan-org/gochan/pkg/server/serverutil"
+	"github.com/rs/zerolog"
+)
+
+// pluginInfo is a plugin as it is shown on the plugins manage page
+type pluginInfo struct {
+	// Type is either "Lua" or "Go"
+	Type string
+	Name string
+	// Path is the script path of a Lua plugin, or the type of a Go plugin
+	Path        string
+	Routes      string
+	Loaded      bool
+	LoadError   string
+	Libraries   []string
+	Events      []string
+	ManagePages []string
+	Errors      []pluginError
+}
+
+func init() {
+	manage.RegisterManagePage("plugins", "Plugins", manage.AdminPerms, gcsql.CapPluginView, manage.OptionalJSON,
+		pluginsCallback)
+	manage.RegisterManagePage("reloadplugins", "Reload plugins and templates", manage.AdminPerms,
+		gcsql.CapPluginReload, manage.OptionalJSON, reloadPluginsCallback)
+}
+
+// pluginsCallback shows the loaded Lua plugins, the restricted libraries they can use, and their recent errors,
+// as well as the registered Go plugins
+func pluginsCallback(writer http.ResponseWriter, request *http.Request, staff *gcsql.Staff, wantsJSON bool, infoEv *zerolog.Event, errEv *zerolog.Event) (output interface{}, err error) {
+	infos := getPluginInfo()
+	if wantsJSON {
+		return infos, nil
+	}
+	return pluginsPage(staff, infos, map[string]interface{}{}, errEv)
+}
+
+// reloadPluginsCallback reloads the Lua plugins and templates when the form on the plugins page is submitted,
+// and shows the plugins page with any errors
+func reloadPluginsCallback(writer http.ResponseWriter, request *http.Request, staff *gcsql.Staff, wantsJSON bool, infoEv *zerolog.Event, errEv *zerolog.Event) (output interface{}, err error) {
+	pageData := map[string]interface{}{}
+	if request.Method == http.MethodPost {
+		pluginErr, templateErr := Reload()
+		infoEv.Bool("pluginErrors", pluginErr != nil).
+			Bool("templateErrors", templateErr != nil).
+			Msg("Reloaded plugins and templates")
+		manage.LogStaffAction(staff, gcsql.ModLogEntry{
+			Action:     gcsql.ModLogSiteReload,
+			TargetType: gcsql.ModLogTargetSite,
+		}, nil, "plugins and templates")
+		pageData["message"] = "Reloaded plugins and templates"
+		if pluginErr != nil {
+			pageData["pluginError"] = pluginErr.Error()
+		}
+		if templateErr != nil {
+			pageData["templateError"] = templateErr.Error()
+		}
+		if wantsJSON {
+			pageData["plugins"] = getPluginInfo()
+			return pageData, nil
+		}
+	} else if wantsJSON {
+		return nil, errors.New("plugins and templates can only be reloaded with a POST request")
+	}
+	return pluginsPage(staff, getPluginInfo(), pageData, errEv)
+}
+
+// getPluginInfo returns the registered Go plugins and the loaded Lua plugins
+func getPluginInfo() []pluginInfo {
+	luaPlugins := getLuaPlugins()
+	infos := make([]pluginInfo, 0, len(luaPlugins)+len(goPlugins))
+	for _, gp := range goPlugins {
+		infos = append(infos, pluginInfo{
+			Type:      "Go",
+			Name:      gp.plugin.Name(),
+			Path:      fmt.Sprintf("%T", gp.plugin),
+			Routes:    config.WebPath("/plugin", gp.plugin.Name()) + "/",
+			Loaded:    gp.initialized,
+			LoadError: gp.InitError,
+			Events:    gp.eventNames(),
+		})
+	}
+	for _, plugin := range luaPlugins {
+		infos = append(infos, pluginInfo{
+			Type:        "Lua",
+			Name:        plugin.Name,
+			Path:        plugin.Path,
+			Loaded:      plugin.Loaded(),
+			LoadError:   plugin.LoadError,
+			Libraries:   plugin.Libraries,
+			Events:      plugin.Events,
+			ManagePages: plugin.ManagePages,
+			Errors:      plugin.Errors(),
+		})
+	}
+	return infos
+}
+
+// pluginsPage executes the plugins page template with the plugins and any other page data
+func pluginsPage(staff *gcsql.Staff, infos []pluginInfo, pageData map[string]interface{}, errEv *zerolog.Event) (string, error) {
+	pageData["plugins"] = infos
+	pageData["timeout"] = config.GetSystemCriticalConfig().PluginTimeout
+	pageData["canReload"] = staff.Can(gcsql.CapPluginReload)
+	pageData["csrfToken"] = staff.CSRFToken
+	pageBuffer := bytes.NewBufferString("")
+	if err := serverutil.MinifyTemplate(gctemplates.ManagePlugins, pageData, pageBuffer, "text/html"); err != nil {
+		errEv.Err(err).Caller().Str("template", "manage_plugins.html").Send()
+		return "", err
+	}
+	return pageBuffer.String(), nil
+}
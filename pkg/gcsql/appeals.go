@@ -2,47 +2,150 @@ package gcsql
 
 import (
 	"database/sql"
+	"errors"
 	"strconv"
+	"time"
 )
 
-// GetAppeals returns an array of appeals, optionally limiting them to a specific ban
-func GetAppeals(banID int, limit int) ([]IPBanAppeal, error) {
-	query := `SELECT id, staff_id, ip_ban_id, appeal_text, staff_response, is_denied FROM DBPREFIXip_ban_appeals`
+const (
+	selectAppealsBaseSQL = `SELECT id, staff_id, ip_ban_id, appeal_text, staff_response, is_denied
+	FROM DBPREFIXip_ban_appeals`
+	// appeals are added to the audit table whenever they are submitted or handled, so that the history of
+	// a ban's appeals can be viewed
+	insertAppealAuditSQL = `INSERT INTO DBPREFIXip_ban_appeals_audit
+	(appeal_id, timestamp, staff_id, appeal_text, staff_response, is_denied)
+	SELECT id, ?, staff_id, appeal_text, staff_response, is_denied FROM DBPREFIXip_ban_appeals WHERE id = ?`
+)
+
+var (
+	ErrAppealNotFound       = errors.New("appeal not found")
+	ErrAppealAlreadyHandled = errors.New("appeal has already been approved or denied")
+)
+
+func scanAppeals(rows *sql.Rows) ([]IPBanAppeal, error) {
+	defer rows.Close()
+	var appeals []IPBanAppeal
+	for rows.Next() {
+		var appeal IPBanAppeal
+		var staffID *int
+		var staffResponse *string
+		err := rows.Scan(&appeal.ID, &staffID, &appeal.IPBanID, &appeal.AppealText, &staffResponse, &appeal.IsDenied)
+		if err != nil {
+			return nil, err
+		}
+		if staffID != nil {
+			appeal.StaffID = *staffID
+		}
+		if staffResponse != nil {
+			appeal.StaffResponse = *staffResponse
+		}
+		appeals = append(appeals, appeal)
+	}
+	return appeals, rows.Close()
+}
+
+// GetAppeals returns an array of appeals, optionally limiting them to a specific ban. If pendingOnly is true,
+// appeals that have already been approved or denied aren't included
+func GetAppeals(banID int, pendingOnly bool, limit int) ([]IPBanAppeal, error) {
+	query := selectAppealsBaseSQL
+	var where []string
+	var params []interface{}
 	if banID > 0 {
-		query += " WHERE ip_ban_id = ?"
+		where = append(where, "ip_ban_id = ?")
+		params = append(params, banID)
+	}
+	if pendingOnly {
+		where = append(where, "staff_id IS NULL AND is_denied = FALSE")
 	}
+	for w, clause := range where {
+		if w == 0 {
+			query += " WHERE " + clause
+		} else {
+			query += " AND " + clause
+		}
+	}
+	query += " ORDER BY id DESC"
 	if limit > 0 {
 		query += " LIMIT " + strconv.Itoa(limit)
 	}
-	var rows *sql.Rows
-	var err error
-	if banID > 0 {
-		rows, err = QuerySQL(query, banID)
-	} else {
-		rows, err = QuerySQL(query)
+	rows, err := QuerySQL(query, params...)
+	if err != nil {
+		return nil, err
+	}
+	return scanAppeals(rows)
+}
+
+// GetAppeal returns the appeal with the given ID
+func GetAppeal(appealID int) (*IPBanAppeal, error) {
+	rows, err := QuerySQL(selectAppealsBaseSQL+" WHERE id = ?", appealID)
+	if err != nil {
+		return nil, err
+	}
+	appeals, err := scanAppeals(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(appeals) == 0 {
+		return nil, ErrAppealNotFound
 	}
+	return &appeals[0], nil
+}
+
+// IsPending returns true if the appeal hasn't been approved or denied yet
+func (a *IPBanAppeal) IsPending() bool {
+	return a.StaffID == 0 && !a.IsDenied
+}
+
+// GetAppealHistory returns the audit entries of the ban's appeals (one for each time an appeal was submitted,
+// approved, or denied), oldest first
+func GetAppealHistory(banID int) ([]IPBanAppealAudit, error) {
+	const query = `SELECT a.appeal_id, a.timestamp, a.staff_id, a.appeal_text, a.staff_response, a.is_denied
+	FROM DBPREFIXip_ban_appeals_audit a
+	JOIN DBPREFIXip_ban_appeals ap ON ap.id = a.appeal_id
+	WHERE ap.ip_ban_id = ? ORDER BY a.timestamp ASC, a.appeal_id ASC`
+	rows, err := QuerySQL(query, banID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var appeals []IPBanAppeal
+	var history []IPBanAppealAudit
 	for rows.Next() {
-		var appeal IPBanAppeal
+		var entry IPBanAppealAudit
 		var staffID *int
 		var staffResponse *string
-		err = rows.Scan(&appeal.ID, &staffID, &appeal.IPBanID, &appeal.AppealText, &staffResponse, &appeal.IsDenied)
-		if err != nil {
+		if err = rows.Scan(&entry.AppealID, &entry.Timestamp, &staffID, &entry.AppealText, &staffResponse,
+			&entry.IsDenied); err != nil {
 			return nil, err
 		}
 		if staffID != nil {
-			appeal.StaffID = *staffID
+			entry.StaffID = *staffID
 		}
 		if staffResponse != nil {
-			appeal.StaffResponse = *staffResponse
+			entry.StaffResponse = *staffResponse
 		}
-		appeals = append(appeals, appeal)
+		history = append(history, entry)
+	}
+	return history, rows.Close()
+}
+
+// handleAppealTx sets the staff member who handled the appeal and their response, and adds it to the audit table.
+// It returns ErrAppealAlreadyHandled if the appeal isn't pending
+func handleAppealTx(tx *sql.Tx, appealID int, staffID int, response string, denied bool) error {
+	const updateQuery = `UPDATE DBPREFIXip_ban_appeals SET staff_id = ?, staff_response = ?, is_denied = ?
+	WHERE id = ? AND staff_id IS NULL AND is_denied = FALSE`
+	result, err := ExecTxSQL(tx, updateQuery, staffID, response, denied, appealID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrAppealAlreadyHandled
 	}
-	return appeals, nil
+	_, err = ExecTxSQL(tx, insertAppealAuditSQL, time.Now(), appealID)
+	return err
 }
 
 // ApproveAppeal deactivates the ban that the appeal was submitted for
@@ -53,20 +156,56 @@ func ApproveAppeal(appealID int, staffID int) error {
 		ip_ban_id, timestamp, staff_id, is_active, is_thread_ban, permanent, staff_note, message, can_appeal)
 		VALUES((SELECT ip_ban_id FROM DBPREFIXip_ban_appeals WHERE id = ?),
 		CURRENT_TIMESTAMP, ?, FALSE, FALSE, FALSE, '', '', TRUE)`
-	const deleteAppealQuery = `DELETE FROM DBPREFIXip_ban_appeals WHERE id = ?`
 	tx, err := BeginTx()
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
+	if err = handleAppealTx(tx, appealID, staffID, "", false); err != nil {
+		return err
+	}
 	if _, err = ExecTxSQL(tx, deactivateQuery, appealID); err != nil {
 		return err
 	}
 	if _, err = ExecTxSQL(tx, deactivateAppealQuery, appealID, staffID); err != nil {
 		return err
 	}
-	if _, err = ExecTxSQL(tx, deleteAppealQuery, appealID); err != nil {
+	return tx.Commit()
+}
+
+// DenyAppeal denies the appeal with the given response, which is shown to the banned user. The ban can be
+// appealed again at nextAppealAt, or never if canAppeal is false
+func DenyAppeal(appealID int, staffID int, response string, nextAppealAt time.Time, canAppeal bool) error {
+	const updateBanQuery = `UPDATE DBPREFIXip_ban SET appeal_at = ?, can_appeal = ? WHERE id = (
+		SELECT ip_ban_id FROM DBPREFIXip_ban_appeals WHERE id = ?)`
+	const banAuditQuery = `INSERT INTO DBPREFIXip_ban_audit
+		(ip_ban_id, timestamp, staff_id, is_active, is_thread_ban, expires_at, appeal_at, permanent, staff_note,
+		message, can_appeal)
+		SELECT id, ?, ?, is_active, is_thread_ban, expires_at, appeal_at, permanent, staff_note, message, can_appeal
+		FROM DBPREFIXip_ban WHERE id = (SELECT ip_ban_id FROM DBPREFIXip_ban_appeals WHERE id = ?)`
+	tx, err := BeginTx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err = handleAppealTx(tx, appealID, staffID, response, true); err != nil {
+		return err
+	}
+	if _, err = ExecTxSQL(tx, updateBanQuery, nextAppealAt, canAppeal, appealID); err != nil {
+		return err
+	}
+	if _, err = ExecTxSQL(tx, banAuditQuery, time.Now(), staffID, appealID); err != nil {
 		return err
 	}
 	return tx.Commit()
 }
+
+// GetAppealBan returns the ban that the appeal with the given ID was submitted for
+func GetAppealBan(appealID int) (*IPBan, error) {
+	const query = `SELECT ip_ban_id FROM DBPREFIXip_ban_appeals WHERE id = ?`
+	var banID int
+	if err := QueryRowSQL(query, interfaceSlice(appealID), interfaceSlice(&banID)); err != nil {
+		return nil, err
+	}
+	return GetIPBanByID(banID)
+}
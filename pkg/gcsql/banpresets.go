@@ -0,0 +1,180 @@
+package gcsql
+
+import (
+	"errors"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/gochan-org/gochan/pkg/gcutil"
+)
+
+const (
+	selectBanPresetsBaseSQL = `SELECT id, name, duration, permanent, message, is_thread_ban, can_appeal, appeal_wait,
+	is_global, escalate_to_id FROM DBPREFIXban_presets `
+)
+
+var (
+	ErrEmptyBanPresetName = errors.New("ban preset name must not be empty")
+	ErrBanPresetNotFound  = errors.New("ban preset not found")
+	ErrBanPresetEscalates = errors.New("ban preset can't escalate to itself")
+)
+
+func getBanPresets(where string, params ...interface{}) ([]BanPreset, error) {
+	rows, err := QuerySQL(selectBanPresetsBaseSQL+where+` ORDER BY name ASC`, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var presets []BanPreset
+	for rows.Next() {
+		var preset BanPreset
+		if err = rows.Scan(&preset.ID, &preset.Name, &preset.Duration, &preset.Permanent, &preset.Message,
+			&preset.IsThreadBan, &preset.CanAppeal, &preset.AppealWait, &preset.IsGlobal, &preset.EscalateToID,
+		); err != nil {
+			return nil, err
+		}
+		presets = append(presets, preset)
+	}
+	return presets, rows.Err()
+}
+
+// GetBanPresets returns every ban preset, sorted by name
+func GetBanPresets() ([]BanPreset, error) {
+	return getBanPresets("")
+}
+
+// GetBanPreset returns the ban preset with the given ID
+func GetBanPreset(id int) (*BanPreset, error) {
+	presets, err := getBanPresets(`WHERE id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(presets) == 0 {
+		return nil, ErrBanPresetNotFound
+	}
+	return &presets[0], nil
+}
+
+// validate checks the preset's name and durations before it is saved
+func (bp *BanPreset) validate() error {
+	bp.Name = strings.TrimSpace(bp.Name)
+	if bp.Name == "" {
+		return ErrEmptyBanPresetName
+	}
+	if bp.EscalateToID != nil && *bp.EscalateToID == bp.ID {
+		return ErrBanPresetEscalates
+	}
+	if !bp.Permanent {
+		if _, err := gcutil.ParseDurationString(bp.Duration); err != nil {
+			return err
+		}
+	}
+	if bp.CanAppeal && bp.AppealWait != "" {
+		if _, err := gcutil.ParseDurationString(bp.AppealWait); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewBanPreset inserts the ban preset into the database and sets its ID
+func NewBanPreset(preset *BanPreset) error {
+	const insertSQL = `INSERT INTO DBPREFIXban_presets
+	(name, duration, permanent, message, is_thread_ban, can_appeal, appeal_wait, is_global, escalate_to_id)
+	VALUES(?,?,?,?,?,?,?,?,?)`
+	if err := preset.validate(); err != nil {
+		return err
+	}
+	tx, err := BeginTx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err = ExecTxSQL(tx, insertSQL, preset.Name, preset.Duration, preset.Permanent, preset.Message,
+		preset.IsThreadBan, preset.CanAppeal, preset.AppealWait, preset.IsGlobal, preset.EscalateToID); err != nil {
+		return err
+	}
+	if preset.ID, err = getLatestID("DBPREFIXban_presets", tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Update saves the changes to the ban preset
+func (bp *BanPreset) Update() error {
+	const updateSQL = `UPDATE DBPREFIXban_presets SET name = ?, duration = ?, permanent = ?, message = ?,
+	is_thread_ban = ?, can_appeal = ?, appeal_wait = ?, is_global = ?, escalate_to_id = ? WHERE id = ?`
+	if err := bp.validate(); err != nil {
+		return err
+	}
+	_, err := ExecSQL(updateSQL, bp.Name, bp.Duration, bp.Permanent, bp.Message, bp.IsThreadBan, bp.CanAppeal,
+		bp.AppealWait, bp.IsGlobal, bp.EscalateToID, bp.ID)
+	return err
+}
+
+// DeleteBanPreset deletes the ban preset with the given ID. Presets that escalated to it no longer escalate
+func DeleteBanPreset(id int) error {
+	result, err := ExecSQL(`DELETE FROM DBPREFIXban_presets WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrBanPresetNotFound
+	}
+	return nil
+}
+
+// Escalate follows the preset's escalation chain once for each of the IP's prior bans and returns the preset
+// it ends on. It stops early at the end of the chain or if the chain loops back on itself
+func (bp *BanPreset) Escalate(priorBans int) (*BanPreset, error) {
+	preset := bp
+	visited := map[int]bool{preset.ID: true}
+	for i := 0; i < priorBans && preset.EscalateToID != nil; i++ {
+		if visited[*preset.EscalateToID] {
+			break
+		}
+		next, err := GetBanPreset(*preset.EscalateToID)
+		if err != nil {
+			return nil, err
+		}
+		visited[next.ID] = true
+		preset = next
+	}
+	return preset, nil
+}
+
+// Apply sets the ban's duration, appeal, thread ban, and message fields from the preset. The ban is made global
+// if the preset is, otherwise its board is left as it is
+func (bp *BanPreset) Apply(ban *IPBan) error {
+	now := time.Now()
+	ban.Permanent = bp.Permanent
+	ban.ExpiresAt = now
+	if !bp.Permanent {
+		duration, err := gcutil.ParseDurationString(bp.Duration)
+		if err != nil {
+			return err
+		}
+		ban.ExpiresAt = now.Add(duration)
+	}
+	ban.CanAppeal = bp.CanAppeal
+	ban.AppealAt = now
+	if bp.CanAppeal && bp.AppealWait != "" {
+		appealDuration, err := gcutil.ParseDurationString(bp.AppealWait)
+		if err != nil {
+			return err
+		}
+		ban.AppealAt = now.Add(appealDuration)
+	}
+	if bp.IsGlobal {
+		ban.BoardID = nil
+	}
+	ban.IsThreadBan = bp.IsThreadBan
+	ban.Message = html.EscapeString(bp.Message)
+	ban.IsActive = true
+	return nil
+}
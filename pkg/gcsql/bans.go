@@ -2,9 +2,14 @@ package gcsql
 
 import (
 	"database/sql"
+	"encoding/hex"
 	"errors"
+	"net"
 	"regexp"
 	"strconv"
+	"time"
+
+	"github.com/gochan-org/gochan/pkg/gcutil"
 )
 
 const (
@@ -24,19 +29,53 @@ type Ban interface {
 	Deactivate(int) error
 }
 
+// IPRangeKeys returns the values stored in the range_start and range_end columns of DBPREFIXip_ban for
+// the given IP address or range (see gcutil.ParseIPRange). They are hex encoded 16 byte addresses, so
+// checking if an address is in the range can be done with string comparisons in any SQL driver
+func IPRangeKeys(rangeStr string) (string, string, error) {
+	start, end, err := gcutil.ParseIPRange(rangeStr)
+	if err != nil {
+		return "", "", err
+	}
+	return hex.EncodeToString(start), hex.EncodeToString(end), nil
+}
+
+// PostIPKey returns the value stored in the ip_key column of DBPREFIXposts for the post's IP address, which
+// is encoded the same way as the IP ban range keys so that posts can be selected by IP range
+func PostIPKey(ip string) (string, error) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return "", gcutil.ErrInvalidIPRange
+	}
+	return hex.EncodeToString(parsed.To16()), nil
+}
+
+// NewIPBan creates a new ban for the IP address or range (single address, CIDR, or start-end) in ban.IP
 func NewIPBan(ban *IPBan) error {
+	tx, err := BeginTx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err = newIPBanTx(tx, ban); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// newIPBanTx creates the ban using the given transaction, so that it can be done along with other changes
+func newIPBanTx(tx *sql.Tx, ban *IPBan) error {
 	const query = `INSERT INTO DBPREFIXip_ban
-	(staff_id, board_id, banned_for_post_id, copy_post_text, is_thread_ban, is_active, ip,
+	(staff_id, board_id, banned_for_post_id, copy_post_text, is_thread_ban, is_active, ip, range_start, range_end,
 		appeal_at, expires_at, permanent, staff_note, message, can_appeal)
-	VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 	if ban.ID > 0 {
 		return ErrBanAlreadyInserted
 	}
-	tx, err := BeginTx()
+	rangeStart, rangeEnd, err := IPRangeKeys(ban.IP)
 	if err != nil {
 		return err
 	}
-	defer tx.Rollback()
 	stmt, err := PrepareSQL(query, tx)
 	if err != nil {
 		return err
@@ -44,34 +83,53 @@ func NewIPBan(ban *IPBan) error {
 	defer stmt.Close()
 	if _, err = stmt.Exec(
 		ban.StaffID, ban.BoardID, ban.BannedForPostID, ban.CopyPostText, ban.IsThreadBan, ban.IsActive, ban.IP,
-		ban.AppealAt, ban.ExpiresAt, ban.Permanent, ban.StaffNote, ban.Message, ban.CanAppeal,
+		rangeStart, rangeEnd, ban.AppealAt, ban.ExpiresAt, ban.Permanent, ban.StaffNote, ban.Message, ban.CanAppeal,
 	); err != nil {
 		return err
 	}
 	ban.ID, err = getLatestID("DBPREFIXip_ban", tx)
-	if err != nil {
-		return err
-	}
-	return tx.Commit()
+	return err
 }
 
-// CheckIPBan returns the latest active IP ban for the given IP, as well as any errors. If the
-// IPBan pointer is nil, the IP has no active bans
+// CheckIPBan returns the latest active IP ban (including range bans) for the given IP, as well as any errors.
+// If the IPBan pointer is nil, the IP has no active bans
 func CheckIPBan(ip string, boardID int) (*IPBan, error) {
-	const query = ipBanQueryBase + ` WHERE ip = ? AND (board_id IS NULL OR board_id = ?) AND
+	const query = ipBanQueryBase + ` WHERE range_start <= ? AND range_end >= ? AND (board_id IS NULL OR board_id = ?) AND
 		is_active AND (expires_at > CURRENT_TIMESTAMP OR permanent)
 	ORDER BY id DESC LIMIT 1`
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return nil, gcutil.ErrInvalidIPRange
+	}
+	key := hex.EncodeToString(addr.To16())
 	var ban IPBan
-	err := QueryRowSQL(query, interfaceSlice(ip, boardID), interfaceSlice(
+	err := QueryRowSQL(query, interfaceSlice(key, key, boardID), interfaceSlice(
 		&ban.ID, &ban.StaffID, &ban.BoardID, &ban.BannedForPostID, &ban.CopyPostText, &ban.IsThreadBan,
 		&ban.IsActive, &ban.IP, &ban.IssuedAt, &ban.AppealAt, &ban.ExpiresAt, &ban.Permanent, &ban.StaffNote,
 		&ban.Message, &ban.CanAppeal))
 	if err == sql.ErrNoRows {
 		return nil, nil
+	} else if err != nil {
+		return nil, err
 	}
 	return &ban, nil
 }
 
+// GetPriorIPBanCount returns the number of the IP address's bans that have a history in DBPREFIXip_ban_audit,
+// meaning they were lifted or had an appeal handled by staff. Range bans that include the address aren't counted
+func GetPriorIPBanCount(ip string) (int, error) {
+	normalized, err := gcutil.NormalizeIPRange(ip)
+	if err != nil {
+		return 0, err
+	}
+	const query = `SELECT COUNT(DISTINCT audit.ip_ban_id) FROM DBPREFIXip_ban_audit audit
+		JOIN DBPREFIXip_ban ban ON ban.id = audit.ip_ban_id
+		WHERE ban.ip = ?`
+	var count int
+	err = QueryRowSQL(query, interfaceSlice(normalized), interfaceSlice(&count))
+	return count, err
+}
+
 func GetIPBanByID(id int) (*IPBan, error) {
 	const query = ipBanQueryBase + " WHERE id = ?"
 	var ban IPBan
@@ -120,10 +178,41 @@ func GetIPBans(boardID int, limit int, onlyActive bool) ([]IPBan, error) {
 	return bans, nil
 }
 
+// Appeal submits an appeal of the ban with the given message and adds it to the appeal history
 func (ipb *IPBan) Appeal(msg string) error {
 	const query = `INSERT INTO DBPREFIXip_ban_appeals (ip_ban_id, appeal_text, is_denied) VALUES(?, ?, FALSE)`
-	_, err := ExecSQL(query, ipb.ID, msg)
-	return err
+	tx, err := BeginTx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err = ExecTxSQL(tx, query, ipb.ID, msg); err != nil {
+		return err
+	}
+	appealID, err := getLatestID("DBPREFIXip_ban_appeals", tx)
+	if err != nil {
+		return err
+	}
+	if _, err = ExecTxSQL(tx, insertAppealAuditSQL, time.Now(), appealID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetAppeals returns the appeals submitted for the ban, newest first
+func (ipb *IPBan) GetAppeals() ([]IPBanAppeal, error) {
+	return GetAppeals(ipb.ID, false, 0)
+}
+
+// IsRangeBan returns true if the ban applies to a range of IP addresses instead of a single address
+func (ipb *IPBan) IsRangeBan() bool {
+	start, end, err := gcutil.ParseIPRange(ipb.IP)
+	return err == nil && !start.Equal(end)
+}
+
+// Matches returns true if the given IP address is covered by the ban
+func (ipb *IPBan) Matches(ip string) bool {
+	return gcutil.IPInRange(ip, ipb.IP)
 }
 
 // IsGlobalBan returns true if BoardID is a nil int, meaning they are banned on all boards, as opposed to a specific one
@@ -493,3 +582,27 @@ func DeleteFileBanByID(id int) error {
 	_, err := ExecSQL("DELETE FROM DBPREFIXfile_ban WHERE id = ?", id)
 	return err
 }
+
+// getBanBoardID returns the board ID of the ban with the given ID in the filename, username, or file
+// checksum ban table, or nil if it is a global ban
+func getBanBoardID(table string, id int) (*int, error) {
+	query := `SELECT board_id FROM DBPREFIX` + table + ` WHERE id = ?`
+	var boardID *int
+	err := QueryRowSQL(query, interfaceSlice(id), interfaceSlice(&boardID))
+	return boardID, err
+}
+
+// GetFilenameBanBoardID returns the board ID of the filename ban, or nil if it is a global ban
+func GetFilenameBanBoardID(id int) (*int, error) {
+	return getBanBoardID("filename_ban", id)
+}
+
+// GetNameBanBoardID returns the board ID of the name ban, or nil if it is a global ban
+func GetNameBanBoardID(id int) (*int, error) {
+	return getBanBoardID("username_ban", id)
+}
+
+// GetFileBanBoardID returns the board ID of the file checksum ban, or nil if it is a global ban
+func GetFileBanBoardID(id int) (*int, error) {
+	return getBanBoardID("file_ban", id)
+}
@@ -134,6 +134,29 @@ func GetBoardIDFromDir(dir string) (id int, err error) {
 	return id, err
 }
 
+// GetBoardIDsFromDirs returns the IDs of the boards with the given dir values, mapped to their dirs. Dirs that
+// don't belong to a board are left out
+func GetBoardIDsFromDirs(dirs ...interface{}) (map[string]int, error) {
+	ids := make(map[string]int)
+	if len(dirs) == 0 {
+		return ids, nil
+	}
+	rows, err := QuerySQL(`SELECT id, dir FROM DBPREFIXboards WHERE dir IN `+createArrayPlaceholder(dirs), dirs...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var dir string
+		if err = rows.Scan(&id, &dir); err != nil {
+			return nil, err
+		}
+		ids[dir] = id
+	}
+	return ids, rows.Err()
+}
+
 // GetBoardURIs gets a list of all existing board URIs
 func GetBoardURIs() (URIS []string, err error) {
 	const sql = `SELECT uri FROM DBPREFIXboards`
@@ -295,19 +318,11 @@ func (board *Board) Delete() error {
 	return nil
 }
 
-// DeleteOldThreads deletes old threads that exceed the limit set by board.MaxThreads and returns the posts in those
-// threads
-func (board *Board) DeleteOldThreads() ([]int, error) {
-	if board.MaxThreads < 1 {
-		return nil, nil
-	}
-	tx, err := BeginTx()
-	if err != nil {
-		return nil, err
-	}
-	defer tx.Rollback()
-
-	rows, err := QueryTxSQL(tx, `SELECT id FROM DBPREFIXthreads WHERE board_id = ? AND is_deleted = FALSE AND stickied = FALSE ORDER BY last_bump DESC`,
+// getOldThreadIDs returns the IDs of the unstickied threads that exceed the limit set by board.MaxThreads
+func (board *Board) getOldThreadIDs(tx *sql.Tx) ([]interface{}, error) {
+	rows, err := QueryTxSQL(tx, `SELECT id FROM DBPREFIXthreads
+		WHERE board_id = ? AND is_deleted = FALSE AND is_archived = FALSE AND stickied = FALSE
+		ORDER BY last_bump DESC`,
 		board.ID)
 	if err != nil {
 		return nil, err
@@ -327,43 +342,171 @@ func (board *Board) DeleteOldThreads() ([]int, error) {
 		}
 		threadIDs = append(threadIDs, id)
 	}
-	if threadIDs == nil {
-		// no threads to trim
-		return nil, nil
-	}
+	return threadIDs, rows.Close()
+}
+
+// deleteThreadsTx marks the given threads and their posts as deleted and returns the IDs of the posts
+func deleteThreadsTx(tx *sql.Tx, threadIDs []interface{}) ([]int, error) {
 	idSetStr := createArrayPlaceholder(threadIDs)
 
-	if _, err = ExecTxSQL(tx, `UPDATE DBPREFIXthreads SET is_deleted = TRUE WHERE id in `+idSetStr,
+	if _, err := ExecTxSQL(tx, `UPDATE DBPREFIXthreads SET is_deleted = TRUE, deleted_at = CURRENT_TIMESTAMP WHERE id in `+idSetStr,
 		threadIDs...); err != nil {
 		return nil, err
 	}
 
-	if rows, err = QueryTxSQL(tx, `SELECT id FROM DBPREFIXposts WHERE thread_id in `+idSetStr,
-		threadIDs...); err != nil {
+	rows, err := QueryTxSQL(tx, `SELECT id FROM DBPREFIXposts WHERE thread_id in `+idSetStr, threadIDs...)
+	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
 	var postIDs []int
+	var id int
 	for rows.Next() {
 		if err = rows.Scan(&id); err != nil {
 			return nil, err
 		}
 		postIDs = append(postIDs, id)
 	}
+	if err = rows.Close(); err != nil {
+		return nil, err
+	}
 
-	if _, err = ExecTxSQL(tx, `UPDATE DBPREFIXposts SET is_deleted = TRUE WHERE thread_id in `+idSetStr,
+	if _, err = ExecTxSQL(tx, `UPDATE DBPREFIXposts SET is_deleted = TRUE, deleted_at = CURRENT_TIMESTAMP,
+		last_modified = CURRENT_TIMESTAMP WHERE thread_id in `+idSetStr,
 		threadIDs...); err != nil {
 		return nil, err
 	}
+	return postIDs, nil
+}
+
+// DeleteOldThreads deletes old threads that exceed the limit set by board.MaxThreads and returns the posts in those
+// threads
+func (board *Board) DeleteOldThreads() ([]int, error) {
+	if board.MaxThreads < 1 {
+		return nil, nil
+	}
+	tx, err := BeginTx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	threadIDs, err := board.getOldThreadIDs(tx)
+	if err != nil {
+		return nil, err
+	}
+	if threadIDs == nil {
+		// no threads to trim
+		return nil, nil
+	}
+	postIDs, err := deleteThreadsTx(tx, threadIDs)
+	if err != nil {
+		return nil, err
+	}
+	return postIDs, tx.Commit()
+}
+
+// ArchiveOldThreads locks and archives old threads that exceed the limit set by board.MaxThreads instead of
+// deleting them, and returns the IDs of the archived threads
+func (board *Board) ArchiveOldThreads() ([]int, error) {
+	if board.MaxThreads < 1 {
+		return nil, nil
+	}
+	tx, err := BeginTx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	threadIDs, err := board.getOldThreadIDs(tx)
+	if err != nil {
+		return nil, err
+	}
+	if threadIDs == nil {
+		return nil, nil
+	}
+	if _, err = ExecTxSQL(tx, `UPDATE DBPREFIXthreads SET locked = TRUE, is_archived = TRUE, archived_at = ?
+		WHERE id in `+createArrayPlaceholder(threadIDs),
+		append([]interface{}{time.Now()}, threadIDs...)...); err != nil {
+		return nil, err
+	}
+	if err = touchThreadsTx(tx, threadIDs...); err != nil {
+		return nil, err
+	}
+	archived := make([]int, len(threadIDs))
+	for i, id := range threadIDs {
+		archived[i] = id.(int)
+	}
+	return archived, tx.Commit()
+}
+
+// PurgeArchivedThreads deletes the board's threads that were archived before the given time, and returns the
+// posts in those threads
+func (board *Board) PurgeArchivedThreads(archivedBefore time.Time) ([]int, error) {
+	tx, err := BeginTx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
 
+	rows, err := QueryTxSQL(tx, `SELECT id FROM DBPREFIXthreads
+		WHERE board_id = ? AND is_archived = TRUE AND is_deleted = FALSE AND archived_at < ?`,
+		board.ID, archivedBefore)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var threadIDs []interface{}
+	var id int
+	for rows.Next() {
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		threadIDs = append(threadIDs, id)
+	}
+	if err = rows.Close(); err != nil {
+		return nil, err
+	}
+	if threadIDs == nil {
+		return nil, nil
+	}
+	postIDs, err := deleteThreadsTx(tx, threadIDs)
+	if err != nil {
+		return nil, err
+	}
 	return postIDs, tx.Commit()
 }
 
+// GetArchivedThreads returns the board's archived threads that haven't been purged yet, most recently
+// archived first
+func (board *Board) GetArchivedThreads() ([]Thread, error) {
+	query := selectThreadsBaseSQL + " WHERE board_id = ? AND is_archived = TRUE AND is_deleted = FALSE ORDER BY archived_at DESC"
+	rows, err := QuerySQL(query, board.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var threads []Thread
+	for rows.Next() {
+		var thread Thread
+		if err = rows.Scan(
+			&thread.ID, &thread.BoardID, &thread.Locked, &thread.Stickied, &thread.Anchored,
+			&thread.Cyclical, &thread.LastBump, &thread.DeletedAt, &thread.IsDeleted,
+			&thread.ArchivedAt, &thread.IsArchived,
+		); err != nil {
+			return threads, err
+		}
+		threads = append(threads, thread)
+	}
+	return threads, nil
+}
+
+// GetThreads returns the board's threads. If onlyNotDeleted is true, deleted and archived threads are omitted
 func (board *Board) GetThreads(onlyNotDeleted bool, orderLastByBump bool, stickiedFirst bool) ([]Thread, error) {
 	query := selectThreadsBaseSQL + " WHERE board_id = ?"
 	if onlyNotDeleted {
-		query += " AND is_deleted = FALSE"
+		query += " AND is_deleted = FALSE AND is_archived = FALSE"
 	}
 	if orderLastByBump || stickiedFirst {
 		query += " ORDER BY "
@@ -388,6 +531,7 @@ func (board *Board) GetThreads(onlyNotDeleted bool, orderLastByBump bool, sticki
 		err = rows.Scan(
 			&thread.ID, &thread.BoardID, &thread.Locked, &thread.Stickied, &thread.Anchored,
 			&thread.Cyclical, &thread.LastBump, &thread.DeletedAt, &thread.IsDeleted,
+			&thread.ArchivedAt, &thread.IsArchived,
 		)
 		if err != nil {
 			return threads, err
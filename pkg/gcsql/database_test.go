@@ -0,0 +1,91 @@
+package gcsql
+
+import (
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// initTestDB provisions an SQLite database in a temporary directory with the SQLite initialization script so
+// that queries can be tested against real tables
+func initTestDB(t *testing.T) {
+	t.Helper()
+	if err := ConnectToDB(filepath.Join(t.TempDir(), "gochan.db"), "sqlite3", "gochan", "gochan", "gochan", ""); err != nil {
+		t.Fatal(err.Error())
+	}
+	t.Cleanup(func() {
+		gcdb.Close()
+	})
+	if err := RunSQLFile(filepath.Join("..", "..", "sql", "initdb_sqlite3.sql")); err != nil {
+		t.Fatal(err.Error())
+	}
+}
+
+// createTestBoard creates a board with the given dir in the test database
+func createTestBoard(t *testing.T, dir string) *Board {
+	t.Helper()
+	sectionID, err := getOrCreateDefaultSectionID()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	board := &Board{
+		SectionID:     sectionID,
+		Dir:           dir,
+		Title:         "/" + dir + "/",
+		MaxThreads:    300,
+		AnonymousName: "Anonymous",
+	}
+	if err = CreateBoard(board, false); err != nil {
+		t.Fatal(err.Error())
+	}
+	return board
+}
+
+// createTestStaff creates a staff account with the default role at index r of DefaultRoles
+func createTestStaff(t *testing.T, username string, r int) *Staff {
+	t.Helper()
+	if err := createDefaultRolesIfNoneExist(); err != nil {
+		t.Fatal(err.Error())
+	}
+	role, err := GetRoleByName(DefaultRoles[r].Name)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err = NewStaff(username, "password", role); err != nil {
+		t.Fatal(err.Error())
+	}
+	staff, err := GetStaffByUsername(username, true)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return staff
+}
+
+// createTestPost inserts a post from the given IP, which is a new thread if threadID is 0, and attaches
+// an upload to it if withUpload is true
+func createTestPost(t *testing.T, boardID int, threadID int, ip string, withUpload bool) *Post {
+	t.Helper()
+	post := &Post{
+		ThreadID:   threadID,
+		IP:         ip,
+		Name:       "Anonymous",
+		Message:    "message",
+		MessageRaw: "message",
+		Password:   "password",
+	}
+	if err := post.Insert(true, boardID, false, false, false, false); err != nil {
+		t.Fatal(err.Error())
+	}
+	if withUpload {
+		if err := post.AttachFile(&Upload{
+			OriginalFilename: "upload.png",
+			Filename:         "upload.png",
+			Checksum:         "checksum",
+			FileSize:         1,
+		}); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	return post
+}
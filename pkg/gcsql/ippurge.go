@@ -0,0 +1,207 @@
+package gcsql
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNoPurgePosts = errors.New("no posts to remove")
+)
+
+// IPPurge selects the posts from an IP address or range to be removed by a mass deletion
+type IPPurge struct {
+	IPRange   string    // single address, CIDR, or start-end range
+	BoardID   int       // if 0, posts on all boards are removed
+	Since     time.Time // if set, only posts made at or after this time are removed
+	Until     time.Time // if set, only posts made at or before this time are removed
+	FilesOnly bool      // if true, only the posts' uploads are removed
+	// PostIDs limits the purge to the given posts if it isn't empty, so that confirming a preview doesn't remove
+	// posts made after it was shown
+	PostIDs []int
+}
+
+// PurgeablePost is a post selected by an IPPurge, along with its board and thread
+type PurgeablePost struct {
+	Post
+	BoardID        int
+	BoardDir       string
+	TopPostID      int
+	ThreadArchived bool
+	Uploads        []Upload // the post's uploads that haven't been deleted
+}
+
+// conditions returns the WHERE clause (to be used in a query on DBPREFIXposts p joined with DBPREFIXthreads t)
+// and its parameters for selecting the purge's posts
+func (purge *IPPurge) conditions() (string, []interface{}, error) {
+	rangeStart, rangeEnd, err := IPRangeKeys(purge.IPRange)
+	if err != nil {
+		return "", nil, err
+	}
+	where := []string{"p.is_deleted = FALSE", "t.is_deleted = FALSE", "p.ip_key BETWEEN ? AND ?"}
+	params := []interface{}{rangeStart, rangeEnd}
+	if purge.BoardID > 0 {
+		where = append(where, "t.board_id = ?")
+		params = append(params, purge.BoardID)
+	}
+	if !purge.Since.IsZero() {
+		where = append(where, "p.created_on >= ?")
+		params = append(params, purge.Since)
+	}
+	if !purge.Until.IsZero() {
+		where = append(where, "p.created_on <= ?")
+		params = append(params, purge.Until)
+	}
+	if len(purge.PostIDs) > 0 {
+		postIDs := make([]interface{}, len(purge.PostIDs))
+		for i, id := range purge.PostIDs {
+			postIDs[i] = id
+		}
+		where = append(where, "p.id IN "+createArrayPlaceholder(postIDs))
+		params = append(params, postIDs...)
+	}
+	return strings.Join(where, " AND "), params, nil
+}
+
+// GetIPPurgePosts returns the posts that would be removed by the purge, newest first. If the purge only
+// removes files, posts without any uploads aren't included
+func GetIPPurgePosts(purge *IPPurge) ([]PurgeablePost, error) {
+	where, params, err := purge.conditions()
+	if err != nil {
+		return nil, err
+	}
+	query := `SELECT p.id, p.thread_id, p.is_top_post, p.ip, p.created_on, p.name, p.tripcode, p.email, p.subject,
+	p.message, p.message_raw, t.board_id, b.dir, t.is_archived,
+	(SELECT op.id FROM DBPREFIXposts op WHERE op.thread_id = p.thread_id AND op.is_top_post LIMIT 1)
+	FROM DBPREFIXposts p
+	JOIN DBPREFIXthreads t ON t.id = p.thread_id
+	JOIN DBPREFIXboards b ON b.id = t.board_id
+	WHERE ` + where + ` ORDER BY p.id DESC`
+	rows, err := QuerySQL(query, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var posts []PurgeablePost
+	for rows.Next() {
+		var post PurgeablePost
+		if err = rows.Scan(&post.ID, &post.ThreadID, &post.IsTopPost, &post.IP, &post.CreatedOn, &post.Name,
+			&post.Tripcode, &post.Email, &post.Subject, &post.Message, &post.MessageRaw, &post.BoardID,
+			&post.BoardDir, &post.ThreadArchived, &post.TopPostID,
+		); err != nil {
+			return nil, err
+		}
+		posts = append(posts, post)
+	}
+	if err = rows.Close(); err != nil {
+		return nil, err
+	}
+	if len(posts) == 0 {
+		return nil, nil
+	}
+
+	// get the uploads of all of the posts at once instead of querying each post's uploads
+	uploadsQuery := selectFilesBaseSQL + `WHERE filename != 'deleted' AND post_id IN (
+		SELECT p.id FROM DBPREFIXposts p JOIN DBPREFIXthreads t ON t.id = p.thread_id WHERE ` + where + `)
+		ORDER BY post_id, file_order ASC`
+	uploads, err := getUploadsTx(nil, uploadsQuery, params...)
+	if err != nil {
+		return nil, err
+	}
+	postUploads := make(map[int][]Upload)
+	for _, upload := range uploads {
+		postUploads[upload.PostID] = append(postUploads[upload.PostID], upload)
+	}
+
+	var selected []PurgeablePost
+	for _, post := range posts {
+		post.Uploads = postUploads[post.ID]
+		if purge.FilesOnly && len(post.Uploads) == 0 {
+			continue
+		}
+		selected = append(selected, post)
+	}
+	return selected, nil
+}
+
+// PurgePosts removes the posts (or their uploads, if filesOnly is true) selected by GetIPPurgePosts from the
+// database. Deleting a top post deletes its thread. If ban is not nil, it is created in the same transaction,
+// so either everything is done or nothing is. It returns the removed uploads mapped to the ID of the purged post
+// they were removed with (including the uploads of replies in deleted threads), so that the caller can remove
+// them from the disk before rebuilding the pages
+func PurgePosts(posts []PurgeablePost, filesOnly bool, ban *IPBan) (map[int][]Upload, error) {
+	const threadUploadsSQL = selectFilesBaseSQL + `WHERE filename != 'deleted' AND post_id IN (
+		SELECT id FROM DBPREFIXposts WHERE thread_id = ?)`
+	const unlinkUploadsSQL = `UPDATE DBPREFIXfiles SET filename = 'deleted', original_filename = 'deleted'
+	WHERE post_id = ?`
+	const touchPostSQL = `UPDATE DBPREFIXposts SET last_modified = CURRENT_TIMESTAMP WHERE id = ?`
+	const deleteUploadsSQL = `DELETE FROM DBPREFIXfiles WHERE post_id = ?`
+	const deletePostSQL = `UPDATE DBPREFIXposts SET is_deleted = TRUE, deleted_at = CURRENT_TIMESTAMP,
+	last_modified = CURRENT_TIMESTAMP WHERE id = ?`
+	if len(posts) == 0 {
+		return nil, ErrNoPurgePosts
+	}
+	tx, err := BeginTx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	removed := make(map[int][]Upload)
+	var threadIDs []interface{}
+	for _, post := range posts {
+		removed[post.ID] = post.Uploads
+		if filesOnly {
+			if _, err = ExecTxSQL(tx, unlinkUploadsSQL, post.ID); err == nil {
+				_, err = ExecTxSQL(tx, touchPostSQL, post.ID)
+			}
+		} else if post.IsTopPost {
+			threadIDs = append(threadIDs, post.ThreadID)
+			removed[post.ID], err = getUploadsTx(tx, threadUploadsSQL, post.ThreadID)
+		} else if _, err = ExecTxSQL(tx, deletePostSQL, post.ID); err == nil {
+			_, err = ExecTxSQL(tx, deleteUploadsSQL, post.ID)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(threadIDs) > 0 {
+		deletedIDs, err := deleteThreadsTx(tx, threadIDs)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range deletedIDs {
+			if _, err = ExecTxSQL(tx, deleteUploadsSQL, id); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if ban != nil {
+		if err = newIPBanTx(tx, ban); err != nil {
+			return nil, err
+		}
+	}
+	return removed, tx.Commit()
+}
+
+func getUploadsTx(tx *sql.Tx, query string, params ...interface{}) ([]Upload, error) {
+	rows, err := QueryTxSQL(tx, query, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var uploads []Upload
+	for rows.Next() {
+		var upload Upload
+		if err = rows.Scan(
+			&upload.ID, &upload.PostID, &upload.FileOrder, &upload.OriginalFilename, &upload.Filename, &upload.Checksum,
+			&upload.FileSize, &upload.IsSpoilered, &upload.ThumbnailWidth, &upload.ThumbnailHeight, &upload.Width,
+			&upload.Height,
+		); err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, upload)
+	}
+	return uploads, rows.Close()
+}
@@ -0,0 +1,149 @@
+package gcsql
+
+import (
+	"testing"
+	"time"
+)
+
+const (
+	testPurgeIP = "192.168.56.1"
+	testOtherIP = "192.168.56.2"
+)
+
+func postIsDeleted(t *testing.T, postID int) bool {
+	t.Helper()
+	var deleted bool
+	if err := QueryRowSQL(`SELECT is_deleted FROM DBPREFIXposts WHERE id = ?`,
+		interfaceSlice(postID), interfaceSlice(&deleted)); err != nil {
+		t.Fatal(err.Error())
+	}
+	return deleted
+}
+
+func postUploadCount(t *testing.T, postID int) int {
+	t.Helper()
+	var count int
+	if err := QueryRowSQL(`SELECT COUNT(id) FROM DBPREFIXfiles WHERE post_id = ? AND filename != 'deleted'`,
+		interfaceSlice(postID), interfaceSlice(&count)); err != nil {
+		t.Fatal(err.Error())
+	}
+	return count
+}
+
+// previewPurge gets the posts selected by the purge and limits it to them, the same way confirming the
+// purge's preview does
+func previewPurge(t *testing.T, purge *IPPurge) []PurgeablePost {
+	t.Helper()
+	posts, err := GetIPPurgePosts(purge)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	purge.PostIDs = nil
+	for _, post := range posts {
+		purge.PostIDs = append(purge.PostIDs, post.ID)
+	}
+	return posts
+}
+
+func TestPurgePostsOnlyPreviewed(t *testing.T) {
+	initTestDB(t)
+	board := createTestBoard(t, "test")
+	op := createTestPost(t, board.ID, 0, testOtherIP, false)
+	withUpload := createTestPost(t, board.ID, op.ThreadID, testPurgeIP, true)
+	reply := createTestPost(t, board.ID, op.ThreadID, testPurgeIP, false)
+
+	purge := &IPPurge{IPRange: testPurgeIP}
+	if preview := previewPurge(t, purge); len(preview) != 2 {
+		t.Fatalf("expected the preview to have 2 posts, got %d", len(preview))
+	}
+	// made after the preview was shown, so it shouldn't be removed
+	newReply := createTestPost(t, board.ID, op.ThreadID, testPurgeIP, false)
+	posts, err := GetIPPurgePosts(purge)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(posts) != 2 {
+		t.Fatalf("expected 2 posts to be selected after confirming the preview, got %d", len(posts))
+	}
+	removed, err := PurgePosts(posts, false, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(removed[withUpload.ID]) != 1 {
+		t.Fatalf("expected the post's upload to be returned as removed, got %d uploads", len(removed[withUpload.ID]))
+	}
+	if !postIsDeleted(t, withUpload.ID) || !postIsDeleted(t, reply.ID) {
+		t.Fatal("expected the previewed posts to be deleted")
+	}
+	if postIsDeleted(t, newReply.ID) || postIsDeleted(t, op.ID) {
+		t.Fatal("expected posts that weren't previewed to not be deleted")
+	}
+	if count := postUploadCount(t, withUpload.ID); count != 0 {
+		t.Fatalf("expected the deleted post's upload to be removed, got %d uploads", count)
+	}
+}
+
+func TestPurgePostsFilesOnly(t *testing.T) {
+	initTestDB(t)
+	board := createTestBoard(t, "test")
+	op := createTestPost(t, board.ID, 0, testPurgeIP, true)
+	reply := createTestPost(t, board.ID, op.ThreadID, testPurgeIP, false)
+
+	purge := &IPPurge{IPRange: testPurgeIP, FilesOnly: true}
+	posts := previewPurge(t, purge)
+	if len(posts) != 1 || posts[0].ID != op.ID {
+		t.Fatal("expected only the post with an upload to be selected")
+	}
+	if _, err := PurgePosts(posts, true, nil); err != nil {
+		t.Fatal(err.Error())
+	}
+	if postIsDeleted(t, op.ID) || postIsDeleted(t, reply.ID) {
+		t.Fatal("expected the posts to be kept when only files are removed")
+	}
+	if count := postUploadCount(t, op.ID); count != 0 {
+		t.Fatalf("expected the upload to be removed, got %d uploads", count)
+	}
+}
+
+func TestPurgePostsBan(t *testing.T) {
+	initTestDB(t)
+	board := createTestBoard(t, "test")
+	staff := createTestStaff(t, "admin", 2)
+	op := createTestPost(t, board.ID, 0, testOtherIP, false)
+	reply := createTestPost(t, board.ID, op.ThreadID, testPurgeIP, false)
+
+	purge := &IPPurge{IPRange: testPurgeIP}
+	posts := previewPurge(t, purge)
+	// an invalid ban should roll back the whole purge
+	invalidBan := &IPBan{IP: "invalid"}
+	invalidBan.StaffID = staff.ID
+	invalidBan.IsActive = true
+	invalidBan.Permanent = true
+	if _, err := PurgePosts(posts, false, invalidBan); err == nil {
+		t.Fatal("expected the purge to fail with an invalid ban")
+	}
+	if postIsDeleted(t, reply.ID) {
+		t.Fatal("expected the post deletion to be rolled back")
+	}
+
+	ban := &IPBan{IP: testPurgeIP}
+	ban.StaffID = staff.ID
+	ban.IsActive = true
+	ban.Permanent = true
+	ban.CanAppeal = true
+	ban.AppealAt = time.Now()
+	ban.ExpiresAt = time.Now()
+	if _, err := PurgePosts(posts, false, ban); err != nil {
+		t.Fatal(err.Error())
+	}
+	if !postIsDeleted(t, reply.ID) {
+		t.Fatal("expected the post to be deleted")
+	}
+	activeBan, err := CheckIPBan(testPurgeIP, board.ID)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if activeBan == nil || activeBan.ID != ban.ID {
+		t.Fatal("expected the ban to be created with the purge")
+	}
+}
@@ -0,0 +1,237 @@
+package gcsql
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// Actions recorded in the moderation log
+const (
+	ModLogPostDelete           = "post.delete"
+	ModLogFileDelete           = "post.deletefile"
+	ModLogPostEdit             = "post.edit"
+	ModLogThreadMove           = "thread.move"
+	ModLogThreadAttributes     = "thread.attributes"
+	ModLogBanCreate            = "ban.create"
+	ModLogBanDelete            = "ban.delete"
+	ModLogBanPresetCreate      = "banpreset.create"
+	ModLogBanPresetEdit        = "banpreset.edit"
+	ModLogBanPresetDelete      = "banpreset.delete"
+	ModLogFileBanCreate        = "fileban.create"
+	ModLogFileBanDelete        = "fileban.delete"
+	ModLogNameBanCreate        = "nameban.create"
+	ModLogNameBanDelete        = "nameban.delete"
+	ModLogWarningCreate        = "warning.create"
+	ModLogWarningDelete        = "warning.delete"
+	ModLogAppealApprove        = "appeal.approve"
+	ModLogAppealDeny           = "appeal.deny"
+	ModLogReportDismiss        = "report.dismiss"
+	ModLogReportBlock          = "report.block"
+	ModLogReportCategoryCreate = "reportcategory.create"
+	ModLogReportCategoryEdit   = "reportcategory.edit"
+	ModLogReportCategoryDelete = "reportcategory.delete"
+	ModLogBoardCreate          = "board.create"
+	ModLogBoardEdit            = "board.edit"
+	ModLogBoardDelete          = "board.delete"
+	ModLogSectionCreate        = "section.create"
+	ModLogSectionEdit          = "section.edit"
+	ModLogSectionDelete        = "section.delete"
+	ModLogWordfilterCreate     = "wordfilter.create"
+	ModLogWordfilterEdit       = "wordfilter.edit"
+	ModLogWordfilterDelete     = "wordfilter.delete"
+	ModLogStaffCreate          = "staff.create"
+	ModLogStaffEdit            = "staff.edit"
+	ModLogStaffDelete          = "staff.delete"
+	ModLogStaffResetTOTP       = "staff.resettotp"
+	ModLogRoleCreate           = "role.create"
+	ModLogRoleEdit             = "role.edit"
+	ModLogRoleDelete           = "role.delete"
+	ModLogSessionRevoke        = "session.revoke"
+	ModLogSiteRebuild          = "site.rebuild"
+	ModLogSiteReparse          = "site.reparse"
+	ModLogSiteCleanup          = "site.cleanup"
+	ModLogSiteReload           = "site.reload"
+	ModLogConfigEdit           = "config.edit"
+	ModLogAnnouncementCreate   = "announcement.create"
+)
+
+// Types of things that moderation log entries can refer to
+const (
+	ModLogTargetPost           = "post"
+	ModLogTargetThread         = "thread"
+	ModLogTargetBan            = "ban"
+	ModLogTargetBanPreset      = "banpreset"
+	ModLogTargetWarning        = "warning"
+	ModLogTargetAppeal         = "appeal"
+	ModLogTargetReport         = "report"
+	ModLogTargetReportCategory = "reportcategory"
+	ModLogTargetBoard          = "board"
+	ModLogTargetSection        = "section"
+	ModLogTargetWordfilter     = "wordfilter"
+	ModLogTargetStaff          = "staff"
+	ModLogTargetRole           = "role"
+	ModLogTargetSession        = "session"
+	ModLogTargetSite           = "site"
+	ModLogTargetAnnouncement   = "announcement"
+)
+
+var (
+	// ModLogTargetTypes are the target types that can be used to filter the moderation log
+	ModLogTargetTypes = []string{
+		ModLogTargetPost, ModLogTargetThread, ModLogTargetBan, ModLogTargetBanPreset, ModLogTargetWarning,
+		ModLogTargetAppeal, ModLogTargetReport, ModLogTargetReportCategory, ModLogTargetBoard,
+		ModLogTargetSection, ModLogTargetWordfilter, ModLogTargetStaff, ModLogTargetRole, ModLogTargetSession,
+		ModLogTargetSite, ModLogTargetAnnouncement,
+	}
+
+	// publicModLogTargets are the target types shown on a board's public moderation log
+	publicModLogTargets = []string{ModLogTargetPost, ModLogTargetThread, ModLogTargetBan}
+)
+
+// ModLogListing is a moderation log entry along with the directory of the board it was on, if any
+type ModLogListing struct {
+	ModLogEntry
+	BoardDir string
+}
+
+// ModLogFilter limits the moderation log entries returned by GetModLog. Fields that are empty (or 0) are
+// not used to filter the entries
+type ModLogFilter struct {
+	StaffUsername string
+	Action        string
+	TargetType    string
+	TargetID      int
+	BoardID       int
+}
+
+// LogStaffAction records an action taken by the staff member in the moderation log. The entry's staff fields
+// and details are set by LogStaffAction. before and after are the state of the target before and after the
+// action, stored as JSON so that changes can be reviewed later. Either can be nil
+func LogStaffAction(staff *Staff, entry *ModLogEntry, before interface{}, after interface{}) error {
+	const insertSQL = `INSERT INTO DBPREFIXstaff_actions
+	(staff_id, staff_username, action, target_type, target_id, board_id, before_details, after_details,
+	performed_at)
+	VALUES(?,?,?,?,?,?,?,?,?)`
+	var err error
+	if entry.Before, err = modLogDetails(before); err != nil {
+		return err
+	}
+	if entry.After, err = modLogDetails(after); err != nil {
+		return err
+	}
+	entry.StaffID = staff.ID
+	entry.StaffUsername = staff.Username
+	var staffID *int
+	if staff.ID > 0 {
+		staffID = &staff.ID
+	}
+	var boardID *int
+	if entry.BoardID != nil && *entry.BoardID > 0 {
+		boardID = entry.BoardID
+	}
+	_, err = ExecSQL(insertSQL, staffID, staff.Username, entry.Action, entry.TargetType, entry.TargetID, boardID,
+		entry.Before, entry.After, time.Now())
+	return err
+}
+
+// modLogDetails returns the JSON representation of the details, or an empty string if they are nil
+func modLogDetails(details interface{}) (string, error) {
+	if details == nil {
+		return "", nil
+	}
+	if str, ok := details.(string); ok {
+		return str, nil
+	}
+	ba, err := json.Marshal(details)
+	return string(ba), err
+}
+
+// GetModLog returns up to limit moderation log entries matching the filter, newest first, skipping the
+// first offset entries
+func GetModLog(filter *ModLogFilter, limit int, offset int) ([]ModLogListing, error) {
+	query := `SELECT log.id, COALESCE(log.staff_id, 0), log.staff_username, log.action, log.target_type,
+	log.target_id, log.board_id, COALESCE(boards.dir, ''), log.before_details, log.after_details,
+	log.performed_at
+	FROM DBPREFIXstaff_actions AS log
+	LEFT JOIN DBPREFIXboards AS boards ON boards.id = log.board_id`
+	var where []string
+	var params []interface{}
+	if filter != nil {
+		if filter.StaffUsername != "" {
+			where = append(where, "log.staff_username = ?")
+			params = append(params, filter.StaffUsername)
+		}
+		if filter.Action != "" {
+			where = append(where, "log.action = ?")
+			params = append(params, filter.Action)
+		}
+		if filter.TargetType != "" {
+			where = append(where, "log.target_type = ?")
+			params = append(params, filter.TargetType)
+		}
+		if filter.TargetID > 0 {
+			where = append(where, "log.target_id = ?")
+			params = append(params, filter.TargetID)
+		}
+		if filter.BoardID > 0 {
+			where = append(where, "log.board_id = ?")
+			params = append(params, filter.BoardID)
+		}
+	}
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY log.id DESC LIMIT ? OFFSET ?"
+	params = append(params, limit, offset)
+
+	rows, err := QuerySQL(query, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var entries []ModLogListing
+	for rows.Next() {
+		var entry ModLogListing
+		if err = rows.Scan(&entry.ID, &entry.StaffID, &entry.StaffUsername, &entry.Action, &entry.TargetType,
+			&entry.TargetID, &entry.BoardID, &entry.BoardDir, &entry.Before, &entry.After, &entry.PerformedAt,
+		); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// GetPublicModLog returns up to limit moderation log entries about posts, threads, and bans on the board,
+// newest first, without the staff member who did them or the details, which may include IP addresses
+func GetPublicModLog(boardID int, limit int) ([]ModLogListing, error) {
+	targets := make([]interface{}, len(publicModLogTargets))
+	for t, target := range publicModLogTargets {
+		targets[t] = target
+	}
+	query := `SELECT log.id, log.action, log.target_type, log.target_id, log.board_id, boards.dir,
+	log.performed_at
+	FROM DBPREFIXstaff_actions AS log
+	JOIN DBPREFIXboards AS boards ON boards.id = log.board_id
+	WHERE log.board_id = ? AND log.target_type IN ` + createArrayPlaceholder(targets) + `
+	ORDER BY log.id DESC LIMIT ?`
+	params := append([]interface{}{boardID}, targets...)
+	params = append(params, limit)
+	rows, err := QuerySQL(query, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var entries []ModLogListing
+	for rows.Next() {
+		var entry ModLogListing
+		if err = rows.Scan(&entry.ID, &entry.Action, &entry.TargetType, &entry.TargetID, &entry.BoardID,
+			&entry.BoardDir, &entry.PerformedAt,
+		); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
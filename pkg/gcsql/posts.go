@@ -129,9 +129,9 @@ func GetBoardTopPosts(boardID int) ([]Post, error) {
 		password, deleted_at, is_deleted, banned_message
 		FROM DBPREFIXposts
 		LEFT JOIN (
-		SELECT id, board_id from DBPREFIXthreads
+		SELECT id, board_id, is_archived from DBPREFIXthreads
 		) t on t.id = DBPREFIXposts.thread_id
-		WHERE is_deleted = FALSE AND is_top_post AND t.board_id = ?`
+		WHERE is_deleted = FALSE AND is_top_post AND t.board_id = ? AND t.is_archived = FALSE`
 
 	rows, err := QuerySQL(query, boardID)
 	if err != nil {
@@ -216,7 +216,8 @@ func SinceLastThread(postIP string) (int, error) {
 
 // UpdateContents updates the email, subject, and message text of the post
 func (p *Post) UpdateContents(email string, subject string, message template.HTML, messageRaw string) error {
-	const sqlUpdate = `UPDATE DBPREFIXposts SET email = ?, subject = ?, message = ?, message_raw = ? WHERE ID = ?`
+	const sqlUpdate = `UPDATE DBPREFIXposts SET email = ?, subject = ?, message = ?, message_raw = ?,
+	last_modified = CURRENT_TIMESTAMP WHERE ID = ?`
 	_, err := ExecSQL(sqlUpdate, email, subject, message, messageRaw, p.ID)
 	if err != nil {
 		return err
@@ -306,6 +307,28 @@ func (p *Post) GetUpload() (*Upload, error) {
 	return upload, err
 }
 
+// GetUploads returns all of the uploads attached to the post, ordered by their file_order value
+func (p *Post) GetUploads() ([]Upload, error) {
+	const query = selectFilesBaseSQL + `WHERE post_id = ? ORDER BY file_order ASC`
+	rows, err := QuerySQL(query, p.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var uploads []Upload
+	for rows.Next() {
+		var upload Upload
+		if err = rows.Scan(
+			&upload.ID, &upload.PostID, &upload.FileOrder, &upload.OriginalFilename, &upload.Filename, &upload.Checksum,
+			&upload.FileSize, &upload.IsSpoilered, &upload.ThumbnailWidth, &upload.ThumbnailHeight, &upload.Width, &upload.Height,
+		); err != nil {
+			return uploads, err
+		}
+		uploads = append(uploads, upload)
+	}
+	return uploads, nil
+}
+
 // UnlinkUploads disassociates the post with any uploads in DBPREFIXfiles
 // that may have been uploaded with it, optionally leaving behind a "File Deleted"
 // frame where the thumbnail appeared
@@ -317,7 +340,10 @@ func (p *Post) UnlinkUploads(leaveDeletedBox bool) error {
 	} else {
 		sqlStr = `DELETE FROM DBPREFIXfiles WHERE post_id = ?`
 	}
-	_, err := ExecSQL(sqlStr, p.ID)
+	if _, err := ExecSQL(sqlStr, p.ID); err != nil {
+		return err
+	}
+	_, err := ExecSQL(`UPDATE DBPREFIXposts SET last_modified = CURRENT_TIMESTAMP WHERE id = ?`, p.ID)
 	return err
 }
 
@@ -326,7 +352,8 @@ func (p *Post) Delete() error {
 	if p.IsTopPost {
 		return deleteThread(p.ThreadID)
 	}
-	const deleteSQL = `UPDATE DBPREFIXposts SET is_deleted = TRUE, deleted_at = CURRENT_TIMESTAMP WHERE id = ?`
+	const deleteSQL = `UPDATE DBPREFIXposts SET is_deleted = TRUE, deleted_at = CURRENT_TIMESTAMP,
+	last_modified = CURRENT_TIMESTAMP WHERE id = ?`
 	_, err := ExecSQL(deleteSQL, p.ID)
 	return err
 }
@@ -337,10 +364,12 @@ func (p *Post) Insert(bumpThread bool, boardID int, locked bool, stickied bool,
 		return ErrorPostAlreadySent
 	}
 	insertSQL := `INSERT INTO DBPREFIXposts
-	(thread_id, is_top_post, ip, created_on, name, tripcode, is_role_signature, email, subject,
+	(thread_id, is_top_post, ip, ip_key, created_on, name, tripcode, is_role_signature, email, subject,
 		message, message_raw, password) 
-	VALUES(?,?,?,CURRENT_TIMESTAMP,?,?,?,?,?,?,?,?)`
+	VALUES(?,?,?,?,CURRENT_TIMESTAMP,?,?,?,?,?,?,?,?)`
 	bumpSQL := `UPDATE DBPREFIXthreads SET last_bump = CURRENT_TIMESTAMP WHERE id = ?`
+	// a post with an IP that can't be parsed is still made, it just isn't found when searching by IP range
+	ipKey, _ := PostIPKey(p.IP)
 
 	tx, err := BeginTx()
 	if err != nil {
@@ -373,7 +402,7 @@ func (p *Post) Insert(bumpThread bool, boardID int, locked bool, stickied bool,
 		return err
 	}
 	if _, err = stmt.Exec(
-		p.ThreadID, p.IsTopPost, p.IP, p.Name, p.Tripcode, p.IsRoleSignature, p.Email, p.Subject,
+		p.ThreadID, p.IsTopPost, p.IP, ipKey, p.Name, p.Tripcode, p.IsRoleSignature, p.Email, p.Subject,
 		p.Message, p.MessageRaw, p.Password,
 	); err != nil {
 		return err
@@ -414,3 +443,34 @@ func (p *Post) WebPath() string {
 	}
 	return webRoot + boardDir + fmt.Sprintf("/res/%d.html#%d", opID, p.ID)
 }
+
+// GetPostLastModified returns the last time the post was made, edited, or deleted
+func GetPostLastModified(postID int) (time.Time, error) {
+	return queryLastModified(`SELECT last_modified FROM DBPREFIXposts WHERE id = ?`, postID)
+}
+
+// GetThreadLastModified returns the most recent time that a post in the thread was made, edited, or deleted, or
+// the thread's attributes or board were changed
+func GetThreadLastModified(threadID int) (time.Time, error) {
+	return queryLastModified(`SELECT last_modified FROM DBPREFIXposts WHERE thread_id = ?
+		ORDER BY last_modified DESC LIMIT 1`, threadID)
+}
+
+// GetBoardLastModified returns the most recent time that a post in one of the board's threads was made, edited,
+// or deleted, or one of its threads was changed
+func GetBoardLastModified(boardID int) (time.Time, error) {
+	return queryLastModified(`SELECT p.last_modified FROM DBPREFIXposts p
+		JOIN DBPREFIXthreads t ON t.id = p.thread_id WHERE t.board_id = ?
+		ORDER BY p.last_modified DESC LIMIT 1`, boardID)
+}
+
+// queryLastModified returns the last_modified timestamp selected by the query, or the zero time if no rows are
+// selected
+func queryLastModified(query string, id int) (time.Time, error) {
+	var lastModified time.Time
+	err := QueryRowSQL(query, interfaceSlice(id), interfaceSlice(&lastModified))
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+	return lastModified, err
+}
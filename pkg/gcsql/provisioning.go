@@ -16,7 +16,7 @@ const (
 	DBUpToDate
 	DBModernButAhead
 
-	targetDatabaseVersion = 2
+	targetDatabaseVersion = 16
 )
 
 var (
@@ -108,7 +108,7 @@ func CheckAndInitializeDatabase(dbType string) error {
 	if err != nil {
 		return err
 	}
-	return nil
+	return initSearch()
 }
 
 func buildNewDatabase(dbType string) error {
@@ -116,6 +116,9 @@ func buildNewDatabase(dbType string) error {
 	if err = initDB("initdb_" + dbType + ".sql"); err != nil {
 		return err
 	}
+	if err = createDefaultRolesIfNoneExist(); err != nil {
+		return errors.New("failed creating default staff roles: " + err.Error())
+	}
 	if err = createDefaultAdminIfNoStaff(); err != nil {
 		return errors.New("failed creating default admin account: " + err.Error())
 	}
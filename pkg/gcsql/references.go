@@ -0,0 +1,90 @@
+package gcsql
+
+const (
+	// selectLinkedPostsBaseSQL selects the ID, board dir, top post ID, and whether the thread is archived of posts
+	// that aren't deleted, using p as the alias for DBPREFIXposts
+	selectLinkedPostsBaseSQL = `SELECT p.id, b.dir, op.id, t.is_archived FROM DBPREFIXposts p
+	INNER JOIN DBPREFIXthreads t ON t.id = p.thread_id
+	INNER JOIN DBPREFIXboards b ON b.id = t.board_id
+	INNER JOIN DBPREFIXposts op ON op.thread_id = p.thread_id AND op.is_top_post = TRUE `
+)
+
+// LinkedPost is the location of a post that is linked to in (or links to) another post's message
+type LinkedPost struct {
+	ID         int
+	BoardDir   string
+	TopPostID  int
+	IsArchived bool
+}
+
+// GetLinkedPosts returns the locations of the posts with the given IDs, mapped to their IDs. Posts that
+// don't exist or are deleted are not included
+func GetLinkedPosts(postIDs ...interface{}) (map[int]LinkedPost, error) {
+	posts := make(map[int]LinkedPost)
+	if len(postIDs) == 0 {
+		return posts, nil
+	}
+	query := selectLinkedPostsBaseSQL + `WHERE p.is_deleted = FALSE AND p.id IN ` + createArrayPlaceholder(postIDs)
+	rows, err := QuerySQL(query, postIDs...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var post LinkedPost
+		if err = rows.Scan(&post.ID, &post.BoardDir, &post.TopPostID, &post.IsArchived); err != nil {
+			return nil, err
+		}
+		posts[post.ID] = post
+	}
+	return posts, rows.Err()
+}
+
+// GetPostReplies returns the posts that link to each of the given posts, mapped to the IDs of the posts
+// they link to and ordered by ID
+func GetPostReplies(postIDs ...interface{}) (map[int][]LinkedPost, error) {
+	replies := make(map[int][]LinkedPost)
+	if len(postIDs) == 0 {
+		return replies, nil
+	}
+	query := `SELECT r.referenced_post_id, p.id, b.dir, op.id, t.is_archived FROM DBPREFIXpost_references r
+	INNER JOIN DBPREFIXposts p ON p.id = r.post_id
+	INNER JOIN DBPREFIXthreads t ON t.id = p.thread_id
+	INNER JOIN DBPREFIXboards b ON b.id = t.board_id
+	INNER JOIN DBPREFIXposts op ON op.thread_id = p.thread_id AND op.is_top_post = TRUE
+	WHERE p.is_deleted = FALSE AND r.referenced_post_id IN ` + createArrayPlaceholder(postIDs) + `
+	ORDER BY p.id ASC`
+	rows, err := QuerySQL(query, postIDs...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var referencedID int
+		var reply LinkedPost
+		if err = rows.Scan(&referencedID, &reply.ID, &reply.BoardDir, &reply.TopPostID, &reply.IsArchived); err != nil {
+			return nil, err
+		}
+		replies[referencedID] = append(replies[referencedID], reply)
+	}
+	return replies, rows.Err()
+}
+
+// SetReferences replaces the list of posts that p links to in its message
+func (p *Post) SetReferences(referenced []LinkedPost) error {
+	tx, err := BeginTx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err = ExecTxSQL(tx, `DELETE FROM DBPREFIXpost_references WHERE post_id = ?`, p.ID); err != nil {
+		return err
+	}
+	const insertSQL = `INSERT INTO DBPREFIXpost_references(post_id, referenced_post_id) VALUES(?,?)`
+	for _, linked := range referenced {
+		if _, err = ExecTxSQL(tx, insertSQL, p.ID, linked.ID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,112 @@
+package gcsql
+
+import (
+	"errors"
+	"strings"
+)
+
+const (
+	selectReportCategoriesBaseSQL = `SELECT id, board_id, name, description, position FROM DBPREFIXreport_categories `
+)
+
+var (
+	ErrEmptyReportCategoryName = errors.New("report category name must not be empty")
+	ErrReportCategoryNotFound  = errors.New("report category not found")
+)
+
+func getReportCategories(where string, params ...interface{}) ([]ReportCategory, error) {
+	rows, err := QuerySQL(selectReportCategoriesBaseSQL+where+` ORDER BY position ASC, name ASC`, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var categories []ReportCategory
+	for rows.Next() {
+		var category ReportCategory
+		if err = rows.Scan(&category.ID, &category.BoardID, &category.Name, &category.Description,
+			&category.Position); err != nil {
+			return nil, err
+		}
+		categories = append(categories, category)
+	}
+	return categories, rows.Err()
+}
+
+// GetAllReportCategories returns every report category, including those limited to a board
+func GetAllReportCategories() ([]ReportCategory, error) {
+	return getReportCategories("")
+}
+
+// GetBoardReportCategories returns the report categories that can be used when reporting a post on the board
+// with the given ID, including categories that aren't limited to a board
+func GetBoardReportCategories(boardID int) ([]ReportCategory, error) {
+	return getReportCategories(`WHERE board_id IS NULL OR board_id = ?`, boardID)
+}
+
+// GetReportCategory returns the report category with the given ID
+func GetReportCategory(id int) (*ReportCategory, error) {
+	categories, err := getReportCategories(`WHERE id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(categories) == 0 {
+		return nil, ErrReportCategoryNotFound
+	}
+	return &categories[0], nil
+}
+
+// CanUseOnBoard returns true if the category can be used when reporting a post on the board with the given ID
+func (rc *ReportCategory) CanUseOnBoard(boardID int) bool {
+	return rc.BoardID == nil || *rc.BoardID == boardID
+}
+
+// NewReportCategory inserts the report category into the database and sets its ID
+func NewReportCategory(category *ReportCategory) error {
+	const insertSQL = `INSERT INTO DBPREFIXreport_categories (board_id, name, description, position) VALUES(?,?,?,?)`
+	category.Name = strings.TrimSpace(category.Name)
+	if category.Name == "" {
+		return ErrEmptyReportCategoryName
+	}
+	tx, err := BeginTx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err = ExecTxSQL(tx, insertSQL, category.BoardID, category.Name, category.Description,
+		category.Position); err != nil {
+		return err
+	}
+	if category.ID, err = getLatestID("DBPREFIXreport_categories", tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Update saves the changes to the report category's name, description, board, and position
+func (rc *ReportCategory) Update() error {
+	const updateSQL = `UPDATE DBPREFIXreport_categories SET board_id = ?, name = ?, description = ?, position = ?
+	WHERE id = ?`
+	rc.Name = strings.TrimSpace(rc.Name)
+	if rc.Name == "" {
+		return ErrEmptyReportCategoryName
+	}
+	_, err := ExecSQL(updateSQL, rc.BoardID, rc.Name, rc.Description, rc.Position, rc.ID)
+	return err
+}
+
+// DeleteReportCategory deletes the report category with the given ID. Reports in the category are kept
+// without a category
+func DeleteReportCategory(id int) error {
+	result, err := ExecSQL(`DELETE FROM DBPREFIXreport_categories WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrReportCategoryNotFound
+	}
+	return nil
+}
@@ -3,11 +3,15 @@ package gcsql
 import "time"
 
 // CreateReport inserts a new report into the database and returns a Report pointer and any
-// errors encountered
-func CreateReport(postID int, ip string, reason string) (*Report, error) {
+// errors encountered. If categoryID is 0, the report doesn't have a category
+func CreateReport(postID int, categoryID int, ip string, reason string) (*Report, error) {
 	currentTime := time.Now()
-	sql := `INSERT INTO DBPREFIXreports (post_id, ip, reason, is_cleared) VALUES(?, ?, ?, FALSE)`
-	result, err := ExecSQL(sql, postID, ip, reason)
+	sql := `INSERT INTO DBPREFIXreports (post_id, category_id, ip, reason, is_cleared) VALUES(?, ?, ?, ?, FALSE)`
+	var category *int
+	if categoryID > 0 {
+		category = &categoryID
+	}
+	result, err := ExecSQL(sql, postID, category, ip, reason)
 	if err != nil {
 		return nil, err
 	}
@@ -24,6 +28,7 @@ func CreateReport(postID int, ip string, reason string) (*Report, error) {
 		ID:               int(reportID),
 		HandledByStaffID: -1,
 		PostID:           postID,
+		CategoryID:       categoryID,
 		IP:               ip,
 		Reason:           reason,
 		IsCleared:        false,
@@ -52,14 +57,15 @@ func ClearReport(id int, staffID int, block bool) (bool, error) {
 	return affected > 0, err
 }
 
-// CheckPostReports checks to see if the given post ID has already been reported, and if a report of the post has been
-// dismissed with prejudice (so that more reports of that post can't be made)
-func CheckPostReports(postID int, reason string) (bool, bool, error) {
+// CheckPostReports checks to see if the given post ID has already been reported in the category with the given
+// reason, and if a report of the post has been dismissed with prejudice (so that more reports of that post can't
+// be made)
+func CheckPostReports(postID int, categoryID int, reason string) (bool, bool, error) {
 	sql := `SELECT COUNT(*), MAX(is_cleared) FROM DBPREFIXreports
-		WHERE post_id = ? AND (reason = ? OR is_cleared = 2)`
+		WHERE post_id = ? AND ((reason = ? AND COALESCE(category_id, 0) = ?) OR is_cleared = 2)`
 	var num int
 	var isCleared interface{}
-	err := QueryRowSQL(sql, interfaceSlice(postID, reason), interfaceSlice(&num, &isCleared))
+	err := QueryRowSQL(sql, interfaceSlice(postID, reason, categoryID), interfaceSlice(&num, &isCleared))
 	isClearedInt, _ := isCleared.(int64)
 	return num > 0, isClearedInt == 2, err
 }
@@ -67,7 +73,7 @@ func CheckPostReports(postID int, reason string) (bool, bool, error) {
 // GetReports returns a Report array and any errors encountered. If `includeCleared` is true,
 // the array will include reports that have already been dismissed
 func GetReports(includeCleared bool) ([]Report, error) {
-	sql := `SELECT id,handled_by_staff_id,post_id,ip,reason,is_cleared FROM DBPREFIXreports`
+	sql := `SELECT id,handled_by_staff_id,post_id,COALESCE(category_id,0),ip,reason,is_cleared FROM DBPREFIXreports`
 	if !includeCleared {
 		sql += ` WHERE is_cleared = FALSE`
 	}
@@ -80,7 +86,8 @@ func GetReports(includeCleared bool) ([]Report, error) {
 	for rows.Next() {
 		var report Report
 		var staffID interface{}
-		err = rows.Scan(&report.ID, &staffID, &report.PostID, &report.IP, &report.Reason, &report.IsCleared)
+		err = rows.Scan(&report.ID, &staffID, &report.PostID, &report.CategoryID, &report.IP, &report.Reason,
+			&report.IsCleared)
 		if err != nil {
 			return nil, err
 		}
@@ -91,3 +98,113 @@ func GetReports(includeCleared bool) ([]Report, error) {
 	}
 	return reports, nil
 }
+
+// GetReportBoardID returns the ID of the board that the post with the given report is on
+func GetReportBoardID(reportID int) (int, error) {
+	const query = `SELECT t.board_id FROM DBPREFIXreports r
+	INNER JOIN DBPREFIXposts p ON p.id = r.post_id
+	INNER JOIN DBPREFIXthreads t ON t.id = p.thread_id
+	WHERE r.id = ?`
+	var boardID int
+	err := QueryRowSQL(query, interfaceSlice(reportID), interfaceSlice(&boardID))
+	return boardID, err
+}
+
+// GetPostReports returns the reports of the post with the given ID that haven't been dismissed
+func GetPostReports(postID int) ([]Report, error) {
+	const query = `SELECT id, post_id, COALESCE(category_id, 0), ip, reason FROM DBPREFIXreports
+	WHERE post_id = ? AND is_cleared = FALSE`
+	rows, err := QuerySQL(query, postID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var reports []Report
+	for rows.Next() {
+		var report Report
+		if err = rows.Scan(&report.ID, &report.PostID, &report.CategoryID, &report.IP, &report.Reason); err != nil {
+			return nil, err
+		}
+		reports = append(reports, report)
+	}
+	return reports, rows.Err()
+}
+
+// ReportListing is a report along with the name of its category, if it has one
+type ReportListing struct {
+	Report
+	CategoryName string
+}
+
+// ReportedPost is a post in the report queue with all of its reports that haven't been dismissed
+type ReportedPost struct {
+	PostID     int
+	TopPostID  int
+	BoardID    int
+	BoardDir   string
+	IP         string
+	MessageRaw string
+	Reports    []ReportListing
+}
+
+// NumReports returns the number of reports of the post
+func (rp *ReportedPost) NumReports() int {
+	return len(rp.Reports)
+}
+
+// ReportQueueFilter limits the posts returned by GetReportQueue. Fields that are 0 are not used to filter
+// the queue
+type ReportQueueFilter struct {
+	BoardID    int
+	CategoryID int
+}
+
+// GetReportQueue returns the reported posts that have reports that haven't been dismissed, with the most
+// recently reported posts first. If filter.CategoryID is set, only reports in that category are included
+func GetReportQueue(filter *ReportQueueFilter) ([]ReportedPost, error) {
+	query := `SELECT r.id, COALESCE(r.handled_by_staff_id, 0), r.post_id, COALESCE(r.category_id, 0), r.ip,
+	r.reason, COALESCE(c.name, ''), t.board_id, b.dir, p.ip, p.message_raw,
+	(SELECT id FROM DBPREFIXposts WHERE thread_id = p.thread_id AND is_top_post = TRUE LIMIT 1) AS op
+	FROM DBPREFIXreports r
+	INNER JOIN DBPREFIXposts p ON p.id = r.post_id
+	INNER JOIN DBPREFIXthreads t ON t.id = p.thread_id
+	INNER JOIN DBPREFIXboards b ON b.id = t.board_id
+	LEFT JOIN DBPREFIXreport_categories c ON c.id = r.category_id
+	WHERE r.is_cleared = FALSE AND p.is_deleted = FALSE`
+	var params []interface{}
+	if filter != nil && filter.BoardID > 0 {
+		query += ` AND t.board_id = ?`
+		params = append(params, filter.BoardID)
+	}
+	if filter != nil && filter.CategoryID > 0 {
+		query += ` AND r.category_id = ?`
+		params = append(params, filter.CategoryID)
+	}
+	query += ` ORDER BY r.id DESC`
+	rows, err := QuerySQL(query, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var posts []ReportedPost
+	postIndexes := make(map[int]int)
+	for rows.Next() {
+		var report ReportListing
+		var post ReportedPost
+		if err = rows.Scan(&report.ID, &report.HandledByStaffID, &report.PostID, &report.CategoryID, &report.IP,
+			&report.Reason, &report.CategoryName, &post.BoardID, &post.BoardDir, &post.IP, &post.MessageRaw,
+			&post.TopPostID,
+		); err != nil {
+			return nil, err
+		}
+		index, ok := postIndexes[report.PostID]
+		if !ok {
+			post.PostID = report.PostID
+			index = len(posts)
+			postIndexes[report.PostID] = index
+			posts = append(posts, post)
+		}
+		posts[index].Reports = append(posts[index].Reports, report)
+	}
+	return posts, rows.Err()
+}
@@ -0,0 +1,381 @@
+package gcsql
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+// Capabilities that can be given to staff roles
+const (
+	CapPostDelete       = "post.delete"
+	CapPostEdit         = "post.edit"
+	CapThreadMove       = "thread.move"
+	CapThreadManage     = "thread.manage"
+	CapIPView           = "ip.view"
+	CapReportManage     = "report.manage"
+	CapReportBlock      = "report.block"
+	CapReportCategories = "report.categories"
+	CapBanCreate        = "ban.create"
+	CapBanDelete        = "ban.delete"
+	CapBanPresets       = "ban.presets"
+	CapWarningCreate    = "warning.create"
+	CapAppealManage     = "appeal.manage"
+	CapBoardEdit        = "board.edit"
+	CapWordfilterManage = "wordfilter.manage"
+	CapStaffManage      = "staff.manage"
+	CapSiteRebuild      = "site.rebuild"
+	CapSiteCleanup      = "site.cleanup"
+	CapConfigEdit       = "config.edit"
+	CapModLogView       = "modlog.view"
+	CapPluginView       = "plugin.view"
+	CapPluginReload     = "plugin.reload"
+)
+
+var (
+	ErrRoleNameInUse = errors.New("a role with that name already exists")
+	ErrRoleHasStaff  = errors.New("the role is still assigned to one or more staff members")
+	ErrEmptyRoleName = errors.New("role name must not be empty")
+	ErrRoleNotFound  = errors.New("role not found")
+
+	// Capabilities lists every capability that can be given to a role, in the order they are shown on the
+	// roles management page. Plugins can add their own with RegisterCapability
+	Capabilities = []Capability{
+		{CapPostDelete, "Delete posts and threads"},
+		{CapPostEdit, "Edit posts"},
+		{CapThreadMove, "Move threads to other boards"},
+		{CapThreadManage, "Lock, sticky, and set other thread attributes"},
+		{CapIPView, "View poster IPs and search posts by IP"},
+		{CapReportManage, "View and dismiss reports"},
+		{CapReportBlock, "Make posts unreportable"},
+		{CapReportCategories, "Manage report categories"},
+		{CapBanCreate, "Create IP, name, and file bans"},
+		{CapBanDelete, "Remove bans"},
+		{CapBanPresets, "Manage ban presets"},
+		{CapWarningCreate, "Warn posters and remove warnings"},
+		{CapAppealManage, "Respond to ban appeals"},
+		{CapBoardEdit, "Create, edit, and delete boards and sections"},
+		{CapWordfilterManage, "Manage wordfilters"},
+		{CapStaffManage, "Manage staff accounts and roles"},
+		{CapSiteRebuild, "Rebuild pages and view the build queue"},
+		{CapSiteCleanup, "Clean up the database"},
+		{CapConfigEdit, "Edit the site configuration"},
+		{CapModLogView, "View the moderation log"},
+		{CapPluginView, "View loaded plugins and their errors"},
+		{CapPluginReload, "Reload plugins and templates"},
+	}
+
+	// DefaultRoles are created if no roles exist when the database is provisioned. Staff accounts from
+	// versions that used ranks are given the role at the index of their rank - 1 (janitor, moderator,
+	// administrator)
+	DefaultRoles = []Role{
+		{Name: "Janitor", Capabilities: []string{CapPostDelete}},
+		{Name: "Moderator", Capabilities: []string{
+			CapPostDelete, CapPostEdit, CapThreadMove, CapThreadManage, CapIPView, CapReportManage,
+			CapBanCreate, CapBanDelete, CapWarningCreate, CapAppealManage, CapModLogView,
+		}},
+		{Name: "Administrator"}, // given every capability in Capabilities
+	}
+)
+
+// Capability is a permission that can be given to a staff role
+type Capability struct {
+	Name        string
+	Description string
+}
+
+// Role is a named set of capabilities that is assigned to staff members
+type Role struct {
+	ID           int
+	Name         string
+	Capabilities []string
+	// RequireTOTP is true if staff with the role must set up two-factor authentication before they can use
+	// any of its capabilities
+	RequireTOTP bool
+}
+
+// RegisterCapability adds a capability to the list shown on the roles management page if it isn't
+// already there. It is used by plugins that add manage pages
+func RegisterCapability(name string, description string) {
+	for _, capability := range Capabilities {
+		if capability.Name == name {
+			return
+		}
+	}
+	Capabilities = append(Capabilities, Capability{Name: name, Description: description})
+}
+
+// Has returns true if the role has the given capability
+func (r *Role) Has(capability string) bool {
+	if r == nil {
+		return false
+	}
+	for _, c := range r.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// getRoleCapabilities returns the capabilities of each role mapped to the role IDs
+func getRoleCapabilities() (map[int][]string, error) {
+	const query = `SELECT role_id, capability FROM DBPREFIXstaff_role_capabilities ORDER BY capability`
+	rows, err := QuerySQL(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	capabilities := make(map[int][]string)
+	for rows.Next() {
+		var roleID int
+		var capability string
+		if err = rows.Scan(&roleID, &capability); err != nil {
+			return nil, err
+		}
+		capabilities[roleID] = append(capabilities[roleID], capability)
+	}
+	return capabilities, rows.Err()
+}
+
+// GetRoles returns all of the staff roles and their capabilities, ordered by name
+func GetRoles() ([]Role, error) {
+	capabilities, err := getRoleCapabilities()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := QuerySQL(`SELECT id, name, require_totp FROM DBPREFIXstaff_roles ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var roles []Role
+	for rows.Next() {
+		var role Role
+		if err = rows.Scan(&role.ID, &role.Name, &role.RequireTOTP); err != nil {
+			return nil, err
+		}
+		role.Capabilities = capabilities[role.ID]
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+// GetRole returns the role with the given ID and its capabilities
+func GetRole(id int) (*Role, error) {
+	role := &Role{ID: id}
+	err := QueryRowSQL(`SELECT name, require_totp FROM DBPREFIXstaff_roles WHERE id = ?`,
+		interfaceSlice(id), interfaceSlice(&role.Name, &role.RequireTOTP))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrRoleNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	rows, err := QuerySQL(`SELECT capability FROM DBPREFIXstaff_role_capabilities WHERE role_id = ? ORDER BY capability`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var capability string
+		if err = rows.Scan(&capability); err != nil {
+			return nil, err
+		}
+		role.Capabilities = append(role.Capabilities, capability)
+	}
+	return role, rows.Err()
+}
+
+// GetRoleByName returns the role with the given name and its capabilities
+func GetRoleByName(name string) (*Role, error) {
+	var id int
+	err := QueryRowSQL(`SELECT id FROM DBPREFIXstaff_roles WHERE name = ?`, interfaceSlice(name), interfaceSlice(&id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrRoleNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return GetRole(id)
+}
+
+// roleNameExists returns true if a role other than the one with the given ID has the name
+func roleNameExists(name string, excludeID int) (bool, error) {
+	var count int
+	err := QueryRowSQL(`SELECT COUNT(*) FROM DBPREFIXstaff_roles WHERE name = ? AND id <> ?`,
+		interfaceSlice(name, excludeID), interfaceSlice(&count))
+	return count > 0, err
+}
+
+func setRoleCapabilities(tx *sql.Tx, roleID int, capabilities []string) error {
+	if _, err := ExecTxSQL(tx, `DELETE FROM DBPREFIXstaff_role_capabilities WHERE role_id = ?`, roleID); err != nil {
+		return err
+	}
+	const insertSQL = `INSERT INTO DBPREFIXstaff_role_capabilities(role_id, capability) VALUES(?,?)`
+	added := make(map[string]bool)
+	for _, capability := range capabilities {
+		if capability == "" || added[capability] {
+			continue
+		}
+		if _, err := ExecTxSQL(tx, insertSQL, roleID, capability); err != nil {
+			return err
+		}
+		added[capability] = true
+	}
+	return nil
+}
+
+// NewRole creates a new staff role with the given capabilities
+func NewRole(name string, capabilities []string) (*Role, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, ErrEmptyRoleName
+	}
+	exists, err := roleNameExists(name, 0)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, ErrRoleNameInUse
+	}
+	tx, err := BeginTx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if _, err = ExecTxSQL(tx, `INSERT INTO DBPREFIXstaff_roles(name) VALUES(?)`, name); err != nil {
+		return nil, err
+	}
+	role := &Role{Name: name, Capabilities: capabilities}
+	if role.ID, err = getLatestID("DBPREFIXstaff_roles", tx); err != nil {
+		return nil, err
+	}
+	if err = setRoleCapabilities(tx, role.ID, capabilities); err != nil {
+		return nil, err
+	}
+	return role, tx.Commit()
+}
+
+// Update sets the role's name and replaces its capabilities
+func (r *Role) Update(name string, capabilities []string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return ErrEmptyRoleName
+	}
+	exists, err := roleNameExists(name, r.ID)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return ErrRoleNameInUse
+	}
+	tx, err := BeginTx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err = ExecTxSQL(tx, `UPDATE DBPREFIXstaff_roles SET name = ? WHERE id = ?`, name, r.ID); err != nil {
+		return err
+	}
+	if err = setRoleCapabilities(tx, r.ID, capabilities); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	r.Name = name
+	r.Capabilities = capabilities
+	return nil
+}
+
+// SetRequireTOTP sets whether staff with the role must set up two-factor authentication
+func (r *Role) SetRequireTOTP(require bool) error {
+	if _, err := ExecSQL(`UPDATE DBPREFIXstaff_roles SET require_totp = ? WHERE id = ?`, require, r.ID); err != nil {
+		return err
+	}
+	r.RequireTOTP = require
+	return nil
+}
+
+// Delete deletes the role. It returns ErrRoleHasStaff if any active staff members have the role
+func (r *Role) Delete() error {
+	var count int
+	err := QueryRowSQL(`SELECT COUNT(*) FROM DBPREFIXstaff WHERE role_id = ? AND is_active = TRUE`,
+		interfaceSlice(r.ID), interfaceSlice(&count))
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrRoleHasStaff
+	}
+	tx, err := BeginTx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// deactivated accounts keep their row, so they need to be detached from the role first
+	if _, err = ExecTxSQL(tx, `UPDATE DBPREFIXstaff SET role_id = NULL WHERE role_id = ?`, r.ID); err != nil {
+		return err
+	}
+	if _, err = ExecTxSQL(tx, `DELETE FROM DBPREFIXstaff_roles WHERE id = ?`, r.ID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DefaultRoleCapabilities returns the capabilities of the default role at index r of DefaultRoles. The
+// last (administrator) role is given all of them
+func DefaultRoleCapabilities(r int) []string {
+	if r < len(DefaultRoles)-1 {
+		return DefaultRoles[r].Capabilities
+	}
+	capabilities := make([]string, len(Capabilities))
+	for c, capability := range Capabilities {
+		capabilities[c] = capability.Name
+	}
+	return capabilities
+}
+
+// createDefaultRolesIfNoneExist creates the roles in DefaultRoles if there aren't any roles yet
+func createDefaultRolesIfNoneExist() error {
+	var count int
+	if err := QueryRowSQL(`SELECT COUNT(id) FROM DBPREFIXstaff_roles`, interfaceSlice(), interfaceSlice(&count)); err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	for r, role := range DefaultRoles {
+		if _, err := NewRole(role.Name, DefaultRoleCapabilities(r)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Can returns true if the staff member's role has the given capability. An empty capability only
+// requires the staff member to be logged in. Staff who still need to set up two-factor authentication
+// can't use any capabilities
+func (s *Staff) Can(capability string) bool {
+	if s == nil || s.ID == 0 {
+		return false
+	}
+	return capability == "" || (s.Role.Has(capability) && !s.NeedsTOTPEnrollment())
+}
+
+// RoleName returns the name of the staff member's role, or an empty string if they don't have one
+func (s *Staff) RoleName() string {
+	if s.Role == nil {
+		return ""
+	}
+	return s.Role.Name
+}
+
+// SetRole changes the staff member's role
+func (s *Staff) SetRole(role *Role) error {
+	if _, err := ExecSQL(`UPDATE DBPREFIXstaff SET role_id = ? WHERE id = ?`, role.ID, s.ID); err != nil {
+		return err
+	}
+	s.RoleID = role.ID
+	s.Role = role
+	return nil
+}
@@ -0,0 +1,191 @@
+package gcsql
+
+import (
+	"strings"
+	"time"
+
+	"github.com/gochan-org/gochan/pkg/gcutil"
+)
+
+const (
+	// postgresSearchVector must match the expression used by posts_search_index in initdb_postgres.sql
+	// so that the index can be used
+	postgresSearchVector = `to_tsvector('simple', DBPREFIXposts.subject || ' ' || DBPREFIXposts.message_raw || ' ' ||
+		DBPREFIXposts.name || ' ' || DBPREFIXposts.tripcode)`
+	// MySQL's default minimum indexed word length for InnoDB FULLTEXT indexes, shorter queries use LIKE
+	mysqlMinSearchLength = 3
+)
+
+var (
+	// fullTextSearch is true if the database supports full-text searching of posts. MySQL and PostgreSQL
+	// always support it, SQLite only supports it if gochan was built with the sqlite_fts5 build tag
+	fullTextSearch bool
+
+	sqliteFTSStatements = []string{
+		`CREATE VIRTUAL TABLE IF NOT EXISTS DBPREFIXposts_fts USING fts5(
+			subject, message_raw, name, tripcode, content='DBPREFIXposts', content_rowid='id')`,
+		`CREATE TRIGGER IF NOT EXISTS DBPREFIXposts_fts_insert AFTER INSERT ON DBPREFIXposts BEGIN
+			INSERT INTO DBPREFIXposts_fts(rowid, subject, message_raw, name, tripcode)
+			VALUES(new.id, new.subject, new.message_raw, new.name, new.tripcode);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS DBPREFIXposts_fts_delete AFTER DELETE ON DBPREFIXposts BEGIN
+			INSERT INTO DBPREFIXposts_fts(DBPREFIXposts_fts, rowid, subject, message_raw, name, tripcode)
+			VALUES('delete', old.id, old.subject, old.message_raw, old.name, old.tripcode);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS DBPREFIXposts_fts_update AFTER UPDATE ON DBPREFIXposts BEGIN
+			INSERT INTO DBPREFIXposts_fts(DBPREFIXposts_fts, rowid, subject, message_raw, name, tripcode)
+			VALUES('delete', old.id, old.subject, old.message_raw, old.name, old.tripcode);
+			INSERT INTO DBPREFIXposts_fts(rowid, subject, message_raw, name, tripcode)
+			VALUES(new.id, new.subject, new.message_raw, new.name, new.tripcode);
+		END`,
+	}
+)
+
+// PostSearch holds the parameters used to search for posts. Zero values are ignored
+type PostSearch struct {
+	// Query is matched against the post subject, message, name, tripcode, and the original filenames
+	// of its uploads
+	Query   string
+	BoardID int
+	// BoardIDs limits the results to posts on any of the given boards if it isn't nil, for staff
+	// who can only moderate specific boards
+	BoardIDs       []int
+	After          time.Time
+	Before         time.Time
+	HasFile        bool
+	OPOnly         bool
+	IncludeDeleted bool
+}
+
+// initSearch checks if the database supports full-text searching, creating the SQLite FTS5 table
+// and triggers if FTS5 is available
+func initSearch() error {
+	switch gcdb.driver {
+	case "mysql":
+		fallthrough
+	case "postgres":
+		fullTextSearch = true
+	case "sqlite3":
+		var fts5 bool
+		err := QueryRowSQL(`SELECT sqlite_compileoption_used('ENABLE_FTS5')`, nil, []interface{}{&fts5})
+		if err != nil {
+			return err
+		}
+		if !fts5 {
+			gcutil.LogWarning().
+				Msg("SQLite was built without FTS5 support, post searches will be slower")
+			return nil
+		}
+		var numTables int
+		if err = QueryRowSQL(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`,
+			[]interface{}{gcdb.replacer.Replace("DBPREFIXposts_fts")}, []interface{}{&numTables}); err != nil {
+			return err
+		}
+		for _, statement := range sqliteFTSStatements {
+			if _, err = ExecSQL(statement); err != nil {
+				return err
+			}
+		}
+		if numTables == 0 {
+			// index any posts that were made before the table was created
+			if _, err = ExecSQL(`INSERT INTO DBPREFIXposts_fts(DBPREFIXposts_fts) VALUES('rebuild')`); err != nil {
+				return err
+			}
+		}
+		fullTextSearch = true
+	}
+	return nil
+}
+
+// escapeLike escapes LIKE wildcards in str, using ! as the escape character
+func escapeLike(str string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(str)
+}
+
+// sqliteMatchQuery quotes each word in the query so that FTS5 treats them as strings instead of
+// query syntax
+func sqliteMatchQuery(query string) string {
+	words := strings.Fields(query)
+	for w, word := range words {
+		words[w] = `"` + strings.ReplaceAll(word, `"`, `""`) + `"`
+	}
+	return strings.Join(words, " ")
+}
+
+// queryCondition returns the condition used to match the search query against a post's text fields
+// using the database's native full-text search if it is available
+func (ps *PostSearch) queryCondition() (string, []interface{}) {
+	query := strings.TrimSpace(ps.Query)
+	if fullTextSearch {
+		switch gcdb.driver {
+		case "mysql":
+			if len(query) >= mysqlMinSearchLength {
+				return `MATCH(DBPREFIXposts.subject, DBPREFIXposts.message_raw, DBPREFIXposts.name, DBPREFIXposts.tripcode)
+					AGAINST(? IN NATURAL LANGUAGE MODE)`, []interface{}{query}
+			}
+		case "postgres":
+			return postgresSearchVector + ` @@ plainto_tsquery('simple', ?)`, []interface{}{query}
+		case "sqlite3":
+			return `DBPREFIXposts.id IN (SELECT rowid FROM DBPREFIXposts_fts WHERE DBPREFIXposts_fts MATCH ?)`,
+				[]interface{}{sqliteMatchQuery(query)}
+		}
+	}
+	like := "%" + strings.ToLower(escapeLike(query)) + "%"
+	return `(LOWER(DBPREFIXposts.subject) LIKE ? ESCAPE '!' OR LOWER(DBPREFIXposts.message_raw) LIKE ? ESCAPE '!'
+		OR LOWER(DBPREFIXposts.name) LIKE ? ESCAPE '!' OR LOWER(DBPREFIXposts.tripcode) LIKE ? ESCAPE '!')`,
+		[]interface{}{like, like, like, like}
+}
+
+// Conditions returns the SQL conditions (to be used in a WHERE clause of a query on DBPREFIXposts) and
+// their parameters for the search
+func (ps *PostSearch) Conditions() (string, []interface{}) {
+	var conditions []string
+	var params []interface{}
+	if strings.TrimSpace(ps.Query) != "" {
+		textCondition, textParams := ps.queryCondition()
+		// uploads' original filenames aren't included in the full-text indexes, so they are searched separately.
+		// ORing the two in the outer query would keep the database from using the full-text index
+		conditions = append(conditions, `DBPREFIXposts.id IN (
+			SELECT DBPREFIXposts.id FROM DBPREFIXposts WHERE `+textCondition+`
+			UNION SELECT sf.post_id FROM DBPREFIXfiles sf WHERE LOWER(sf.original_filename) LIKE ? ESCAPE '!')`)
+		params = append(params, textParams...)
+		params = append(params, "%"+strings.ToLower(escapeLike(strings.TrimSpace(ps.Query)))+"%")
+	}
+	if ps.BoardID > 0 {
+		conditions = append(conditions, `DBPREFIXposts.thread_id IN (SELECT id FROM DBPREFIXthreads WHERE board_id = ?)`)
+		params = append(params, ps.BoardID)
+	}
+	if ps.BoardIDs != nil && len(ps.BoardIDs) == 0 {
+		// the staff member isn't assigned to any boards
+		conditions = append(conditions, `1 = 0`)
+	} else if len(ps.BoardIDs) > 0 {
+		boardIDs := make([]interface{}, len(ps.BoardIDs))
+		for b, boardID := range ps.BoardIDs {
+			boardIDs[b] = boardID
+		}
+		conditions = append(conditions, `DBPREFIXposts.thread_id IN (SELECT id FROM DBPREFIXthreads WHERE board_id IN `+
+			createArrayPlaceholder(boardIDs)+`)`)
+		params = append(params, boardIDs...)
+	}
+	if !ps.After.IsZero() {
+		conditions = append(conditions, `DBPREFIXposts.created_on >= ?`)
+		params = append(params, ps.After)
+	}
+	if !ps.Before.IsZero() {
+		conditions = append(conditions, `DBPREFIXposts.created_on < ?`)
+		params = append(params, ps.Before)
+	}
+	if ps.HasFile {
+		conditions = append(conditions, `EXISTS (SELECT 1 FROM DBPREFIXfiles hf WHERE hf.post_id = DBPREFIXposts.id)`)
+	}
+	if ps.OPOnly {
+		conditions = append(conditions, `DBPREFIXposts.is_top_post = TRUE`)
+	}
+	if !ps.IncludeDeleted {
+		conditions = append(conditions, `DBPREFIXposts.is_deleted = FALSE`)
+	}
+	if len(conditions) == 0 {
+		return "1 = 1", nil
+	}
+	return strings.Join(conditions, " AND "), params
+}
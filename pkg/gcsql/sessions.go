@@ -0,0 +1,120 @@
+package gcsql
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+const (
+	// maxUserAgentLength is the length of the user_agent column in DBPREFIXsessions
+	maxUserAgentLength = 255
+)
+
+var (
+	ErrSessionNotFound = errors.New("session not found")
+)
+
+// StaffSession is a login session along with the username of the staff member it belongs to
+type StaffSession struct {
+	LoginSession
+	Username string
+}
+
+// generateCSRFToken returns a new random token to be used for a session's POST requests
+func generateCSRFToken() (string, error) {
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(tokenBytes), nil
+}
+
+// GetStaffSessions returns the unexpired login sessions of the staff member with the given ID, or of all
+// staff members if staffID is 0, newest first
+func GetStaffSessions(staffID int) ([]StaffSession, error) {
+	query := `SELECT sessions.id, sessions.staff_id, sessions.expires, sessions.created_on, sessions.ip,
+	sessions.user_agent, staff.username
+	FROM DBPREFIXsessions AS sessions
+	JOIN DBPREFIXstaff AS staff ON staff.id = sessions.staff_id
+	WHERE sessions.expires > ?`
+	params := []interface{}{time.Now()}
+	if staffID > 0 {
+		query += ` AND sessions.staff_id = ?`
+		params = append(params, staffID)
+	}
+	query += ` ORDER BY sessions.created_on DESC`
+	rows, err := QuerySQL(query, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var sessions []StaffSession
+	for rows.Next() {
+		var session StaffSession
+		if err = rows.Scan(&session.ID, &session.StaffID, &session.Expires, &session.CreatedOn, &session.IP,
+			&session.UserAgent, &session.Username); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// GetSessionID returns the ID of the session with the given key
+func GetSessionID(key string) (int, error) {
+	var id int
+	err := QueryRowSQL(`SELECT id FROM DBPREFIXsessions WHERE data = ?`, interfaceSlice(key), interfaceSlice(&id))
+	return id, err
+}
+
+// DeleteSession ends the login session with the given ID, requiring the staff member to log in again
+func DeleteSession(id int) error {
+	result, err := ExecSQL(`DELETE FROM DBPREFIXsessions WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// deleteExpiredSessions deletes sessions that can no longer be used to log in
+func deleteExpiredSessions() error {
+	_, err := ExecSQL(`DELETE FROM DBPREFIXsessions WHERE expires <= ?`, time.Now())
+	return err
+}
+
+// RecordFailedLogin stores a failed staff login attempt from the given IP address, to be counted by
+// NumFailedLogins
+func RecordFailedLogin(ip string, username string) error {
+	const insertSQL = `INSERT INTO DBPREFIXlogin_attempts(ip, username, attempted_on) VALUES(?,?,?)`
+	_, err := ExecSQL(insertSQL, ip, username, time.Now())
+	return err
+}
+
+// NumFailedLogins returns the number of failed login attempts from the IP address and for the username
+// since the given time
+func NumFailedLogins(ip string, username string, since time.Time) (ipAttempts int, usernameAttempts int, err error) {
+	const query = `SELECT
+	(SELECT COUNT(*) FROM DBPREFIXlogin_attempts WHERE ip = ? AND attempted_on > ?),
+	(SELECT COUNT(*) FROM DBPREFIXlogin_attempts WHERE username = ? AND attempted_on > ?)`
+	err = QueryRowSQL(query,
+		interfaceSlice(ip, since, username, since),
+		interfaceSlice(&ipAttempts, &usernameAttempts))
+	return
+}
+
+// ClearFailedLogins deletes the failed login attempts for the username and IP address after a successful
+// login, as well as any attempts older than the given time, which are no longer counted
+func ClearFailedLogins(ip string, username string, before time.Time) error {
+	const deleteSQL = `DELETE FROM DBPREFIXlogin_attempts WHERE (ip = ? AND username = ?) OR attempted_on <= ?`
+	_, err := ExecSQL(deleteSQL, ip, username, before)
+	return err
+}
@@ -22,23 +22,29 @@ func createDefaultAdminIfNoStaff() error {
 	if count > 0 {
 		return nil
 	}
-	_, err := NewStaff("admin", "password", 3)
+	role, err := GetRoleByName(DefaultRoles[len(DefaultRoles)-1].Name)
+	if err != nil {
+		return err
+	}
+	_, err = NewStaff("admin", "password", role)
 	return err
 }
 
-func NewStaff(username string, password string, rank int) (*Staff, error) {
+// NewStaff creates a new staff account with the given role
+func NewStaff(username string, password string, role *Role) (*Staff, error) {
 	const sqlINSERT = `INSERT INTO DBPREFIXstaff
-	(username, password_checksum, global_rank)
+	(username, password_checksum, role_id)
 	VALUES(?,?,?)`
 	passwordChecksum := gcutil.BcryptSum(password)
-	_, err := ExecSQL(sqlINSERT, username, passwordChecksum, rank)
+	_, err := ExecSQL(sqlINSERT, username, passwordChecksum, role.ID)
 	if err != nil {
 		return nil, err
 	}
 	return &Staff{
 		Username:         username,
 		PasswordChecksum: passwordChecksum,
-		Rank:             rank,
+		RoleID:           role.ID,
+		Role:             role,
 		AddedOn:          time.Now(),
 		IsActive:         true,
 	}, nil
@@ -72,17 +78,6 @@ func (s *Staff) ClearSessions() error {
 	return err
 }
 
-func (s *Staff) RankTitle() string {
-	if s.Rank == 3 {
-		return "Administrator"
-	} else if s.Rank == 2 {
-		return "Moderator"
-	} else if s.Rank == 1 {
-		return "Janitor"
-	}
-	return ""
-}
-
 // EndStaffSession deletes any session rows associated with the requests session cookie and then
 // makes the cookie expire, essentially deleting it
 func EndStaffSession(writer http.ResponseWriter, request *http.Request) error {
@@ -128,45 +123,183 @@ func GetStaffBySession(session string) (*Staff, error) {
 		staff.id, 
 		staff.username, 
 		staff.password_checksum, 
-		staff.global_rank,
+		COALESCE(staff.role_id, 0),
 		staff.added_on,
-		staff.last_login 
+		staff.last_login,
+		staff.totp_secret,
+		staff.totp_enabled,
+		staff.totp_last_step,
+		staff.all_boards,
+		sessions.csrf_token
 	FROM DBPREFIXstaff as staff
 	JOIN DBPREFIXsessions as sessions
 	ON sessions.staff_id = staff.id
-	WHERE sessions.data = ?`
+	WHERE sessions.data = ? AND sessions.expires > ?`
 	staff := new(Staff)
-	err := QueryRowSQL(query, interfaceSlice(session), interfaceSlice(
-		&staff.ID, &staff.Username, &staff.PasswordChecksum, &staff.Rank, &staff.AddedOn, &staff.LastLogin))
-	return staff, err
+	err := QueryRowSQL(query, interfaceSlice(session, time.Now()), interfaceSlice(
+		&staff.ID, &staff.Username, &staff.PasswordChecksum, &staff.RoleID, &staff.AddedOn, &staff.LastLogin,
+		&staff.TOTPSecret, &staff.TOTPEnabled, &staff.TOTPLastStep, &staff.AllBoards, &staff.CSRFToken))
+	if err != nil {
+		return staff, err
+	}
+	return staff, staff.loadRole()
+}
+
+// loadRole gets the staff member's role and its capabilities if they have one
+func (s *Staff) loadRole() error {
+	if s.RoleID == 0 {
+		return nil
+	}
+	var err error
+	s.Role, err = GetRole(s.RoleID)
+	if errors.Is(err, ErrRoleNotFound) {
+		return nil
+	}
+	return err
 }
 
 func GetStaffByUsername(username string, onlyActive bool) (*Staff, error) {
 	query := `SELECT 
-	id, username, password_checksum, global_rank, added_on, last_login, is_active
+	id, username, password_checksum, COALESCE(role_id, 0), added_on, last_login, is_active,
+	totp_secret, totp_enabled, totp_last_step, all_boards
 	FROM DBPREFIXstaff WHERE username = ?`
 	if onlyActive {
 		query += ` AND is_active = TRUE`
 	}
 	staff := new(Staff)
 	err := QueryRowSQL(query, interfaceSlice(username), interfaceSlice(
-		&staff.ID, &staff.Username, &staff.PasswordChecksum, &staff.Rank, &staff.AddedOn,
-		&staff.LastLogin, &staff.IsActive,
+		&staff.ID, &staff.Username, &staff.PasswordChecksum, &staff.RoleID, &staff.AddedOn,
+		&staff.LastLogin, &staff.IsActive, &staff.TOTPSecret, &staff.TOTPEnabled, &staff.TOTPLastStep,
+		&staff.AllBoards,
 	))
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, ErrUnrecognizedUsername
+	} else if err != nil {
+		return staff, err
 	}
-	return staff, err
+	return staff, staff.loadRole()
 }
 
-// CreateLoginSession inserts a session for a given key and username into the database
-func (staff *Staff) CreateLoginSession(key string) error {
-	const insertSQL = `INSERT INTO DBPREFIXsessions (staff_id,data,expires) VALUES(?,?,?)`
+// CreateLoginSession inserts a session for a given key into the database, along with the IP address and
+// user agent of the browser it was created from so that it can be recognized on the sessions page. The
+// session expires after maxAge
+func (staff *Staff) CreateLoginSession(key string, ip string, userAgent string, maxAge time.Duration) error {
+	const insertSQL = `INSERT INTO DBPREFIXsessions
+	(staff_id, data, expires, created_on, ip, user_agent, csrf_token)
+	VALUES(?,?,?,?,?,?,?)`
 	const updateSQL = `UPDATE DBPREFIXstaff SET last_login = CURRENT_TIMESTAMP WHERE id = ?`
-	_, err := ExecSQL(insertSQL, staff.ID, key, time.Now().Add(time.Duration(time.Hour*730))) //TODO move amount of time to config file
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+	csrfToken, err := generateCSRFToken()
 	if err != nil {
 		return err
 	}
+	if err = deleteExpiredSessions(); err != nil {
+		return err
+	}
+	now := time.Now()
+	if _, err = ExecSQL(insertSQL, staff.ID, key, now.Add(maxAge), now, ip, userAgent, csrfToken); err != nil {
+		return err
+	}
+	staff.CSRFToken = csrfToken
 	_, err = ExecSQL(updateSQL, staff.ID)
 	return err
 }
+
+// GetAllBoardStaff returns the IDs of the boards assigned to each staff member that has any, mapped
+// to their staff IDs
+func GetAllBoardStaff() (map[int][]int, error) {
+	const query = `SELECT staff_id, board_id FROM DBPREFIXboard_staff ORDER BY board_id`
+	rows, err := QuerySQL(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	boardStaff := make(map[int][]int)
+	for rows.Next() {
+		var staffID, boardID int
+		if err = rows.Scan(&staffID, &boardID); err != nil {
+			return nil, err
+		}
+		boardStaff[staffID] = append(boardStaff[staffID], boardID)
+	}
+	return boardStaff, rows.Err()
+}
+
+// GetBoardIDs returns the IDs of the boards the staff member is assigned to
+func (s *Staff) GetBoardIDs() ([]int, error) {
+	const query = `SELECT board_id FROM DBPREFIXboard_staff WHERE staff_id = ? ORDER BY board_id`
+	rows, err := QuerySQL(query, s.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var boardIDs []int
+	for rows.Next() {
+		var boardID int
+		if err = rows.Scan(&boardID); err != nil {
+			return nil, err
+		}
+		boardIDs = append(boardIDs, boardID)
+	}
+	return boardIDs, rows.Err()
+}
+
+// SetBoards replaces the staff member's board assignments and sets whether they can moderate every board.
+// If allBoards is false, the staff member can only moderate the boards in boardIDs, or none if it is empty
+func (s *Staff) SetBoards(boardIDs []int, allBoards bool) error {
+	tx, err := BeginTx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err = ExecTxSQL(tx, `UPDATE DBPREFIXstaff SET all_boards = ? WHERE id = ?`, allBoards, s.ID); err != nil {
+		return err
+	}
+	if _, err = ExecTxSQL(tx, `DELETE FROM DBPREFIXboard_staff WHERE staff_id = ?`, s.ID); err != nil {
+		return err
+	}
+	const insertSQL = `INSERT INTO DBPREFIXboard_staff(board_id, staff_id) VALUES(?,?)`
+	for _, boardID := range boardIDs {
+		if _, err = ExecTxSQL(tx, insertSQL, boardID, s.ID); err != nil {
+			return err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	s.AllBoards = allBoards
+	return nil
+}
+
+// ModeratedBoardIDs returns the IDs of the only boards the staff member can moderate, or nil if they can
+// moderate every board. Staff who can manage staff accounts (and could give themselves access to every
+// board) are not limited. Staff who are limited but not assigned to any boards get an empty, non-nil slice
+func (s *Staff) ModeratedBoardIDs() ([]int, error) {
+	if s.AllBoards || s.Can(CapStaffManage) {
+		return nil, nil
+	}
+	boardIDs, err := s.GetBoardIDs()
+	if err != nil {
+		return nil, err
+	}
+	if boardIDs == nil {
+		boardIDs = []int{}
+	}
+	return boardIDs, nil
+}
+
+// CanModerateBoard returns true if the staff member is allowed to moderate the board with the given ID
+func (s *Staff) CanModerateBoard(boardID int) (bool, error) {
+	boardIDs, err := s.ModeratedBoardIDs()
+	if err != nil || boardIDs == nil {
+		return err == nil, err
+	}
+	for _, id := range boardIDs {
+		if id == boardID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,45 @@
+package gcsql
+
+import "testing"
+
+func TestModeratedBoardIDs(t *testing.T) {
+	initTestDB(t)
+	board := createTestBoard(t, "test")
+	otherBoard := createTestBoard(t, "other")
+	mod := createTestStaff(t, "moderator", 1)
+
+	// staff without any assignments can't moderate anything
+	boardIDs, err := mod.ModeratedBoardIDs()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if boardIDs == nil || len(boardIDs) > 0 {
+		t.Fatalf("expected an empty, non-nil slice for staff without boards, got %#v", boardIDs)
+	}
+	if canModerate, err := mod.CanModerateBoard(board.ID); err != nil || canModerate {
+		t.Fatal("expected staff without boards to not be able to moderate any board")
+	}
+
+	if err = mod.SetBoards([]int{board.ID}, false); err != nil {
+		t.Fatal(err.Error())
+	}
+	if canModerate, err := mod.CanModerateBoard(board.ID); err != nil || !canModerate {
+		t.Fatal("expected staff to be able to moderate their assigned board")
+	}
+	if canModerate, err := mod.CanModerateBoard(otherBoard.ID); err != nil || canModerate {
+		t.Fatal("expected staff to not be able to moderate a board they aren't assigned to")
+	}
+
+	if err = mod.SetBoards(nil, true); err != nil {
+		t.Fatal(err.Error())
+	}
+	if boardIDs, err = mod.ModeratedBoardIDs(); err != nil || boardIDs != nil {
+		t.Fatal("expected staff with all_boards set to not be limited")
+	}
+
+	// administrators can manage staff, so they can moderate every board without being assigned to any
+	admin := createTestStaff(t, "admin", 2)
+	if boardIDs, err = admin.ModeratedBoardIDs(); err != nil || boardIDs != nil {
+		t.Fatal("expected administrators to not be limited")
+	}
+}
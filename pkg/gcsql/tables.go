@@ -147,6 +147,21 @@ type IPBanAppealAudit struct {
 	ipBanAppealBase
 }
 
+// BanPreset is a set of ban settings that can be selected when banning instead of entering them by hand.
+// table: DBPREFIXban_presets
+type BanPreset struct {
+	ID           int    // sql: `id`
+	Name         string // sql: `name`
+	Duration     string // sql: `duration`
+	Permanent    bool   // sql: `permanent`
+	Message      string // sql: `message`
+	IsThreadBan  bool   // sql: `is_thread_ban`
+	CanAppeal    bool   // sql: `can_appeal`
+	AppealWait   string // sql: `appeal_wait`
+	IsGlobal     bool   // sql: `is_global`
+	EscalateToID *int   // sql: `escalate_to_id`
+}
+
 // table: DBPREFIXposts
 type Post struct {
 	ID              int           // sql: `id`
@@ -174,11 +189,21 @@ type Report struct {
 	ID               int    // sql: `id`
 	HandledByStaffID int    // sql: `handled_by_staff_id`
 	PostID           int    // sql: `post_id`
+	CategoryID       int    // sql: `category_id`
 	IP               string // sql: `ip`
 	Reason           string // sql: `reason`
 	IsCleared        bool   // sql: `is_cleared`
 }
 
+// table: DBPREFIXreport_categories
+type ReportCategory struct {
+	ID          int    // sql: `id`
+	BoardID     *int   // sql: `board_id`
+	Name        string // sql: `name`
+	Description string // sql: `description`
+	Position    int    // sql: `position`
+}
+
 // table: DBPREFIXreports_audit
 type ReportAudit struct {
 	Report           int       // sql: `report_id`
@@ -198,10 +223,28 @@ type Section struct {
 
 // table: DBPREFIXsessions
 type LoginSession struct {
-	ID      int       // sql: `id`
-	StaffID int       // sql: `staff_id`
-	Expires time.Time // sql: `expires`
-	Data    string    // sql: `data`
+	ID        int       // sql: `id`
+	StaffID   int       // sql: `staff_id`
+	Expires   time.Time // sql: `expires`
+	Data      string    `json:"-"` // sql: `data`
+	CreatedOn time.Time // sql: `created_on`
+	IP        string    // sql: `ip`
+	UserAgent string    // sql: `user_agent`
+	CSRFToken string    `json:"-"` // sql: `csrf_token`
+}
+
+// table: DBPREFIXstaff_actions
+type ModLogEntry struct {
+	ID            int       // sql: `id`
+	StaffID       int       // sql: `staff_id`
+	StaffUsername string    // sql: `staff_username`
+	Action        string    // sql: `action`
+	TargetType    string    // sql: `target_type`
+	TargetID      int       // sql: `target_id`
+	BoardID       *int      // sql: `board_id`
+	Before        string    // sql: `before_details`
+	After         string    // sql: `after_details`
+	PerformedAt   time.Time // sql: `performed_at`
 }
 
 // DBPREFIXstaff
@@ -209,23 +252,35 @@ type Staff struct {
 	ID               int       // sql: `id`
 	Username         string    // sql: `username`
 	PasswordChecksum string    `json:"-"` // sql: `password_checksum`
-	Rank             int       // sql: `global_rank`
+	RoleID           int       // sql: `role_id`
+	Role             *Role     `json:",omitempty"`
 	AddedOn          time.Time `json:"-"` // sql: `added_on`
 	LastLogin        time.Time `json:"-"` // sql: `last_login`
 	IsActive         bool      `json:"-"` // sql: `is_active`
+	TOTPSecret       string    `json:"-"` // sql: `totp_secret`
+	TOTPEnabled      bool      // sql: `totp_enabled`
+	TOTPLastStep     int64     `json:"-"` // sql: `totp_last_step`
+	// AllBoards is true if the staff member can moderate every board instead of only the boards they are
+	// assigned to
+	AllBoards bool // sql: `all_boards`
+	// CSRFToken is the token of the session the staff member was loaded from, which must be included
+	// in POST requests to manage pages
+	CSRFToken string `json:",omitempty"`
 }
 
 // table: DBPREFIXthreads
 type Thread struct {
-	ID        int       // sql: `id`
-	BoardID   int       // sql: `board_id`
-	Locked    bool      // sql: `locked`
-	Stickied  bool      // sql: `stickied`
-	Anchored  bool      // sql: `anchored`
-	Cyclical  bool      // sql: `cyclical`
-	LastBump  time.Time // sql: `last_bump`
-	DeletedAt time.Time // sql: `deleted_at`
-	IsDeleted bool      // sql: `is_deleted`
+	ID         int       // sql: `id`
+	BoardID    int       // sql: `board_id`
+	Locked     bool      // sql: `locked`
+	Stickied   bool      // sql: `stickied`
+	Anchored   bool      // sql: `anchored`
+	Cyclical   bool      // sql: `cyclical`
+	LastBump   time.Time // sql: `last_bump`
+	DeletedAt  time.Time // sql: `deleted_at`
+	IsDeleted  bool      // sql: `is_deleted`
+	ArchivedAt time.Time // sql: `archived_at`
+	IsArchived bool      // sql: `is_archived`
 }
 
 // table: DBPREFIXusername_ban
@@ -234,6 +289,22 @@ type UsernameBan struct {
 	Username string // sql: `username`
 }
 
+// Warning is a message from staff about a post that the poster has to acknowledge before they can post again.
+// table: DBPREFIXwarnings
+type Warning struct {
+	ID             int           // sql: `id`
+	StaffID        int           // sql: `staff_id`
+	BoardID        *int          // sql: `board_id`
+	PostID         *int          // sql: `post_id`
+	CopyPostText   template.HTML // sql: `copy_post_text`
+	IP             string        // sql: `ip`
+	Message        string        // sql: `message`
+	StaffNote      string        // sql: `staff_note`
+	IssuedAt       time.Time     // sql: `issued_at`
+	IsAcknowledged bool          // sql: `is_acknowledged`
+	AcknowledgedAt time.Time     // sql: `acknowledged_at`
+}
+
 // table DBPREFIXwordfilters
 type Wordfilter struct {
 	ID        int       `json:"id"`         // sql: `id`
@@ -9,7 +9,7 @@ import (
 
 const (
 	selectThreadsBaseSQL = `SELECT
-	id, board_id, locked, stickied, anchored, cyclical, last_bump, deleted_at, is_deleted
+	id, board_id, locked, stickied, anchored, cyclical, last_bump, deleted_at, is_deleted, archived_at, is_archived
 	FROM DBPREFIXthreads `
 )
 
@@ -43,6 +43,7 @@ func GetThread(threadID int) (*Thread, error) {
 	err := QueryRowSQL(query, interfaceSlice(threadID), interfaceSlice(
 		&thread.ID, &thread.BoardID, &thread.Locked, &thread.Stickied, &thread.Anchored, &thread.Cyclical,
 		&thread.LastBump, &thread.DeletedAt, &thread.IsDeleted,
+		&thread.ArchivedAt, &thread.IsArchived,
 	))
 	return thread, err
 }
@@ -54,6 +55,7 @@ func GetPostThread(opID int) (*Thread, error) {
 	err := QueryRowSQL(query, interfaceSlice(opID), interfaceSlice(
 		&thread.ID, &thread.BoardID, &thread.Locked, &thread.Stickied, &thread.Anchored, &thread.Cyclical,
 		&thread.LastBump, &thread.DeletedAt, &thread.IsDeleted,
+		&thread.ArchivedAt, &thread.IsArchived,
 	))
 	if errors.Is(err, sql.ErrNoRows) {
 		err = ErrThreadDoesNotExist
@@ -73,12 +75,12 @@ func GetTopPostThreadID(opID int) (int, error) {
 }
 
 // GetThreadsWithBoardID queries the database for the threads with the given board ID from the database.
-// If onlyNotDeleted is true, it omits deleted threads and threads that were removed because the max
-// thread limit was reached
+// If onlyNotDeleted is true, it omits deleted threads and threads that were removed or archived because
+// the max thread limit was reached
 func GetThreadsWithBoardID(boardID int, onlyNotDeleted bool) ([]Thread, error) {
 	query := selectThreadsBaseSQL + `WHERE board_id = ?`
 	if onlyNotDeleted {
-		query += " AND  is_deleted = FALSE"
+		query += " AND is_deleted = FALSE AND is_archived = FALSE"
 	}
 	rows, err := QuerySQL(query, boardID)
 	if err != nil {
@@ -91,6 +93,7 @@ func GetThreadsWithBoardID(boardID int, onlyNotDeleted bool) ([]Thread, error) {
 		if err = rows.Scan(
 			&thread.ID, &thread.BoardID, &thread.Locked, &thread.Stickied, &thread.Anchored,
 			&thread.Cyclical, &thread.LastBump, &thread.DeletedAt, &thread.IsDeleted,
+			&thread.ArchivedAt, &thread.IsArchived,
 		); err != nil {
 			return threads, err
 		}
@@ -112,8 +115,10 @@ func ChangeThreadBoardID(threadID int, newBoardID int) error {
 	if !DoesBoardExistByID(newBoardID) {
 		return ErrBoardDoesNotExist
 	}
-	_, err := ExecSQL(`UPDATE DBPREFIXthreads SET board_id = ? WHERE id = ?`, newBoardID, threadID)
-	return err
+	if _, err := ExecSQL(`UPDATE DBPREFIXthreads SET board_id = ? WHERE id = ?`, newBoardID, threadID); err != nil {
+		return err
+	}
+	return touchThreadsTx(nil, threadID)
 }
 
 // ChangeThreadBoardByURI updates a thread's board ID, given the thread's post ID and
@@ -221,13 +226,24 @@ func (t *Thread) UpdateAttribute(attribute string, value bool) error {
 		return fmt.Errorf("invalid thread attribute %q", attribute)
 	}
 	updateSQL += attribute + " = ? WHERE id = ?"
-	_, err := ExecSQL(updateSQL, value, t.ID)
+	if _, err := ExecSQL(updateSQL, value, t.ID); err != nil {
+		return err
+	}
+	return touchThreadsTx(nil, t.ID)
+}
+
+// touchThreadsTx updates the last_modified timestamp of the threads' top posts, so that the threads' changes are
+// seen by API clients checking if the thread or board was modified
+func touchThreadsTx(tx *sql.Tx, threadIDs ...interface{}) error {
+	_, err := ExecTxSQL(tx, `UPDATE DBPREFIXposts SET last_modified = CURRENT_TIMESTAMP
+		WHERE is_top_post = TRUE AND thread_id IN `+createArrayPlaceholder(threadIDs), threadIDs...)
 	return err
 }
 
 // deleteThread updates the thread and sets it as deleted, as well as the posts where thread_id = threadID
 func deleteThread(threadID int) error {
-	const deletePostsSQL = `UPDATE DBPREFIXposts SET is_deleted = TRUE, deleted_at = CURRENT_TIMESTAMP WHERE thread_id = ?`
+	const deletePostsSQL = `UPDATE DBPREFIXposts SET is_deleted = TRUE, deleted_at = CURRENT_TIMESTAMP,
+	last_modified = CURRENT_TIMESTAMP WHERE thread_id = ?`
 	const deleteThreadSQL = `UPDATE DBPREFIXthreads SET is_deleted = TRUE, deleted_at = CURRENT_TIMESTAMP WHERE id = ?`
 	_, err := ExecSQL(deletePostsSQL, threadID)
 	if err != nil {
@@ -0,0 +1,210 @@
+package gcsql
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gochan-org/gochan/pkg/gcutil"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// NumRecoveryCodes is the number of single-use recovery codes generated when two-factor authentication
+	// is enabled
+	NumRecoveryCodes = 10
+)
+
+var (
+	ErrInvalidTOTPCode      = errors.New("invalid two-factor authentication code")
+	ErrTOTPNotEnrolling     = errors.New("two-factor authentication setup hasn't been started")
+	ErrTOTPAlreadyEnabled   = errors.New("two-factor authentication is already enabled")
+	ErrTOTPNotEnabled       = errors.New("two-factor authentication is not enabled")
+	ErrTOTPRequiredByRole   = errors.New("your role requires two-factor authentication")
+	errRecoveryCodeMismatch = errors.New("recovery code doesn't match")
+)
+
+// NeedsTOTPEnrollment returns true if the staff member's role requires two-factor authentication and they
+// haven't set it up yet
+func (s *Staff) NeedsTOTPEnrollment() bool {
+	return s.Role != nil && s.Role.RequireTOTP && !s.TOTPEnabled
+}
+
+// StartTOTPEnrollment generates a new two-factor authentication secret for the staff member, which is
+// used once EnableTOTP is called with a valid code generated from it
+func (s *Staff) StartTOTPEnrollment() (string, error) {
+	if s.TOTPEnabled {
+		return "", ErrTOTPAlreadyEnabled
+	}
+	secret, err := gcutil.GenerateTOTPSecret()
+	if err != nil {
+		return "", err
+	}
+	const updateSQL = `UPDATE DBPREFIXstaff SET totp_secret = ?, totp_enabled = FALSE WHERE id = ?`
+	if _, err = ExecSQL(updateSQL, secret, s.ID); err != nil {
+		return "", err
+	}
+	s.TOTPSecret = secret
+	return secret, nil
+}
+
+// EnableTOTP finishes two-factor authentication setup if the code was generated from the secret created
+// by StartTOTPEnrollment, and returns new recovery codes that can be used if the staff member loses access
+// to their authenticator. The recovery codes are only stored as checksums, so they can't be shown again
+func (s *Staff) EnableTOTP(code string) ([]string, error) {
+	if s.TOTPEnabled {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+	if s.TOTPSecret == "" {
+		return nil, ErrTOTPNotEnrolling
+	}
+	valid, step, err := gcutil.ValidateTOTP(s.TOTPSecret, code, time.Now(), 0)
+	if err != nil {
+		return nil, err
+	}
+	if !valid {
+		return nil, ErrInvalidTOTPCode
+	}
+	recoveryCodes := make([]string, NumRecoveryCodes)
+	for c := range recoveryCodes {
+		if recoveryCodes[c], err = gcutil.GenerateRecoveryCode(); err != nil {
+			return nil, err
+		}
+	}
+
+	tx, err := BeginTx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	const updateSQL = `UPDATE DBPREFIXstaff SET totp_enabled = TRUE, totp_last_step = ? WHERE id = ?`
+	if _, err = ExecTxSQL(tx, updateSQL, step, s.ID); err != nil {
+		return nil, err
+	}
+	if _, err = ExecTxSQL(tx, `DELETE FROM DBPREFIXstaff_recovery_codes WHERE staff_id = ?`, s.ID); err != nil {
+		return nil, err
+	}
+	const insertSQL = `INSERT INTO DBPREFIXstaff_recovery_codes(staff_id, code_checksum) VALUES(?,?)`
+	for _, recoveryCode := range recoveryCodes {
+		if _, err = ExecTxSQL(tx, insertSQL, s.ID, gcutil.BcryptSum(gcutil.NormalizeRecoveryCode(recoveryCode))); err != nil {
+			return nil, err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	s.TOTPEnabled = true
+	s.TOTPLastStep = step
+	return recoveryCodes, nil
+}
+
+// DisableTOTP turns off two-factor authentication for the staff member and deletes their recovery codes
+func (s *Staff) DisableTOTP() error {
+	tx, err := BeginTx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	const updateSQL = `UPDATE DBPREFIXstaff SET totp_secret = '', totp_enabled = FALSE, totp_last_step = 0 WHERE id = ?`
+	if _, err = ExecTxSQL(tx, updateSQL, s.ID); err != nil {
+		return err
+	}
+	if _, err = ExecTxSQL(tx, `DELETE FROM DBPREFIXstaff_recovery_codes WHERE staff_id = ?`, s.ID); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	s.TOTPSecret = ""
+	s.TOTPEnabled = false
+	s.TOTPLastStep = 0
+	return nil
+}
+
+// VerifyTOTP returns nil if the code is a valid two-factor authentication code that hasn't been used yet,
+// or one of the staff member's recovery codes, which is deleted so that it can't be used again
+func (s *Staff) VerifyTOTP(code string) error {
+	if !s.TOTPEnabled {
+		return ErrTOTPNotEnabled
+	}
+	valid, step, err := gcutil.ValidateTOTP(s.TOTPSecret, code, time.Now(), s.TOTPLastStep)
+	if err != nil {
+		return err
+	}
+	if valid {
+		// store the step so that the code can't be reused by someone who saw it. The step is only updated if
+		// it is newer than the stored one so that two logins racing with the same code can't both succeed
+		result, err := ExecSQL(`UPDATE DBPREFIXstaff SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?`,
+			step, s.ID, step)
+		if err != nil {
+			return err
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rowsAffected != 1 {
+			return ErrInvalidTOTPCode
+		}
+		s.TOTPLastStep = step
+		return nil
+	}
+	err = s.useRecoveryCode(code)
+	if errors.Is(err, errRecoveryCodeMismatch) {
+		return ErrInvalidTOTPCode
+	}
+	return err
+}
+
+// useRecoveryCode deletes the recovery code if it matches one of the staff member's unused codes, or
+// returns errRecoveryCodeMismatch if it doesn't
+func (s *Staff) useRecoveryCode(code string) error {
+	code = gcutil.NormalizeRecoveryCode(code)
+	if code == "" {
+		return errRecoveryCodeMismatch
+	}
+	rows, err := QuerySQL(`SELECT id, code_checksum FROM DBPREFIXstaff_recovery_codes WHERE staff_id = ?`, s.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	matchedID := 0
+	for rows.Next() {
+		var id int
+		var checksum string
+		if err = rows.Scan(&id, &checksum); err != nil {
+			return err
+		}
+		if bcrypt.CompareHashAndPassword([]byte(checksum), []byte(code)) == nil {
+			matchedID = id
+			break
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+	if matchedID == 0 {
+		return errRecoveryCodeMismatch
+	}
+	result, err := ExecSQL(`DELETE FROM DBPREFIXstaff_recovery_codes WHERE id = ?`, matchedID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected != 1 {
+		// the code was used by another login between the SELECT and the DELETE
+		return errRecoveryCodeMismatch
+	}
+	return nil
+}
+
+// NumRecoveryCodesLeft returns the number of unused recovery codes the staff member has
+func (s *Staff) NumRecoveryCodesLeft() (int, error) {
+	var count int
+	err := QueryRowSQL(`SELECT COUNT(*) FROM DBPREFIXstaff_recovery_codes WHERE staff_id = ?`,
+		interfaceSlice(s.ID), interfaceSlice(&count))
+	return count, err
+}
@@ -42,6 +42,32 @@ func GetThreadFiles(post *Post) ([]Upload, error) {
 	return uploads, nil
 }
 
+// GetPostsUploads takes a variable number of post IDs and returns a map[postID][]Upload of the files attached
+// to the posts, ordered by their file_order value
+func GetPostsUploads(postIDs ...interface{}) (map[int][]Upload, error) {
+	uploads := make(map[int][]Upload)
+	if postIDs == nil {
+		return uploads, nil
+	}
+	query := selectFilesBaseSQL + `WHERE post_id IN ` + createArrayPlaceholder(postIDs) + ` ORDER BY post_id ASC, file_order ASC`
+	rows, err := QuerySQL(query, postIDs...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var upload Upload
+		if err = rows.Scan(
+			&upload.ID, &upload.PostID, &upload.FileOrder, &upload.OriginalFilename, &upload.Filename, &upload.Checksum,
+			&upload.FileSize, &upload.IsSpoilered, &upload.ThumbnailWidth, &upload.ThumbnailHeight, &upload.Width, &upload.Height,
+		); err != nil {
+			return uploads, err
+		}
+		uploads[upload.PostID] = append(uploads[upload.PostID], upload)
+	}
+	return uploads, nil
+}
+
 func (p *Post) nextFileOrder() (int, error) {
 	const query = `SELECT COALESCE(MAX(file_order) + 1, 0) FROM DBPREFIXfiles WHERE post_id = ?`
 	var next int
@@ -53,13 +79,12 @@ func (p *Post) AttachFile(upload *Upload) error {
 	if upload == nil {
 		return nil // no upload to attach, so no error
 	}
-	_, recovered := events.TriggerEvent("incoming-upload", upload)
-	if recovered {
-		gcutil.LogWarning().Caller().
+	if _, err := events.TriggerEvent("incoming-upload", upload); err != nil {
+		gcutil.LogWarning().Err(err).Caller().
 			Str("triggeredEvent", "incoming-upload").
 			Str("originalFilename", upload.OriginalFilename).
 			Str("filename", upload.Filename).
-			Msg("Recovered from a panic in event handler")
+			Msg("Error in event handler")
 	}
 
 	const query = `INSERT INTO DBPREFIXfiles (
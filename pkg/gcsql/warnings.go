@@ -0,0 +1,146 @@
+package gcsql
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	selectWarningsBaseSQL = `SELECT w.id, w.staff_id, w.board_id, w.post_id, w.copy_post_text, w.ip, w.message,
+	w.staff_note, w.issued_at, w.is_acknowledged, w.acknowledged_at, COALESCE(staff.username, ''),
+	COALESCE(boards.dir, '')
+	FROM DBPREFIXwarnings AS w
+	LEFT JOIN DBPREFIXstaff AS staff ON staff.id = w.staff_id
+	LEFT JOIN DBPREFIXboards AS boards ON boards.id = w.board_id `
+)
+
+var (
+	ErrEmptyWarningMessage = errors.New("warning message must not be empty")
+	ErrWarningNotFound     = errors.New("warning not found")
+)
+
+// WarningListing is a warning along with the username of the staff member who issued it and the directory
+// of the board it was issued on, if any
+type WarningListing struct {
+	Warning
+	StaffUsername string
+	BoardDir      string
+}
+
+func getWarnings(where string, params ...interface{}) ([]WarningListing, error) {
+	rows, err := QuerySQL(selectWarningsBaseSQL+where, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var warnings []WarningListing
+	for rows.Next() {
+		var warning WarningListing
+		if err = rows.Scan(&warning.ID, &warning.StaffID, &warning.BoardID, &warning.PostID, &warning.CopyPostText,
+			&warning.IP, &warning.Message, &warning.StaffNote, &warning.IssuedAt, &warning.IsAcknowledged,
+			&warning.AcknowledgedAt, &warning.StaffUsername, &warning.BoardDir,
+		); err != nil {
+			return nil, err
+		}
+		warnings = append(warnings, warning)
+	}
+	return warnings, rows.Err()
+}
+
+// NewWarning inserts the warning into the database and sets its ID and issue time
+func NewWarning(warning *Warning) error {
+	const insertSQL = `INSERT INTO DBPREFIXwarnings
+	(staff_id, board_id, post_id, copy_post_text, ip, message, staff_note, issued_at, is_acknowledged)
+	VALUES(?,?,?,?,?,?,?,?,FALSE)`
+	warning.Message = strings.TrimSpace(warning.Message)
+	if warning.Message == "" {
+		return ErrEmptyWarningMessage
+	}
+	warning.IssuedAt = time.Now()
+	tx, err := BeginTx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err = ExecTxSQL(tx, insertSQL, warning.StaffID, warning.BoardID, warning.PostID, warning.CopyPostText,
+		warning.IP, warning.Message, warning.StaffNote, warning.IssuedAt); err != nil {
+		return err
+	}
+	if warning.ID, err = getLatestID("DBPREFIXwarnings", tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetWarning returns the warning with the given ID
+func GetWarning(id int) (*WarningListing, error) {
+	warnings, err := getWarnings(`WHERE w.id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(warnings) == 0 {
+		return nil, ErrWarningNotFound
+	}
+	return &warnings[0], nil
+}
+
+// GetUnacknowledgedWarning returns the oldest warning given to the IP address that hasn't been acknowledged
+// yet. If the pointer is nil, the IP has no warnings waiting to be acknowledged
+func GetUnacknowledgedWarning(ip string) (*WarningListing, error) {
+	warnings, err := getWarnings(`WHERE w.ip = ? AND NOT w.is_acknowledged ORDER BY w.id ASC LIMIT 1`, ip)
+	if err != nil || len(warnings) == 0 {
+		return nil, err
+	}
+	return &warnings[0], nil
+}
+
+// GetWarningsByIP returns the warnings given to the IP address, newest first
+func GetWarningsByIP(ip string) ([]WarningListing, error) {
+	return getWarnings(`WHERE w.ip = ? ORDER BY w.id DESC`, ip)
+}
+
+// GetRecentWarnings returns up to limit of the most recently issued warnings. If boardIDs is not nil, only
+// warnings issued on those boards are returned
+func GetRecentWarnings(boardIDs []int, limit int) ([]WarningListing, error) {
+	var where string
+	var params []interface{}
+	if boardIDs != nil {
+		if len(boardIDs) == 0 {
+			return nil, nil
+		}
+		for _, id := range boardIDs {
+			params = append(params, id)
+		}
+		where = `WHERE w.board_id IN ` + createArrayPlaceholder(params) + ` `
+	}
+	params = append(params, limit)
+	return getWarnings(where+`ORDER BY w.id DESC LIMIT ?`, params...)
+}
+
+// Acknowledge marks the warning as acknowledged by the poster so that they can post again
+func (w *Warning) Acknowledge() error {
+	w.AcknowledgedAt = time.Now()
+	if _, err := ExecSQL(`UPDATE DBPREFIXwarnings SET is_acknowledged = TRUE, acknowledged_at = ? WHERE id = ?`,
+		w.AcknowledgedAt, w.ID); err != nil {
+		return err
+	}
+	w.IsAcknowledged = true
+	return nil
+}
+
+// DeleteWarning deletes the warning with the given ID
+func DeleteWarning(id int) error {
+	result, err := ExecSQL(`DELETE FROM DBPREFIXwarnings WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrWarningNotFound
+	}
+	return nil
+}
@@ -253,14 +253,8 @@ var funcMap = template.FuncMap{
 		return loopArr
 	},
 	"generateConfigTable": func() template.HTML {
-		siteCfg := config.GetSiteConfig()
-		boardCfg := config.GetBoardConfig("")
-		tableOut := `<table style="border-collapse: collapse;" id="config"><tr><th>Field name</th><th>Value</th><th>Type</th><th>Description</th></tr>`
-
-		tableOut += configTable(siteCfg) +
-			configTable(boardCfg) +
-			"</table>"
-		return template.HTML(tableOut)
+		return template.HTML(`<table style="border-collapse: collapse;" id="config"><tr><th>Field name</th><th>Value</th><th>Type</th><th>Description</th></tr>` +
+			configTable(config.EditableFields()) + "</table>")
 	},
 	"isStyleDefault": func(style string) bool {
 		return style == config.GetBoardConfig("").DefaultStyle
@@ -270,60 +264,31 @@ var funcMap = template.FuncMap{
 	},
 }
 
-func configTable(cfg interface{}) string {
-	cVal := reflect.ValueOf(cfg)
-	if cVal.Kind() == reflect.Ptr {
-		cVal = cVal.Elem()
-	}
+// configTable returns the rows of the config editor table, with an input for each field
+func configTable(fields []config.EditableField) string {
 	var tableOut string
-	if cVal.Kind() != reflect.Struct {
-		return ""
-	}
-	cType := cVal.Type()
-	numFields := cVal.NumField()
-
-	for f := 0; f < numFields; f++ {
-		field := cType.Field(f)
-		name := field.Name
-
-		fVal := reflect.Indirect(cVal).FieldByName(name)
-		fKind := fVal.Kind()
-		// interf := cVal.Field(f).Interface()
-		switch fKind {
+	for _, field := range fields {
+		tableOut += "<tr><td>" + field.Name + "</td><td>"
+		switch field.Kind {
 		case reflect.Int:
-			tableOut += `<input name="` + name + `" type="number" value="` + html.EscapeString(fmt.Sprintf("%v", f)) + `" class="config-text"/>`
+			tableOut += `<input name="` + field.Name + `" type="number" value="` + fmt.Sprintf("%d", field.Value) + `" class="config-text"/>`
 		case reflect.String:
-			tableOut += `<input name="` + name + `" type="text" value="` + html.EscapeString(fmt.Sprintf("%v", f)) + `" class="config-text"/>`
+			tableOut += `<input name="` + field.Name + `" type="text" value="` + html.EscapeString(field.Value.(string)) + `" class="config-text"/>`
 		case reflect.Bool:
 			checked := ""
-			if fVal.Bool() {
+			if field.Value.(bool) {
 				checked = "checked"
 			}
-			tableOut += `<input name="` + name + `" type="checkbox" ` + checked + " />"
-
+			tableOut += `<input name="` + field.Name + `" type="checkbox" ` + checked + " />"
 		case reflect.Slice:
-			tableOut += `<textarea name="` + name + `" rows="4" cols="28">`
-			arrLength := fVal.Len()
-			for s := 0; s < arrLength; s++ {
-				newLine := "\n"
-				if s == arrLength-1 {
-					newLine = ""
-				}
-				tableOut += html.EscapeString(fVal.Slice(s, s+1).Index(0).String()) + newLine
-			}
-			tableOut += "</textarea>"
-		default:
-			tableOut += fmt.Sprintf("%v", fKind)
+			tableOut += `<textarea name="` + field.Name + `" rows="4" cols="28">` +
+				html.EscapeString(strings.Join(field.Value.([]string), "\n")) + "</textarea>"
 		}
-
-		tableOut += "</td><td>" + fKind.String() + "</td><td>"
-		defaultTag := field.Tag.Get("default")
-		var defaultTagHTML string
-		if defaultTag != "" {
-			defaultTagHTML = " <b>Default: " + defaultTag + "</b>"
+		tableOut += "</td><td>" + field.Kind.String() + "</td><td>" + field.Description
+		if field.Default != "" {
+			tableOut += " <b>Default: " + html.EscapeString(field.Default) + "</b>"
 		}
-		tableOut += field.Tag.Get("description") + defaultTagHTML + "</td>"
-		tableOut += "</tr>"
+		tableOut += "</td></tr>"
 	}
 	return tableOut
 }
@@ -1,45 +1,86 @@
 package gctemplates
 
 import (
+	"errors"
 	"fmt"
 	"html/template"
+	"io"
 	"os"
 	"path"
+	"strings"
+	"sync/atomic"
 
 	"github.com/gochan-org/gochan/pkg/config"
 	"github.com/gochan-org/gochan/pkg/gcsql"
 )
 
 var (
-	Banpage           *template.Template
-	Captcha           *template.Template
-	Catalog           *template.Template
-	ErrorPage         *template.Template
-	FrontPage         *template.Template
-	BoardPage         *template.Template
-	JsConsts          *template.Template
-	ManageAppeals     *template.Template
-	ManageBans        *template.Template
-	ManageBoards      *template.Template
-	ManageThreadAttrs *template.Template
-	ManageSections    *template.Template
-	ManageConfig      *template.Template
-	ManageDashboard   *template.Template
-	ManageFileBans    *template.Template
-	ManageNameBans    *template.Template
-	ManageIPSearch    *template.Template
-	ManageRecentPosts *template.Template
-	ManageWordfilters *template.Template
-	ManageLogin       *template.Template
-	ManageReports     *template.Template
-	ManageStaff       *template.Template
-	MoveThreadPage    *template.Template
-	PageHeader        *template.Template
-	PageFooter        *template.Template
-	PostEdit          *template.Template
-	ThreadPage        *template.Template
+	ErrTemplateNotLoaded = errors.New("template has not been loaded")
+
+	BoardArchive           = &Template{}
+	Banpage                = &Template{}
+	Captcha                = &Template{}
+	Catalog                = &Template{}
+	ErrorPage              = &Template{}
+	FrontPage              = &Template{}
+	BoardPage              = &Template{}
+	JsConsts               = &Template{}
+	ManageAppeals          = &Template{}
+	ManageBans             = &Template{}
+	ManageBanPresets       = &Template{}
+	ManageBoards           = &Template{}
+	ManageBuildQueue       = &Template{}
+	ManageThreadAttrs      = &Template{}
+	ManageSections         = &Template{}
+	ManageConfig           = &Template{}
+	ManageDashboard        = &Template{}
+	ManageFileBans         = &Template{}
+	ManageNameBans         = &Template{}
+	ManageIPPurge          = &Template{}
+	ManageIPSearch         = &Template{}
+	ManageRecentPosts      = &Template{}
+	ManageWarnings         = &Template{}
+	ManageWordfilters      = &Template{}
+	ManageLogin            = &Template{}
+	ManageModLog           = &Template{}
+	ManagePlugins          = &Template{}
+	ManageReportCategories = &Template{}
+	ManageReports          = &Template{}
+	ManageRoles            = &Template{}
+	ManageSearch           = &Template{}
+	ManageSessions         = &Template{}
+	ManageStaff            = &Template{}
+	ManageStaffInfo        = &Template{}
+	ModLog                 = &Template{}
+	MoveThreadPage         = &Template{}
+	PageHeader             = &Template{}
+	PageFooter             = &Template{}
+	PostEdit               = &Template{}
+	Search                 = &Template{}
+	ThreadPage             = &Template{}
+	WarningPage            = &Template{}
 )
 
+// Template is a loaded template that can be replaced by InitTemplates while it is being executed
+type Template struct {
+	value atomic.Value
+}
+
+// Load returns the currently loaded template, or nil if it hasn't been loaded
+func (t *Template) Load() *template.Template {
+	tmpl, _ := t.value.Load().(*template.Template)
+	return tmpl
+}
+
+// Execute applies the currently loaded template to data and writes the output to writer
+func (t *Template) Execute(writer io.Writer, data interface{}) error {
+	tmpl := t.Load()
+	if tmpl == nil {
+		return ErrTemplateNotLoaded
+	}
+	return tmpl.Execute(writer, data)
+}
+
 func LoadTemplate(files ...string) (*template.Template, error) {
 	var templates []string
 	templateDir := config.GetSystemCriticalConfig().TemplateDir
@@ -61,6 +102,28 @@ func ParseTemplate(name, tmplStr string) (*template.Template, error) {
 	return template.New(name).Funcs(funcMap).Parse(tmplStr)
 }
 
+// TemplateErrors are the errors from loading one or more templates
+type TemplateErrors []error
+
+func (te TemplateErrors) Error() string {
+	errStrs := make([]string, len(te))
+	for e, err := range te {
+		errStrs[e] = err.Error()
+	}
+	return strings.Join(errStrs, "\n")
+}
+
+// loadTemplate loads the template files into tmpl if they can be parsed, otherwise tmpl is left as it was
+func loadTemplate(tmpl *Template, files ...string) error {
+	name := files[0] // LoadTemplate replaces the file names with their paths
+	loaded, err := LoadTemplate(files...)
+	if err != nil {
+		return templateError(name, err)
+	}
+	tmpl.value.Store(loaded)
+	return nil
+}
+
 func templateError(name string, err error) error {
 	if err == nil {
 		return nil
@@ -87,169 +150,222 @@ func InitTemplates(which ...string) error {
 	return nil
 }
 
+// templateLoading loads the template with the given name, or all of them if buildAll is true. Templates that fail
+// to load keep their previous value, so that a template with a syntax error doesn't break pages that were working
 func templateLoading(t string, buildAll bool) error {
-	var err error
+	var errs TemplateErrors
+	if buildAll || t == "archive" {
+		if err := loadTemplate(BoardArchive, "archive.html", "page_header.html", "page_footer.html"); err != nil {
+			errs = append(errs, err)
+		}
+	}
 	if buildAll || t == "banpage" {
-		Banpage, err = LoadTemplate("banpage.html", "page_footer.html")
-		if err != nil {
-			return templateError("banpage.html", err)
+		if err := loadTemplate(Banpage, "banpage.html", "page_footer.html"); err != nil {
+			errs = append(errs, err)
 		}
 	}
 	if buildAll || t == "captcha" {
-		Captcha, err = LoadTemplate("captcha.html")
-		if err != nil {
-			return templateError("captcha.html", err)
+		if err := loadTemplate(Captcha, "captcha.html", "captcha_widget.html"); err != nil {
+			errs = append(errs, err)
 		}
 	}
 	if buildAll || t == "catalog" {
-		Catalog, err = LoadTemplate("catalog.html", "page_header.html", "page_footer.html")
-		if err != nil {
-			return templateError("catalog.html", err)
+		if err := loadTemplate(Catalog, "catalog.html", "page_header.html", "page_footer.html"); err != nil {
+			errs = append(errs, err)
 		}
 	}
 	if buildAll || t == "error" {
-		ErrorPage, err = LoadTemplate("error.html")
-		if err != nil {
-			return templateError("error.html", err)
+		if err := loadTemplate(ErrorPage, "error.html"); err != nil {
+			errs = append(errs, err)
 		}
 	}
 	if buildAll || t == "front" {
-		FrontPage, err = LoadTemplate("front.html", "front_intro.html", "page_header.html", "page_footer.html")
-		if err != nil {
-			return templateError("front.html", err)
+		if err := loadTemplate(FrontPage, "front.html", "front_intro.html", "page_header.html", "page_footer.html"); err != nil {
+			errs = append(errs, err)
 		}
 	}
 	if buildAll || t == "boardpage" {
-		BoardPage, err = LoadTemplate("boardpage.html", "post.html", "page_header.html", "postbox.html", "page_footer.html")
-		if err != nil {
-			return templateError("boardpage.html", err)
+		if err := loadTemplate(BoardPage, "boardpage.html", "post.html", "page_header.html", "postbox.html", "captcha_widget.html", "page_footer.html"); err != nil {
+			errs = append(errs, err)
 		}
 	}
 	if buildAll || t == "threadpage" {
-		ThreadPage, err = LoadTemplate("threadpage.html", "post.html", "page_header.html", "postbox.html", "page_footer.html")
-		if err != nil {
-			return templateError("threadpage.html", err)
+		if err := loadTemplate(ThreadPage, "threadpage.html", "post.html", "page_header.html", "postbox.html", "captcha_widget.html", "page_footer.html"); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if buildAll || t == "warningpage" {
+		if err := loadTemplate(WarningPage, "warning.html", "page_footer.html"); err != nil {
+			errs = append(errs, err)
 		}
 	}
 	if buildAll || t == "postedit" {
-		PostEdit, err = LoadTemplate("post_edit.html", "page_header.html", "page_footer.html")
-		if err != nil {
-			return templateError("threadpage.html", err)
+		if err := loadTemplate(PostEdit, "post_edit.html", "page_header.html", "page_footer.html"); err != nil {
+			errs = append(errs, err)
 		}
 	}
 	if buildAll || t == "manageappeals" {
-		ManageAppeals, err = LoadTemplate("manage_appeals.html")
-		if err != nil {
-			return templateError("manage_appeals.html", err)
+		if err := loadTemplate(ManageAppeals, "manage_appeals.html"); err != nil {
+			errs = append(errs, err)
 		}
 	}
 	if buildAll || t == "managebans" {
-		ManageBans, err = LoadTemplate("manage_bans.html")
-		if err != nil {
-			return templateError("manage_bans.html", err)
+		if err := loadTemplate(ManageBans, "manage_bans.html"); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if buildAll || t == "managebanpresets" {
+		if err := loadTemplate(ManageBanPresets, "manage_banpresets.html"); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if buildAll || t == "managebuildqueue" {
+		if err := loadTemplate(ManageBuildQueue, "manage_buildqueue.html"); err != nil {
+			errs = append(errs, err)
 		}
 	}
 	if buildAll || t == "manageboards" {
-		ManageBoards, err = LoadTemplate("manage_boards.html")
-		if err != nil {
-			return templateError("manage_boards.html", err)
+		if err := loadTemplate(ManageBoards, "manage_boards.html"); err != nil {
+			errs = append(errs, err)
 		}
 	}
 	if buildAll || t == "managethreadattrs" {
-		ManageThreadAttrs, err = LoadTemplate("manage_threadattrs.html")
-		if err != nil {
-			return templateError("manage_threadattrs.html", err)
+		if err := loadTemplate(ManageThreadAttrs, "manage_threadattrs.html"); err != nil {
+			errs = append(errs, err)
 		}
 	}
 	if buildAll || t == "managesections" {
-		ManageSections, err = LoadTemplate("manage_sections.html")
-		if err != nil {
-			return templateError("manage_sections.html", err)
+		if err := loadTemplate(ManageSections, "manage_sections.html"); err != nil {
+			errs = append(errs, err)
 		}
 	}
 	if buildAll || t == "manageconfig" {
-		ManageConfig, err = LoadTemplate("manage_config.html")
-		if err != nil {
-			return templateError("manage_config.html", err)
+		if err := loadTemplate(ManageConfig, "manage_config.html"); err != nil {
+			errs = append(errs, err)
 		}
 	}
 	if buildAll || t == "managedashboard" {
-		ManageDashboard, err = LoadTemplate("manage_dashboard.html")
-		if err != nil {
-			return templateError("manage_dashboard.html", err)
+		if err := loadTemplate(ManageDashboard, "manage_dashboard.html"); err != nil {
+			errs = append(errs, err)
 		}
 	}
 	if buildAll || t == "managelogin" {
-		ManageLogin, err = LoadTemplate("manage_login.html")
-		if err != nil {
-			return templateError("manage_login.html", err)
+		if err := loadTemplate(ManageLogin, "manage_login.html"); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if buildAll || t == "managemodlog" {
+		if err := loadTemplate(ManageModLog, "manage_modlog.html"); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if buildAll || t == "managereportcategories" {
+		if err := loadTemplate(ManageReportCategories, "manage_reportcategories.html"); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if buildAll || t == "manageplugins" {
+		if err := loadTemplate(ManagePlugins, "manage_plugins.html"); err != nil {
+			errs = append(errs, err)
 		}
 	}
 	if buildAll || t == "managereports" {
-		ManageReports, err = LoadTemplate("manage_reports.html")
-		if err != nil {
-			return templateError("manage_reports.html", err)
+		if err := loadTemplate(ManageReports, "manage_reports.html"); err != nil {
+			errs = append(errs, err)
 		}
 	}
 	if buildAll || t == "managefilebans" {
-		ManageFileBans, err = LoadTemplate("manage_filebans.html")
-		if err != nil {
-			return templateError("manage_filebans.html", err)
+		if err := loadTemplate(ManageFileBans, "manage_filebans.html"); err != nil {
+			errs = append(errs, err)
 		}
 	}
 	if buildAll || t == "managenamebans" {
-		ManageNameBans, err = LoadTemplate("manage_namebans.html")
-		if err != nil {
-			return templateError("manage_namebans.html", err)
+		if err := loadTemplate(ManageNameBans, "manage_namebans.html"); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if buildAll || t == "manageippurge" {
+		if err := loadTemplate(ManageIPPurge, "manage_ippurge.html"); err != nil {
+			errs = append(errs, err)
 		}
 	}
 	if buildAll || t == "manageipsearch" {
-		ManageIPSearch, err = LoadTemplate("manage_ipsearch.html")
-		if err != nil {
-			return templateError("manage_ipsearch.html", err)
+		if err := loadTemplate(ManageIPSearch, "manage_ipsearch.html"); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if buildAll || t == "managesearch" {
+		if err := loadTemplate(ManageSearch, "manage_search.html", "search_results.html"); err != nil {
+			errs = append(errs, err)
 		}
 	}
 	if buildAll || t == "managerecents" {
-		ManageRecentPosts, err = LoadTemplate("manage_recentposts.html")
-		if err != nil {
-			return templateError("manage_recentposts.html", err)
+		if err := loadTemplate(ManageRecentPosts, "manage_recentposts.html"); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if buildAll || t == "managewarnings" {
+		if err := loadTemplate(ManageWarnings, "manage_warnings.html"); err != nil {
+			errs = append(errs, err)
 		}
 	}
 	if buildAll || t == "managewordfilters" {
-		ManageWordfilters, err = LoadTemplate("manage_wordfilters.html")
-		if err != nil {
-			return templateError("manage_wordfilters.html", err)
+		if err := loadTemplate(ManageWordfilters, "manage_wordfilters.html"); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if buildAll || t == "manageroles" {
+		if err := loadTemplate(ManageRoles, "manage_roles.html"); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if buildAll || t == "managesessions" {
+		if err := loadTemplate(ManageSessions, "manage_sessions.html"); err != nil {
+			errs = append(errs, err)
 		}
 	}
 	if buildAll || t == "managestaff" {
-		ManageStaff, err = LoadTemplate("manage_staff.html")
-		if err != nil {
-			return templateError("manage_staff.html", err)
+		if err := loadTemplate(ManageStaff, "manage_staff.html"); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if buildAll || t == "managestaffinfo" {
+		if err := loadTemplate(ManageStaffInfo, "manage_staffinfo.html"); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if buildAll || t == "modlog" {
+		if err := loadTemplate(ModLog, "modlog.html", "page_header.html", "page_footer.html"); err != nil {
+			errs = append(errs, err)
 		}
 	}
 	if buildAll || t == "movethreadpage" {
-		MoveThreadPage, err = LoadTemplate("movethreadpage.html", "page_header.html", "page_footer.html")
-		if err != nil {
-			return templateError("movethreadpage.html", err)
+		if err := loadTemplate(MoveThreadPage, "movethreadpage.html", "page_header.html", "page_footer.html"); err != nil {
+			errs = append(errs, err)
 		}
 	}
 	if buildAll || t == "pageheader" {
-		PageHeader, err = LoadTemplate("page_header.html")
-		if err != nil {
-			return templateError("page_header.html", err)
+		if err := loadTemplate(PageHeader, "page_header.html"); err != nil {
+			errs = append(errs, err)
 		}
 	}
 	if buildAll || t == "pagefooter" {
-		PageFooter, err = LoadTemplate("page_footer.html")
-		if err != nil {
-			return templateError("page_footer.html", err)
+		if err := loadTemplate(PageFooter, "page_footer.html"); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if buildAll || t == "search" {
+		if err := loadTemplate(Search, "search.html", "search_results.html", "page_header.html", "page_footer.html"); err != nil {
+			errs = append(errs, err)
 		}
 	}
 	if buildAll || t == "js" {
-		JsConsts, err = LoadTemplate("consts.js")
-		if err != nil {
-			return templateError("consts.js", err)
+		if err := loadTemplate(JsConsts, "consts.js"); err != nil {
+			errs = append(errs, err)
 		}
 	}
+	if len(errs) > 0 {
+		return errs
+	}
 	return nil
 }
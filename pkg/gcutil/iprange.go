@@ -0,0 +1,88 @@
+package gcutil
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"strings"
+)
+
+var (
+	ErrInvalidIPRange = errors.New("invalid IP address or range")
+)
+
+// ParseIPRange parses a single IP address, a CIDR range (192.168.1.0/24, 2001:db8::/64), or two addresses
+// separated by a dash (192.168.1.10-192.168.1.50) and returns the first and last addresses in the range.
+// The returned addresses are always 16 bytes long (IPv4 addresses are IPv4-mapped), so that IPv4 and IPv6
+// ranges can be compared the same way
+func ParseIPRange(rangeStr string) (net.IP, net.IP, error) {
+	rangeStr = strings.TrimSpace(rangeStr)
+	if strings.Contains(rangeStr, "/") {
+		_, ipNet, err := net.ParseCIDR(rangeStr)
+		if err != nil {
+			return nil, nil, ErrInvalidIPRange
+		}
+		start := ipNet.IP.To16()
+		end := make(net.IP, net.IPv6len)
+		copy(end, start)
+		// IPv4 masks are 4 bytes long, and apply to the last 4 bytes of the IPv4-mapped address
+		offset := net.IPv6len - len(ipNet.Mask)
+		for m, maskByte := range ipNet.Mask {
+			end[offset+m] |= ^maskByte
+		}
+		return start, end, nil
+	}
+	if startStr, endStr, isRange := strings.Cut(rangeStr, "-"); isRange {
+		start := net.ParseIP(strings.TrimSpace(startStr))
+		end := net.ParseIP(strings.TrimSpace(endStr))
+		if start == nil || end == nil || (start.To4() == nil) != (end.To4() == nil) {
+			return nil, nil, ErrInvalidIPRange
+		}
+		start = start.To16()
+		end = end.To16()
+		if bytes.Compare(start, end) > 0 {
+			return nil, nil, ErrInvalidIPRange
+		}
+		return start, end, nil
+	}
+	ip := net.ParseIP(rangeStr)
+	if ip == nil {
+		return nil, nil, ErrInvalidIPRange
+	}
+	return ip.To16(), ip.To16(), nil
+}
+
+// NormalizeIPRange returns the range in a consistent format (for example, 192.168.1.77/24 becomes
+// 192.168.1.0/24), or an error if it is not a valid IP address or range
+func NormalizeIPRange(rangeStr string) (string, error) {
+	rangeStr = strings.TrimSpace(rangeStr)
+	if strings.Contains(rangeStr, "/") {
+		_, ipNet, err := net.ParseCIDR(rangeStr)
+		if err != nil {
+			return "", ErrInvalidIPRange
+		}
+		return ipNet.String(), nil
+	}
+	start, end, err := ParseIPRange(rangeStr)
+	if err != nil {
+		return "", err
+	}
+	if start.Equal(end) {
+		return start.String(), nil
+	}
+	return start.String() + "-" + end.String(), nil
+}
+
+// IPInRange returns true if the IP address is in the range (parsed by ParseIPRange)
+func IPInRange(ip string, rangeStr string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	start, end, err := ParseIPRange(rangeStr)
+	if err != nil {
+		return false
+	}
+	addr = addr.To16()
+	return bytes.Compare(addr, start) >= 0 && bytes.Compare(addr, end) <= 0
+}
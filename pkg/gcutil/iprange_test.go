@@ -0,0 +1,65 @@
+package gcutil
+
+import (
+	"testing"
+)
+
+func TestParseIPRange(t *testing.T) {
+	testCases := []struct {
+		rangeStr string
+		start    string
+		end      string
+		invalid  bool
+	}{
+		{rangeStr: "192.168.1.5", start: "192.168.1.5", end: "192.168.1.5"},
+		{rangeStr: "192.168.1.77/24", start: "192.168.1.0", end: "192.168.1.255"},
+		{rangeStr: "10.0.0.0/8", start: "10.0.0.0", end: "10.255.255.255"},
+		{rangeStr: "192.168.1.10 - 192.168.1.50", start: "192.168.1.10", end: "192.168.1.50"},
+		{rangeStr: "2001:db8::1", start: "2001:db8::1", end: "2001:db8::1"},
+		{rangeStr: "2001:db8:1:2::/64", start: "2001:db8:1:2::", end: "2001:db8:1:2:ffff:ffff:ffff:ffff"},
+		{rangeStr: "2001:db8::/127", start: "2001:db8::", end: "2001:db8::1"},
+		{rangeStr: "192.168.1.50-192.168.1.10", invalid: true},
+		{rangeStr: "192.168.1.1-2001:db8::1", invalid: true},
+		{rangeStr: "192.168.1.0/33", invalid: true},
+		{rangeStr: "not an ip", invalid: true},
+		{rangeStr: "", invalid: true},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.rangeStr, func(t *testing.T) {
+			start, end, err := ParseIPRange(tC.rangeStr)
+			if tC.invalid {
+				if err == nil {
+					t.Fatalf("expected %q to be invalid, got %s-%s", tC.rangeStr, start, end)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			if start.String() != tC.start || end.String() != tC.end {
+				t.Fatalf("expected %s-%s, got %s-%s", tC.start, tC.end, start, end)
+			}
+			if len(start) != 16 || len(end) != 16 {
+				t.Fatalf("expected 16 byte addresses, got %d and %d bytes", len(start), len(end))
+			}
+		})
+	}
+}
+
+func TestIPInRange(t *testing.T) {
+	if !IPInRange("192.168.1.200", "192.168.1.0/24") {
+		t.Fatal("expected 192.168.1.200 to be in 192.168.1.0/24")
+	}
+	if IPInRange("192.168.2.1", "192.168.1.0/24") {
+		t.Fatal("expected 192.168.2.1 to not be in 192.168.1.0/24")
+	}
+	if !IPInRange("2001:db8::abcd:1", "2001:db8::/64") {
+		t.Fatal("expected 2001:db8::abcd:1 to be in 2001:db8::/64")
+	}
+	if IPInRange("2001:db8:0:1::1", "2001:db8::/64") {
+		t.Fatal("expected 2001:db8:0:1::1 to not be in 2001:db8::/64")
+	}
+	if IPInRange("192.168.1.1", "2001:db8::/32") {
+		t.Fatal("expected IPv4 address to not be in an IPv6 range")
+	}
+}
@@ -0,0 +1,125 @@
+package gcutil
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// TOTPPeriod is the number of seconds each time-based one-time password is valid for
+	TOTPPeriod = 30
+	// TOTPDigits is the number of digits in a time-based one-time password
+	TOTPDigits = 6
+	// totpSkew is the number of periods before and after the current one that are also accepted, to allow
+	// for clock drift and slow typing
+	totpSkew = 1
+	// recoveryCodeChars are used for recovery codes, without characters that are easily confused (0/O, 1/I).
+	// There are 32 of them so that each random byte maps to one without bias
+	recoveryCodeChars = "23456789ABCDEFGHJKLMNPQRSTUVWXYZ"
+)
+
+var (
+	totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+)
+
+// GenerateTOTPSecret returns a new random base32 encoded secret for RFC 6238 time-based one-time passwords
+func GenerateTOTPSecret() (string, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+// TOTPStep returns the RFC 6238 time step (the number of TOTPPeriods since the Unix epoch) of t
+func TOTPStep(t time.Time) int64 {
+	return t.Unix() / TOTPPeriod
+}
+
+// TOTPCode returns the one-time password for the base32 encoded secret at the given time step
+func TOTPCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return "", err
+	}
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+	// dynamic truncation (RFC 4226 section 5.3)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for d := 0; d < TOTPDigits; d++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", TOTPDigits, value%mod), nil
+}
+
+// ValidateTOTP checks the code against the base32 encoded secret at the time t, accepting codes from
+// adjacent time steps. If the code is valid, it returns true and the time step it matched, which should
+// be stored so that the same code can't be used again. Steps at or before lastStep are not accepted
+func ValidateTOTP(secret string, code string, t time.Time, lastStep int64) (bool, int64, error) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != TOTPDigits {
+		return false, 0, nil
+	}
+	current := TOTPStep(t)
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		expected, err := TOTPCode(secret, step)
+		if err != nil {
+			return false, 0, err
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return true, step, nil
+		}
+	}
+	return false, 0, nil
+}
+
+// TOTPURI returns an otpauth:// URI that authenticator apps can use to add the account
+func TOTPURI(issuer string, account string, secret string) string {
+	label := url.PathEscape(issuer + ":" + account)
+	query := url.Values{
+		"secret":    {secret},
+		"issuer":    {issuer},
+		"algorithm": {"SHA1"},
+		"digits":    {fmt.Sprint(TOTPDigits)},
+		"period":    {fmt.Sprint(TOTPPeriod)},
+	}
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// GenerateRecoveryCode returns a random single-use recovery code in the format XXXXX-XXXXX
+func GenerateRecoveryCode() (string, error) {
+	buf := make([]byte, 10)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	var code strings.Builder
+	for b, randByte := range buf {
+		if b == len(buf)/2 {
+			code.WriteByte('-')
+		}
+		code.WriteByte(recoveryCodeChars[int(randByte)%len(recoveryCodeChars)])
+	}
+	return code.String(), nil
+}
+
+// NormalizeRecoveryCode removes spaces and dashes from the recovery code and makes it uppercase so that
+// it can be compared to the generated code regardless of how it was typed
+func NormalizeRecoveryCode(code string) string {
+	return strings.NewReplacer("-", "", " ", "").Replace(strings.ToUpper(strings.TrimSpace(code)))
+}
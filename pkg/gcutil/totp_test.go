@@ -0,0 +1,74 @@
+package gcutil
+
+import (
+	"testing"
+	"time"
+)
+
+const (
+	// base32 encoding of the RFC 6238 SHA1 test secret "12345678901234567890"
+	rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// the last 6 digits of the RFC 6238 appendix B test vectors
+	testCases := []struct {
+		unix int64
+		code string
+	}{
+		{unix: 59, code: "287082"},
+		{unix: 1111111109, code: "081804"},
+		{unix: 1111111111, code: "050471"},
+		{unix: 1234567890, code: "005924"},
+		{unix: 2000000000, code: "279037"},
+	}
+	for _, tC := range testCases {
+		code, err := TOTPCode(rfc6238Secret, TOTPStep(time.Unix(tC.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != tC.code {
+			t.Errorf("expected code %s at %d, got %s", tC.code, tC.unix, code)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+	step := TOTPStep(now)
+	prevCode, _ := TOTPCode(rfc6238Secret, step-1)
+	oldCode, _ := TOTPCode(rfc6238Secret, step-3)
+
+	valid, matched, err := ValidateTOTP(rfc6238Secret, "081 804", now, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !valid || matched != step {
+		t.Errorf("expected current code to be valid at step %d, got %t at step %d", step, valid, matched)
+	}
+	if valid, _, _ = ValidateTOTP(rfc6238Secret, prevCode, now, 0); !valid {
+		t.Error("expected code from the previous step to be valid")
+	}
+	if valid, _, _ = ValidateTOTP(rfc6238Secret, oldCode, now, 0); valid {
+		t.Error("expected code from three steps ago to be invalid")
+	}
+	if valid, _, _ = ValidateTOTP(rfc6238Secret, "081804", now, step); valid {
+		t.Error("expected already used code to be rejected")
+	}
+	if valid, _, _ = ValidateTOTP(rfc6238Secret, "12345", now, 0); valid {
+		t.Error("expected code with the wrong length to be invalid")
+	}
+}
+
+func TestRecoveryCode(t *testing.T) {
+	code, err := GenerateRecoveryCode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(code) != 11 || code[5] != '-' {
+		t.Errorf("unexpected recovery code format: %q", code)
+	}
+	if NormalizeRecoveryCode(" "+code[:5]+" "+code[6:]+" ") != code[:5]+code[6:] {
+		t.Errorf("normalized code doesn't match %q", code)
+	}
+}
@@ -1,7 +1,10 @@
 package manage
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"sync"
 
 	"github.com/gochan-org/gochan/pkg/gcsql"
 	"github.com/rs/zerolog"
@@ -10,14 +13,31 @@ import (
 const (
 	// NoPerms allows anyone to access this Action
 	NoPerms = iota
-	// JanitorPerms allows anyone with at least a janitor-level account to access this Action
+	// JanitorPerms requires the user to be logged in and lists the Action in the janitorial section of the
+	// staff menu
 	JanitorPerms
-	// ModPerms allows anyone with at least a moderator-level account to access this Action
+	// ModPerms requires the user to be logged in and lists the Action in the moderation section of the
+	// staff menu
 	ModPerms
-	// AdminPerms allows only the site administrator to view this Action
+	// AdminPerms requires the user to be logged in and lists the Action in the administration section of
+	// the staff menu
 	AdminPerms
 )
 
+var (
+	// ErrCapability is returned when a staff member's role doesn't have the capability needed to do something
+	ErrCapability = errors.New("your role does not have permission to do this")
+	// ErrManagePageExists is returned when a manage page is registered with the ID of another page
+	ErrManagePageExists = errors.New("a manage page with this ID is already registered")
+
+	// legacyPermsCapabilities are the capabilities required by manage pages registered by plugins without
+	// one, so that pages meant for moderators or administrators aren't opened up to every staff member
+	legacyPermsCapabilities = map[int]string{
+		ModPerms:   gcsql.CapBanCreate,
+		AdminPerms: gcsql.CapStaffManage,
+	}
+)
+
 const (
 	// NoJSON actions will return an error if JSON is requested by the user
 	NoJSON = iota
@@ -37,11 +57,14 @@ type Action struct {
 	// The text shown in the staff menu and the window title
 	Title string `json:"title"`
 
-	// Permissions represent who can access the page. 0 for anyone,
-	// 1 requires the user to have a janitor, mod, or admin account. 2 requires mod or admin,
-	// and 3 is only accessible by admins
+	// Permissions sets whether the page can be accessed without logging in (NoPerms) and which section
+	// of the staff menu it is listed in. Anything other than NoPerms requires the user to be logged in
 	Permissions int `json:"perms"`
 
+	// Capability is the role capability (for example "ban.create") that the staff member needs to access
+	// the page. If it is empty, any logged in staff member can access it
+	Capability string `json:"capability,omitempty"`
+
 	// JSONoutput sets what the action can output. If it is 0, it will throw an error if
 	// JSON is requested. If it is 1, it can output JSON if requested, and if 2, it always
 	// outputs JSON whether it is requested or not
@@ -53,37 +76,126 @@ type Action struct {
 	// IMPORTANT: the writer parameter should only be written to if absolutely necessary (for example,
 	// if a redirect wouldn't work in handler.go) and even then, it should be done sparingly
 	Callback CallbackFunction `json:"-"`
+
+	// plugin is the path of the Lua plugin that registered the page, or empty for built-in pages and pages
+	// registered by Go plugins, which can't be replaced
+	plugin string
 }
 
-var actions []Action
+var (
+	actions []Action
+	// actionsLock protects actions after the server starts, since plugins' manage pages are replaced when
+	// plugins are reloaded
+	actionsLock sync.RWMutex
+)
 
 // returns the action by its ID, or nil if it doesn't exist
-func getAction(id string, rank int) *Action {
+func getAction(id string, staff *gcsql.Staff) *Action {
+	actionsLock.RLock()
+	defer actionsLock.RUnlock()
 	for a := range actions {
-		if rank == NoPerms && actions[a].Permissions > NoPerms {
+		if staff.ID == 0 && actions[a].Permissions > NoPerms {
 			id = "login"
 		}
 		if actions[a].ID == id {
-			return &actions[a]
+			action := actions[a]
+			return &action
 		}
 	}
 	return nil
 }
 
-func RegisterManagePage(id string, title string, permissions int, jsonOutput int, callback CallbackFunction) {
-	actions = append(actions, Action{
+// RegisterManagePage adds a page to /manage/<id>. If capability is empty and permissions is ModPerms or
+// AdminPerms, a capability that moderators or administrators have by default is required. It returns
+// ErrManagePageExists if a page with the same ID is already registered
+func RegisterManagePage(id string, title string, permissions int, capability string, jsonOutput int, callback CallbackFunction) error {
+	action := NewPluginManagePage("", id, title, permissions, capability, jsonOutput, callback)
+	actionsLock.Lock()
+	defer actionsLock.Unlock()
+	if actionExists(id) {
+		return fmt.Errorf("%w: %s", ErrManagePageExists, id)
+	}
+	actions = append(actions, action)
+	return nil
+}
+
+// NewPluginManagePage returns a page registered by the Lua plugin at pluginPath, to be passed to
+// SetPluginManagePages along with the pages of the other plugins
+func NewPluginManagePage(pluginPath string, id string, title string, permissions int, capability string, jsonOutput int, callback CallbackFunction) Action {
+	if capability == "" {
+		capability = legacyPermsCapabilities[permissions]
+	} else {
+		gcsql.RegisterCapability(capability, title)
+	}
+	return Action{
 		ID:          id,
 		Title:       title,
 		Permissions: permissions,
+		Capability:  capability,
 		JSONoutput:  jsonOutput,
 		Callback:    callback,
-	})
+		plugin:      pluginPath,
+	}
+}
+
+// SetPluginManagePages replaces the pages registered by Lua plugins with pages in one step, so that reloading
+// plugins doesn't leave their pages missing in between. Built-in pages are kept, and pages with the same ID as
+// a built-in page or a page earlier in pages aren't added and are returned
+func SetPluginManagePages(pages []Action) (rejected []Action) {
+	actionsLock.Lock()
+	defer actionsLock.Unlock()
+	registered := make([]Action, 0, len(actions)+len(pages))
+	for _, action := range actions {
+		if action.plugin == "" {
+			registered = append(registered, action)
+		}
+	}
+	actions = registered
+	for _, page := range pages {
+		if page.plugin == "" || actionExists(page.ID) {
+			rejected = append(rejected, page)
+			continue
+		}
+		actions = append(actions, page)
+	}
+	return rejected
+}
+
+// Plugin returns the path of the Lua plugin that registered the page, or an empty string if it wasn't registered
+// by a Lua plugin
+func (a *Action) Plugin() string {
+	return a.plugin
+}
+
+// actionExists returns true if a page with the given ID is registered. actionsLock must be held by the caller
+func actionExists(id string) bool {
+	for _, action := range actions {
+		if action.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// canAccess returns true if the staff member is allowed to access the action
+func (a *Action) canAccess(staff *gcsql.Staff) bool {
+	return a.Permissions == NoPerms || staff.Can(a.Capability)
+}
+
+// checkCapability returns ErrCapability if the staff member's role doesn't have the capability
+func checkCapability(staff *gcsql.Staff, capability string) error {
+	if !staff.Can(capability) {
+		return ErrCapability
+	}
+	return nil
 }
 
-func getAvailableActions(rank int, noJSON bool) []Action {
+func getAvailableActions(staff *gcsql.Staff, noJSON bool) []Action {
 	available := []Action{}
+	actionsLock.RLock()
+	defer actionsLock.RUnlock()
 	for _, action := range actions {
-		if (rank < action.Permissions || action.Permissions == NoPerms) ||
+		if action.Permissions == NoPerms || !action.canAccess(staff) ||
 			(noJSON && action.JSONoutput == AlwaysJSON) {
 			continue
 		}
@@ -93,6 +205,6 @@ func getAvailableActions(rank int, noJSON bool) []Action {
 }
 
 func getStaffActions(writer http.ResponseWriter, request *http.Request, staff *gcsql.Staff, wantsJSON bool, infoEv *zerolog.Event, errEv *zerolog.Event) (interface{}, error) {
-	availableActions := getAvailableActions(staff.Rank, false)
+	availableActions := getAvailableActions(staff, false)
 	return availableActions, nil
 }
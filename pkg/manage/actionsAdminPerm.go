@@ -27,9 +27,10 @@ func RegisterAdminPages() {
 			ID:          "cleanup",
 			Title:       "Cleanup",
 			Permissions: AdminPerms,
+			Capability:  gcsql.CapSiteCleanup,
 			Callback: func(writer http.ResponseWriter, request *http.Request, staff *gcsql.Staff, wantsJSON bool, infoEv *zerolog.Event, errEv *zerolog.Event) (output interface{}, err error) {
 				outputStr := ""
-				if request.FormValue("run") == "Run Cleanup" {
+				if request.PostFormValue("run") == "Run Cleanup" {
 					outputStr += "Removing deleted posts from the database.<hr />"
 					if err = gcsql.PermanentlyRemoveDeletedPosts(); err != nil {
 						errEv.Err(err).
@@ -49,8 +50,13 @@ func RegisterAdminPages() {
 						return outputStr + "<tr><td>" + err.Error() + "</td></tr></table>", err
 					}
 					outputStr += "Cleanup finished"
+					LogStaffAction(staff, gcsql.ModLogEntry{
+						Action:     gcsql.ModLogSiteCleanup,
+						TargetType: gcsql.ModLogTargetSite,
+					}, nil, nil)
 				} else {
 					outputStr += `<form action="` + config.GetSystemCriticalConfig().WebRoot + `manage/cleanup" method="post">` +
+						`<input type="hidden" name="csrftoken" value="` + staff.CSRFToken + `" />` +
 						`<input name="run" id="run" type="submit" value="Run Cleanup" />` +
 						`</form>`
 				}
@@ -60,10 +66,11 @@ func RegisterAdminPages() {
 			ID:          "staff",
 			Title:       "Staff",
 			Permissions: AdminPerms,
+			Capability:  gcsql.CapStaffManage,
 			JSONoutput:  OptionalJSON,
 			Callback: func(writer http.ResponseWriter, request *http.Request, staff *gcsql.Staff, wantsJSON bool, infoEv *zerolog.Event, errEv *zerolog.Event) (output interface{}, err error) {
 				var outputStr string
-				do := request.FormValue("do")
+				do := request.PostFormValue("do")
 				allStaff, err := getAllStaffNopass(true)
 				if wantsJSON {
 					if err != nil {
@@ -77,51 +84,173 @@ func RegisterAdminPages() {
 					return "", err
 				}
 
-				for _, staff := range allStaff {
-					username := request.FormValue("username")
-					password := request.FormValue("password")
-					rank := request.FormValue("rank")
-					rankI, _ := strconv.Atoi(rank)
-					if do == "add" {
-						if _, err = gcsql.NewStaff(username, password, rankI); err != nil {
-							errEv.
-								Str("newStaff", username).
-								Str("newPass", password).
-								Int("newRank", rankI).
-								Caller().Msg("Error creating new staff account")
-							return "", fmt.Errorf("Error creating new staff account %q by %q: %s",
-								username, staff.Username, err.Error())
+				username := request.FormValue("username")
+				password := request.FormValue("password")
+				roleID, _ := strconv.Atoi(request.FormValue("roleid"))
+				allBoards := request.FormValue("allboards") != ""
+				var boardIDs []int
+				for _, boardIDstr := range request.Form["boardid"] {
+					boardID, err := strconv.Atoi(boardIDstr)
+					if err != nil {
+						errEv.Err(err).
+							Str("boardid", boardIDstr).
+							Caller().Send()
+						return "", err
+					}
+					boardIDs = append(boardIDs, boardID)
+				}
+				var role *gcsql.Role
+				if do == "add" || do == "edit" {
+					if role, err = gcsql.GetRole(roleID); err != nil {
+						errEv.Err(err).
+							Int("roleID", roleID).
+							Caller().Send()
+						return "", err
+					}
+				}
+				switch {
+				case do == "add":
+					if _, err = gcsql.NewStaff(username, password, role); err != nil {
+						errEv.
+							Str("newStaff", username).
+							Str("newPass", password).
+							Str("newRole", role.Name).
+							Caller().Msg("Error creating new staff account")
+						return "", fmt.Errorf("Error creating new staff account %q by %q: %s",
+							username, staff.Username, err.Error())
+					}
+					fallthrough
+				case do == "edit" && username != "":
+					var editStaff *gcsql.Staff
+					if editStaff, err = gcsql.GetStaffByUsername(username, true); err != nil {
+						errEv.Err(err).
+							Str("editStaff", username).
+							Caller().Send()
+						return "", err
+					}
+					oldBoardIDs, err := editStaff.ModeratedBoardIDs()
+					if err != nil {
+						errEv.Err(err).
+							Str("editStaff", username).
+							Caller().Send()
+						return "", err
+					}
+					logBefore := map[string]interface{}{
+						"roleID": editStaff.RoleID, "boardIDs": oldBoardIDs, "allBoards": editStaff.AllBoards,
+					}
+					if editStaff.RoleID != role.ID {
+						if username == staff.Username && !role.Has(gcsql.CapStaffManage) {
+							return "", errors.New("you can't give yourself a role that can't manage staff")
 						}
-					} else if do == "del" && username != "" {
-						if err = gcsql.DeactivateStaff(username); err != nil {
+						if err = editStaff.SetRole(role); err != nil {
 							errEv.Err(err).
-								Str("delStaff", username).
-								Caller().Msg("Error deleting staff account")
-							return "", fmt.Errorf("Error deleting staff account %q by %q: %s",
-								username, staff.Username, err.Error())
+								Str("editStaff", username).
+								Str("role", role.Name).
+								Caller().Msg("Error setting staff role")
+							return "", fmt.Errorf("Error setting role for %q: %s", username, err.Error())
 						}
+						infoEv.
+							Str("editStaff", username).
+							Str("role", role.Name).
+							Msg("Staff role updated")
+					}
+					if err = editStaff.SetBoards(boardIDs, allBoards); err != nil {
+						errEv.Err(err).
+							Str("editStaff", username).
+							Ints("boardIDs", boardIDs).
+							Bool("allBoards", allBoards).
+							Caller().Msg("Error setting staff board assignments")
+						return "", fmt.Errorf("Error setting board assignments for %q: %s", username, err.Error())
+					}
+					infoEv.
+						Str("editStaff", username).
+						Ints("boardIDs", boardIDs).
+						Bool("allBoards", allBoards).
+						Msg("Staff board assignments updated")
+					logEntry := gcsql.ModLogEntry{
+						Action:     gcsql.ModLogStaffEdit,
+						TargetType: gcsql.ModLogTargetStaff,
+						TargetID:   editStaff.ID,
+					}
+					if do == "add" {
+						logEntry.Action = gcsql.ModLogStaffCreate
+						logBefore = nil
+					}
+					LogStaffAction(staff, logEntry, logBefore, map[string]interface{}{
+						"username": username, "roleID": role.ID, "boardIDs": boardIDs, "allBoards": allBoards,
+					})
+				case do == "resettotp" && username != "":
+					// for staff who have lost access to their authenticator app and recovery codes
+					var resetStaff *gcsql.Staff
+					if resetStaff, err = gcsql.GetStaffByUsername(username, true); err == nil {
+						err = resetStaff.DisableTOTP()
 					}
-					allStaff, err = getAllStaffNopass(true)
 					if err != nil {
+						errEv.Err(err).
+							Str("resetStaff", username).
+							Caller().Msg("Error resetting two-factor authentication")
+						return "", fmt.Errorf("Error resetting two-factor authentication for %q: %s", username, err.Error())
+					}
+					infoEv.
+						Str("resetStaff", username).
+						Msg("Two-factor authentication reset")
+					LogStaffAction(staff, gcsql.ModLogEntry{
+						Action:     gcsql.ModLogStaffResetTOTP,
+						TargetType: gcsql.ModLogTargetStaff,
+						TargetID:   resetStaff.ID,
+					}, nil, nil)
+				case do == "del" && username != "":
+					if err = gcsql.DeactivateStaff(username); err != nil {
+						errEv.Err(err).
+							Str("delStaff", username).
+							Caller().Msg("Error deleting staff account")
+						return "", fmt.Errorf("Error deleting staff account %q by %q: %s",
+							username, staff.Username, err.Error())
+					}
+					LogStaffAction(staff, gcsql.ModLogEntry{
+						Action:     gcsql.ModLogStaffDelete,
+						TargetType: gcsql.ModLogTargetStaff,
+					}, map[string]string{"username": username}, nil)
+				}
+				if do != "" {
+					if allStaff, err = getAllStaffNopass(true); err != nil {
 						errEv.Err(err).Caller().Msg("Error getting updated staff list")
 						err = errors.New("Error getting updated staff list: " + err.Error())
 						return "", err
 					}
+				}
 
-					switch {
-					case staff.Rank == 3:
-						rank = "admin"
-					case staff.Rank == 2:
-						rank = "mod"
-					case staff.Rank == 1:
-						rank = "janitor"
+				boardStaff, err := gcsql.GetAllBoardStaff()
+				if err != nil {
+					errEv.Err(err).Caller().Msg("Error getting staff board assignments")
+					return "", errors.New("Error getting staff board assignments: " + err.Error())
+				}
+				// the dirs of the boards each staff member is assigned to, mapped to their usernames
+				staffBoardDirs := make(map[string][]string)
+				for _, s := range allStaff {
+					for _, boardID := range boardStaff[s.ID] {
+						for _, board := range gcsql.AllBoards {
+							if board.ID == boardID {
+								staffBoardDirs[s.Username] = append(staffBoardDirs[s.Username], board.Dir)
+							}
+						}
 					}
 				}
 
+				roles, err := gcsql.GetRoles()
+				if err != nil {
+					errEv.Err(err).Caller().Msg("Error getting staff roles")
+					return "", errors.New("Error getting staff roles: " + err.Error())
+				}
+
 				staffBuffer := bytes.NewBufferString("")
 				if err = serverutil.MinifyTemplate(gctemplates.ManageStaff, map[string]interface{}{
+					"csrfToken":       staff.CSRFToken,
 					"allstaff":        allStaff,
 					"currentUsername": staff.Username,
+					"allBoards":       gcsql.AllBoards,
+					"staffBoardDirs":  staffBoardDirs,
+					"roles":           roles,
 				}, staffBuffer, "text/html"); err != nil {
 					errEv.Err(err).Str("template", "manage_staff.html").Send()
 					return "", errors.New("Error executing staff management page template: " + err.Error())
@@ -129,10 +258,182 @@ func RegisterAdminPages() {
 				outputStr += staffBuffer.String()
 				return outputStr, nil
 			}},
+		Action{
+			ID:          "roles",
+			Title:       "Staff roles",
+			Permissions: AdminPerms,
+			Capability:  gcsql.CapStaffManage,
+			JSONoutput:  OptionalJSON,
+			Callback: func(writer http.ResponseWriter, request *http.Request, staff *gcsql.Staff, wantsJSON bool, infoEv *zerolog.Event, errEv *zerolog.Event) (output interface{}, err error) {
+				var role *gcsql.Role
+				editID := request.Form.Get("edit")
+				updateID := request.PostForm.Get("updaterole")
+				deleteID := request.PostForm.Get("delete")
+				if editID != "" {
+					if role, err = gcsql.GetRole(gcutil.HackyStringToInt(editID)); err != nil {
+						errEv.Err(err).Caller().Str("editRole", editID).Send()
+						return "", err
+					}
+				} else if updateID != "" {
+					if role, err = gcsql.GetRole(gcutil.HackyStringToInt(updateID)); err != nil {
+						errEv.Err(err).Caller().Str("updateRole", updateID).Send()
+						return "", err
+					}
+				} else if deleteID != "" {
+					if role, err = gcsql.GetRole(gcutil.HackyStringToInt(deleteID)); err == nil {
+						err = role.Delete()
+					}
+					if err != nil {
+						errEv.Err(err).Caller().Str("deleteRole", deleteID).Send()
+						return "", err
+					}
+					infoEv.Str("deleteRole", role.Name).Msg("Staff role deleted")
+					LogStaffAction(staff, gcsql.ModLogEntry{
+						Action:     gcsql.ModLogRoleDelete,
+						TargetType: gcsql.ModLogTargetRole,
+						TargetID:   role.ID,
+					}, role, nil)
+					role = nil
+				}
+
+				if request.PostForm.Get("save_role") != "" {
+					name := request.PostForm.Get("rolename")
+					capabilities := request.PostForm["capability"]
+					requireTOTP := request.PostForm.Get("requiretotp") == "on"
+					logEntry := gcsql.ModLogEntry{
+						Action:     gcsql.ModLogRoleCreate,
+						TargetType: gcsql.ModLogTargetRole,
+					}
+					var logBefore interface{}
+					if role != nil {
+						logEntry.Action = gcsql.ModLogRoleEdit
+						oldRole := *role
+						logBefore = oldRole
+						if role.ID == staff.RoleID && !(&gcsql.Role{Capabilities: capabilities}).Has(gcsql.CapStaffManage) {
+							return "", fmt.Errorf("you can't remove the %q capability from your own role", gcsql.CapStaffManage)
+						}
+						err = role.Update(name, capabilities)
+					} else {
+						role, err = gcsql.NewRole(name, capabilities)
+					}
+					if err == nil {
+						err = role.SetRequireTOTP(requireTOTP)
+					}
+					if err != nil {
+						errEv.Err(err).Caller().
+							Str("roleName", name).
+							Strs("capabilities", capabilities).
+							Msg("Unable to save staff role")
+						return "", err
+					}
+					infoEv.
+						Str("roleName", name).
+						Strs("capabilities", capabilities).
+						Bool("requireTOTP", requireTOTP).
+						Msg("Staff role saved")
+					logEntry.TargetID = role.ID
+					LogStaffAction(staff, logEntry, logBefore, role)
+					role = nil
+				}
+
+				roles, err := gcsql.GetRoles()
+				if err != nil {
+					errEv.Err(err).Caller().Send()
+					return "", err
+				}
+				if wantsJSON {
+					return roles, nil
+				}
+				pageBuffer := bytes.NewBufferString("")
+				pageMap := map[string]interface{}{
+					"roles":        roles,
+					"csrfToken":    staff.CSRFToken,
+					"capabilities": gcsql.Capabilities,
+				}
+				if role != nil {
+					pageMap["edit_role"] = role
+				}
+				if err = serverutil.MinifyTemplate(gctemplates.ManageRoles, pageMap, pageBuffer, "text/html"); err != nil {
+					errEv.Err(err).Caller().Str("template", "manage_roles.html").Send()
+					return "", err
+				}
+				output = pageBuffer.String()
+				return
+			}},
+		Action{
+			ID:          "sessions",
+			Title:       "Staff sessions",
+			Permissions: AdminPerms,
+			Capability:  gcsql.CapStaffManage,
+			JSONoutput:  OptionalJSON,
+			Callback: func(writer http.ResponseWriter, request *http.Request, staff *gcsql.Staff, wantsJSON bool, infoEv *zerolog.Event, errEv *zerolog.Event) (output interface{}, err error) {
+				staffID, _ := strconv.Atoi(request.FormValue("staff"))
+				if revokeID := request.PostForm.Get("revoke"); revokeID != "" {
+					if err = gcsql.DeleteSession(gcutil.HackyStringToInt(revokeID)); err != nil {
+						errEv.Err(err).Caller().
+							Str("revokeSession", revokeID).
+							Msg("Unable to revoke staff session")
+						return "", err
+					}
+					infoEv.Str("revokeSession", revokeID).Msg("Staff session revoked")
+					LogStaffAction(staff, gcsql.ModLogEntry{
+						Action:     gcsql.ModLogSessionRevoke,
+						TargetType: gcsql.ModLogTargetSession,
+						TargetID:   gcutil.HackyStringToInt(revokeID),
+					}, nil, nil)
+				} else if request.PostForm.Get("revokestaff") != "" && staffID > 0 {
+					if staffID == staff.ID {
+						return "", errors.New(`use "Log me out everywhere" to end your own sessions`)
+					}
+					if err = (&gcsql.Staff{ID: staffID}).ClearSessions(); err != nil {
+						errEv.Err(err).Caller().
+							Int("revokeStaffID", staffID).
+							Msg("Unable to revoke staff sessions")
+						return "", err
+					}
+					infoEv.Int("revokeStaffID", staffID).Msg("All sessions revoked for staff")
+					LogStaffAction(staff, gcsql.ModLogEntry{
+						Action:     gcsql.ModLogSessionRevoke,
+						TargetType: gcsql.ModLogTargetStaff,
+						TargetID:   staffID,
+					}, nil, nil)
+				}
+
+				sessions, err := gcsql.GetStaffSessions(staffID)
+				if err != nil {
+					errEv.Err(err).Caller().Msg("Unable to get staff sessions")
+					return "", err
+				}
+				if wantsJSON {
+					return sessions, nil
+				}
+				allStaff, err := getAllStaffNopass(true)
+				if err != nil {
+					errEv.Err(err).Caller().Msg("Failed getting staff list")
+					return "", err
+				}
+				var currentSessionID int
+				if sessionCookie, err := request.Cookie("sessiondata"); err == nil {
+					currentSessionID, _ = gcsql.GetSessionID(sessionCookie.Value)
+				}
+				pageBuffer := bytes.NewBufferString("")
+				if err = serverutil.MinifyTemplate(gctemplates.ManageSessions, map[string]interface{}{
+					"sessions":         sessions,
+					"allstaff":         allStaff,
+					"staffID":          staffID,
+					"currentSessionID": currentSessionID,
+					"csrfToken":        staff.CSRFToken,
+				}, pageBuffer, "text/html"); err != nil {
+					errEv.Err(err).Caller().Str("template", "manage_sessions.html").Send()
+					return "", err
+				}
+				return pageBuffer.String(), nil
+			}},
 		Action{
 			ID:          "boards",
 			Title:       "Boards",
 			Permissions: AdminPerms,
+			Capability:  gcsql.CapBoardEdit,
 			JSONoutput:  NoJSON,
 			Callback: func(writer http.ResponseWriter, request *http.Request, staff *gcsql.Staff, wantsJSON bool, infoEv *zerolog.Event, errEv *zerolog.Event) (output interface{}, err error) {
 				board := &gcsql.Board{
@@ -159,6 +460,12 @@ func RegisterAdminPages() {
 						Str("createBoard", board.Dir).
 						Int("boardID", board.ID).
 						Msg("New board created")
+					LogStaffAction(staff, gcsql.ModLogEntry{
+						Action:     gcsql.ModLogBoardCreate,
+						TargetType: gcsql.ModLogTargetBoard,
+						TargetID:   board.ID,
+						BoardID:    &board.ID,
+					}, nil, board)
 				case "delete":
 					// delete button clicked, delete the board
 					boardID, err := getIntField("board", staff.Username, request, 0)
@@ -177,6 +484,11 @@ func RegisterAdminPages() {
 					}
 					infoEv.
 						Str("deleteBoard", deleteBoard.Dir).Send()
+					LogStaffAction(staff, gcsql.ModLogEntry{
+						Action:     gcsql.ModLogBoardDelete,
+						TargetType: gcsql.ModLogTargetBoard,
+						TargetID:   deleteBoard.ID,
+					}, deleteBoard, nil)
 					if err = os.RemoveAll(deleteBoard.AbsolutePath()); err != nil {
 						errEv.Err(err).Caller().Send()
 						return "", err
@@ -198,9 +510,22 @@ func RegisterAdminPages() {
 					if err = getBoardDataFromForm(board, request); err != nil {
 						return "", err
 					}
+					oldBoard, err := gcsql.GetBoardFromID(board.ID)
+					if err != nil {
+						errEv.Err(err).
+							Int("boardID", board.ID).
+							Caller().Msg("Unable to get board info")
+						return "", err
+					}
 					if err = board.ModifyInDB(); err != nil {
 						return "", errors.New("Unable to apply changes: " + err.Error())
 					}
+					LogStaffAction(staff, gcsql.ModLogEntry{
+						Action:     gcsql.ModLogBoardEdit,
+						TargetType: gcsql.ModLogTargetBoard,
+						TargetID:   board.ID,
+						BoardID:    &board.ID,
+					}, oldBoard, board)
 				case "cancel":
 					// cancel button was clicked
 					fallthrough
@@ -218,14 +543,22 @@ func RegisterAdminPages() {
 					if err = building.BuildBoardListJSON(); err != nil {
 						return "", err
 					}
-					if err = building.BuildBoards(false); err != nil {
+					// every board's navigation links to the other boards, so they are all rebuilt
+					var boards []gcsql.Board
+					if boards, err = gcsql.GetAllBoards(false); err != nil {
+						errEv.Err(err).Caller().Send()
 						return "", err
 					}
+					for b := range boards {
+						building.QueueFullBoard(&boards[b])
+					}
+					building.QueueFrontPage()
 				}
 				pageBuffer := bytes.NewBufferString("")
 				if err = serverutil.MinifyTemplate(gctemplates.ManageBoards,
 					map[string]interface{}{
 						"siteConfig":  config.GetSiteConfig(),
+						"csrfToken":   staff.CSRFToken,
 						"sections":    gcsql.AllSections,
 						"boards":      gcsql.AllBoards,
 						"boardConfig": config.GetBoardConfig(""),
@@ -238,16 +571,40 @@ func RegisterAdminPages() {
 
 				return pageBuffer.String(), nil
 			}},
+		Action{
+			ID:          "config",
+			Title:       "Configuration",
+			Permissions: AdminPerms,
+			Capability:  gcsql.CapConfigEdit,
+			Callback:    configCallback,
+		},
+		Action{
+			ID:          "banpresets",
+			Title:       "Ban presets",
+			Permissions: AdminPerms,
+			Capability:  gcsql.CapBanPresets,
+			JSONoutput:  OptionalJSON,
+			Callback:    banPresetsCallback,
+		},
+		Action{
+			ID:          "reportcategories",
+			Title:       "Report categories",
+			Permissions: AdminPerms,
+			Capability:  gcsql.CapReportCategories,
+			JSONoutput:  OptionalJSON,
+			Callback:    reportCategoriesCallback,
+		},
 		Action{
 			ID:          "boardsections",
 			Title:       "Board sections",
 			Permissions: AdminPerms,
+			Capability:  gcsql.CapBoardEdit,
 			JSONoutput:  OptionalJSON,
 			Callback: func(writer http.ResponseWriter, request *http.Request, staff *gcsql.Staff, wantsJSON bool, infoEv *zerolog.Event, errEv *zerolog.Event) (output interface{}, err error) {
 				section := &gcsql.Section{}
 				editID := request.Form.Get("edit")
-				updateID := request.Form.Get("updatesection")
-				deleteID := request.Form.Get("delete")
+				updateID := request.PostForm.Get("updatesection")
+				deleteID := request.PostForm.Get("delete")
 				if editID != "" {
 					if section, err = gcsql.GetSectionFromID(gcutil.HackyStringToInt(editID)); err != nil {
 						errEv.Err(err).Caller().Send()
@@ -275,6 +632,11 @@ func RegisterAdminPages() {
 							Message:    err.Error(),
 						}
 					}
+					LogStaffAction(staff, gcsql.ModLogEntry{
+						Action:     gcsql.ModLogSectionDelete,
+						TargetType: gcsql.ModLogTargetSection,
+						TargetID:   gcutil.HackyStringToInt(deleteID),
+					}, nil, nil)
 				}
 
 				if request.PostForm.Get("save_section") != "" {
@@ -282,6 +644,7 @@ func RegisterAdminPages() {
 					if section == nil {
 						section = &gcsql.Section{}
 					}
+					oldSection := *section
 					section.Name = request.PostForm.Get("sectionname")
 					section.Abbreviation = request.PostForm.Get("sectionabbr")
 					section.Hidden = request.PostForm.Get("sectionhidden") == "on"
@@ -300,12 +663,17 @@ func RegisterAdminPages() {
 							Message:    err.Error(),
 						}
 					}
+					logEntry := gcsql.ModLogEntry{TargetType: gcsql.ModLogTargetSection}
+					var logBefore interface{}
 					if updateID != "" {
 						// submitting changes to the section
 						err = section.UpdateValues()
+						logEntry.Action = gcsql.ModLogSectionEdit
+						logBefore = oldSection
 					} else {
 						// creating a new section
 						section, err = gcsql.NewSection(section.Name, section.Abbreviation, section.Hidden, section.Position)
+						logEntry.Action = gcsql.ModLogSectionCreate
 					}
 					if err != nil {
 						errEv.Err(err).Caller().Send()
@@ -315,6 +683,8 @@ func RegisterAdminPages() {
 							Message:    err.Error(),
 						}
 					}
+					logEntry.TargetID = section.ID
+					LogStaffAction(staff, logEntry, logBefore, section)
 					gcsql.ResetBoardSectionArrays()
 				}
 
@@ -326,6 +696,7 @@ func RegisterAdminPages() {
 				pageBuffer := bytes.NewBufferString("")
 				pageMap := map[string]interface{}{
 					"siteConfig": config.GetSiteConfig(),
+					"csrfToken":  staff.CSRFToken,
 					"sections":   sections,
 				}
 				if section.ID > 0 {
@@ -342,12 +713,18 @@ func RegisterAdminPages() {
 			ID:          "rebuildfront",
 			Title:       "Rebuild front page",
 			Permissions: AdminPerms,
+			Capability:  gcsql.CapSiteRebuild,
 			JSONoutput:  OptionalJSON,
 			Callback: func(writer http.ResponseWriter, request *http.Request, staff *gcsql.Staff, wantsJSON bool, infoEv *zerolog.Event, errEv *zerolog.Event) (output interface{}, err error) {
 				if err = gctemplates.InitTemplates(); err != nil {
 					return "", err
 				}
-				err = building.BuildFrontPage()
+				if err = building.BuildFrontPage(); err == nil {
+					LogStaffAction(staff, gcsql.ModLogEntry{
+						Action:     gcsql.ModLogSiteRebuild,
+						TargetType: gcsql.ModLogTargetSite,
+					}, nil, "front")
+				}
 				if wantsJSON {
 					return map[string]string{
 						"front": "Built front page successfully",
@@ -359,6 +736,7 @@ func RegisterAdminPages() {
 			ID:          "rebuildall",
 			Title:       "Rebuild everything",
 			Permissions: AdminPerms,
+			Capability:  gcsql.CapSiteRebuild,
 			JSONoutput:  OptionalJSON,
 			Callback: func(writer http.ResponseWriter, request *http.Request, staff *gcsql.Staff, wantsJSON bool, infoEv *zerolog.Event, errEv *zerolog.Event) (output interface{}, err error) {
 				gctemplates.InitTemplates()
@@ -405,6 +783,10 @@ func RegisterAdminPages() {
 					}
 					return buildErr.Message, buildErr
 				}
+				LogStaffAction(staff, gcsql.ModLogEntry{
+					Action:     gcsql.ModLogSiteRebuild,
+					TargetType: gcsql.ModLogTargetSite,
+				}, nil, "all")
 				if wantsJSON {
 					return buildMap, nil
 				}
@@ -418,6 +800,7 @@ func RegisterAdminPages() {
 			ID:          "rebuildboards",
 			Title:       "Rebuild boards",
 			Permissions: AdminPerms,
+			Capability:  gcsql.CapSiteRebuild,
 			JSONoutput:  OptionalJSON,
 			Callback: func(writer http.ResponseWriter, request *http.Request, staff *gcsql.Staff, wantsJSON bool, infoEv *zerolog.Event, errEv *zerolog.Event) (output interface{}, err error) {
 				if err = gctemplates.InitTemplates(); err != nil {
@@ -428,6 +811,10 @@ func RegisterAdminPages() {
 				if err != nil {
 					return "", err
 				}
+				LogStaffAction(staff, gcsql.ModLogEntry{
+					Action:     gcsql.ModLogSiteRebuild,
+					TargetType: gcsql.ModLogTargetSite,
+				}, nil, "boards")
 				if wantsJSON {
 					return map[string]interface{}{
 						"success": true,
@@ -436,12 +823,39 @@ func RegisterAdminPages() {
 				}
 				return "Boards built successfully", nil
 			}},
+		Action{
+			ID:          "buildqueue",
+			Title:       "Build queue",
+			Permissions: AdminPerms,
+			Capability:  gcsql.CapSiteRebuild,
+			JSONoutput:  OptionalJSON,
+			Callback: func(writer http.ResponseWriter, request *http.Request, staff *gcsql.Staff, wantsJSON bool, infoEv *zerolog.Event, errEv *zerolog.Event) (output interface{}, err error) {
+				status := building.GetBuildQueueStatus()
+				if wantsJSON {
+					return status, nil
+				}
+				queueBuffer := bytes.NewBufferString("")
+				if err = serverutil.MinifyTemplate(gctemplates.ManageBuildQueue, map[string]interface{}{
+					"status": status,
+				}, queueBuffer, "text/html"); err != nil {
+					errEv.Err(err).Caller().Send()
+					return "", err
+				}
+				return queueBuffer.String(), nil
+			}},
 		Action{
 			ID:          "reparsehtml",
 			Title:       "Reparse HTML",
 			Permissions: AdminPerms,
+			Capability:  gcsql.CapSiteRebuild,
 			Callback: func(writer http.ResponseWriter, request *http.Request, staff *gcsql.Staff, wantsJSON bool, infoEv *zerolog.Event, errEv *zerolog.Event) (output interface{}, err error) {
 				var outputStr string
+				// reload the board configurations so that posts are formatted with each board's current
+				// formatting mode
+				if err = gcsql.ResetBoardSectionArrays(); err != nil {
+					errEv.Err(err).Caller().Send()
+					return "", err
+				}
 				tx, err := gcsql.BeginTx()
 				if err != nil {
 					errEv.Err(err).Msg("Unable to begin transaction")
@@ -454,7 +868,7 @@ func RegisterAdminPages() {
 					(SELECT board_id FROM DBPREFIXthreads WHERE id = threadid) AS boardid,
 					(SELECT dir FROM DBPREFIXboards WHERE id = boardid) AS dir
 					FROM DBPREFIXposts WHERE is_deleted = FALSE`
-				const updateQuery = `UPDATE DBPREFIXposts SET message = ? WHERE id = ?`
+				const updateQuery = `UPDATE DBPREFIXposts SET message = ?, last_modified = CURRENT_TIMESTAMP WHERE id = ?`
 
 				stmt, err := gcsql.PrepareSQL(query, tx)
 				if err != nil {
@@ -475,10 +889,21 @@ func RegisterAdminPages() {
 						errEv.Err(err).Caller().Msg("Unable to scan SQL row")
 						return "", err
 					}
-					formatted := posting.FormatMessage(messageRaw, boardDir)
+					formatted, references := posting.FormatMessage(messageRaw, boardDir)
 					gcsql.ExecSQL(updateQuery, formatted, postID)
+					post := gcsql.Post{ID: postID}
+					if err = post.SetReferences(references); err != nil {
+						errEv.Err(err).Caller().
+							Int("postID", postID).
+							Msg("Unable to update post references")
+						return "", err
+					}
 				}
 				outputStr += "Done reparsing HTML<hr />"
+				LogStaffAction(staff, gcsql.ModLogEntry{
+					Action:     gcsql.ModLogSiteReparse,
+					TargetType: gcsql.ModLogTargetSite,
+				}, nil, nil)
 
 				if err = building.BuildFrontPage(); err != nil {
 					return "", err
@@ -500,10 +925,11 @@ func RegisterAdminPages() {
 			ID:          "wordfilters",
 			Title:       "Wordfilters",
 			Permissions: AdminPerms,
+			Capability:  gcsql.CapWordfilterManage,
 			Callback: func(writer http.ResponseWriter, request *http.Request, staff *gcsql.Staff, wantsJSON bool, infoEv *zerolog.Event, errEv *zerolog.Event) (output interface{}, err error) {
 				managePageBuffer := bytes.NewBufferString("")
 				editIDstr := request.FormValue("edit")
-				deleteIDstr := request.FormValue("delete")
+				deleteIDstr := request.PostFormValue("delete")
 				if deleteIDstr != "" {
 					var result sql.Result
 					if result, err = gcsql.ExecSQL(`DELETE FROM DBPREFIXwordfilters WHERE id = ?`, deleteIDstr); err != nil {
@@ -515,9 +941,15 @@ func RegisterAdminPages() {
 						return err, err
 					}
 					infoEv.Str("deletedWordfilterID", deleteIDstr)
+					LogStaffAction(staff, gcsql.ModLogEntry{
+						Action:     gcsql.ModLogWordfilterDelete,
+						TargetType: gcsql.ModLogTargetWordfilter,
+						TargetID:   gcutil.HackyStringToInt(deleteIDstr),
+					}, nil, nil)
 				}
 
-				submitBtn := request.FormValue("dowordfilter")
+				submitBtn := request.PostFormValue("dowordfilter")
+				logEntry := gcsql.ModLogEntry{TargetType: gcsql.ModLogTargetWordfilter}
 				switch submitBtn {
 				case "Edit wordfilter":
 					regexCheckStr := request.FormValue("isregex")
@@ -540,8 +972,11 @@ func RegisterAdminPages() {
 						request.FormValue("replace"),
 						editIDstr)
 					infoEv.Str("do", "update")
+					logEntry.Action = gcsql.ModLogWordfilterEdit
+					logEntry.TargetID = gcutil.HackyStringToInt(editIDstr)
 				case "Create new wordfilter":
-					_, err = gcsql.CreateWordFilter(
+					var filter *gcsql.Wordfilter
+					filter, err = gcsql.CreateWordFilter(
 						request.FormValue("find"),
 						request.FormValue("replace"),
 						request.FormValue("isregex") == "on",
@@ -549,6 +984,10 @@ func RegisterAdminPages() {
 						staff.ID,
 						request.FormValue("staffnote"))
 					infoEv.Str("do", "create")
+					logEntry.Action = gcsql.ModLogWordfilterCreate
+					if err == nil {
+						logEntry.TargetID = filter.ID
+					}
 				case "":
 					infoEv.Discard()
 				}
@@ -558,6 +997,15 @@ func RegisterAdminPages() {
 						Str("replace", request.FormValue("replace")).
 						Str("staffnote", request.FormValue("staffnote")).
 						Str("boarddirs", request.FormValue("boarddirs"))
+					if logEntry.Action != "" {
+						LogStaffAction(staff, logEntry, nil, map[string]interface{}{
+							"find":      request.FormValue("find"),
+							"replace":   request.FormValue("replace"),
+							"isRegex":   request.FormValue("isregex") == "on",
+							"boardDirs": request.FormValue("boarddirs"),
+							"staffNote": request.FormValue("staffnote"),
+						})
+					}
 				} else {
 					return err, err
 				}
@@ -579,6 +1027,7 @@ func RegisterAdminPages() {
 				}
 				filterMap := map[string]interface{}{
 					"wordfilters": wordfilters,
+					"csrfToken":   staff.CSRFToken,
 					"edit":        editFilter,
 				}
 
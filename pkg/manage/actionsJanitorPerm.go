@@ -102,18 +102,33 @@ func RegisterJanitorPages() {
 						return "", err
 					}
 				}
+				boards, globalStaff, err := moderatedBoards(staff)
+				if err != nil {
+					errEv.Err(err).Caller().Msg("Unable to get staff board assignments")
+					return "", err
+				}
+				if boardid > 0 {
+					if err = checkBoardPermission(staff, boardid); err != nil {
+						errEv.Err(err).Caller().
+							Int("boardid", boardid).Send()
+						return "", err
+					}
+				}
 				recentposts, err = building.GetRecentPosts(boardid, limit)
 				if err != nil {
 					errEv.Err(err).Caller().Send()
 					return "", err
 				}
+				if !globalStaff && boardid == 0 {
+					recentposts = filterModeratedPosts(recentposts, boards)
+				}
 				if wantsJSON {
 					return recentposts, nil
 				}
 				manageRecentsBuffer := bytes.NewBufferString("")
 				if err = serverutil.MinifyTemplate(gctemplates.ManageRecentPosts, map[string]interface{}{
 					"recentposts": recentposts,
-					"allBoards":   gcsql.AllBoards,
+					"allBoards":   boards,
 					"boardid":     boardid,
 					"limit":       limit,
 				}, manageRecentsBuffer, "text/html"); err != nil {
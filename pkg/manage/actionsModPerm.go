@@ -12,6 +12,7 @@ import (
 
 	"github.com/gochan-org/gochan/pkg/building"
 	"github.com/gochan-org/gochan/pkg/config"
+	"github.com/gochan-org/gochan/pkg/events"
 	"github.com/gochan-org/gochan/pkg/gcsql"
 	"github.com/gochan-org/gochan/pkg/gctemplates"
 	"github.com/gochan-org/gochan/pkg/gcutil"
@@ -27,11 +28,17 @@ func RegisterModeratorPages() {
 			ID:          "bans",
 			Title:       "Bans",
 			Permissions: ModPerms,
+			Capability:  gcsql.CapBanCreate,
 			Callback: func(writer http.ResponseWriter, request *http.Request, staff *gcsql.Staff, wantsJSON bool, infoEv *zerolog.Event, errEv *zerolog.Event) (output interface{}, err error) {
 				var outputStr string
 				var ban gcsql.IPBan
 				ban.StaffID = staff.ID
-				deleteIDStr := request.FormValue("delete")
+				boards, globalStaff, err := moderatedBoards(staff)
+				if err != nil {
+					errEv.Err(err).Caller().Msg("Unable to get staff board assignments")
+					return "", err
+				}
+				deleteIDStr := request.PostFormValue("delete")
 				if deleteIDStr != "" {
 					// deleting a ban
 					ban.ID, err = strconv.Atoi(deleteIDStr)
@@ -41,15 +48,37 @@ func RegisterModeratorPages() {
 							Caller().Send()
 						return "", err
 					}
+					existing, err := gcsql.GetIPBanByID(ban.ID)
+					if err != nil {
+						errEv.Err(err).
+							Int("deleteBan", ban.ID).
+							Caller().Send()
+						return "", err
+					}
+					if err = checkBanPermission(staff, existing.BoardID); err == nil {
+						err = checkCapability(staff, gcsql.CapBanDelete)
+					}
+					if err != nil {
+						errEv.Err(err).
+							Int("deleteBan", ban.ID).
+							Caller().Send()
+						return "", err
+					}
 					if err = ban.Deactivate(staff.ID); err != nil {
 						errEv.Err(err).
 							Int("deleteBan", ban.ID).
 							Caller().Send()
 						return "", err
 					}
+					LogStaffAction(staff, gcsql.ModLogEntry{
+						Action:     gcsql.ModLogBanDelete,
+						TargetType: gcsql.ModLogTargetBan,
+						TargetID:   ban.ID,
+						BoardID:    existing.BoardID,
+					}, existing, nil)
 
-				} else if request.FormValue("do") == "add" {
-					err := ipBanFromRequest(&ban, request, errEv)
+				} else if request.PostFormValue("do") == "add" {
+					err := ipBanFromRequest(&ban, request, staff, errEv)
 					if err != nil {
 						errEv.Err(err).
 							Str("banIP", ban.IP).
@@ -64,6 +93,12 @@ func RegisterModeratorPages() {
 						Bool("permanent", ban.Permanent).
 						Str("reason", ban.Message).
 						Msg("Added IP ban")
+					LogStaffAction(staff, gcsql.ModLogEntry{
+						Action:     gcsql.ModLogBanCreate,
+						TargetType: gcsql.ModLogTargetBan,
+						TargetID:   ban.ID,
+						BoardID:    ban.BoardID,
+					}, nil, ban)
 				}
 
 				filterBoardIDstr := request.FormValue("filterboardid")
@@ -90,11 +125,33 @@ func RegisterModeratorPages() {
 					err = errors.New("Error getting ban list: " + err.Error())
 					return "", err
 				}
+				if !globalStaff {
+					boardIDs, err := staff.ModeratedBoardIDs()
+					if err != nil {
+						errEv.Err(err).Caller().Msg("Unable to get staff board assignments")
+						return "", err
+					}
+					var moderatedBans []gcsql.IPBan
+					for _, listedBan := range banlist {
+						if canModerateBan(boardIDs, listedBan.BoardID) {
+							moderatedBans = append(moderatedBans, listedBan)
+						}
+					}
+					banlist = moderatedBans
+				}
+				presets, err := gcsql.GetBanPresets()
+				if err != nil {
+					errEv.Err(err).Caller().Msg("Unable to get ban presets")
+					return "", err
+				}
 				manageBansBuffer := bytes.NewBufferString("")
 
 				if err = serverutil.MinifyTemplate(gctemplates.ManageBans, map[string]interface{}{
+					"csrfToken":     staff.CSRFToken,
+					"presets":       presets,
 					"banlist":       banlist,
-					"allBoards":     gcsql.AllBoards,
+					"allBoards":     boards,
+					"globalStaff":   globalStaff,
 					"ban":           ban,
 					"filterboardid": filterBoardID,
 				}, manageBansBuffer, "text/html"); err != nil {
@@ -108,66 +165,18 @@ func RegisterModeratorPages() {
 			ID:          "appeals",
 			Title:       "Ban appeals",
 			Permissions: ModPerms,
+			Capability:  gcsql.CapAppealManage,
 			JSONoutput:  OptionalJSON,
-			Callback: func(writer http.ResponseWriter, request *http.Request, staff *gcsql.Staff, wantsJSON bool, infoEv, errEv *zerolog.Event) (output interface{}, err error) {
-				banIDstr := request.FormValue("banid")
-				var banID int
-				if banIDstr != "" {
-					if banID, err = strconv.Atoi(banIDstr); err != nil {
-						errEv.Err(err).Caller().Send()
-						return "", err
-					}
-				}
-				infoEv.Int("banID", banID)
-
-				limitStr := request.FormValue("limit")
-				limit := 20
-				if limitStr != "" {
-					if limit, err = strconv.Atoi(limitStr); err != nil {
-						errEv.Err(err).Caller().Send()
-						return "", err
-					}
-				}
-				approveStr := request.FormValue("approve")
-				if approveStr != "" {
-					// approving an appeal
-					approveID, err := strconv.Atoi(approveStr)
-					if err != nil {
-						errEv.Err(err).
-							Str("approveStr", approveStr).Caller().Send()
-					}
-					if err = gcsql.ApproveAppeal(approveID, staff.ID); err != nil {
-						errEv.Err(err).
-							Int("approveAppeal", approveID).
-							Caller().Send()
-						return "", err
-					}
-				}
-
-				appeals, err := gcsql.GetAppeals(banID, limit)
-				if err != nil {
-					errEv.Err(err).Caller().Send()
-					return "", errors.New("Unable to get appeals: " + err.Error())
-				}
-
-				manageAppealsBuffer := bytes.NewBufferString("")
-				pageData := map[string]interface{}{}
-				if len(appeals) > 0 {
-					pageData["appeals"] = appeals
-				}
-				if err = serverutil.MinifyTemplate(gctemplates.ManageAppeals, pageData, manageAppealsBuffer, "text/html"); err != nil {
-					errEv.Err(err).Str("template", "manage_appeals.html").Caller().Send()
-					return "", errors.New("Error executing appeal management page template: " + err.Error())
-				}
-				return manageAppealsBuffer.String(), err
-			}},
+			Callback:    appealsCallback,
+		},
 		Action{
 			ID:          "filebans",
 			Title:       "Filename and checksum bans",
 			Permissions: ModPerms,
+			Capability:  gcsql.CapBanCreate,
 			Callback: func(writer http.ResponseWriter, request *http.Request, staff *gcsql.Staff, wantsJSON bool, infoEv, errEv *zerolog.Event) (output interface{}, err error) {
-				delFilenameBanIDStr := request.FormValue("delfnb") // filename ban deletion
-				delChecksumBanIDStr := request.FormValue("delcsb") // checksum ban deletion
+				delFilenameBanIDStr := request.PostFormValue("delfnb") // filename ban deletion
+				delChecksumBanIDStr := request.PostFormValue("delcsb") // checksum ban deletion
 
 				boardidStr := request.FormValue("boardid")
 				boardid := 0
@@ -181,8 +190,21 @@ func RegisterModeratorPages() {
 					}
 				}
 				staffnote := request.FormValue("staffnote")
+				boardIDs, err := staff.ModeratedBoardIDs()
+				if err != nil {
+					errEv.Err(err).Caller().Msg("Unable to get staff board assignments")
+					return "", err
+				}
+				if request.PostFormValue("dofilenameban") != "" || request.PostFormValue("dochecksumban") != "" {
+					if !canModerateBan(boardIDs, &boardid) {
+						errEv.Err(ErrBoardPermission).
+							Int("boardid", boardid).
+							Caller().Send()
+						return "", ErrBoardPermission
+					}
+				}
 
-				if request.FormValue("dofilenameban") != "" {
+				if request.PostFormValue("dofilenameban") != "" {
 					// creating a new filename ban
 					filename := request.FormValue("filename")
 					isRegex := request.FormValue("isregex") == "on"
@@ -196,13 +218,20 @@ func RegisterModeratorPages() {
 							return "", err
 						}
 					}
-					if _, err = gcsql.NewFilenameBan(filename, isRegex, boardid, staff.ID, staffnote); err != nil {
+					fnb, err := gcsql.NewFilenameBan(filename, isRegex, boardid, staff.ID, staffnote)
+					if err != nil {
 						errEv.Err(err).
 							Str("filename", filename).
 							Bool("isregex", isRegex).
 							Caller().Send()
 						return "", err
 					}
+					LogStaffAction(staff, gcsql.ModLogEntry{
+						Action:     gcsql.ModLogFileBanCreate,
+						TargetType: gcsql.ModLogTargetBan,
+						TargetID:   fnb.ID,
+						BoardID:    &boardid,
+					}, nil, fnb)
 					infoEv.
 						Str("filename", filename).
 						Bool("isregex", isRegex).
@@ -216,6 +245,18 @@ func RegisterModeratorPages() {
 							Caller().Send()
 						return "", err
 					}
+					banBoardID, err := gcsql.GetFilenameBanBoardID(delFilenameBanID)
+					if err == nil && !canModerateBan(boardIDs, banBoardID) {
+						err = ErrBoardPermission
+					} else if err == nil {
+						err = checkCapability(staff, gcsql.CapBanDelete)
+					}
+					if err != nil {
+						errEv.Err(err).
+							Int("deleteFilenameBanID", delFilenameBanID).
+							Caller().Send()
+						return "", err
+					}
 					var fnb gcsql.FilenameBan
 					fnb.ID = delFilenameBanID
 					if err = fnb.Deactivate(staff.ID); err != nil {
@@ -228,15 +269,28 @@ func RegisterModeratorPages() {
 						Int("deleteFilenameBanID", delFilenameBanID).
 						Int("boardid", boardid).
 						Msg("Filename ban deleted")
-				} else if request.FormValue("dochecksumban") != "" {
+					LogStaffAction(staff, gcsql.ModLogEntry{
+						Action:     gcsql.ModLogFileBanDelete,
+						TargetType: gcsql.ModLogTargetBan,
+						TargetID:   delFilenameBanID,
+						BoardID:    banBoardID,
+					}, nil, nil)
+				} else if request.PostFormValue("dochecksumban") != "" {
 					// creating a new file checksum ban
 					checksum := request.FormValue("checksum")
-					if _, err = gcsql.NewFileChecksumBan(checksum, boardid, staff.ID, staffnote); err != nil {
+					fileBan, err := gcsql.NewFileChecksumBan(checksum, boardid, staff.ID, staffnote)
+					if err != nil {
 						errEv.Err(err).
 							Str("checksum", checksum).
 							Caller().Send()
 						return "", err
 					}
+					LogStaffAction(staff, gcsql.ModLogEntry{
+						Action:     gcsql.ModLogFileBanCreate,
+						TargetType: gcsql.ModLogTargetBan,
+						TargetID:   fileBan.ID,
+						BoardID:    &boardid,
+					}, nil, fileBan)
 					infoEv.
 						Str("checksum", checksum).
 						Int("boardid", boardid).
@@ -250,6 +304,18 @@ func RegisterModeratorPages() {
 							Caller().Send()
 						return "", err
 					}
+					banBoardID, err := gcsql.GetFileBanBoardID(delChecksumBanID)
+					if err == nil && !canModerateBan(boardIDs, banBoardID) {
+						err = ErrBoardPermission
+					} else if err == nil {
+						err = checkCapability(staff, gcsql.CapBanDelete)
+					}
+					if err != nil {
+						errEv.Err(err).
+							Int("deleteChecksumBanID", delChecksumBanID).
+							Caller().Send()
+						return "", err
+					}
 					if err = (gcsql.FileBan{ID: delChecksumBanID}).Deactivate(staff.ID); err != nil {
 						errEv.Err(err).
 							Int("deleteChecksumBanID", delChecksumBanID).
@@ -257,6 +323,12 @@ func RegisterModeratorPages() {
 						return "", err
 					}
 					infoEv.Int("deleteChecksumBanID", delChecksumBanID).Msg("File checksum ban deleted")
+					LogStaffAction(staff, gcsql.ModLogEntry{
+						Action:     gcsql.ModLogFileBanDelete,
+						TargetType: gcsql.ModLogTargetBan,
+						TargetID:   delChecksumBanID,
+						BoardID:    banBoardID,
+					}, nil, nil)
 				}
 				filterBoardIDstr := request.FormValue("filterboardid")
 				var filterBoardID int
@@ -284,10 +356,32 @@ func RegisterModeratorPages() {
 				if err != nil {
 					return "", err
 				}
+				boards, globalStaff, err := moderatedBoards(staff)
+				if err != nil {
+					return "", err
+				}
+				if !globalStaff {
+					var moderatedChecksumBans []gcsql.FileBan
+					for _, ban := range checksumBans {
+						if canModerateBan(boardIDs, ban.BoardID) {
+							moderatedChecksumBans = append(moderatedChecksumBans, ban)
+						}
+					}
+					checksumBans = moderatedChecksumBans
+					var moderatedFilenameBans []gcsql.FilenameBan
+					for _, ban := range filenameBans {
+						if canModerateBan(boardIDs, ban.BoardID) {
+							moderatedFilenameBans = append(moderatedFilenameBans, ban)
+						}
+					}
+					filenameBans = moderatedFilenameBans
+				}
 				manageBansBuffer := bytes.NewBufferString("")
 
 				if err = serverutil.MinifyTemplate(gctemplates.ManageFileBans, map[string]interface{}{
-					"allBoards":     gcsql.AllBoards,
+					"csrfToken":     staff.CSRFToken,
+					"allBoards":     boards,
+					"globalStaff":   globalStaff,
 					"checksumBans":  checksumBans,
 					"filenameBans":  filenameBans,
 					"filterboardid": filterBoardID,
@@ -302,9 +396,20 @@ func RegisterModeratorPages() {
 			ID:          "namebans",
 			Title:       "Name bans",
 			Permissions: ModPerms,
+			Capability:  gcsql.CapBanCreate,
 			Callback: func(writer http.ResponseWriter, request *http.Request, staff *gcsql.Staff, wantsJSON bool, infoEv, errEv *zerolog.Event) (output interface{}, err error) {
-				doNameBan := request.FormValue("donameban")
-				deleteIDstr := request.FormValue("del")
+				doNameBan := request.PostFormValue("donameban")
+				deleteIDstr := request.PostFormValue("del")
+				boards, globalStaff, err := moderatedBoards(staff)
+				if err != nil {
+					errEv.Err(err).Caller().Msg("Unable to get staff board assignments")
+					return "", err
+				}
+				boardIDs, err := staff.ModeratedBoardIDs()
+				if err != nil {
+					errEv.Err(err).Caller().Msg("Unable to get staff board assignments")
+					return "", err
+				}
 				if deleteIDstr != "" {
 					deleteID, err := strconv.Atoi(deleteIDstr)
 					if err != nil {
@@ -313,16 +418,36 @@ func RegisterModeratorPages() {
 							Caller().Send()
 						return "", err
 					}
+					banBoardID, err := gcsql.GetNameBanBoardID(deleteID)
+					if err == nil && !canModerateBan(boardIDs, banBoardID) {
+						err = ErrBoardPermission
+					} else if err == nil {
+						err = checkCapability(staff, gcsql.CapBanDelete)
+					}
+					if err != nil {
+						errEv.Err(err).
+							Int("deleteID", deleteID).
+							Caller().Send()
+						return "", err
+					}
 					if err = gcsql.DeleteNameBan(deleteID); err != nil {
 						errEv.Err(err).
 							Int("deleteID", deleteID).
 							Caller().Msg("Unable to delete name ban")
 						return "", errors.New("Unable to delete name ban: " + err.Error())
 					}
+					LogStaffAction(staff, gcsql.ModLogEntry{
+						Action:     gcsql.ModLogNameBanDelete,
+						TargetType: gcsql.ModLogTargetBan,
+						TargetID:   deleteID,
+						BoardID:    banBoardID,
+					}, nil, nil)
 				}
 				data := map[string]interface{}{
 					"currentStaff": staff.Username,
-					"allBoards":    gcsql.AllBoards,
+					"csrfToken":    staff.CSRFToken,
+					"allBoards":    boards,
+					"globalStaff":  globalStaff,
 				}
 				if doNameBan == "Create" {
 					var name string
@@ -336,17 +461,41 @@ func RegisterModeratorPages() {
 					if boardID, err = getIntField("boardid", staff.Username, request); err != nil {
 						return "", err
 					}
+					if !canModerateBan(boardIDs, &boardID) {
+						errEv.Err(ErrBoardPermission).
+							Int("boardID", boardID).
+							Caller().Send()
+						return "", ErrBoardPermission
+					}
 					isRegex := request.FormValue("isregex") == "on"
-					if _, err = gcsql.NewNameBan(name, isRegex, boardID, staff.ID, request.FormValue("staffnote")); err != nil {
+					nameBan, err := gcsql.NewNameBan(name, isRegex, boardID, staff.ID, request.FormValue("staffnote"))
+					if err != nil {
 						errEv.Err(err).
 							Str("name", name).
 							Int("boardID", boardID)
 						return "", err
 					}
+					LogStaffAction(staff, gcsql.ModLogEntry{
+						Action:     gcsql.ModLogNameBanCreate,
+						TargetType: gcsql.ModLogTargetBan,
+						TargetID:   nameBan.ID,
+						BoardID:    &boardID,
+					}, nil, nameBan)
 				}
-				if data["nameBans"], err = gcsql.GetNameBans(0, 0); err != nil {
+				nameBans, err := gcsql.GetNameBans(0, 0)
+				if err != nil {
 					return "", err
 				}
+				if !globalStaff {
+					var moderatedNameBans []gcsql.UsernameBan
+					for _, ban := range nameBans {
+						if canModerateBan(boardIDs, ban.BoardID) {
+							moderatedNameBans = append(moderatedNameBans, ban)
+						}
+					}
+					nameBans = moderatedNameBans
+				}
+				data["nameBans"] = nameBans
 				buf := bytes.NewBufferString("")
 				if err = serverutil.MinifyTemplate(gctemplates.ManageNameBans, data, buf, "text/html"); err != nil {
 					errEv.Err(err).Str("template", "manage_namebans.html").Caller().Send()
@@ -358,6 +507,7 @@ func RegisterModeratorPages() {
 			ID:          "ipsearch",
 			Title:       "IP Search",
 			Permissions: ModPerms,
+			Capability:  gcsql.CapIPView,
 			JSONoutput:  NoJSON,
 			Callback: func(writer http.ResponseWriter, request *http.Request, staff *gcsql.Staff, wantsJSON bool, infoEv *zerolog.Event, errEv *zerolog.Event) (output interface{}, err error) {
 				ipQuery := request.FormValue("ip")
@@ -379,7 +529,7 @@ func RegisterModeratorPages() {
 						data["reverseAddrs"] = []string{err.Error()}
 					}
 
-					data["posts"], err = building.GetBuildablePostsByIP(ipQuery, limit)
+					posts, err := building.GetBuildablePostsByIP(ipQuery, limit)
 					if err != nil {
 						errEv.Err(err).
 							Str("ipQuery", ipQuery).
@@ -388,6 +538,21 @@ func RegisterModeratorPages() {
 							Caller().Send()
 						return "", fmt.Errorf("Error getting list of posts from %q by staff %s: %s", ipQuery, staff.Username, err.Error())
 					}
+					boards, globalStaff, err := moderatedBoards(staff)
+					if err != nil {
+						errEv.Err(err).Caller().Msg("Unable to get staff board assignments")
+						return "", err
+					}
+					if !globalStaff {
+						posts = filterModeratedPosts(posts, boards)
+					}
+					data["posts"] = posts
+					if data["warnings"], err = moderatedWarningsByIP(staff, ipQuery); err != nil {
+						errEv.Err(err).
+							Str("ipQuery", ipQuery).
+							Caller().Msg("Unable to get warnings")
+						return "", err
+					}
 				}
 
 				manageIpBuffer := bytes.NewBufferString("")
@@ -400,102 +565,90 @@ func RegisterModeratorPages() {
 				return manageIpBuffer.String(), nil
 			}},
 		Action{
-			ID:          "reports",
-			Title:       "Reports",
+			ID:          "purgeip",
+			Title:       "Delete posts by IP",
+			Permissions: ModPerms,
+			Capability:  gcsql.CapPostDelete,
+			JSONoutput:  OptionalJSON,
+			Callback:    ipPurgeCallback,
+		},
+		Action{
+			ID:          "search",
+			Title:       "Search posts",
 			Permissions: ModPerms,
+			Capability:  gcsql.CapIPView,
 			JSONoutput:  OptionalJSON,
 			Callback: func(writer http.ResponseWriter, request *http.Request, staff *gcsql.Staff, wantsJSON bool, infoEv *zerolog.Event, errEv *zerolog.Event) (output interface{}, err error) {
-				dismissIDstr := request.FormValue("dismiss")
-				if dismissIDstr != "" {
-					// staff is dismissing a report
-					dismissID := gcutil.HackyStringToInt(dismissIDstr)
-					block := request.FormValue("block")
-					if block != "" && staff.Rank != 3 {
-						errEv.
-							Int("postID", dismissID).
-							Str("rejected", "not an admin").
-							Caller().Send()
-						return "", errors.New("only the administrator can block reports")
-					}
-					found, err := gcsql.ClearReport(dismissID, staff.ID, block != "" && staff.Rank == 3)
-					if err != nil {
-						errEv.Err(err).
-							Int("postID", dismissID).
-							Caller().Send()
-						return nil, err
-					}
-					if !found {
-						return nil, errors.New("no matching reports")
-					}
-					infoEv.
-						Int("reportID", dismissID).
-						Bool("blocked", block != "").
-						Msg("Report cleared")
-				}
-				rows, err := gcsql.QuerySQL(`SELECT id,
-					handled_by_staff_id as staff_id,
-					(SELECT username FROM DBPREFIXstaff WHERE id = DBPREFIXreports.handled_by_staff_id) as staff_user,
-					post_id, ip, reason, is_cleared from DBPREFIXreports WHERE is_cleared = FALSE`)
+				search, err := building.PostSearchFromRequest(request)
 				if err != nil {
-					return nil, err
-				}
-				defer rows.Close()
-				reports := make([]map[string]interface{}, 0)
-				for rows.Next() {
-					var id int
-					var staff_id interface{}
-					var staff_user []byte
-					var post_id int
-					var ip string
-					var reason string
-					var is_cleared int
-					err = rows.Scan(&id, &staff_id, &staff_user, &post_id, &ip, &reason, &is_cleared)
-					if err != nil {
-						return nil, err
-					}
-
-					post, err := gcsql.GetPostFromID(post_id, true)
-					if err != nil {
-						return nil, err
-					}
-
-					staff_id_int, _ := staff_id.(int64)
-					reports = append(reports, map[string]interface{}{
-						"id":         id,
-						"staff_id":   int(staff_id_int),
-						"staff_user": string(staff_user),
-						"post_link":  post.WebPath(),
-						"ip":         ip,
-						"reason":     reason,
-						"is_cleared": is_cleared,
-					})
+					errEv.Err(err).Caller().Send()
+					return "", err
 				}
-				if wantsJSON {
-					return reports, err
-				}
-				reportsBuffer := bytes.NewBufferString("")
-				err = serverutil.MinifyTemplate(gctemplates.ManageReports,
-					map[string]interface{}{
-						"reports": reports,
-						"staff":   staff,
-					}, reportsBuffer, "text/html")
+				search.IncludeDeleted = request.FormValue("deleted") != ""
+				if search.BoardIDs, err = staff.ModeratedBoardIDs(); err != nil {
+					errEv.Err(err).Caller().Msg("Unable to get staff board assignments")
+					return "", err
+				}
+				if search.BoardID > 0 && !canModerate(search.BoardIDs, search.BoardID) {
+					errEv.Err(ErrBoardPermission).Caller().
+						Int("boardID", search.BoardID).Send()
+					return "", ErrBoardPermission
+				}
+				data, err := building.SearchTemplateData(request, search, config.WebPath("manage/search"), true)
 				if err != nil {
-					errEv.Err(err).Caller().Send()
+					errEv.Err(err).Caller().
+						Str("query", search.Query).Send()
 					return "", err
 				}
-				output = reportsBuffer.String()
-				return
+				if wantsJSON {
+					posts, _ := data["posts"].([]building.Post)
+					if posts == nil {
+						posts = []building.Post{}
+					}
+					return posts, nil
+				}
+				buf := bytes.NewBufferString("")
+				if err = serverutil.MinifyTemplate(gctemplates.ManageSearch, data, buf, "text/html"); err != nil {
+					errEv.Err(err).
+						Str("template", "manage_search.html").
+						Caller().Send()
+					return "", errors.New("Error executing post search page template: " + err.Error())
+				}
+				return buf.String(), nil
 			}},
+		Action{
+			ID:          "reports",
+			Title:       "Reports",
+			Permissions: ModPerms,
+			Capability:  gcsql.CapReportManage,
+			JSONoutput:  OptionalJSON,
+			Callback:    reportsCallback,
+		},
+		Action{
+			ID:          "warnings",
+			Title:       "Warnings",
+			Permissions: ModPerms,
+			Capability:  gcsql.CapWarningCreate,
+			JSONoutput:  OptionalJSON,
+			Callback:    warningsCallback,
+		},
 		Action{
 			ID:          "threadattrs",
 			Title:       "View/Update Thread Attributes",
 			Permissions: ModPerms,
+			Capability:  gcsql.CapThreadManage,
 			JSONoutput:  OptionalJSON,
 			Callback: func(writer http.ResponseWriter, request *http.Request, staff *gcsql.Staff, wantsJSON bool, infoEv, errEv *zerolog.Event) (output interface{}, err error) {
 				boardDir := request.FormValue("board")
 				attrBuffer := bytes.NewBufferString("")
+				boards, _, err := moderatedBoards(staff)
+				if err != nil {
+					errEv.Err(err).Caller().Msg("Unable to get staff board assignments")
+					return "", err
+				}
 				data := map[string]interface{}{
-					"boards": gcsql.AllBoards,
+					"boards":    boards,
+					"csrfToken": staff.CSRFToken,
 				}
 				if boardDir == "" {
 					if wantsJSON {
@@ -513,6 +666,10 @@ func RegisterModeratorPages() {
 					errEv.Err(err).Caller().Send()
 					return "", err
 				}
+				if err = checkBoardPermission(staff, board.ID); err != nil {
+					errEv.Err(err).Caller().Send()
+					return "", err
+				}
 				data["board"] = board
 				topPostStr := request.FormValue("thread")
 				if topPostStr != "" {
@@ -531,35 +688,35 @@ func RegisterModeratorPages() {
 						errEv.Err(err).Caller().Send()
 						return "", err
 					}
-					if request.FormValue("unlock") != "" {
+					if request.PostFormValue("unlock") != "" {
 						attr = "locked"
 						newVal = false
 						doChange = thread.Locked != newVal
-					} else if request.FormValue("lock") != "" {
+					} else if request.PostFormValue("lock") != "" {
 						attr = "locked"
 						newVal = true
 						doChange = thread.Locked != newVal
-					} else if request.FormValue("unsticky") != "" {
+					} else if request.PostFormValue("unsticky") != "" {
 						attr = "stickied"
 						newVal = false
 						doChange = thread.Stickied != newVal
-					} else if request.FormValue("sticky") != "" {
+					} else if request.PostFormValue("sticky") != "" {
 						attr = "stickied"
 						newVal = true
 						doChange = thread.Stickied != newVal
-					} else if request.FormValue("unanchor") != "" {
+					} else if request.PostFormValue("unanchor") != "" {
 						attr = "anchored"
 						newVal = false
 						doChange = thread.Anchored != newVal
-					} else if request.FormValue("anchor") != "" {
+					} else if request.PostFormValue("anchor") != "" {
 						attr = "anchored"
 						newVal = true
 						doChange = thread.Anchored != newVal
-					} else if request.FormValue("uncyclical") != "" {
+					} else if request.PostFormValue("uncyclical") != "" {
 						attr = "cyclical"
 						newVal = false
 						doChange = thread.Cyclical != newVal
-					} else if request.FormValue("cyclical") != "" {
+					} else if request.PostFormValue("cyclical") != "" {
 						attr = "cyclical"
 						newVal = true
 						doChange = thread.Cyclical != newVal
@@ -568,22 +725,10 @@ func RegisterModeratorPages() {
 					if attr != "" && doChange {
 						gcutil.LogStr("attribute", attr, errEv, infoEv)
 						gcutil.LogBool("attrVal", newVal, errEv, infoEv)
-						if err = thread.UpdateAttribute(attr, newVal); err != nil {
+						if err = SetThreadAttribute(staff, thread, board, topPostID, attr, newVal); err != nil {
 							errEv.Err(err).Caller().Send()
 							return "", err
 						}
-						if err = building.BuildBoardPages(board); err != nil {
-							return "", err
-						}
-						post, err := gcsql.GetPostFromID(topPostID, true)
-						if err != nil {
-							errEv.Err(err).Caller().Send()
-							return "", err
-						}
-						if err = building.BuildThreadPages(post); err != nil {
-							return "", err
-						}
-						fmt.Println("Done rebuilding", board.Dir)
 					}
 					data["thread"] = thread
 				}
@@ -627,6 +772,7 @@ func RegisterModeratorPages() {
 			ID:          "postinfo",
 			Title:       "Post info",
 			Permissions: ModPerms,
+			Capability:  gcsql.CapIPView,
 			JSONoutput:  AlwaysJSON,
 			Callback: func(writer http.ResponseWriter, request *http.Request, staff *gcsql.Staff, wantsJSON bool, infoEv *zerolog.Event, errEv *zerolog.Event) (output interface{}, err error) {
 				postIDstr := request.FormValue("postid")
@@ -641,6 +787,17 @@ func RegisterModeratorPages() {
 				if err != nil {
 					return "", err
 				}
+				boardID, err := post.GetBoardID()
+				if err != nil {
+					errEv.Err(err).Caller().
+						Int("postID", postID).Send()
+					return "", err
+				}
+				if err = checkBoardPermission(staff, boardID); err != nil {
+					errEv.Err(err).Caller().
+						Int("postID", postID).Send()
+					return "", err
+				}
 
 				postInfo := map[string]interface{}{
 					"post": post,
@@ -652,7 +809,63 @@ func RegisterModeratorPages() {
 				} else {
 					postInfo["ipFQDN"] = []string{err.Error()}
 				}
+				if postInfo["warnings"], err = moderatedWarningsByIP(staff, post.IP); err != nil {
+					errEv.Err(err).Caller().
+						Int("postID", postID).Msg("Unable to get warnings")
+					return "", err
+				}
 				return postInfo, nil
 			}},
+		Action{
+			ID:          "modlog",
+			Title:       "Moderation log",
+			Permissions: ModPerms,
+			Capability:  gcsql.CapModLogView,
+			JSONoutput:  OptionalJSON,
+			Callback:    modLogCallback,
+		},
 	)
 }
+
+// SetThreadAttribute sets the thread's locked, stickied, anchored, or cyclical attribute if it isn't already set to
+// the value, adds the change to the moderation log, triggers the thread-updated event, and queues rebuilds of the
+// thread and its board
+func SetThreadAttribute(staff *gcsql.Staff, thread *gcsql.Thread, board *gcsql.Board, topPostID int, attr string, value bool) error {
+	var oldVal bool
+	switch attr {
+	case "locked":
+		oldVal = thread.Locked
+	case "stickied":
+		oldVal = thread.Stickied
+	case "anchored":
+		oldVal = thread.Anchored
+	case "cyclical":
+		oldVal = thread.Cyclical
+	default:
+		// let UpdateAttribute return the invalid attribute error
+		oldVal = !value
+	}
+	if oldVal == value {
+		return nil
+	}
+	if err := thread.UpdateAttribute(attr, value); err != nil {
+		return err
+	}
+	LogStaffAction(staff, gcsql.ModLogEntry{
+		Action:     gcsql.ModLogThreadAttributes,
+		TargetType: gcsql.ModLogTargetThread,
+		TargetID:   topPostID,
+		BoardID:    &board.ID,
+	}, map[string]bool{attr: oldVal}, map[string]bool{attr: value})
+	if _, err := events.TriggerEvent("thread-updated", thread, board, attr, value); err != nil {
+		// the thread was already updated, so the handler's error doesn't stop anything
+		gcutil.LogWarning().Err(err).Caller().
+			Str("triggeredEvent", "thread-updated").
+			Int("topPostID", topPostID).
+			Msg("Error in event handler")
+	}
+	building.QueueThreadPages(board, topPostID)
+	building.QueueBoardPages(board)
+	building.QueueCatalog(board)
+	return nil
+}
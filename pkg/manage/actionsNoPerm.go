@@ -22,7 +22,7 @@ func RegisterNoPermPages() {
 			Permissions: NoPerms,
 			Callback: func(writer http.ResponseWriter, request *http.Request, staff *gcsql.Staff, wantsJSON bool, infoEv *zerolog.Event, errEv *zerolog.Event) (output interface{}, err error) {
 				systemCritical := config.GetSystemCriticalConfig()
-				if staff.Rank > 0 {
+				if staff.ID > 0 {
 					http.Redirect(writer, request, path.Join(systemCritical.WebRoot, "manage"), http.StatusFound)
 				}
 				username := request.FormValue("username")
@@ -31,34 +31,58 @@ func RegisterNoPermPages() {
 				if redirectAction == "" || redirectAction == "logout" {
 					redirectAction = "dashboard"
 				}
+				loginData := map[string]interface{}{
+					"siteConfig":  config.GetSiteConfig(),
+					"sections":    gcsql.AllSections,
+					"boards":      gcsql.AllBoards,
+					"boardConfig": config.GetBoardConfig(""),
+					"redirect":    redirectAction,
+				}
 
-				if username == "" || password == "" {
-					//assume that they haven't logged in
-					manageLoginBuffer := bytes.NewBufferString("")
-					if err = serverutil.MinifyTemplate(gctemplates.ManageLogin, map[string]interface{}{
-						"siteConfig":  config.GetSiteConfig(),
-						"sections":    gcsql.AllSections,
-						"boards":      gcsql.AllBoards,
-						"boardConfig": config.GetBoardConfig(""),
-						"redirect":    redirectAction,
-					}, manageLoginBuffer, "text/html"); err != nil {
-						errEv.Err(err).Str("template", "manage_login.html").Send()
-						return "", errors.New("Error executing staff login page template: " + err.Error())
+				if loginToken := request.FormValue("logintoken"); loginToken != "" {
+					// second step for staff with two-factor authentication enabled
+					switch finishTOTPLogin(loginToken, request.FormValue("totp"), request, writer) {
+					case sSuccess:
+						http.Redirect(writer, request, path.Join(systemCritical.WebRoot, "manage/"+request.FormValue("redirect")), http.StatusFound)
+						return
+					case sInvalidTOTP:
+						loginData["loginToken"] = loginToken
+						loginData["loginError"] = gcsql.ErrInvalidTOTPCode.Error()
+					default:
+						loginData["loginError"] = "Login expired, please log in again"
 					}
-					output = manageLoginBuffer.String()
-				} else {
+					loginData["redirect"] = request.FormValue("redirect")
+				} else if username != "" && password != "" {
 					key := gcutil.Md5Sum(request.RemoteAddr + username + password + systemCritical.RandomSeed + gcutil.RandomString(3))[0:10]
-					createSession(key, username, password, request, writer)
-					http.Redirect(writer, request, path.Join(systemCritical.WebRoot, "manage/"+request.FormValue("redirect")), http.StatusFound)
+					switch createSession(key, username, password, request, writer) {
+					case sTOTPRequired:
+						if loginData["loginToken"], err = addPendingLogin(username, key); err != nil {
+							errEv.Err(err).Caller().Msg("Unable to start two-factor authentication login")
+							return "", err
+						}
+					case sLockedOut:
+						loginData["loginError"] = "Too many failed login attempts, please try again later"
+					default:
+						http.Redirect(writer, request, path.Join(systemCritical.WebRoot, "manage/"+request.FormValue("redirect")), http.StatusFound)
+						return
+					}
+					loginData["redirect"] = request.FormValue("redirect")
+				}
+
+				manageLoginBuffer := bytes.NewBufferString("")
+				if err = serverutil.MinifyTemplate(gctemplates.ManageLogin, loginData, manageLoginBuffer, "text/html"); err != nil {
+					errEv.Err(err).Str("template", "manage_login.html").Send()
+					return "", errors.New("Error executing staff login page template: " + err.Error())
 				}
+				output = manageLoginBuffer.String()
 				return
 			}},
 		Action{
 			ID:          "staffinfo",
+			Title:       "Account",
 			Permissions: NoPerms,
-			JSONoutput:  AlwaysJSON,
-			Callback: func(writer http.ResponseWriter, request *http.Request, staff *gcsql.Staff, wantsJSON bool, infoEv *zerolog.Event, errEv *zerolog.Event) (output interface{}, err error) {
-				return staff, nil
-			}},
+			JSONoutput:  OptionalJSON,
+			Callback:    staffInfoCallback,
+		},
 	)
 }
@@ -0,0 +1,214 @@
+package manage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gochan-org/gochan/pkg/gcsql"
+	"github.com/gochan-org/gochan/pkg/gctemplates"
+	"github.com/gochan-org/gochan/pkg/gcutil"
+	"github.com/gochan-org/gochan/pkg/server/serverutil"
+	"github.com/rs/zerolog"
+)
+
+// appealListing is an appeal shown on the appeals page, along with the ban it was submitted for
+type appealListing struct {
+	gcsql.IPBanAppeal
+	Ban *gcsql.IPBan
+}
+
+// appealsCallback lists the pending ban appeals (or every appeal of a ban and its appeal history if banid
+// is set), and handles approving and denying them
+func appealsCallback(writer http.ResponseWriter, request *http.Request, staff *gcsql.Staff, wantsJSON bool, infoEv *zerolog.Event, errEv *zerolog.Event) (output interface{}, err error) {
+	banIDstr := request.FormValue("banid")
+	var banID int
+	if banIDstr != "" {
+		if banID, err = strconv.Atoi(banIDstr); err != nil {
+			errEv.Err(err).Caller().Send()
+			return "", err
+		}
+	}
+	infoEv.Int("banID", banID)
+
+	limitStr := request.FormValue("limit")
+	limit := 20
+	if limitStr != "" {
+		if limit, err = strconv.Atoi(limitStr); err != nil {
+			errEv.Err(err).Caller().Send()
+			return "", err
+		}
+	}
+	boardIDs, err := staff.ModeratedBoardIDs()
+	if err != nil {
+		errEv.Err(err).Caller().Send()
+		return "", err
+	}
+	pageData := map[string]interface{}{
+		"csrfToken": staff.CSRFToken,
+	}
+
+	if approveStr := request.PostFormValue("approve"); approveStr != "" {
+		approveID, err := strconv.Atoi(approveStr)
+		if err != nil {
+			errEv.Err(err).
+				Str("approveStr", approveStr).Caller().Send()
+			return "", err
+		}
+		appealBan, err := moderatedAppealBan(approveID, boardIDs, errEv)
+		if err != nil {
+			return "", err
+		}
+		if err = gcsql.ApproveAppeal(approveID, staff.ID); err != nil {
+			errEv.Err(err).
+				Int("approveAppeal", approveID).
+				Caller().Send()
+			return "", err
+		}
+		infoEv.Int("approveAppeal", approveID).Msg("Appeal approved")
+		LogStaffAction(staff, gcsql.ModLogEntry{
+			Action:     gcsql.ModLogAppealApprove,
+			TargetType: gcsql.ModLogTargetAppeal,
+			TargetID:   approveID,
+			BoardID:    appealBan.BoardID,
+		}, appealBan, nil)
+		pageData["message"] = fmt.Sprintf("Approved appeal #%d, the ban has been lifted", approveID)
+	} else if denyStr := request.FormValue("deny"); denyStr != "" {
+		denyID, err := strconv.Atoi(denyStr)
+		if err != nil {
+			errEv.Err(err).
+				Str("denyStr", denyStr).Caller().Send()
+			return "", err
+		}
+		appealBan, err := moderatedAppealBan(denyID, boardIDs, errEv)
+		if err != nil {
+			return "", err
+		}
+		if request.PostFormValue("dodeny") == "" {
+			// show the form for denying the appeal
+			appeal, err := gcsql.GetAppeal(denyID)
+			if err != nil {
+				errEv.Err(err).
+					Int("denyAppeal", denyID).
+					Caller().Send()
+				return "", err
+			}
+			pageData["denyAppeal"] = appealListing{IPBanAppeal: *appeal, Ban: appealBan}
+		} else {
+			if err = denyAppealFromRequest(denyID, request, staff, errEv); err != nil {
+				return "", err
+			}
+			infoEv.Int("denyAppeal", denyID).Msg("Appeal denied")
+			updatedBan, err := gcsql.GetIPBanByID(appealBan.ID)
+			if err != nil {
+				errEv.Err(err).Caller().
+					Int("banID", appealBan.ID).Send()
+				return "", err
+			}
+			LogStaffAction(staff, gcsql.ModLogEntry{
+				Action:     gcsql.ModLogAppealDeny,
+				TargetType: gcsql.ModLogTargetAppeal,
+				TargetID:   denyID,
+				BoardID:    appealBan.BoardID,
+			}, appealBan, updatedBan)
+			pageData["message"] = fmt.Sprintf("Denied appeal #%d", denyID)
+		}
+	}
+
+	var ban *gcsql.IPBan
+	if banID > 0 {
+		if ban, err = gcsql.GetIPBanByID(banID); err != nil {
+			errEv.Err(err).Caller().Send()
+			return "", err
+		}
+		if !canModerateBan(boardIDs, ban.BoardID) {
+			errEv.Err(ErrBoardPermission).Caller().Send()
+			return "", ErrBoardPermission
+		}
+		history, err := gcsql.GetAppealHistory(banID)
+		if err != nil {
+			errEv.Err(err).Caller().Msg("Unable to get appeal history")
+			return "", err
+		}
+		pageData["ban"] = ban
+		pageData["history"] = history
+	}
+
+	appeals, err := gcsql.GetAppeals(banID, banID == 0, limit)
+	if err != nil {
+		errEv.Err(err).Caller().Send()
+		return "", errors.New("Unable to get appeals: " + err.Error())
+	}
+	listings := []appealListing{}
+	bans := map[int]*gcsql.IPBan{}
+	for _, appeal := range appeals {
+		appealBan, ok := bans[appeal.IPBanID]
+		if !ok {
+			if appealBan, err = gcsql.GetIPBanByID(appeal.IPBanID); err != nil {
+				errEv.Err(err).Caller().
+					Int("banID", appeal.IPBanID).Send()
+				return "", err
+			}
+			bans[appeal.IPBanID] = appealBan
+		}
+		// only show appeals of bans on the staff member's boards
+		if canModerateBan(boardIDs, appealBan.BoardID) {
+			listings = append(listings, appealListing{IPBanAppeal: appeal, Ban: appealBan})
+		}
+	}
+	if wantsJSON {
+		return listings, nil
+	}
+	pageData["appeals"] = listings
+
+	manageAppealsBuffer := bytes.NewBufferString("")
+	if err = serverutil.MinifyTemplate(gctemplates.ManageAppeals, pageData, manageAppealsBuffer, "text/html"); err != nil {
+		errEv.Err(err).Str("template", "manage_appeals.html").Caller().Send()
+		return "", errors.New("Error executing appeal management page template: " + err.Error())
+	}
+	return manageAppealsBuffer.String(), nil
+}
+
+// moderatedAppealBan returns the ban that the appeal was submitted for, or ErrBoardPermission if the staff
+// member can't moderate it
+func moderatedAppealBan(appealID int, boardIDs []int, errEv *zerolog.Event) (*gcsql.IPBan, error) {
+	appealBan, err := gcsql.GetAppealBan(appealID)
+	if err == nil && !canModerateBan(boardIDs, appealBan.BoardID) {
+		err = ErrBoardPermission
+	}
+	if err != nil {
+		errEv.Err(err).
+			Int("appealID", appealID).
+			Caller().Send()
+		return nil, err
+	}
+	return appealBan, nil
+}
+
+// denyAppealFromRequest denies the appeal with the response in the request's "response" form value. The ban
+// can be appealed again after the "appealwait" duration, or never if "noappeals" is checked
+func denyAppealFromRequest(appealID int, request *http.Request, staff *gcsql.Staff, errEv *zerolog.Event) error {
+	nextAppealAt := time.Now()
+	canAppeal := request.PostFormValue("noappeals") != "on"
+	if appealWaitStr := request.PostFormValue("appealwait"); canAppeal && appealWaitStr != "" {
+		appealWait, err := gcutil.ParseDurationString(appealWaitStr)
+		if err != nil {
+			errEv.Err(err).
+				Str("appealwait", appealWaitStr).
+				Caller().Msg("Invalid appeal delay duration string")
+			return err
+		}
+		nextAppealAt = nextAppealAt.Add(appealWait)
+	}
+	response := request.PostFormValue("response")
+	if err := gcsql.DenyAppeal(appealID, staff.ID, response, nextAppealAt, canAppeal); err != nil {
+		errEv.Err(err).
+			Int("denyAppeal", appealID).
+			Caller().Send()
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package manage
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+
+	"github.com/gochan-org/gochan/pkg/gcsql"
+	"github.com/gochan-org/gochan/pkg/gctemplates"
+	"github.com/gochan-org/gochan/pkg/gcutil"
+	"github.com/gochan-org/gochan/pkg/server/serverutil"
+	"github.com/rs/zerolog"
+)
+
+// banPresetsCallback lists the ban presets and handles creating, editing, and deleting them
+func banPresetsCallback(writer http.ResponseWriter, request *http.Request, staff *gcsql.Staff, wantsJSON bool, infoEv *zerolog.Event, errEv *zerolog.Event) (output interface{}, err error) {
+	var editPreset *gcsql.BanPreset
+	if editIDstr := request.FormValue("edit"); editIDstr != "" {
+		if editPreset, err = gcsql.GetBanPreset(gcutil.HackyStringToInt(editIDstr)); err != nil {
+			errEv.Err(err).Caller().
+				Str("editPreset", editIDstr).Send()
+			return "", err
+		}
+	} else if deleteIDstr := request.PostFormValue("delete"); deleteIDstr != "" {
+		deleteID := gcutil.HackyStringToInt(deleteIDstr)
+		deleted, err := gcsql.GetBanPreset(deleteID)
+		if err == nil {
+			err = gcsql.DeleteBanPreset(deleteID)
+		}
+		if err != nil {
+			errEv.Err(err).Caller().
+				Str("deletePreset", deleteIDstr).Send()
+			return "", err
+		}
+		infoEv.Str("deletePreset", deleted.Name).Msg("Ban preset deleted")
+		LogStaffAction(staff, gcsql.ModLogEntry{
+			Action:     gcsql.ModLogBanPresetDelete,
+			TargetType: gcsql.ModLogTargetBanPreset,
+			TargetID:   deleteID,
+		}, deleted, nil)
+	} else if request.PostFormValue("save_preset") != "" {
+		preset := &gcsql.BanPreset{
+			Name:        request.PostFormValue("name"),
+			Duration:    request.PostFormValue("duration"),
+			Permanent:   request.PostFormValue("permanent") == "on",
+			Message:     request.PostFormValue("reason"),
+			IsThreadBan: request.PostFormValue("threadban") == "on",
+			CanAppeal:   request.PostFormValue("noappeals") != "on",
+			AppealWait:  request.PostFormValue("appealwait"),
+			IsGlobal:    request.PostFormValue("global") == "on",
+		}
+		if escalateID, _ := strconv.Atoi(request.PostFormValue("escalateto")); escalateID > 0 {
+			preset.EscalateToID = &escalateID
+		}
+		logEntry := gcsql.ModLogEntry{
+			Action:     gcsql.ModLogBanPresetCreate,
+			TargetType: gcsql.ModLogTargetBanPreset,
+		}
+		var oldPreset *gcsql.BanPreset
+		if updateIDstr := request.PostFormValue("updatepreset"); updateIDstr != "" {
+			preset.ID = gcutil.HackyStringToInt(updateIDstr)
+			if oldPreset, err = gcsql.GetBanPreset(preset.ID); err == nil {
+				err = preset.Update()
+			}
+			logEntry.Action = gcsql.ModLogBanPresetEdit
+		} else {
+			err = gcsql.NewBanPreset(preset)
+		}
+		if err != nil {
+			errEv.Err(err).Caller().
+				Str("presetName", preset.Name).
+				Msg("Unable to save ban preset")
+			return "", err
+		}
+		infoEv.
+			Int("presetID", preset.ID).
+			Str("presetName", preset.Name).
+			Msg("Ban preset saved")
+		logEntry.TargetID = preset.ID
+		if oldPreset != nil {
+			LogStaffAction(staff, logEntry, oldPreset, preset)
+		} else {
+			LogStaffAction(staff, logEntry, nil, preset)
+		}
+	}
+
+	presets, err := gcsql.GetBanPresets()
+	if err != nil {
+		errEv.Err(err).Caller().Msg("Unable to get ban presets")
+		return "", err
+	}
+	if wantsJSON {
+		if presets == nil {
+			presets = []gcsql.BanPreset{}
+		}
+		return presets, nil
+	}
+	pageBuffer := bytes.NewBufferString("")
+	pageMap := map[string]interface{}{
+		"presets":   presets,
+		"csrfToken": staff.CSRFToken,
+	}
+	if editPreset != nil {
+		pageMap["editPreset"] = editPreset
+	}
+	if err = serverutil.MinifyTemplate(gctemplates.ManageBanPresets, pageMap, pageBuffer, "text/html"); err != nil {
+		errEv.Err(err).Caller().Str("template", "manage_banpresets.html").Send()
+		return "", err
+	}
+	return pageBuffer.String(), nil
+}
@@ -2,6 +2,7 @@ package manage
 
 import (
 	"errors"
+	"fmt"
 	"html"
 	"net/http"
 	"strconv"
@@ -12,8 +13,7 @@ import (
 	"github.com/rs/zerolog"
 )
 
-func ipBanFromRequest(ban *gcsql.IPBan, request *http.Request, errEv *zerolog.Event) error {
-	now := time.Now()
+func ipBanFromRequest(ban *gcsql.IPBan, request *http.Request, staff *gcsql.Staff, errEv *zerolog.Event) error {
 	banIDStr := request.FormValue("edit")
 	if banIDStr != "" && request.FormValue("do") == "edit" {
 		banID, err := strconv.Atoi(banIDStr)
@@ -30,10 +30,95 @@ func ipBanFromRequest(ban *gcsql.IPBan, request *http.Request, errEv *zerolog.Ev
 				Caller().Send()
 			return errors.New("Unable to get ban with id " + banIDStr + " (SQL error)")
 		}
+		if err = checkBanPermission(staff, editing.BoardID); err != nil {
+			return err
+		}
 		*ban = *editing
 		return nil
 	}
-	ban.IP = request.FormValue("ip")
+	ipStr := request.FormValue("ip")
+	var err error
+	if ban.IP, err = gcutil.NormalizeIPRange(ipStr); err != nil {
+		errEv.Err(err).
+			Str("ip", ipStr).
+			Caller().Msg("Invalid IP address or range")
+		return fmt.Errorf("invalid IP address or range %q", ipStr)
+	}
+	boardIDstr := request.FormValue("boardid")
+	if boardIDstr != "" && boardIDstr != "0" {
+		boardID, err := strconv.Atoi(boardIDstr)
+		if err != nil {
+			errEv.Err(err).
+				Str("boardid", boardIDstr).
+				Caller().Send()
+			return err
+		}
+		ban.BoardID = new(int)
+		*ban.BoardID = boardID
+	}
+	usedPreset, err := banPresetFromRequest(ban, request, errEv)
+	if err != nil {
+		return err
+	}
+	if !usedPreset {
+		if err = banDetailsFromRequest(ban, request, errEv); err != nil {
+			return err
+		}
+	}
+	if err = checkBanPermission(staff, ban.BoardID); err != nil {
+		return err
+	}
+	return gcsql.NewIPBan(ban)
+}
+
+// banPresetFromRequest sets the ban's details from the ban preset selected in the request's "preset" form
+// value, if there is one, and returns true if a preset was used. If "escalate" is checked, the preset is
+// escalated once for each prior ban of the ban's IP. The ban's IP must already be set
+func banPresetFromRequest(ban *gcsql.IPBan, request *http.Request, errEv *zerolog.Event) (bool, error) {
+	presetIDstr := request.FormValue("preset")
+	if presetIDstr == "" || presetIDstr == "0" {
+		return false, nil
+	}
+	presetID, err := strconv.Atoi(presetIDstr)
+	if err != nil {
+		errEv.Err(err).
+			Str("preset", presetIDstr).
+			Caller().Send()
+		return false, err
+	}
+	preset, err := gcsql.GetBanPreset(presetID)
+	if err != nil {
+		errEv.Err(err).
+			Int("presetID", presetID).
+			Caller().Msg("Unable to get ban preset")
+		return false, err
+	}
+	if request.FormValue("escalate") == "on" {
+		priorBans, err := gcsql.GetPriorIPBanCount(ban.IP)
+		if err == nil {
+			preset, err = preset.Escalate(priorBans)
+		}
+		if err != nil {
+			errEv.Err(err).
+				Int("presetID", presetID).
+				Caller().Msg("Unable to escalate ban preset")
+			return false, err
+		}
+	}
+	if err = preset.Apply(ban); err != nil {
+		errEv.Err(err).
+			Int("presetID", preset.ID).
+			Caller().Msg("Unable to apply ban preset")
+		return false, err
+	}
+	ban.StaffNote = html.EscapeString(request.FormValue("staffnote"))
+	return true, nil
+}
+
+// banDetailsFromRequest sets the ban's duration, appeal, and message fields from the request's form values.
+// It is used for bans created from the bans page and from the report queue
+func banDetailsFromRequest(ban *gcsql.IPBan, request *http.Request, errEv *zerolog.Event) error {
+	now := time.Now()
 	ban.Permanent = request.FormValue("permanent") == "on"
 	if ban.Permanent {
 		ban.ExpiresAt = now
@@ -69,20 +154,8 @@ func ipBanFromRequest(ban *gcsql.IPBan, request *http.Request, errEv *zerolog.Ev
 	}
 
 	ban.IsThreadBan = request.FormValue("threadban") == "on"
-	boardIDstr := request.FormValue("boardid")
-	if boardIDstr != "" && boardIDstr != "0" {
-		boardID, err := strconv.Atoi(boardIDstr)
-		if err != nil {
-			errEv.Err(err).
-				Str("boardid", boardIDstr).
-				Caller().Send()
-			return err
-		}
-		ban.BoardID = new(int)
-		*ban.BoardID = boardID
-	}
 	ban.Message = html.EscapeString(request.FormValue("reason"))
 	ban.StaffNote = html.EscapeString(request.FormValue("staffnote"))
 	ban.IsActive = true
-	return gcsql.NewIPBan(ban)
+	return nil
 }
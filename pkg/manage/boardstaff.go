@@ -0,0 +1,112 @@
+package manage
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gochan-org/gochan/pkg/building"
+	"github.com/gochan-org/gochan/pkg/gcsql"
+)
+
+var (
+	// ErrBoardPermission is returned when a staff member tries to moderate a board they aren't assigned to
+	ErrBoardPermission = errors.New("you do not have permission to moderate this board")
+	// ErrGlobalPermission is returned when a staff member who is assigned to specific boards tries to
+	// do something that affects every board, like creating a global ban
+	ErrGlobalPermission = errors.New("you can only moderate the boards you are assigned to")
+)
+
+// canModerate returns true if boardID is in boardIDs (from Staff.ModeratedBoardIDs), or if boardIDs is nil,
+// meaning the staff member can moderate every board
+func canModerate(boardIDs []int, boardID int) bool {
+	if boardIDs == nil {
+		return true
+	}
+	for _, id := range boardIDs {
+		if id == boardID {
+			return true
+		}
+	}
+	return false
+}
+
+// canModerateBan returns true if the staff member can moderate the ban with the given board ID, or
+// if boardID is nil (a global ban), if they can moderate every board
+func canModerateBan(boardIDs []int, boardID *int) bool {
+	if boardID == nil || *boardID == 0 {
+		return boardIDs == nil
+	}
+	return canModerate(boardIDs, *boardID)
+}
+
+// checkBoardPermission returns ErrBoardPermission if the staff member isn't allowed to moderate the board
+func checkBoardPermission(staff *gcsql.Staff, boardID int) error {
+	allowed, err := staff.CanModerateBoard(boardID)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return ErrBoardPermission
+	}
+	return nil
+}
+
+// checkBanPermission returns ErrGlobalPermission or ErrBoardPermission if the staff member isn't allowed
+// to create or remove a ban on the board with the given ID. A nil or 0 ID is treated as a global ban
+func checkBanPermission(staff *gcsql.Staff, boardID *int) error {
+	boardIDs, err := staff.ModeratedBoardIDs()
+	if err != nil {
+		return err
+	}
+	if canModerateBan(boardIDs, boardID) {
+		return nil
+	}
+	if boardID == nil || *boardID == 0 {
+		return ErrGlobalPermission
+	}
+	return ErrBoardPermission
+}
+
+// moderatedBoards returns the boards in gcsql.AllBoards that the staff member can moderate and false if
+// they can't moderate every board
+func moderatedBoards(staff *gcsql.Staff) ([]gcsql.Board, bool, error) {
+	boardIDs, err := staff.ModeratedBoardIDs()
+	if err != nil {
+		return nil, false, err
+	}
+	if boardIDs == nil {
+		return gcsql.AllBoards, true, nil
+	}
+	var boards []gcsql.Board
+	for _, board := range gcsql.AllBoards {
+		if canModerate(boardIDs, board.ID) {
+			boards = append(boards, board)
+		}
+	}
+	return boards, false, nil
+}
+
+// filterModeratedPosts returns the posts that are on any of the given boards (from moderatedBoards)
+func filterModeratedPosts(posts []building.Post, boards []gcsql.Board) []building.Post {
+	var moderatedPosts []building.Post
+	for _, post := range posts {
+		for _, board := range boards {
+			if post.BoardDir == board.Dir {
+				moderatedPosts = append(moderatedPosts, post)
+				break
+			}
+		}
+	}
+	return moderatedPosts
+}
+
+// StaffCanOnBoard returns true if the staff member referenced in the request is allowed to moderate the board
+// with the given ID and their role has the given capability
+func StaffCanOnBoard(request *http.Request, capability string, boardID int) bool {
+	staff, err := getCurrentFullStaff(request)
+	if err != nil || !staff.Can(capability) {
+		return false
+	}
+	allowed, err := staff.CanModerateBoard(boardID)
+	return err == nil && allowed
+}
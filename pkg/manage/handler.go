@@ -7,6 +7,7 @@ import (
 	"net/http"
 
 	"github.com/gochan-org/gochan/pkg/building"
+	"github.com/gochan-org/gochan/pkg/config"
 	"github.com/gochan-org/gochan/pkg/gcsql"
 	"github.com/gochan-org/gochan/pkg/gcutil"
 	"github.com/gochan-org/gochan/pkg/server"
@@ -66,7 +67,7 @@ func CallManageFunction(writer http.ResponseWriter, request *http.Request) {
 		return
 	}
 	if actionID == "" {
-		if staff.Rank == NoPerms {
+		if staff.ID == 0 {
 			// no action requested and user is not logged in, have them go to login page
 			actionID = "login"
 		} else {
@@ -75,7 +76,7 @@ func CallManageFunction(writer http.ResponseWriter, request *http.Request) {
 	}
 	gcutil.LogStr("staff", staff.Username, infoEv, accessEv, errEv)
 	var managePageBuffer bytes.Buffer
-	action := getAction(actionID, staff.Rank)
+	action := getAction(actionID, staff)
 	if action == nil {
 		if wantsJSON {
 			serveError(writer, "notfound", actionID, "action not found", wantsJSON || (action.JSONoutput == AlwaysJSON))
@@ -85,16 +86,38 @@ func CallManageFunction(writer http.ResponseWriter, request *http.Request) {
 		return
 	}
 
-	if staff.Rank < action.Permissions {
+	if staff.NeedsTOTPEnrollment() && action.Permissions > NoPerms && action.ID != "logout" {
+		// staff whose role requires two-factor authentication have to set it up before using anything else
+		if wantsJSON || action.JSONoutput == AlwaysJSON {
+			writer.WriteHeader(http.StatusForbidden)
+			serveError(writer, "totprequired", actionID, gcsql.ErrTOTPRequiredByRole.Error(), true)
+		} else {
+			http.Redirect(writer, request, config.WebPath("/manage/staffinfo"), http.StatusFound)
+		}
+		return
+	}
+
+	if !action.canAccess(staff) {
 		writer.WriteHeader(http.StatusForbidden)
 		errEv.
-			Int("rank", staff.Rank).
-			Int("requiredRank", action.Permissions).
+			Str("role", staff.RoleName()).
+			Str("capability", action.Capability).
 			Msg("Insufficient permissions")
 		serveError(writer, "permission", actionID, "You do not have permission to access this page", wantsJSON || (action.JSONoutput == AlwaysJSON))
 		return
 	}
 
+	// actions only read the form fields that change something from the request body, so every request
+	// that can have one (not just POST) needs a valid token
+	if request.Method != http.MethodGet && request.Method != http.MethodHead && staff.ID > 0 &&
+		!validCSRFToken(request, staff) {
+		writer.WriteHeader(http.StatusForbidden)
+		errEv.Msg("Missing or invalid CSRF token")
+		serveError(writer, "csrf", actionID, "Invalid or expired form token, please reload the page and try again",
+			wantsJSON || (action.JSONoutput == AlwaysJSON))
+		return
+	}
+
 	var output interface{}
 	if wantsJSON && action.JSONoutput == NoJSON {
 		output = nil
@@ -0,0 +1,246 @@
+package manage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gochan-org/gochan/pkg/building"
+	"github.com/gochan-org/gochan/pkg/gcsql"
+	"github.com/gochan-org/gochan/pkg/gctemplates"
+	"github.com/gochan-org/gochan/pkg/gcutil"
+	"github.com/gochan-org/gochan/pkg/server/serverutil"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// purgeTimeLayout is the layout of the datetime-local inputs of the IP purge form
+	purgeTimeLayout = "2006-01-02T15:04"
+)
+
+var (
+	ipPurgeFormFields = []string{
+		"ip", "boardid", "since", "until", "filesonly", "ban", "preset", "escalate", "duration", "permanent",
+		"appealwait", "noappeals", "threadban", "reason", "staffnote",
+	}
+)
+
+// ipPurgeFromRequest gets the purge settings from the request's form values. The staff member must be able to
+// moderate every board if no board is selected
+func ipPurgeFromRequest(request *http.Request, staff *gcsql.Staff, errEv *zerolog.Event) (*gcsql.IPPurge, error) {
+	ipStr := request.FormValue("ip")
+	ipRange, err := gcutil.NormalizeIPRange(ipStr)
+	if err != nil {
+		errEv.Err(err).
+			Str("ip", ipStr).
+			Caller().Msg("Invalid IP address or range")
+		return nil, fmt.Errorf("invalid IP address or range %q", ipStr)
+	}
+	purge := &gcsql.IPPurge{
+		IPRange:   ipRange,
+		FilesOnly: request.FormValue("filesonly") == "on",
+	}
+	if boardIDstr := request.FormValue("boardid"); boardIDstr != "" {
+		if purge.BoardID, err = strconv.Atoi(boardIDstr); err != nil {
+			errEv.Err(err).
+				Str("boardid", boardIDstr).
+				Caller().Send()
+			return nil, err
+		}
+	}
+	if err = checkBanPermission(staff, &purge.BoardID); err != nil {
+		errEv.Err(err).Caller().
+			Int("boardID", purge.BoardID).Send()
+		return nil, err
+	}
+	for field, t := range map[string]*time.Time{"since": &purge.Since, "until": &purge.Until} {
+		timeStr := request.FormValue(field)
+		if timeStr == "" {
+			continue
+		}
+		if *t, err = time.ParseInLocation(purgeTimeLayout, timeStr, time.Local); err != nil {
+			errEv.Err(err).
+				Str(field, timeStr).
+				Caller().Msg("Invalid purge time")
+			return nil, fmt.Errorf("invalid %s time %q", field, timeStr)
+		}
+	}
+	if !purge.Since.IsZero() && !purge.Until.IsZero() && purge.Until.Before(purge.Since) {
+		return nil, errors.New("the end of the time window must be after the start")
+	}
+	return purge, nil
+}
+
+// purgeBanFromRequest creates the ban to be issued along with the purge if the "ban" checkbox is checked, using
+// the selected ban preset or the ban fields
+func purgeBanFromRequest(purge *gcsql.IPPurge, request *http.Request, staff *gcsql.Staff, errEv *zerolog.Event) (*gcsql.IPBan, error) {
+	if request.FormValue("ban") != "on" {
+		return nil, nil
+	}
+	if err := checkCapability(staff, gcsql.CapBanCreate); err != nil {
+		return nil, err
+	}
+	ban := &gcsql.IPBan{IP: purge.IPRange}
+	ban.StaffID = staff.ID
+	if purge.BoardID > 0 {
+		boardID := purge.BoardID
+		ban.BoardID = &boardID
+	}
+	usedPreset, err := banPresetFromRequest(ban, request, errEv)
+	if err != nil {
+		return nil, err
+	}
+	if !usedPreset {
+		if err = banDetailsFromRequest(ban, request, errEv); err != nil {
+			return nil, err
+		}
+	}
+	if err = checkBanPermission(staff, ban.BoardID); err != nil {
+		return nil, err
+	}
+	return ban, nil
+}
+
+// ipPurgeCallback shows the form for removing every post (or file) from an IP address or range, previews the
+// posts that would be removed, and removes them, optionally banning the IP in the same transaction
+func ipPurgeCallback(writer http.ResponseWriter, request *http.Request, staff *gcsql.Staff, wantsJSON bool, infoEv *zerolog.Event, errEv *zerolog.Event) (output interface{}, err error) {
+	if err = checkCapability(staff, gcsql.CapIPView); err != nil {
+		return "", err
+	}
+	boards, globalStaff, err := moderatedBoards(staff)
+	if err != nil {
+		errEv.Err(err).Caller().Msg("Unable to get staff board assignments")
+		return "", err
+	}
+	presets, err := gcsql.GetBanPresets()
+	if err != nil {
+		errEv.Err(err).Caller().Msg("Unable to get ban presets")
+		return "", err
+	}
+	data := map[string]interface{}{
+		"csrfToken":   staff.CSRFToken,
+		"boards":      boards,
+		"globalStaff": globalStaff,
+		"presets":     presets,
+		"canBan":      staff.Can(gcsql.CapBanCreate),
+	}
+	// the form is filled in with the submitted values so that the previewed purge can be confirmed
+	form := make(map[string]string, len(ipPurgeFormFields))
+	for _, field := range ipPurgeFormFields {
+		form[field] = request.FormValue(field)
+	}
+	data["form"] = form
+
+	do := request.PostFormValue("do")
+	if request.FormValue("ip") != "" {
+		purge, err := ipPurgeFromRequest(request, staff, errEv)
+		if err != nil {
+			return "", err
+		}
+		ban, err := purgeBanFromRequest(purge, request, staff, errEv)
+		if err != nil {
+			return "", err
+		}
+		infoEv.Str("ipRange", purge.IPRange).
+			Int("boardID", purge.BoardID).
+			Bool("filesOnly", purge.FilesOnly)
+
+		if do == "purge" {
+			// only the posts shown in the preview are removed, in case more were made after it was shown
+			for _, postIDstr := range request.PostForm["postid"] {
+				postID, err := strconv.Atoi(postIDstr)
+				if err != nil {
+					errEv.Err(err).
+						Str("postid", postIDstr).
+						Caller().Send()
+					return "", err
+				}
+				purge.PostIDs = append(purge.PostIDs, postID)
+			}
+			if len(purge.PostIDs) == 0 {
+				return "", errors.New("the posts to be removed must be previewed before they can be deleted")
+			}
+			posts, err := building.PurgeIPPosts(purge, ban)
+			if err != nil {
+				return "", err
+			}
+			infoEv.Int("purged", len(posts)).Msg("Purged posts from IP")
+			logPurgedPosts(staff, posts, purge.FilesOnly)
+			if ban != nil {
+				LogStaffAction(staff, gcsql.ModLogEntry{
+					Action:     gcsql.ModLogBanCreate,
+					TargetType: gcsql.ModLogTargetBan,
+					TargetID:   ban.ID,
+					BoardID:    ban.BoardID,
+				}, nil, ban)
+			}
+			if wantsJSON {
+				if posts == nil {
+					posts = []gcsql.PurgeablePost{}
+				}
+				return map[string]interface{}{"purged": posts, "ban": ban}, nil
+			}
+			message := fmt.Sprintf("Removed %d post(s) from %s", len(posts), purge.IPRange)
+			if purge.FilesOnly {
+				message = fmt.Sprintf("Removed the files of %d post(s) from %s", len(posts), purge.IPRange)
+			}
+			if ban != nil {
+				message += " and banned it"
+			}
+			data["message"] = message
+		} else {
+			posts, err := gcsql.GetIPPurgePosts(purge)
+			if err != nil {
+				errEv.Err(err).Caller().Msg("Unable to get posts to purge")
+				return "", err
+			}
+			if wantsJSON {
+				if posts == nil {
+					posts = []gcsql.PurgeablePost{}
+				}
+				return posts, nil
+			}
+			var threads, files int
+			for _, post := range posts {
+				if post.IsTopPost {
+					threads++
+				}
+				files += len(post.Uploads)
+			}
+			data["preview"] = true
+			data["posts"] = posts
+			data["threads"] = threads
+			data["files"] = files
+		}
+	}
+
+	buf := bytes.NewBufferString("")
+	if err = serverutil.MinifyTemplate(gctemplates.ManageIPPurge, data, buf, "text/html"); err != nil {
+		errEv.Err(err).Caller().Str("template", "manage_ippurge.html").Send()
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// logPurgedPosts adds a moderation log entry for each post removed by an IP purge
+func logPurgedPosts(staff *gcsql.Staff, posts []gcsql.PurgeablePost, filesOnly bool) {
+	for p := range posts {
+		post := &posts[p]
+		boardID := post.BoardID
+		entry := gcsql.ModLogEntry{
+			Action:     gcsql.ModLogPostDelete,
+			TargetType: gcsql.ModLogTargetPost,
+			TargetID:   post.ID,
+			BoardID:    &boardID,
+		}
+		if filesOnly {
+			entry.Action = gcsql.ModLogFileDelete
+		} else if post.IsTopPost {
+			entry.TargetType = gcsql.ModLogTargetThread
+		}
+		LogStaffAction(staff, entry, post.Post, nil)
+	}
+}
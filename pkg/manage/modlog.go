@@ -0,0 +1,113 @@
+package manage
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gochan-org/gochan/pkg/config"
+	"github.com/gochan-org/gochan/pkg/gcsql"
+	"github.com/gochan-org/gochan/pkg/gctemplates"
+	"github.com/gochan-org/gochan/pkg/gcutil"
+	"github.com/gochan-org/gochan/pkg/server/serverutil"
+	"github.com/rs/zerolog"
+)
+
+const (
+	modLogPageSize = 50
+)
+
+// LogStaffAction records the staff member's action in the moderation log. The action has already been
+// done by the time it is recorded, so a failure to record it is logged instead of returned
+func LogStaffAction(staff *gcsql.Staff, entry gcsql.ModLogEntry, before interface{}, after interface{}) {
+	if err := gcsql.LogStaffAction(staff, &entry, before, after); err != nil {
+		gcutil.LogError(err).Caller(1).
+			Str("staff", staff.Username).
+			Str("modLogAction", entry.Action).
+			Int("targetID", entry.TargetID).
+			Msg("Unable to record staff action in the moderation log")
+	}
+}
+
+// LogRequestStaffAction records the action in the moderation log if the request was made by a logged in staff
+// member. It is used by handlers outside of /manage, like post deletion, that can also be used by non-staff
+func LogRequestStaffAction(request *http.Request, entry gcsql.ModLogEntry, before interface{}, after interface{}) {
+	staff, err := getCurrentFullStaff(request)
+	if err != nil || staff.ID == 0 {
+		return
+	}
+	LogStaffAction(staff, entry, before, after)
+}
+
+// modLogCallback shows the moderation log, filtered by the request's form values. Staff assigned to boards
+// only see entries about those boards
+func modLogCallback(writer http.ResponseWriter, request *http.Request, staff *gcsql.Staff, wantsJSON bool, infoEv *zerolog.Event, errEv *zerolog.Event) (output interface{}, err error) {
+	filter := &gcsql.ModLogFilter{
+		StaffUsername: request.FormValue("staff"),
+		Action:        request.FormValue("logaction"),
+		TargetType:    request.FormValue("target"),
+	}
+	filter.TargetID, _ = strconv.Atoi(request.FormValue("targetid"))
+	filter.BoardID, _ = strconv.Atoi(request.FormValue("boardid"))
+	offset, _ := strconv.Atoi(request.FormValue("offset"))
+	if offset < 0 {
+		offset = 0
+	}
+
+	entries, err := gcsql.GetModLog(filter, modLogPageSize, offset)
+	if err != nil {
+		errEv.Err(err).Caller().Msg("Unable to get moderation log")
+		return "", err
+	}
+	numEntries := len(entries)
+	boards, globalStaff, err := moderatedBoards(staff)
+	if err != nil {
+		errEv.Err(err).Caller().Msg("Unable to get staff board assignments")
+		return "", err
+	}
+	if !globalStaff {
+		boardIDs, err := staff.ModeratedBoardIDs()
+		if err != nil {
+			errEv.Err(err).Caller().Msg("Unable to get staff board assignments")
+			return "", err
+		}
+		var moderatedEntries []gcsql.ModLogListing
+		for _, entry := range entries {
+			if canModerateBan(boardIDs, entry.BoardID) {
+				moderatedEntries = append(moderatedEntries, entry)
+			}
+		}
+		entries = moderatedEntries
+	}
+	if wantsJSON {
+		return entries, nil
+	}
+
+	data := map[string]interface{}{
+		"entries":     entries,
+		"filter":      filter,
+		"boards":      boards,
+		"targetTypes": gcsql.ModLogTargetTypes,
+	}
+	if numEntries == modLogPageSize {
+		query := request.URL.Query()
+		query.Set("offset", strconv.Itoa(offset+modLogPageSize))
+		data["olderURL"] = (&url.URL{Path: config.WebPath("/manage/modlog"), RawQuery: query.Encode()}).String()
+	}
+	if offset > 0 {
+		query := request.URL.Query()
+		if offset > modLogPageSize {
+			query.Set("offset", strconv.Itoa(offset-modLogPageSize))
+		} else {
+			query.Del("offset")
+		}
+		data["newerURL"] = (&url.URL{Path: config.WebPath("/manage/modlog"), RawQuery: query.Encode()}).String()
+	}
+	pageBuffer := bytes.NewBufferString("")
+	if err = serverutil.MinifyTemplate(gctemplates.ManageModLog, data, pageBuffer, "text/html"); err != nil {
+		errEv.Err(err).Caller().Str("template", "manage_modlog.html").Send()
+		return "", err
+	}
+	return pageBuffer.String(), nil
+}
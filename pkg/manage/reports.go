@@ -0,0 +1,422 @@
+package manage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gochan-org/gochan/pkg/building"
+	"github.com/gochan-org/gochan/pkg/events"
+	"github.com/gochan-org/gochan/pkg/gcsql"
+	"github.com/gochan-org/gochan/pkg/gctemplates"
+	"github.com/gochan-org/gochan/pkg/gcutil"
+	"github.com/gochan-org/gochan/pkg/server/serverutil"
+	"github.com/rs/zerolog"
+)
+
+const (
+	reportActionDismiss   = "dismiss"
+	reportActionDelete    = "delete"
+	reportActionDeleteBan = "deleteban"
+)
+
+var (
+	ErrNoReportedPostsSelected = errors.New("no reported posts selected")
+	ErrInvalidReportAction     = errors.New("invalid report queue action")
+)
+
+// reportsCallback shows the report queue, with each reported post's reports in one row, and handles
+// dismissing reports and the queue's bulk actions
+func reportsCallback(writer http.ResponseWriter, request *http.Request, staff *gcsql.Staff, wantsJSON bool, infoEv *zerolog.Event, errEv *zerolog.Event) (output interface{}, err error) {
+	boardIDs, err := staff.ModeratedBoardIDs()
+	if err != nil {
+		errEv.Err(err).Caller().Msg("Unable to get staff board assignments")
+		return "", err
+	}
+	var message string
+	dismissIDstr := request.PostFormValue("dismiss")
+	block := false
+	if blockIDstr := request.PostFormValue("block"); blockIDstr != "" {
+		// staff is dismissing a report and making the post unreportable
+		dismissIDstr = blockIDstr
+		block = true
+	}
+	if dismissIDstr != "" {
+		// staff is dismissing a report
+		dismissID := gcutil.HackyStringToInt(dismissIDstr)
+		if err = dismissReport(dismissID, block, staff, boardIDs, infoEv, errEv); err != nil {
+			return nil, err
+		}
+	} else if action := request.PostFormValue("bulkaction"); action != "" {
+		if message, err = reportBulkAction(action, request, staff, boardIDs, infoEv, errEv); err != nil {
+			return nil, err
+		}
+	}
+
+	filter := &gcsql.ReportQueueFilter{}
+	filter.BoardID, _ = strconv.Atoi(request.FormValue("boardid"))
+	filter.CategoryID, _ = strconv.Atoi(request.FormValue("categoryid"))
+	queue, err := gcsql.GetReportQueue(filter)
+	if err != nil {
+		errEv.Err(err).Caller().Msg("Unable to get report queue")
+		return nil, err
+	}
+	if boardIDs != nil {
+		var moderatedQueue []gcsql.ReportedPost
+		for _, reported := range queue {
+			if canModerate(boardIDs, reported.BoardID) {
+				moderatedQueue = append(moderatedQueue, reported)
+			}
+		}
+		queue = moderatedQueue
+	}
+	if wantsJSON {
+		if queue == nil {
+			queue = []gcsql.ReportedPost{}
+		}
+		return queue, nil
+	}
+
+	boards, _, err := moderatedBoards(staff)
+	if err != nil {
+		errEv.Err(err).Caller().Msg("Unable to get staff board assignments")
+		return "", err
+	}
+	categories, err := gcsql.GetAllReportCategories()
+	if err != nil {
+		errEv.Err(err).Caller().Msg("Unable to get report categories")
+		return "", err
+	}
+	presets, err := gcsql.GetBanPresets()
+	if err != nil {
+		errEv.Err(err).Caller().Msg("Unable to get ban presets")
+		return "", err
+	}
+	reportsBuffer := bytes.NewBufferString("")
+	if err = serverutil.MinifyTemplate(gctemplates.ManageReports, map[string]interface{}{
+		"queue":      queue,
+		"boards":     boards,
+		"categories": categories,
+		"presets":    presets,
+		"filter":     filter,
+		"message":    message,
+		"csrfToken":  staff.CSRFToken,
+		"canBlock":   staff.Can(gcsql.CapReportBlock),
+		"canDelete":  staff.Can(gcsql.CapPostDelete),
+		"canBan":     staff.Can(gcsql.CapBanCreate),
+	}, reportsBuffer, "text/html"); err != nil {
+		errEv.Err(err).Caller().Str("template", "manage_reports.html").Send()
+		return "", err
+	}
+	return reportsBuffer.String(), nil
+}
+
+// dismissReport clears the report with the given ID. If block is true, the post can't be reported again
+func dismissReport(reportID int, block bool, staff *gcsql.Staff, boardIDs []int, infoEv *zerolog.Event, errEv *zerolog.Event) error {
+	reportBoardID, err := gcsql.GetReportBoardID(reportID)
+	if err == nil && !canModerate(boardIDs, reportBoardID) {
+		err = ErrBoardPermission
+	}
+	if err == nil && block {
+		err = checkCapability(staff, gcsql.CapReportBlock)
+	}
+	if err != nil {
+		errEv.Err(err).
+			Int("reportID", reportID).
+			Caller().Send()
+		return err
+	}
+	found, err := gcsql.ClearReport(reportID, staff.ID, block)
+	if err != nil {
+		errEv.Err(err).
+			Int("reportID", reportID).
+			Caller().Send()
+		return err
+	}
+	if !found {
+		return errors.New("no matching reports")
+	}
+	infoEv.
+		Int("reportID", reportID).
+		Bool("blocked", block).
+		Msg("Report cleared")
+	logAction := gcsql.ModLogReportDismiss
+	if block {
+		logAction = gcsql.ModLogReportBlock
+	}
+	LogStaffAction(staff, gcsql.ModLogEntry{
+		Action:     logAction,
+		TargetType: gcsql.ModLogTargetReport,
+		TargetID:   reportID,
+		BoardID:    &reportBoardID,
+	}, nil, nil)
+	return nil
+}
+
+// reportBulkAction dismisses the reports of the posts selected in the report queue, optionally deleting the
+// posts and banning their posters, and returns a message describing what was done
+func reportBulkAction(action string, request *http.Request, staff *gcsql.Staff, boardIDs []int, infoEv *zerolog.Event, errEv *zerolog.Event) (string, error) {
+	gcutil.LogStr("bulkAction", action, infoEv, errEv)
+	var err error
+	switch action {
+	case reportActionDismiss:
+	case reportActionDelete:
+		err = checkCapability(staff, gcsql.CapPostDelete)
+	case reportActionDeleteBan:
+		if err = checkCapability(staff, gcsql.CapPostDelete); err == nil {
+			err = checkCapability(staff, gcsql.CapBanCreate)
+		}
+	default:
+		err = ErrInvalidReportAction
+	}
+	if err != nil {
+		errEv.Err(err).Caller().Send()
+		return "", err
+	}
+
+	var postIDs []int
+	for _, postIDstr := range request.PostForm["post"] {
+		postID, err := strconv.Atoi(postIDstr)
+		if err != nil {
+			errEv.Err(err).Caller().
+				Str("postID", postIDstr).Send()
+			return "", err
+		}
+		postIDs = append(postIDs, postID)
+	}
+	if len(postIDs) == 0 {
+		return "", ErrNoReportedPostsSelected
+	}
+
+	var banTemplate gcsql.IPBan
+	globalBan := request.PostFormValue("globalban") == "on"
+	presetID := request.PostFormValue("preset")
+	usePreset := presetID != "" && presetID != "0"
+	if action == reportActionDeleteBan && !usePreset {
+		if err = banDetailsFromRequest(&banTemplate, request, errEv); err != nil {
+			return "", err
+		}
+	}
+
+	rebuildBoards := map[int]*gcsql.Board{}
+	for _, postID := range postIDs {
+		post, err := gcsql.GetPostFromID(postID, true)
+		if err != nil {
+			errEv.Err(err).Caller().
+				Int("postID", postID).Msg("Unable to get reported post")
+			return "", fmt.Errorf("unable to get post #%d: %w", postID, err)
+		}
+		board, err := post.GetBoard()
+		if err == nil && !canModerate(boardIDs, board.ID) {
+			err = ErrBoardPermission
+		}
+		if err != nil {
+			errEv.Err(err).Caller().
+				Int("postID", postID).Send()
+			return "", err
+		}
+
+		reports, err := gcsql.GetPostReports(postID)
+		if err != nil {
+			errEv.Err(err).Caller().
+				Int("postID", postID).Msg("Unable to get post reports")
+			return "", err
+		}
+		for _, report := range reports {
+			if _, err = gcsql.ClearReport(report.ID, staff.ID, false); err != nil {
+				errEv.Err(err).Caller().
+					Int("reportID", report.ID).Send()
+				return "", err
+			}
+			if action == reportActionDismiss {
+				LogStaffAction(staff, gcsql.ModLogEntry{
+					Action:     gcsql.ModLogReportDismiss,
+					TargetType: gcsql.ModLogTargetReport,
+					TargetID:   report.ID,
+					BoardID:    &board.ID,
+				}, nil, nil)
+			}
+		}
+		if action == reportActionDismiss {
+			continue
+		}
+
+		if action == reportActionDeleteBan {
+			ban := banTemplate
+			ban.StaffID = staff.ID
+			ban.IP = post.IP
+			ban.BannedForPostID = &post.ID
+			ban.CopyPostText = post.Message
+			if !globalBan {
+				ban.BoardID = &board.ID
+			}
+			if usePreset {
+				_, err = banPresetFromRequest(&ban, request, errEv)
+			}
+			if err == nil {
+				err = checkBanPermission(staff, ban.BoardID)
+			}
+			if err == nil {
+				err = gcsql.NewIPBan(&ban)
+			}
+			if err != nil {
+				errEv.Err(err).Caller().
+					Int("postID", postID).
+					Msg("Unable to ban reported post's IP")
+				return "", err
+			}
+			infoEv.Int("bannedForPostID", postID)
+			LogStaffAction(staff, gcsql.ModLogEntry{
+				Action:     gcsql.ModLogBanCreate,
+				TargetType: gcsql.ModLogTargetBan,
+				TargetID:   ban.ID,
+				BoardID:    ban.BoardID,
+			}, nil, ban)
+		}
+
+		if err = building.DeletePost(post, board); err != nil {
+			return "", err
+		}
+		events.TriggerEvent("post-deleted", post, board, false)
+		logEntry := gcsql.ModLogEntry{
+			Action:     gcsql.ModLogPostDelete,
+			TargetType: gcsql.ModLogTargetPost,
+			TargetID:   post.ID,
+			BoardID:    &board.ID,
+		}
+		if post.IsTopPost {
+			logEntry.TargetType = gcsql.ModLogTargetThread
+		}
+		LogStaffAction(staff, logEntry, post, nil)
+		rebuildBoards[board.ID] = board
+	}
+	infoEv.Ints("postIDs", postIDs).Msg("Report queue action completed")
+
+	for _, board := range rebuildBoards {
+		building.QueueFullBoard(board)
+	}
+	if len(rebuildBoards) > 0 {
+		building.QueueFrontPage()
+	}
+	switch action {
+	case reportActionDelete:
+		return fmt.Sprintf("Deleted %d reported post(s)", len(postIDs)), nil
+	case reportActionDeleteBan:
+		return fmt.Sprintf("Deleted and banned the posters of %d reported post(s)", len(postIDs)), nil
+	default:
+		return fmt.Sprintf("Dismissed the reports of %d post(s)", len(postIDs)), nil
+	}
+}
+
+// reportCategoriesCallback lists the report categories and handles creating, editing, and deleting them.
+// Boards using a changed category are rebuilt, since the categories are shown in their report forms
+func reportCategoriesCallback(writer http.ResponseWriter, request *http.Request, staff *gcsql.Staff, wantsJSON bool, infoEv *zerolog.Event, errEv *zerolog.Event) (output interface{}, err error) {
+	var editCategory *gcsql.ReportCategory
+	var changedCategory *gcsql.ReportCategory
+	if editIDstr := request.FormValue("edit"); editIDstr != "" {
+		if editCategory, err = gcsql.GetReportCategory(gcutil.HackyStringToInt(editIDstr)); err != nil {
+			errEv.Err(err).Caller().
+				Str("editCategory", editIDstr).Send()
+			return "", err
+		}
+	} else if deleteIDstr := request.PostFormValue("delete"); deleteIDstr != "" {
+		deleteID := gcutil.HackyStringToInt(deleteIDstr)
+		if changedCategory, err = gcsql.GetReportCategory(deleteID); err == nil {
+			err = gcsql.DeleteReportCategory(deleteID)
+		}
+		if err != nil {
+			errEv.Err(err).Caller().
+				Str("deleteCategory", deleteIDstr).Send()
+			return "", err
+		}
+		infoEv.Str("deleteCategory", changedCategory.Name).Msg("Report category deleted")
+		LogStaffAction(staff, gcsql.ModLogEntry{
+			Action:     gcsql.ModLogReportCategoryDelete,
+			TargetType: gcsql.ModLogTargetReportCategory,
+			TargetID:   deleteID,
+		}, changedCategory, nil)
+	} else if request.PostFormValue("save_category") != "" {
+		changedCategory = &gcsql.ReportCategory{
+			Name:        request.PostFormValue("name"),
+			Description: request.PostFormValue("description"),
+		}
+		changedCategory.Position, _ = strconv.Atoi(request.PostFormValue("position"))
+		if boardID, _ := strconv.Atoi(request.PostFormValue("boardid")); boardID > 0 {
+			changedCategory.BoardID = &boardID
+		}
+		logEntry := gcsql.ModLogEntry{
+			Action:     gcsql.ModLogReportCategoryCreate,
+			TargetType: gcsql.ModLogTargetReportCategory,
+		}
+		var oldCategory *gcsql.ReportCategory
+		if updateIDstr := request.PostFormValue("updatecategory"); updateIDstr != "" {
+			changedCategory.ID = gcutil.HackyStringToInt(updateIDstr)
+			if oldCategory, err = gcsql.GetReportCategory(changedCategory.ID); err == nil {
+				err = changedCategory.Update()
+			}
+			logEntry.Action = gcsql.ModLogReportCategoryEdit
+		} else {
+			err = gcsql.NewReportCategory(changedCategory)
+		}
+		if err != nil {
+			errEv.Err(err).Caller().
+				Str("categoryName", changedCategory.Name).
+				Msg("Unable to save report category")
+			return "", err
+		}
+		infoEv.
+			Int("categoryID", changedCategory.ID).
+			Str("categoryName", changedCategory.Name).
+			Msg("Report category saved")
+		logEntry.TargetID = changedCategory.ID
+		if oldCategory != nil {
+			LogStaffAction(staff, logEntry, oldCategory, changedCategory)
+		} else {
+			LogStaffAction(staff, logEntry, nil, changedCategory)
+		}
+	}
+
+	if changedCategory != nil {
+		// the categories are shown in the report forms on board and thread pages
+		var rebuildBoards []gcsql.Board
+		if changedCategory.BoardID != nil {
+			board, err := gcsql.GetBoardFromID(*changedCategory.BoardID)
+			if err != nil {
+				errEv.Err(err).Caller().Msg("Unable to get the category's board")
+				return "", err
+			}
+			rebuildBoards = append(rebuildBoards, *board)
+		} else if rebuildBoards, err = gcsql.GetAllBoards(false); err != nil {
+			errEv.Err(err).Caller().Msg("Unable to get boards to rebuild")
+			return "", err
+		}
+		for b := range rebuildBoards {
+			building.QueueFullBoard(&rebuildBoards[b])
+		}
+	}
+
+	categories, err := gcsql.GetAllReportCategories()
+	if err != nil {
+		errEv.Err(err).Caller().Msg("Unable to get report categories")
+		return "", err
+	}
+	if wantsJSON {
+		return categories, nil
+	}
+	pageBuffer := bytes.NewBufferString("")
+	pageMap := map[string]interface{}{
+		"categories": categories,
+		"boards":     gcsql.AllBoards,
+		"csrfToken":  staff.CSRFToken,
+	}
+	if editCategory != nil {
+		pageMap["editCategory"] = editCategory
+	}
+	if err = serverutil.MinifyTemplate(gctemplates.ManageReportCategories, pageMap, pageBuffer, "text/html"); err != nil {
+		errEv.Err(err).Caller().Str("template", "manage_reportcategories.html").Send()
+		return "", err
+	}
+	return pageBuffer.String(), nil
+}
@@ -0,0 +1,89 @@
+package manage
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/gochan-org/gochan/pkg/config"
+	"github.com/gochan-org/gochan/pkg/gcsql"
+	"github.com/gochan-org/gochan/pkg/gctemplates"
+	"github.com/gochan-org/gochan/pkg/server/serverutil"
+	"github.com/rs/zerolog"
+)
+
+// configCallback shows the editable site and default board configuration fields and saves changes to them
+func configCallback(_ http.ResponseWriter, request *http.Request, staff *gcsql.Staff, _ bool, infoEv *zerolog.Event, errEv *zerolog.Event) (output interface{}, err error) {
+	var status string
+	if request.PostFormValue("do") == "save" {
+		changes, err := configChangesFromForm(request)
+		if err != nil {
+			errEv.Err(err).Caller().Send()
+			return "", err
+		}
+		if err = config.UpdateFromMap(changes, true); err != nil {
+			errEv.Err(err).Caller().Msg("Invalid configuration")
+			return "", err
+		}
+		if err = config.WriteConfig(); err != nil {
+			errEv.Err(err).Caller().Msg("Unable to write configuration file")
+			return "", err
+		}
+		infoEv.Msg("Configuration updated")
+		LogStaffAction(staff, gcsql.ModLogEntry{
+			Action:     gcsql.ModLogConfigEdit,
+			TargetType: gcsql.ModLogTargetSite,
+		}, nil, changes)
+		status = "Configuration saved"
+	}
+
+	configBuffer := bytes.NewBufferString("")
+	if err = serverutil.MinifyTemplate(gctemplates.ManageConfig, map[string]interface{}{
+		"csrfToken": staff.CSRFToken,
+		"status":    status,
+	}, configBuffer, "text/html"); err != nil {
+		errEv.Err(err).Str("template", "manage_config.html").Caller().Send()
+		return "", err
+	}
+	return configBuffer.String(), nil
+}
+
+// configChangesFromForm returns the new values of the editable configuration fields that were changed in the
+// config editor form, mapped to their field names
+func configChangesFromForm(request *http.Request) (map[string]interface{}, error) {
+	changes := make(map[string]interface{})
+	for _, field := range config.EditableFields() {
+		formValue := request.PostFormValue(field.Name)
+		var value interface{}
+		switch field.Kind {
+		case reflect.Int:
+			intValue, err := strconv.Atoi(strings.TrimSpace(formValue))
+			if err != nil {
+				return nil, fmt.Errorf("invalid value for %s: %q", field.Name, formValue)
+			}
+			value = intValue
+		case reflect.Bool:
+			value = formValue != ""
+		case reflect.String:
+			value = formValue
+		case reflect.Slice:
+			values := []string{}
+			for _, line := range strings.Split(formValue, "\n") {
+				if line = strings.TrimSpace(line); line != "" {
+					values = append(values, line)
+				}
+			}
+			value = values
+		}
+		if values, ok := value.([]string); ok && len(values) == 0 && reflect.ValueOf(field.Value).Len() == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(value, field.Value) {
+			changes[field.Name] = value
+		}
+	}
+	return changes, nil
+}
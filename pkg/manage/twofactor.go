@@ -0,0 +1,211 @@
+package manage
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gochan-org/gochan/pkg/config"
+	"github.com/gochan-org/gochan/pkg/gcsql"
+	"github.com/gochan-org/gochan/pkg/gctemplates"
+	"github.com/gochan-org/gochan/pkg/gcutil"
+	"github.com/gochan-org/gochan/pkg/server/serverutil"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// pendingLoginTimeout is how long staff have to enter their two-factor authentication code after
+	// entering their password
+	pendingLoginTimeout = 5 * time.Minute
+	// maxTOTPAttempts is the number of codes that can be tried before the staff member has to enter
+	// their password again
+	maxTOTPAttempts = 5
+)
+
+var (
+	pendingLogins     = map[string]*pendingLogin{}
+	pendingLoginsLock sync.Mutex
+)
+
+// pendingLogin is a login by a staff member with two-factor authentication enabled who has entered the
+// correct password but not their code yet
+type pendingLogin struct {
+	username   string
+	sessionKey string
+	expires    time.Time
+	attempts   int
+}
+
+// addPendingLogin stores the login until the staff member enters their two-factor authentication code and
+// returns the token that the login form uses to refer to it
+func addPendingLogin(username string, sessionKey string) (string, error) {
+	tokenBytes := make([]byte, 16)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(tokenBytes)
+	now := time.Now()
+
+	pendingLoginsLock.Lock()
+	defer pendingLoginsLock.Unlock()
+	for t, login := range pendingLogins {
+		if now.After(login.expires) {
+			delete(pendingLogins, t)
+		}
+	}
+	pendingLogins[token] = &pendingLogin{
+		username:   username,
+		sessionKey: sessionKey,
+		expires:    now.Add(pendingLoginTimeout),
+	}
+	return token, nil
+}
+
+// usePendingLogin returns the pending login with the given token and counts the attempt, or nil if it
+// doesn't exist, has expired, or has had too many attempts
+func usePendingLogin(token string) *pendingLogin {
+	pendingLoginsLock.Lock()
+	defer pendingLoginsLock.Unlock()
+	login, ok := pendingLogins[token]
+	if !ok {
+		return nil
+	}
+	login.attempts++
+	if time.Now().After(login.expires) || login.attempts > maxTOTPAttempts {
+		delete(pendingLogins, token)
+		return nil
+	}
+	return login
+}
+
+func removePendingLogin(token string) {
+	pendingLoginsLock.Lock()
+	delete(pendingLogins, token)
+	pendingLoginsLock.Unlock()
+}
+
+// finishTOTPLogin checks the two-factor authentication (or recovery) code for the pending login and
+// creates the session if it is valid. It returns sInvalidTOTP if the code is wrong but can be tried again,
+// or sInvalidPassword if the staff member needs to start over
+func finishTOTPLogin(token string, code string, request *http.Request, writer http.ResponseWriter) int {
+	login := usePendingLogin(token)
+	if login == nil {
+		return sInvalidPassword
+	}
+	errEv := gcutil.LogError(nil).
+		Str("staff", login.username).
+		Str("IP", gcutil.GetRealIP(request))
+	defer errEv.Discard()
+
+	staff, err := gcsql.GetStaffByUsername(login.username, true)
+	if err != nil {
+		errEv.Err(err).Caller().Send()
+		removePendingLogin(token)
+		return sOtherError
+	}
+	if err = staff.VerifyTOTP(code); errors.Is(err, gcsql.ErrInvalidTOTPCode) {
+		errEv.Err(err).Caller().
+			Int("attempt", login.attempts).
+			Msg("Invalid two-factor authentication code")
+		recordFailedLogin(gcutil.GetRealIP(request), login.username)
+		return sInvalidTOTP
+	} else if err != nil {
+		errEv.Err(err).Caller().Send()
+		removePendingLogin(token)
+		return sOtherError
+	}
+	removePendingLogin(token)
+	return startSession(staff, login.sessionKey, request, writer)
+}
+
+// staffInfoCallback returns the logged in staff member's info as JSON, or shows their account page, where
+// they can set up or disable two-factor authentication
+func staffInfoCallback(writer http.ResponseWriter, request *http.Request, staff *gcsql.Staff, wantsJSON bool, infoEv *zerolog.Event, errEv *zerolog.Event) (output interface{}, err error) {
+	do := request.PostFormValue("totp")
+	if staff.ID == 0 {
+		if wantsJSON {
+			return staff, nil
+		}
+		http.Redirect(writer, request, config.WebPath("/manage/login"), http.StatusFound)
+		return "", nil
+	}
+	roleRequiresTOTP := staff.Role != nil && staff.Role.RequireTOTP
+	data := map[string]interface{}{
+		"staff":            staff,
+		"roleRequiresTOTP": roleRequiresTOTP,
+		"csrfToken":        staff.CSRFToken,
+	}
+	switch do {
+	case "":
+		if wantsJSON {
+			return staff, nil
+		}
+	case "enroll":
+		if _, err = staff.StartTOTPEnrollment(); err != nil {
+			errEv.Err(err).Caller().Msg("Unable to start two-factor authentication setup")
+			return "", err
+		}
+	case "confirm":
+		var recoveryCodes []string
+		recoveryCodes, err = staff.EnableTOTP(request.PostFormValue("code"))
+		if errors.Is(err, gcsql.ErrInvalidTOTPCode) {
+			data["totpError"] = err.Error()
+			break
+		} else if err != nil {
+			errEv.Err(err).Caller().Msg("Unable to enable two-factor authentication")
+			return "", err
+		}
+		infoEv.Msg("Two-factor authentication enabled")
+		data["recoveryCodes"] = recoveryCodes
+	case "disable":
+		if roleRequiresTOTP {
+			return "", gcsql.ErrTOTPRequiredByRole
+		}
+		if err = staff.VerifyTOTP(request.PostFormValue("code")); errors.Is(err, gcsql.ErrInvalidTOTPCode) {
+			data["totpError"] = err.Error()
+			break
+		} else if err != nil {
+			errEv.Err(err).Caller().Send()
+			return "", err
+		}
+		if err = staff.DisableTOTP(); err != nil {
+			errEv.Err(err).Caller().Msg("Unable to disable two-factor authentication")
+			return "", err
+		}
+		infoEv.Msg("Two-factor authentication disabled")
+	default:
+		return "", &ErrStaffAction{
+			ErrorField: "formerror",
+			Action:     "staffinfo",
+			Message:    "Unrecognized two-factor authentication request",
+		}
+	}
+
+	if !staff.TOTPEnabled && staff.TOTPSecret != "" {
+		// setup has been started but not confirmed yet
+		data["totpSecret"] = staff.TOTPSecret
+		data["totpURI"] = gcutil.TOTPURI(config.GetSiteConfig().SiteName, staff.Username, staff.TOTPSecret)
+	}
+	if staff.TOTPEnabled {
+		if data["recoveryCodesLeft"], err = staff.NumRecoveryCodesLeft(); err != nil {
+			errEv.Err(err).Caller().Send()
+			return "", err
+		}
+	}
+	if wantsJSON {
+		delete(data, "staff")
+		delete(data, "roleRequiresTOTP")
+		delete(data, "csrfToken")
+		return data, nil
+	}
+	pageBuffer := bytes.NewBufferString("")
+	if err = serverutil.MinifyTemplate(gctemplates.ManageStaffInfo, data, pageBuffer, "text/html"); err != nil {
+		errEv.Err(err).Caller().Str("template", "manage_staffinfo.html").Send()
+		return "", err
+	}
+	return pageBuffer.String(), nil
+}
@@ -2,11 +2,13 @@ package manage
 
 import (
 	"bytes"
-	"database/sql"
+	"crypto/subtle"
+	"errors"
 	"fmt"
 	"net/http"
 	"regexp"
 	"strconv"
+	"time"
 
 	"github.com/gochan-org/gochan/pkg/config"
 	"github.com/gochan-org/gochan/pkg/gcsql"
@@ -21,6 +23,13 @@ const (
 	sSuccess = iota
 	sInvalidPassword
 	sOtherError
+	// sTOTPRequired means the password was correct but the staff member needs to enter their two-factor
+	// authentication code before the session is created
+	sTOTPRequired
+	sInvalidTOTP
+	// sLockedOut means there have been too many failed login attempts from the IP address or for the
+	// username, so the login wasn't checked
+	sLockedOut
 )
 
 var (
@@ -28,64 +37,125 @@ var (
 )
 
 func createSession(key, username, password string, request *http.Request, writer http.ResponseWriter) int {
-	//returns 0 for successful, 1 for password mismatch, and 2 for other
-	domain := request.Host
+	//returns 0 for successful, 1 for password mismatch, 2 for other, 3 if a 2FA code is needed, and 5 if
+	// there have been too many failed attempts
 	var err error
+	ip := gcutil.GetRealIP(request)
 	errEv := gcutil.LogError(nil).
 		Str("staff", username).
-		Str("IP", gcutil.GetRealIP(request))
+		Str("IP", ip)
 	defer errEv.Discard()
 
-	domain = chopPortNumRegex.Split(domain, -1)[0]
-
 	if !serverutil.ValidReferer(request) {
 		gcutil.LogWarning().
 			Str("staff", username).
 			Str("IP", gcutil.GetRealIP(request)).
-			Str("remoteAddr", request.Response.Request.RemoteAddr).
+			Str("remoteAddr", request.RemoteAddr).
 			Msg("Rejected login from possible spambot")
 		return sOtherError
 	}
+	lockedOut, err := loginLockedOut(ip, username)
+	if err != nil {
+		errEv.Err(err).Caller().Msg("Unable to get failed login attempts")
+		return sOtherError
+	}
+	if lockedOut {
+		gcutil.LogWarning().
+			Str("staff", username).
+			Str("IP", ip).
+			Msg("Rejected login, too many failed attempts")
+		return sLockedOut
+	}
 	staff, err := gcsql.GetStaffByUsername(username, true)
 	if err != nil {
-		if err != sql.ErrNoRows {
+		if err != gcsql.ErrUnrecognizedUsername {
 			errEv.Err(err).
 				Str("remoteAddr", request.RemoteAddr).
 				Caller().Msg("Invalid password")
 		}
+		recordFailedLogin(ip, username)
 		return sInvalidPassword
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(staff.PasswordChecksum), []byte(password))
-	if err == bcrypt.ErrMismatchedHashAndPassword {
-		// password mismatch
+	if err = bcrypt.CompareHashAndPassword([]byte(staff.PasswordChecksum), []byte(password)); err != nil {
+		// a password mismatch, or a password checksum that isn't a valid bcrypt hash
+		if !errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			errEv.Err(err)
+		}
 		errEv.Caller().
 			Msg("Invalid password")
+		recordFailedLogin(ip, username)
 		return sInvalidPassword
 	}
+	if staff.TOTPEnabled {
+		return sTOTPRequired
+	}
+	return startSession(staff, key, request, writer)
+}
 
+// loginLockedOut returns true if there have been at least MaxLoginAttempts failed logins from the IP address
+// or for the username within the LoginLockout duration
+func loginLockedOut(ip string, username string) (bool, error) {
+	siteConfig := config.GetSiteConfig()
+	lockout, err := gcutil.ParseDurationString(siteConfig.LoginLockout)
+	if err != nil {
+		return false, err
+	}
+	ipAttempts, usernameAttempts, err := gcsql.NumFailedLogins(ip, username, time.Now().Add(-lockout))
+	if err != nil {
+		return false, err
+	}
+	return ipAttempts >= siteConfig.MaxLoginAttempts || usernameAttempts >= siteConfig.MaxLoginAttempts, nil
+}
+
+// recordFailedLogin stores the failed login so that it is counted by loginLockedOut
+func recordFailedLogin(ip string, username string) {
+	if err := gcsql.RecordFailedLogin(ip, username); err != nil {
+		gcutil.LogError(err).
+			Str("staff", username).
+			Str("IP", ip).
+			Caller().Msg("Unable to record failed login attempt")
+	}
+}
+
+// startSession sets the session cookie and creates the staff member's login session after they have
+// been authenticated
+func startSession(staff *gcsql.Staff, key string, request *http.Request, writer http.ResponseWriter) int {
+	domain := chopPortNumRegex.Split(request.Host, -1)[0]
 	// successful login, add cookie that expires in one month
 	systemCritical := config.GetSystemCriticalConfig()
 	siteConfig := config.GetSiteConfig()
 	maxAge, err := gcutil.ParseDurationString(siteConfig.CookieMaxAge)
 	if err != nil {
-		maxAge = gcutil.DefaultMaxAge
+		maxAge = gcutil.DefaultMaxAge * time.Second
 	}
 	http.SetCookie(writer, &http.Cookie{
-		Name:   "sessiondata",
-		Value:  key,
-		Path:   systemCritical.WebRoot,
-		Domain: domain,
-		MaxAge: int(maxAge),
+		Name:     "sessiondata",
+		Value:    key,
+		Path:     systemCritical.WebRoot,
+		Domain:   domain,
+		MaxAge:   int(maxAge.Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
 	})
 
-	if err = staff.CreateLoginSession(key); err != nil {
+	ip := gcutil.GetRealIP(request)
+	if err = staff.CreateLoginSession(key, ip, request.UserAgent(), maxAge); err != nil {
 		gcutil.LogError(err).
-			Str("staff", username).
+			Str("staff", staff.Username).
 			Str("sessionKey", key).
 			Caller().Msg("Error creating new staff session")
 		return sOtherError
 	}
+	lockout, err := gcutil.ParseDurationString(siteConfig.LoginLockout)
+	if err != nil {
+		lockout = 0
+	}
+	if err = gcsql.ClearFailedLogins(ip, staff.Username, time.Now().Add(-lockout)); err != nil {
+		gcutil.LogError(err).
+			Str("staff", staff.Username).
+			Caller().Msg("Unable to clear failed login attempts")
+	}
 
 	return sSuccess
 }
@@ -110,18 +180,33 @@ func getCurrentFullStaff(request *http.Request) (*gcsql.Staff, error) {
 	return gcsql.GetStaffBySession(sessionCookie.Value)
 }
 
-// GetStaffRank returns the rank number of the staff referenced in the request
-func GetStaffRank(request *http.Request) int {
+// validCSRFToken returns true if the request includes the CSRF token of the staff member's session,
+// either as the csrftoken form value or in the X-CSRF-Token header (used by the frontend for AJAX requests)
+func validCSRFToken(request *http.Request, staff *gcsql.Staff) bool {
+	if staff.CSRFToken == "" {
+		// sessions created before CSRF tokens were added don't have one
+		return false
+	}
+	token := request.PostFormValue("csrftoken")
+	if token == "" {
+		token = request.Header.Get("X-CSRF-Token")
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(staff.CSRFToken)) == 1
+}
+
+// StaffCan returns true if the staff member referenced in the request is logged in and their role has
+// the given capability
+func StaffCan(request *http.Request, capability string) bool {
 	staff, err := getCurrentFullStaff(request)
 	if err != nil {
-		return NoPerms
+		return false
 	}
-	return staff.Rank
+	return staff.Can(capability)
 }
 
 func init() {
-	RegisterManagePage("actions", "Staff actions", JanitorPerms, AlwaysJSON, getStaffActions)
-	RegisterManagePage("dashboard", "Dashboard", JanitorPerms, NoJSON, dashboardCallback)
+	RegisterManagePage("actions", "Staff actions", JanitorPerms, "", AlwaysJSON, getStaffActions)
+	RegisterManagePage("dashboard", "Dashboard", JanitorPerms, "", NoJSON, dashboardCallback)
 	RegisterNoPermPages()
 	RegisterJanitorPages()
 	RegisterModeratorPages()
@@ -134,21 +219,10 @@ func dashboardCallback(writer http.ResponseWriter, request *http.Request, staff
 	if err != nil {
 		return nil, err
 	}
-	rankString := ""
-	switch staff.Rank {
-	case AdminPerms:
-		rankString = "administrator"
-	case ModPerms:
-		rankString = "moderator"
-	case JanitorPerms:
-		rankString = "janitor"
-	}
-
-	availableActions := getAvailableActions(staff.Rank, true)
+	availableActions := getAvailableActions(staff, true)
 	if err = serverutil.MinifyTemplate(gctemplates.ManageDashboard, map[string]interface{}{
 		"actions":       availableActions,
-		"rank":          staff.Rank,
-		"rankString":    rankString,
+		"roleName":      staff.RoleName(),
 		"announcements": announcements,
 		"boards":        gcsql.AllBoards,
 	}, dashBuffer, "text/html"); err != nil {
@@ -159,20 +233,21 @@ func dashboardCallback(writer http.ResponseWriter, request *http.Request, staff
 }
 
 // bordsRequestType takes the request and returns "cancel", "create", "delete",
-// "edit", or "modify" and the board's ID according to the request
+// "edit", or "modify" and the board's ID according to the request. The request types that
+// change a board are only read from POST requests, which have their CSRF token checked
 func boardsRequestType(request *http.Request) (string, int, error) {
 	var requestType string
 	var boardID int
 	var err error
 	if request.FormValue("docancel") != "" {
 		requestType = "cancel"
-	} else if request.FormValue("docreate") != "" {
+	} else if request.PostFormValue("docreate") != "" {
 		requestType = "create"
-	} else if request.FormValue("dodelete") != "" {
+	} else if request.PostFormValue("dodelete") != "" {
 		requestType = "delete"
 	} else if request.FormValue("doedit") != "" {
 		requestType = "edit"
-	} else if request.FormValue("domodify") != "" {
+	} else if request.PostFormValue("domodify") != "" {
 		requestType = "modify"
 	}
 	boardIDstr := request.FormValue("board")
@@ -184,11 +259,15 @@ func boardsRequestType(request *http.Request) (string, int, error) {
 
 func getAllStaffNopass(activeOnly bool) ([]gcsql.Staff, error) {
 	query := `SELECT
-	id, username, global_rank, added_on, last_login, is_active
+	id, username, COALESCE(role_id, 0), added_on, last_login, is_active, totp_enabled, all_boards
 	FROM DBPREFIXstaff`
 	if activeOnly {
 		query += " WHERE is_active"
 	}
+	roles, err := gcsql.GetRoles()
+	if err != nil {
+		return nil, err
+	}
 	rows, err := gcsql.QuerySQL(query)
 	if err != nil {
 		return nil, err
@@ -197,10 +276,16 @@ func getAllStaffNopass(activeOnly bool) ([]gcsql.Staff, error) {
 	var staff []gcsql.Staff
 	for rows.Next() {
 		var s gcsql.Staff
-		err = rows.Scan(&s.ID, &s.Username, &s.Rank, &s.AddedOn, &s.LastLogin, &s.IsActive)
+		err = rows.Scan(&s.ID, &s.Username, &s.RoleID, &s.AddedOn, &s.LastLogin, &s.IsActive, &s.TOTPEnabled,
+			&s.AllBoards)
 		if err != nil {
 			return nil, err
 		}
+		for r := range roles {
+			if roles[r].ID == s.RoleID {
+				s.Role = &roles[r]
+			}
+		}
 		staff = append(staff, s)
 	}
 	return staff, nil
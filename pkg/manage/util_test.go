@@ -0,0 +1,123 @@
+package manage
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBoardsRequestType(t *testing.T) {
+	testCases := []struct {
+		desc         string
+		method       string
+		query        string
+		body         string
+		expectedType string
+	}{
+		{
+			desc:         "no request type",
+			method:       http.MethodGet,
+			expectedType: "",
+		},
+		{
+			desc:         "edit in GET request",
+			method:       http.MethodGet,
+			query:        "doedit=Edit&board=1",
+			expectedType: "edit",
+		},
+		{
+			desc:   "delete in GET request",
+			method: http.MethodGet,
+			query:  "dodelete=Delete&board=1",
+		},
+		{
+			desc:   "create in GET request",
+			method: http.MethodGet,
+			query:  "docreate=Create&dir=test",
+		},
+		{
+			desc:   "modify in GET request",
+			method: http.MethodGet,
+			query:  "domodify=Save&board=1",
+		},
+		{
+			desc:   "delete in the query of a POST request",
+			method: http.MethodPost,
+			query:  "dodelete=Delete&board=1",
+		},
+		{
+			desc:         "delete in POST request",
+			method:       http.MethodPost,
+			body:         "dodelete=Delete&board=1",
+			expectedType: "delete",
+		},
+		{
+			desc:         "modify in POST request",
+			method:       http.MethodPost,
+			body:         "domodify=Save&board=1",
+			expectedType: "modify",
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			request := httptest.NewRequest(tC.method, "/manage/boards?"+tC.query, strings.NewReader(tC.body))
+			if tC.body != "" {
+				request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+			if err := request.ParseForm(); err != nil {
+				t.Fatal(err)
+			}
+			requestType, _, err := boardsRequestType(request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if requestType != tC.expectedType {
+				t.Errorf("expected request type %q, got %q", tC.expectedType, requestType)
+			}
+		})
+	}
+}
+
+func TestCanModerate(t *testing.T) {
+	boardID := 2
+	testCases := []struct {
+		desc              string
+		boardIDs          []int
+		canModerate       bool
+		canModerateGlobal bool
+	}{
+		{
+			desc:              "every board",
+			boardIDs:          nil,
+			canModerate:       true,
+			canModerateGlobal: true,
+		},
+		{
+			desc:     "no assigned boards",
+			boardIDs: []int{},
+		},
+		{
+			desc:        "assigned board",
+			boardIDs:    []int{1, 2},
+			canModerate: true,
+		},
+		{
+			desc:     "other board",
+			boardIDs: []int{1},
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			if canModerate(tC.boardIDs, boardID) != tC.canModerate {
+				t.Errorf("expected canModerate to return %t", tC.canModerate)
+			}
+			if canModerateBan(tC.boardIDs, &boardID) != tC.canModerate {
+				t.Errorf("expected canModerateBan to return %t for a board ban", tC.canModerate)
+			}
+			if canModerateBan(tC.boardIDs, nil) != tC.canModerateGlobal {
+				t.Errorf("expected canModerateBan to return %t for a global ban", tC.canModerateGlobal)
+			}
+		})
+	}
+}
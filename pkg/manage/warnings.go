@@ -0,0 +1,178 @@
+package manage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gochan-org/gochan/pkg/gcsql"
+	"github.com/gochan-org/gochan/pkg/gctemplates"
+	"github.com/gochan-org/gochan/pkg/gcutil"
+	"github.com/gochan-org/gochan/pkg/server/serverutil"
+	"github.com/rs/zerolog"
+)
+
+const (
+	recentWarningsLimit = 100
+)
+
+var (
+	ErrMissingWarningPost = errors.New("missing or invalid postid value")
+)
+
+// warningsCallback shows the form for warning the poster of a post and the most recent warnings, and handles
+// issuing and deleting warnings
+func warningsCallback(writer http.ResponseWriter, request *http.Request, staff *gcsql.Staff, wantsJSON bool, infoEv *zerolog.Event, errEv *zerolog.Event) (output interface{}, err error) {
+	boardIDs, err := staff.ModeratedBoardIDs()
+	if err != nil {
+		errEv.Err(err).Caller().Msg("Unable to get staff board assignments")
+		return "", err
+	}
+	data := map[string]interface{}{
+		"csrfToken": staff.CSRFToken,
+	}
+
+	if deleteIDstr := request.PostFormValue("delete"); deleteIDstr != "" {
+		deleteID, err := strconv.Atoi(deleteIDstr)
+		if err != nil {
+			errEv.Err(err).Caller().
+				Str("deleteID", deleteIDstr).Send()
+			return "", err
+		}
+		if err = deleteWarning(deleteID, staff, boardIDs, infoEv, errEv); err != nil {
+			return "", err
+		}
+		data["message"] = fmt.Sprintf("Deleted warning #%d", deleteID)
+	} else if postIDstr := request.FormValue("postid"); postIDstr != "" {
+		postID, err := strconv.Atoi(postIDstr)
+		if err != nil {
+			errEv.Err(err).Caller().
+				Str("postID", postIDstr).Send()
+			return "", ErrMissingWarningPost
+		}
+		gcutil.LogInt("postID", postID, infoEv, errEv)
+		post, err := gcsql.GetPostFromID(postID, true)
+		if err != nil {
+			errEv.Err(err).Caller().Msg("Unable to get post")
+			return "", err
+		}
+		boardID, err := post.GetBoardID()
+		if err != nil {
+			errEv.Err(err).Caller().Msg("Unable to get post's board ID")
+			return "", err
+		}
+		if !canModerate(boardIDs, boardID) {
+			errEv.Err(ErrBoardPermission).Caller().Int("boardID", boardID).Send()
+			return "", ErrBoardPermission
+		}
+		if request.PostFormValue("dowarn") != "" {
+			warning := &gcsql.Warning{
+				StaffID:      staff.ID,
+				BoardID:      &boardID,
+				PostID:       &post.ID,
+				CopyPostText: post.Message,
+				IP:           post.IP,
+				Message:      request.PostFormValue("message"),
+				StaffNote:    request.PostFormValue("staffnote"),
+			}
+			if err = gcsql.NewWarning(warning); err != nil {
+				errEv.Err(err).Caller().Msg("Unable to create warning")
+				return "", err
+			}
+			infoEv.Int("warningID", warning.ID).Msg("Warning issued")
+			LogStaffAction(staff, gcsql.ModLogEntry{
+				Action:     gcsql.ModLogWarningCreate,
+				TargetType: gcsql.ModLogTargetWarning,
+				TargetID:   warning.ID,
+				BoardID:    &boardID,
+			}, nil, map[string]interface{}{
+				"postID":  post.ID,
+				"ip":      warning.IP,
+				"message": warning.Message,
+			})
+			if wantsJSON {
+				return warning, nil
+			}
+			data["message"] = fmt.Sprintf("Warned the poster of post #%d", post.ID)
+		} else {
+			board, err := gcsql.GetBoardFromID(boardID)
+			if err != nil {
+				errEv.Err(err).Caller().Msg("Unable to get post's board")
+				return "", err
+			}
+			data["post"] = post
+			data["board"] = board
+		}
+	}
+
+	warnings, err := gcsql.GetRecentWarnings(boardIDs, recentWarningsLimit)
+	if err != nil {
+		errEv.Err(err).Caller().Msg("Unable to get warnings")
+		return "", err
+	}
+	if wantsJSON {
+		if warnings == nil {
+			warnings = []gcsql.WarningListing{}
+		}
+		return warnings, nil
+	}
+	data["warnings"] = warnings
+	data["canViewIP"] = staff.Can(gcsql.CapIPView)
+	warningsBuffer := bytes.NewBufferString("")
+	if err = serverutil.MinifyTemplate(gctemplates.ManageWarnings, data, warningsBuffer, "text/html"); err != nil {
+		errEv.Err(err).Caller().Str("template", "manage_warnings.html").Send()
+		return "", err
+	}
+	return warningsBuffer.String(), nil
+}
+
+// deleteWarning deletes the warning with the given ID if it was issued on a board the staff member moderates
+func deleteWarning(warningID int, staff *gcsql.Staff, boardIDs []int, infoEv *zerolog.Event, errEv *zerolog.Event) error {
+	gcutil.LogInt("warningID", warningID, infoEv, errEv)
+	warning, err := gcsql.GetWarning(warningID)
+	if err == nil && !canModerateBan(boardIDs, warning.BoardID) {
+		err = ErrBoardPermission
+	}
+	if err == nil {
+		err = gcsql.DeleteWarning(warningID)
+	}
+	if err != nil {
+		errEv.Err(err).Caller().Send()
+		return err
+	}
+	infoEv.Msg("Warning deleted")
+	LogStaffAction(staff, gcsql.ModLogEntry{
+		Action:     gcsql.ModLogWarningDelete,
+		TargetType: gcsql.ModLogTargetWarning,
+		TargetID:   warningID,
+		BoardID:    warning.BoardID,
+	}, map[string]interface{}{
+		"postID":       warning.PostID,
+		"ip":           warning.IP,
+		"message":      warning.Message,
+		"acknowledged": warning.IsAcknowledged,
+	}, nil)
+	return nil
+}
+
+// moderatedWarningsByIP returns the warnings given to the IP address that were issued on boards the staff member
+// moderates
+func moderatedWarningsByIP(staff *gcsql.Staff, ip string) ([]gcsql.WarningListing, error) {
+	boardIDs, err := staff.ModeratedBoardIDs()
+	if err != nil {
+		return nil, err
+	}
+	warnings, err := gcsql.GetWarningsByIP(ip)
+	if err != nil || boardIDs == nil {
+		return warnings, err
+	}
+	var moderatedWarnings []gcsql.WarningListing
+	for _, warning := range warnings {
+		if canModerateBan(boardIDs, warning.BoardID) {
+			moderatedWarnings = append(moderatedWarnings, warning)
+		}
+	}
+	return moderatedWarnings, nil
+}
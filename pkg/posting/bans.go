@@ -16,16 +16,50 @@ import (
 	"github.com/rs/zerolog"
 )
 
+// banAppealStatus returns the ban's most recent appeal (nil if it hasn't been appealed) and the number of times
+// it can still be appealed, or -1 if there is no limit
+func banAppealStatus(ban *gcsql.IPBan) (*gcsql.IPBanAppeal, int, error) {
+	appeals, err := ban.GetAppeals()
+	if err != nil {
+		return nil, 0, err
+	}
+	appealsLeft := -1
+	if maxAppeals := config.GetSiteConfig().MaxBanAppeals; maxAppeals > 0 {
+		appealsLeft = maxAppeals - len(appeals)
+		if appealsLeft < 0 {
+			appealsLeft = 0
+		}
+	}
+	if len(appeals) == 0 {
+		return nil, appealsLeft, nil
+	}
+	return &appeals[0], appealsLeft, nil
+}
+
 func showBanpage(ban *gcsql.IPBan, post *gcsql.Post, postBoard *gcsql.Board, writer http.ResponseWriter, request *http.Request) {
+	appeal, appealsLeft, err := banAppealStatus(ban)
+	if err != nil {
+		gcutil.LogError(err).
+			Str("IP", post.IP).
+			Int("banID", ban.ID).
+			Msg("Unable to get ban appeals")
+		server.ServeErrorPage(writer, "Error getting ban info")
+		return
+	}
+	siteConfig := config.GetSiteConfig()
 	banPageBuffer := bytes.NewBufferString("")
-	err := serverutil.MinifyTemplate(gctemplates.Banpage, map[string]interface{}{
+	err = serverutil.MinifyTemplate(gctemplates.Banpage, map[string]interface{}{
 		"systemCritical": config.GetSystemCriticalConfig(),
-		"siteConfig":     config.GetSiteConfig(),
+		"siteConfig":     siteConfig,
 		"boardConfig":    config.GetBoardConfig(postBoard.Dir),
 		"ban":            ban,
+		"ip":             post.IP,
 		"board":          postBoard,
 		"permanent":      ban.Permanent,
 		"expires":        ban.ExpiresAt,
+		"appeal":         appeal,
+		"appealsLeft":    appealsLeft,
+		"appealLater":    ban.AppealAt.After(time.Now()),
 	}, banPageBuffer, "text/html")
 	if err != nil {
 		gcutil.LogError(err).
@@ -170,7 +204,7 @@ func handleAppeal(writer http.ResponseWriter, request *http.Request, errEv *zero
 		server.ServeErrorPage(writer, fmt.Sprintf("Invalid banid %d", banID))
 		return
 	}
-	if ban.IP != gcutil.GetRealIP(request) {
+	if !ban.Matches(gcutil.GetRealIP(request)) {
 		errEv.Caller().
 			Str("banIP", ban.IP).
 			Msg("User tried to appeal a ban from a different IP")
@@ -182,15 +216,36 @@ func handleAppeal(writer http.ResponseWriter, request *http.Request, errEv *zero
 		server.ServeErrorPage(writer, "Requested ban is not active")
 		return
 	}
-	if !ban.CanAppeal {
+	if !ban.CanAppeal || !config.GetSiteConfig().EnableAppeals {
 		errEv.Caller().Msg("Rejected appeal submission, appeals denied for this ban")
 		server.ServeErrorPage(writer, "You can not appeal this ban")
+		return
 	}
 	if ban.AppealAt.After(time.Now()) {
 		errEv.Caller().
 			Time("appealAt", ban.AppealAt).
 			Msg("Rejected appeal submission, can't appeal yet")
 		server.ServeErrorPage(writer, "You are not able to appeal this ban until "+ban.AppealAt.Format(config.GetBoardConfig("").DateTimeFormat))
+		return
+	}
+	lastAppeal, appealsLeft, err := banAppealStatus(ban)
+	if err != nil {
+		errEv.Err(err).
+			Caller().Msg("Unable to get ban appeals")
+		server.ServeErrorPage(writer, "Error getting ban info")
+		return
+	}
+	if lastAppeal != nil && lastAppeal.IsPending() {
+		errEv.Caller().
+			Int("appealID", lastAppeal.ID).
+			Msg("Rejected appeal submission, previous appeal is still pending")
+		server.ServeErrorPage(writer, "Your previous appeal has not been reviewed yet")
+		return
+	}
+	if appealsLeft == 0 {
+		errEv.Caller().Msg("Rejected appeal submission, ban has been appealed the maximum number of times")
+		server.ServeErrorPage(writer, "You have already appealed this ban the maximum number of times")
+		return
 	}
 	if err = ban.Appeal(appealMsg); err != nil {
 		errEv.Err(err).
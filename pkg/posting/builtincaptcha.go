@@ -0,0 +1,278 @@
+package posting
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"html/template"
+	"image"
+	"image/color"
+	"image/png"
+	"math/big"
+	mathrand "math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gochan-org/gochan/pkg/config"
+	"github.com/gochan-org/gochan/pkg/gcutil"
+	"github.com/gochan-org/gochan/pkg/server"
+)
+
+const (
+	builtinCaptchaCookie        = "captchaid"
+	defaultBuiltinCaptchaLength = 6
+	defaultBuiltinCaptchaExpire = 15
+	maxPendingCaptchas          = 10000
+	maxPendingCaptchasPerIP     = 10
+	glyphScale                  = 4
+	glyphPadding                = 8
+)
+
+var (
+	// 5x7 bitmaps for the digits used in builtin CAPTCHA challenges
+	captchaGlyphs = map[byte][7]string{
+		'0': {"01110", "10001", "10011", "10101", "11001", "10001", "01110"},
+		'1': {"00100", "01100", "00100", "00100", "00100", "00100", "01110"},
+		'2': {"01110", "10001", "00001", "00010", "00100", "01000", "11111"},
+		'3': {"11111", "00010", "00100", "00010", "00001", "10001", "01110"},
+		'4': {"00010", "00110", "01010", "10010", "11111", "00010", "00010"},
+		'5': {"11111", "10000", "11110", "00001", "00001", "10001", "01110"},
+		'6': {"00110", "01000", "10000", "11110", "10001", "10001", "01110"},
+		'7': {"11111", "00001", "00010", "00100", "01000", "01000", "01000"},
+		'8': {"01110", "10001", "10001", "01110", "10001", "10001", "01110"},
+		'9': {"01110", "10001", "10001", "01111", "00001", "00010", "01100"},
+	}
+)
+
+type captchaChallenge struct {
+	answer  string
+	ip      string
+	created time.Time
+	expires time.Time
+}
+
+// builtinCaptcha generates image challenges without relying on a third party service. Solutions
+// are kept in memory and can only be used once. Each IP can have up to maxPendingCaptchasPerIP
+// pending challenges, and the oldest one is replaced when a new one is requested after that
+type builtinCaptcha struct {
+	length     int
+	expire     time.Duration
+	challenges map[string]captchaChallenge
+	lock       sync.Mutex
+}
+
+func (bc *builtinCaptcha) Init(cfg *config.CaptchaConfig) error {
+	bc.length = cfg.Length
+	if bc.length < 1 {
+		bc.length = defaultBuiltinCaptchaLength
+	}
+	bc.expire = time.Duration(cfg.MinutesExpire) * time.Minute
+	if bc.expire <= 0 {
+		bc.expire = defaultBuiltinCaptchaExpire * time.Minute
+	}
+	bc.lock.Lock()
+	bc.challenges = make(map[string]captchaChallenge)
+	bc.lock.Unlock()
+	return nil
+}
+
+// newChallenge creates and stores a new challenge for the IP, returning its ID and rendered PNG image
+func (bc *builtinCaptcha) newChallenge(ip string) (string, []byte, error) {
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", nil, err
+	}
+	id := hex.EncodeToString(idBytes)
+
+	answer := make([]byte, bc.length)
+	for i := range answer {
+		n, err := rand.Int(rand.Reader, big.NewInt(10))
+		if err != nil {
+			return "", nil, err
+		}
+		answer[i] = '0' + byte(n.Int64())
+	}
+
+	bc.lock.Lock()
+	now := time.Now()
+	var ipPending int
+	var oldestID, oldestIPID string
+	for challengeID, challenge := range bc.challenges {
+		if now.After(challenge.expires) {
+			delete(bc.challenges, challengeID)
+			continue
+		}
+		if oldestID == "" || challenge.created.Before(bc.challenges[oldestID].created) {
+			oldestID = challengeID
+		}
+		if challenge.ip == ip {
+			ipPending++
+			if oldestIPID == "" || challenge.created.Before(bc.challenges[oldestIPID].created) {
+				oldestIPID = challengeID
+			}
+		}
+	}
+	if ipPending >= maxPendingCaptchasPerIP {
+		delete(bc.challenges, oldestIPID)
+	} else if len(bc.challenges) >= maxPendingCaptchas {
+		delete(bc.challenges, oldestID)
+	}
+	bc.challenges[id] = captchaChallenge{
+		answer:  string(answer),
+		ip:      ip,
+		created: now,
+		expires: now.Add(bc.expire),
+	}
+	bc.lock.Unlock()
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, drawCaptcha(answer)); err != nil {
+		return "", nil, err
+	}
+	return id, buf.Bytes(), nil
+}
+
+func (bc *builtinCaptcha) TemplateData(request *http.Request) (map[string]interface{}, error) {
+	id, img, err := bc.newChallenge(gcutil.GetRealIP(request))
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"captchaID":    id,
+		"captchaImage": template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(img)),
+	}, nil
+}
+
+// Verify checks the submitted answer against the challenge identified by the captcha-id form value
+// (set by captcha.html) or the cookie set when the image was requested. The challenge is removed
+// whether or not the answer is correct
+func (bc *builtinCaptcha) Verify(request *http.Request) (bool, error) {
+	answer := strings.TrimSpace(request.PostFormValue("captcha-answer"))
+	id := request.PostFormValue("captcha-id")
+	if id == "" {
+		if cookie, err := request.Cookie(builtinCaptchaCookie); err == nil {
+			id = cookie.Value
+		}
+	}
+	if answer == "" || id == "" {
+		return false, ErrNoCaptchaToken
+	}
+
+	bc.lock.Lock()
+	challenge, ok := bc.challenges[id]
+	delete(bc.challenges, id)
+	bc.lock.Unlock()
+
+	if !ok || time.Now().After(challenge.expires) {
+		return false, nil
+	}
+	return subtle.ConstantTimeCompare([]byte(answer), []byte(challenge.answer)) == 1, nil
+}
+
+// serveImage creates a new challenge for a static page's post form, storing the challenge ID
+// in a cookie
+func (bc *builtinCaptcha) serveImage(writer http.ResponseWriter, request *http.Request) {
+	id, img, err := bc.newChallenge(gcutil.GetRealIP(request))
+	if err != nil {
+		gcutil.LogError(err).Caller().Send()
+		server.ServeErrorPage(writer, "Error creating CAPTCHA: "+err.Error())
+		return
+	}
+	http.SetCookie(writer, &http.Cookie{
+		Name:     builtinCaptchaCookie,
+		Value:    id,
+		Path:     config.GetSystemCriticalConfig().WebRoot,
+		MaxAge:   int(bc.expire.Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	writer.Header().Set("Cache-Control", "no-store")
+	writer.Header().Set("Content-Type", "image/png")
+	writer.Write(img)
+}
+
+// drawCaptcha renders the answer with some jitter and noise to make it harder to read automatically
+func drawCaptcha(answer []byte) image.Image {
+	rng := mathrand.New(mathrand.NewSource(time.Now().UnixNano()))
+	glyphWidth := 5 * glyphScale
+	glyphHeight := 7 * glyphScale
+	width := len(answer)*(glyphWidth+glyphPadding) + glyphPadding
+	height := glyphHeight + glyphPadding*3
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			shade := uint8(220 + rng.Intn(36))
+			img.Set(x, y, color.RGBA{shade, shade, shade, 255})
+		}
+	}
+
+	for c, char := range answer {
+		glyph := captchaGlyphs[char]
+		fg := color.RGBA{uint8(rng.Intn(120)), uint8(rng.Intn(120)), uint8(rng.Intn(120)), 255}
+		offsetX := glyphPadding + c*(glyphWidth+glyphPadding) + rng.Intn(glyphPadding/2+1) - glyphPadding/4
+		offsetY := glyphPadding + rng.Intn(glyphPadding+1)
+		slant := rng.Intn(3) - 1
+		for row, line := range glyph {
+			for col, bit := range line {
+				if bit != '1' {
+					continue
+				}
+				px := offsetX + col*glyphScale + slant*(7-row)
+				py := offsetY + row*glyphScale
+				for dy := 0; dy < glyphScale; dy++ {
+					for dx := 0; dx < glyphScale; dx++ {
+						img.Set(px+dx, py+dy, fg)
+					}
+				}
+			}
+		}
+	}
+
+	for l := 0; l < len(answer); l++ {
+		lineColor := color.RGBA{uint8(rng.Intn(200)), uint8(rng.Intn(200)), uint8(rng.Intn(200)), 255}
+		drawLine(img, rng.Intn(width), rng.Intn(height), rng.Intn(width), rng.Intn(height), lineColor)
+	}
+	for d := 0; d < width*height/20; d++ {
+		shade := uint8(rng.Intn(256))
+		img.Set(rng.Intn(width), rng.Intn(height), color.RGBA{shade, shade, shade, 255})
+	}
+	return img
+}
+
+func drawLine(img *image.RGBA, x0, y0, x1, y1 int, c color.Color) {
+	dx := x1 - x0
+	if dx < 0 {
+		dx = -dx
+	}
+	dy := y1 - y0
+	if dy > 0 {
+		dy = -dy
+	}
+	sx, sy := 1, 1
+	if x0 > x1 {
+		sx = -1
+	}
+	if y0 > y1 {
+		sy = -1
+	}
+	e := dx + dy
+	for {
+		img.Set(x0, y0, c)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		e2 := 2 * e
+		if e2 >= dy {
+			e += dy
+			x0 += sx
+		}
+		if e2 <= dx {
+			e += dx
+			y0 += sy
+		}
+	}
+}
@@ -19,35 +19,125 @@ import (
 
 var (
 	ErrNoCaptchaToken     = errors.New("missing required CAPTCHA")
-	ErrUnsupportedCaptcha = errors.New("unsupported captcha type set in configuration (currently only hcaptcha is supported)")
-	validCaptchaTypes     = []string{"hcaptcha"}
+	ErrUnsupportedCaptcha = errors.New("unsupported captcha type set in configuration")
+	ErrMissingCaptchaKeys = errors.New("missing CAPTCHA site key or account secret")
+
+	captchaProviders = map[string]CaptchaProvider{
+		"hcaptcha": &siteverifyCaptcha{
+			responseField:    "h-captcha-response",
+			defaultVerifyURL: "https://hcaptcha.com/siteverify",
+		},
+		"recaptcha": &siteverifyCaptcha{
+			responseField:    "g-recaptcha-response",
+			defaultVerifyURL: "https://www.google.com/recaptcha/api/siteverify",
+		},
+		"turnstile": &siteverifyCaptcha{
+			responseField:    "cf-turnstile-response",
+			defaultVerifyURL: "https://challenges.cloudflare.com/turnstile/v0/siteverify",
+		},
+		"builtin": &builtinCaptcha{},
+	}
+	captchaProvider CaptchaProvider
 )
 
+// CaptchaProvider verifies CAPTCHA responses submitted with new posts and to /captcha
+type CaptchaProvider interface {
+	// Init validates the CAPTCHA configuration and prepares the provider to be used
+	Init(cfg *config.CaptchaConfig) error
+	// TemplateData returns any extra values captcha.html needs to render the provider's challenge
+	TemplateData(request *http.Request) (map[string]interface{}, error)
+	// Verify checks the CAPTCHA response in the request's form values
+	Verify(request *http.Request) (bool, error)
+}
+
+// RegisterCaptchaProvider makes a CAPTCHA provider available to be selected by the Captcha.Type
+// configuration value. It must be called before InitCaptcha
+func RegisterCaptchaProvider(captchaType string, provider CaptchaProvider) {
+	captchaProviders[captchaType] = provider
+}
+
 type CaptchaResult struct {
-	Hostname  string    `json:"hostname"`
-	Credit    bool      `json:"credit"`
-	Success   bool      `json:"success"`
-	Timestamp time.Time `json:"challenge_ts"`
+	Hostname   string    `json:"hostname"`
+	Credit     bool      `json:"credit"`
+	Success    bool      `json:"success"`
+	Timestamp  time.Time `json:"challenge_ts"`
+	ErrorCodes []string  `json:"error-codes"`
+}
+
+// siteverifyCaptcha handles services that verify a response token by POSTing it along with the
+// account secret to a verification endpoint (hCaptcha, reCAPTCHA, and Cloudflare Turnstile)
+type siteverifyCaptcha struct {
+	responseField    string
+	defaultVerifyURL string
+	verifyURL        string
+	secret           string
+}
+
+func (sc *siteverifyCaptcha) Init(cfg *config.CaptchaConfig) error {
+	if cfg.SiteKey == "" || cfg.AccountSecret == "" {
+		return ErrMissingCaptchaKeys
+	}
+	sc.secret = cfg.AccountSecret
+	sc.verifyURL = sc.defaultVerifyURL
+	if cfg.VerifyURL != "" {
+		if _, err := url.ParseRequestURI(cfg.VerifyURL); err != nil {
+			return err
+		}
+		sc.verifyURL = cfg.VerifyURL
+	}
+	return nil
+}
+
+func (*siteverifyCaptcha) TemplateData(_ *http.Request) (map[string]interface{}, error) {
+	return nil, nil
+}
+
+func (sc *siteverifyCaptcha) Verify(request *http.Request) (bool, error) {
+	token := request.PostFormValue(sc.responseField)
+	if token == "" {
+		return false, ErrNoCaptchaToken
+	}
+	params := url.Values{
+		"secret":   []string{sc.secret},
+		"response": []string{token},
+		"remoteip": []string{gcutil.GetRealIP(request)},
+	}
+	resp, err := http.PostForm(sc.verifyURL, params)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	var vals CaptchaResult
+	if err = json.NewDecoder(resp.Body).Decode(&vals); err != nil {
+		return false, err
+	}
+	return vals.Success, nil
 }
 
 func InitCaptcha() {
-	var typeIsValid bool
 	captchaCfg := config.GetSiteConfig().Captcha
 	if !captchaCfg.UseCaptcha() {
 		return
 	}
-	for _, vType := range validCaptchaTypes {
-		if captchaCfg.Type == vType {
-			typeIsValid = true
+	provider, ok := captchaProviders[captchaCfg.Type]
+	if !ok {
+		validTypes := make([]string, 0, len(captchaProviders))
+		for captchaType := range captchaProviders {
+			validTypes = append(validTypes, captchaType)
 		}
-	}
-	if !typeIsValid {
 		fmt.Printf("Unrecognized Captcha.Type value in configuration: %q, valid values: %v\n",
-			captchaCfg.Type, validCaptchaTypes)
-		gcutil.LogFatal().
+			captchaCfg.Type, validTypes)
+		gcutil.LogFatal().Err(ErrUnsupportedCaptcha).
 			Str("captchaType", captchaCfg.Type).
 			Msg("Unsupported captcha type set in configuration")
 	}
+	if err := provider.Init(&captchaCfg); err != nil {
+		fmt.Printf("Unable to initialize %s CAPTCHA: %s\n", captchaCfg.Type, err.Error())
+		gcutil.LogFatal().Err(err).
+			Str("captchaType", captchaCfg.Type).
+			Msg("Unable to initialize CAPTCHA")
+	}
+	captchaProvider = provider
 }
 
 // SubmitCaptchaResponse parses the incoming captcha form values, submits them, and returns the results
@@ -60,31 +150,10 @@ func SubmitCaptchaResponse(request *http.Request) (bool, error) {
 	if captchaCfg.OnlyNeededForThreads && threadid > 0 {
 		return true, nil
 	}
-
-	var token string
-	switch captchaCfg.Type {
-	case "hcaptcha":
-		token = request.PostFormValue("h-captcha-response")
-	default:
-
-	}
-
-	if token == "" {
-		return false, ErrNoCaptchaToken
-	}
-	params := url.Values{
-		"secret":   []string{captchaCfg.AccountSecret},
-		"response": []string{token},
-	}
-	resp, err := http.PostForm("https://hcaptcha.com/siteverify", params)
-	if err != nil {
-		return false, err
+	if captchaProvider == nil {
+		return false, ErrUnsupportedCaptcha
 	}
-	var vals CaptchaResult
-	if err = json.NewDecoder(resp.Body).Decode(&vals); err != nil {
-		return false, err
-	}
-	return vals.Success, resp.Body.Close()
+	return captchaProvider.Verify(request)
 }
 
 // ServeCaptcha handles requests to /captcha if the captcha is properly configured
@@ -94,23 +163,41 @@ func ServeCaptcha(writer http.ResponseWriter, request *http.Request) {
 		fmt.Fprint(writer, captchaCfg.UseCaptcha())
 		return
 	}
-	if !captchaCfg.UseCaptcha() {
+	if !captchaCfg.UseCaptcha() || captchaProvider == nil {
 		server.ServeErrorPage(writer, "This site is not set up to require a CAPTCHA test")
 		return
 	}
-	if request.Method == "POST" {
-		result, err := SubmitCaptchaResponse(request)
-		if err != nil {
-			server.ServeErrorPage(writer, "Error checking results: "+err.Error())
-		}
-		fmt.Println("Success:", result)
+	if builtin, ok := captchaProvider.(*builtinCaptcha); ok && request.Method == "GET" && request.FormValue("image") != "" {
+		builtin.serveImage(writer, request)
+		return
 	}
-	err := serverutil.MinifyTemplate(gctemplates.Captcha, map[string]interface{}{
+	data := map[string]interface{}{
 		"boardConfig": config.GetBoardConfig(""),
 		"boards":      gcsql.AllBoards,
 		"siteKey":     captchaCfg.SiteKey,
-	}, writer, "text/html")
+		"captcha":     captchaCfg,
+	}
+	if request.Method == "POST" {
+		result, err := captchaProvider.Verify(request)
+		if err != nil && err != ErrNoCaptchaToken {
+			gcutil.LogError(err).Caller().
+				Str("IP", gcutil.GetRealIP(request)).
+				Str("captchaType", captchaCfg.Type).Send()
+			server.ServeErrorPage(writer, "Error checking results: "+err.Error())
+			return
+		}
+		data["result"] = result
+		data["submitted"] = true
+	}
+	extraData, err := captchaProvider.TemplateData(request)
 	if err != nil {
+		server.ServeErrorPage(writer, "Error creating CAPTCHA: "+err.Error())
+		return
+	}
+	for k, v := range extraData {
+		data[k] = v
+	}
+	if err = serverutil.MinifyTemplate(gctemplates.Captcha, data, writer, "text/html"); err != nil {
 		server.ServeErrorPage(writer, "Error serving CAPTCHA: "+err.Error())
 	}
 }
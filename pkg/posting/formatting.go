@@ -2,11 +2,14 @@ package posting
 
 import (
 	"fmt"
+	"html"
 	"html/template"
+	"regexp"
 	"strconv"
 	"strings"
 
 	"github.com/frustra/bbcode"
+	"github.com/gochan-org/gochan/pkg/building"
 	"github.com/gochan-org/gochan/pkg/config"
 	"github.com/gochan-org/gochan/pkg/gcsql"
 	"github.com/gochan-org/gochan/pkg/gcutil"
@@ -14,6 +17,10 @@ import (
 
 var (
 	msgfmtr *MessageFormatter
+	// postLinkRE matches >>123 after the message has been compiled
+	postLinkRE = regexp.MustCompile(`^&gt;&gt;(\d+)$`)
+	// boardLinkRE matches >>>/board/ and >>>/board/123 after the message has been compiled
+	boardLinkRE = regexp.MustCompile(`^&gt;&gt;&gt;/(\w+)/(\d*)$`)
 )
 
 // InitPosting prepares the formatter and the temp post pruner
@@ -25,8 +32,7 @@ func InitPosting() {
 
 type MessageFormatter struct {
 	// Go's garbage collection does weird things with bbcode's internal tag map.
-	// Moving the bbcode compiler isntance (and eventually a Markdown compiler) to a struct
-	// appears to fix this
+	// Moving the bbcode compiler isntance to a struct appears to fix this
 	bbCompiler bbcode.Compiler
 }
 
@@ -59,9 +65,14 @@ func (*MessageFormatter) ApplyWordFilters(message string, boardDir string) (stri
 	return message, nil
 }
 
+// Compile converts the message to HTML using the board's formatting mode, with lines separated by <br>
 func (mf *MessageFormatter) Compile(msg string, boardDir string) string {
-	if config.GetBoardConfig(boardDir).DisableBBcode {
-		return msg
+	switch config.GetBoardConfig(boardDir).GetFormattingMode() {
+	case config.FormattingMarkdown:
+		return RenderMarkdown(msg)
+	case config.FormattingPlain:
+		msg = strings.ReplaceAll(msg, "\r\n", "\n")
+		return strings.ReplaceAll(html.EscapeString(msg), "\n", "<br>")
 	}
 	return mf.bbCompiler.Compile(msg)
 }
@@ -70,42 +81,60 @@ func ApplyWordFilters(message string, boardDir string) (string, error) {
 	return msgfmtr.ApplyWordFilters(message, boardDir)
 }
 
-func FormatMessage(message string, boardDir string) template.HTML {
+// FormatMessage compiles the message and formats its greentext and links. Post links (>>123 and
+// >>>/board/123) and board links (>>>/board/) are resolved in a single query. The posts it links to are
+// returned in the order they first appear, to be stored with Post.SetReferences
+func FormatMessage(message string, boardDir string) (template.HTML, []gcsql.LinkedPost) {
 	message = msgfmtr.Compile(message, boardDir)
 	// prepare each line to be formatted
 	postLines := strings.Split(message, "<br>")
+	linkedPosts := getLinkedPosts(postLines)
+	linkedBoards := getLinkedBoards(postLines)
+	var references []gcsql.LinkedPost
+	referenced := make(map[int]bool)
+	WebRoot := config.GetSystemCriticalConfig().WebRoot
 	for i, line := range postLines {
+		if strings.HasPrefix(line, "<pre>") {
+			// Markdown code block, don't format its contents
+			continue
+		}
 		trimmedLine := strings.TrimSpace(line)
 		lineWords := strings.Split(trimmedLine, " ")
 		isGreentext := false // if true, append </span> to end of line
-		WebRoot := config.GetSystemCriticalConfig().WebRoot
 		for w, word := range lineWords {
-			if strings.LastIndex(word, "&gt;&gt;") == 0 {
-				//word is a backlink
-				if postID, err := strconv.Atoi(word[8:]); err == nil {
-					// the link is in fact, a valid int
-					var boardDir string
-					var linkParent int
-					var p gcsql.Post
-					p.GetTopPost()
-					if boardDir, err = gcsql.GetBoardDirFromPostID(postID); err != nil {
-						gcutil.LogError(err).
-							Int("postid", postID).
-							Msg("Error getting board dir for backlink")
-					}
-					if err == gcsql.ErrBoardDoesNotExist {
-						lineWords[w] = `<a href="javascript:;"><strike>` + word + `</strike></a>`
-						continue
-					}
-					linkParent, err := gcsql.GetTopPostInThread(postID)
-					if err != nil {
-						gcutil.LogError(err).
-							Int("postid", postID).
-							Msg("Error getting post parent for backlink")
+			if matches := postLinkRE.FindStringSubmatch(word); matches != nil {
+				// word is a link to a post
+				postID, _ := strconv.Atoi(matches[1])
+				linked, ok := linkedPosts[postID]
+				if !ok {
+					lineWords[w] = `<a href="javascript:;"><strike>` + word + `</strike></a>`
+					continue
+				}
+				lineWords[w] = fmt.Sprintf(`<a href="%s" class="postref">%s</a>`, linkedPostURL(WebRoot, &linked), word)
+				if !referenced[postID] {
+					referenced[postID] = true
+					references = append(references, linked)
+				}
+			} else if matches := boardLinkRE.FindStringSubmatch(word); matches != nil {
+				// word is a link to a board or a post on a (possibly different) board
+				if matches[2] == "" {
+					if _, ok := linkedBoards[matches[1]]; !ok {
 						lineWords[w] = `<a href="javascript:;"><strike>` + word + `</strike></a>`
 					} else {
-						lineWords[w] = fmt.Sprintf(`<a href="%s%s/res/%d.html#%s" class="postref">%s</a>`, WebRoot, boardDir, linkParent, word[8:], word)
+						lineWords[w] = fmt.Sprintf(`<a href="%s%s/" class="boardref">%s</a>`, WebRoot, matches[1], word)
 					}
+					continue
+				}
+				postID, _ := strconv.Atoi(matches[2])
+				linked, ok := linkedPosts[postID]
+				if !ok || linked.BoardDir != matches[1] {
+					lineWords[w] = `<a href="javascript:;"><strike>` + word + `</strike></a>`
+					continue
+				}
+				lineWords[w] = fmt.Sprintf(`<a href="%s" class="postref">%s</a>`, linkedPostURL(WebRoot, &linked), word)
+				if !referenced[postID] {
+					referenced[postID] = true
+					references = append(references, linked)
 				}
 			} else if strings.Index(word, "&gt;") == 0 && w == 0 {
 				// word is at the beginning of a line, and is greentext
@@ -119,5 +148,92 @@ func FormatMessage(message string, boardDir string) template.HTML {
 		}
 		postLines[i] = line
 	}
-	return template.HTML(strings.Join(postLines, "<br />"))
+	return template.HTML(strings.Join(postLines, "<br />")), references
+}
+
+// linkedPostURL returns the URL of the linked post in its thread, which is in the board's archive if the thread
+// was archived
+func linkedPostURL(webRoot string, linked *gcsql.LinkedPost) string {
+	resDir := "res"
+	if linked.IsArchived {
+		resDir = "arch/res"
+	}
+	return fmt.Sprintf("%s%s/%s/%d.html#%d", webRoot, linked.BoardDir, resDir, linked.TopPostID, linked.ID)
+}
+
+// getLinkedPosts gets the locations of all of the posts linked to in the compiled message lines
+func getLinkedPosts(postLines []string) map[int]gcsql.LinkedPost {
+	var postIDs []interface{}
+	for _, line := range postLines {
+		if strings.HasPrefix(line, "<pre>") {
+			continue
+		}
+		for _, word := range strings.Split(strings.TrimSpace(line), " ") {
+			if matches := postLinkRE.FindStringSubmatch(word); matches != nil {
+				postIDs = append(postIDs, matches[1])
+			} else if matches = boardLinkRE.FindStringSubmatch(word); matches != nil && matches[2] != "" {
+				postIDs = append(postIDs, matches[2])
+			}
+		}
+	}
+	linkedPosts, err := gcsql.GetLinkedPosts(postIDs...)
+	if err != nil {
+		// links will be shown as dead links
+		gcutil.LogError(err).
+			Interface("postIDs", postIDs).
+			Msg("Error getting linked posts")
+		return map[int]gcsql.LinkedPost{}
+	}
+	return linkedPosts
+}
+
+// getLinkedBoards gets the IDs of the boards linked to (without a post number) in the compiled message lines,
+// mapped to their dirs
+func getLinkedBoards(postLines []string) map[string]int {
+	var boardDirs []interface{}
+	for _, line := range postLines {
+		if strings.HasPrefix(line, "<pre>") {
+			continue
+		}
+		for _, word := range strings.Split(strings.TrimSpace(line), " ") {
+			if matches := boardLinkRE.FindStringSubmatch(word); matches != nil && matches[2] == "" {
+				boardDirs = append(boardDirs, matches[1])
+			}
+		}
+	}
+	linkedBoards, err := gcsql.GetBoardIDsFromDirs(boardDirs...)
+	if err != nil {
+		// links will be shown as dead links
+		gcutil.LogError(err).
+			Interface("boardDirs", boardDirs).
+			Msg("Error getting linked boards")
+		return map[string]int{}
+	}
+	return linkedBoards
+}
+
+// QueueReferencedThreads adds jobs to the build queue to rebuild the threads containing the linked posts
+// so that their reply lists are updated. The thread with the top post ID skipThread is assumed to already be
+// queued
+func QueueReferencedThreads(references []gcsql.LinkedPost, skipThread int) {
+	queued := map[int]bool{skipThread: true}
+	boards := map[string]*gcsql.Board{}
+	for _, linked := range references {
+		if queued[linked.TopPostID] {
+			continue
+		}
+		queued[linked.TopPostID] = true
+		board, ok := boards[linked.BoardDir]
+		if !ok {
+			var err error
+			if board, err = gcsql.GetBoardFromDir(linked.BoardDir); err != nil {
+				gcutil.LogError(err).
+					Str("board", linked.BoardDir).
+					Msg("Unable to get board of linked post")
+				continue
+			}
+			boards[linked.BoardDir] = board
+		}
+		building.QueueThreadPages(board, linked.TopPostID)
+	}
 }
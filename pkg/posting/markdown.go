@@ -0,0 +1,155 @@
+package posting
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	markdownFence = "```"
+	// markdownPlaceholder surrounds the index of a protected piece of HTML (a code span or a link) so that
+	// the emphasis expressions are only applied to the text between them. NUL bytes are removed from the
+	// message before it is rendered so it can't be forged
+	markdownPlaceholder = "\x00"
+)
+
+var (
+	mdCodeSpanRE   = regexp.MustCompile("`([^`]+)`")
+	mdLinkRE       = regexp.MustCompile(`\[([^\[\]]+)\]\(([^()\s]+)\)`)
+	mdPlaceholdRE  = regexp.MustCompile(markdownPlaceholder + `(\d+)` + markdownPlaceholder)
+	mdEmphasisExps = []struct {
+		re          *regexp.Regexp
+		replacement string
+	}{
+		// checked first so that the tags are nested correctly
+		{regexp.MustCompile(`\*\*\*(\S|\S.*?\S)\*\*\*`), "<strong><em>$1</em></strong>"},
+		{regexp.MustCompile(`\*\*(\S|\S.*?\S)\*\*`), "<strong>$1</strong>"},
+		{regexp.MustCompile(`\b__(\S|\S.*?\S)__\b`), "<strong>$1</strong>"},
+		{regexp.MustCompile(`~~(\S|\S.*?\S)~~`), "<del>$1</del>"},
+		{regexp.MustCompile(`\*(\S|\S.*?\S)\*`), "<em>$1</em>"},
+		{regexp.MustCompile(`\b_(\S|\S.*?\S)_\b`), "<em>$1</em>"},
+	}
+	// mdWrappedEmphasisExps match emphasis markers directly around a single code span or link, like
+	// **[link](url)**, which can't be matched by mdEmphasisExps since they are only applied to the text
+	// between placeholders
+	mdWrappedEmphasisExps = []struct {
+		re          *regexp.Regexp
+		open, close string
+	}{
+		{mdWrappedEmphasisRE(`\*\*\*`, `\*\*\*`), "<strong><em>", "</em></strong>"},
+		{mdWrappedEmphasisRE(`\*\*`, `\*\*`), "<strong>", "</strong>"},
+		{mdWrappedEmphasisRE(`\b__`, `__\b`), "<strong>", "</strong>"},
+		{mdWrappedEmphasisRE(`~~`, `~~`), "<del>", "</del>"},
+		{mdWrappedEmphasisRE(`\*`, `\*`), "<em>", "</em>"},
+		{mdWrappedEmphasisRE(`\b_`, `_\b`), "<em>", "</em>"},
+	}
+)
+
+func mdWrappedEmphasisRE(open, close string) *regexp.Regexp {
+	return regexp.MustCompile(open + markdownPlaceholder + `(\d+)` + markdownPlaceholder + close)
+}
+
+// markdownRenderer renders the subset of Markdown that makes sense in imageboard posts: emphasis,
+// strikethrough, code spans, fenced code blocks, and links. Raw HTML is always escaped, and lines
+// starting with > are left alone so that they are still treated as greentext instead of blockquotes
+type markdownRenderer struct {
+	protected []string
+}
+
+// RenderMarkdown converts the message to HTML, with lines separated by <br> like the BBCode compiler's
+// output so that FormatMessage can handle greentext and backlinks the same way. Code blocks are output as
+// a single <pre> "line"
+func RenderMarkdown(message string) string {
+	message = strings.ReplaceAll(message, markdownPlaceholder, "")
+	message = strings.ReplaceAll(message, "\r\n", "\n")
+	lines := strings.Split(message, "\n")
+	var output []string
+	for l := 0; l < len(lines); l++ {
+		if !strings.HasPrefix(strings.TrimSpace(lines[l]), markdownFence) {
+			output = append(output, renderMarkdownLine(lines[l]))
+			continue
+		}
+		// fenced code block, the language name (if any) after the fence is ignored
+		var code []string
+		for l++; l < len(lines) && strings.TrimSpace(lines[l]) != markdownFence; l++ {
+			code = append(code, html.EscapeString(lines[l]))
+		}
+		output = append(output, "<pre><code>"+strings.Join(code, "\n")+"</code></pre>")
+	}
+	return strings.Join(output, "<br>")
+}
+
+func renderMarkdownLine(line string) string {
+	var md markdownRenderer
+	line = html.EscapeString(line)
+	line = mdCodeSpanRE.ReplaceAllStringFunc(line, func(span string) string {
+		return md.protect("<code>" + span[1:len(span)-1] + "</code>")
+	})
+	line = mdLinkRE.ReplaceAllStringFunc(line, func(link string) string {
+		matches := mdLinkRE.FindStringSubmatch(link)
+		href, ok := sanitizeMarkdownURL(matches[2])
+		if !ok {
+			return link
+		}
+		return md.protect(`<a href="` + href + `" rel="nofollow noopener">` + md.render(matches[1]) + "</a>")
+	})
+	for _, exp := range mdWrappedEmphasisExps {
+		line = exp.re.ReplaceAllStringFunc(line, func(wrapped string) string {
+			i, _ := strconv.Atoi(exp.re.FindStringSubmatch(wrapped)[1])
+			return md.protect(exp.open + md.protected[i] + exp.close)
+		})
+	}
+	return md.render(line)
+}
+
+// render applies the emphasis expressions to each piece of text between the placeholders separately, so that
+// the tags can't be misnested with a link's tags or span part of a code span, and replaces the placeholders
+// with the HTML they protect
+func (md *markdownRenderer) render(text string) string {
+	var rendered strings.Builder
+	last := 0
+	for _, loc := range mdPlaceholdRE.FindAllStringSubmatchIndex(text, -1) {
+		rendered.WriteString(renderMarkdownEmphasis(text[last:loc[0]]))
+		i, _ := strconv.Atoi(text[loc[2]:loc[3]])
+		rendered.WriteString(md.protected[i])
+		last = loc[1]
+	}
+	rendered.WriteString(renderMarkdownEmphasis(text[last:]))
+	return rendered.String()
+}
+
+func renderMarkdownEmphasis(text string) string {
+	for _, exp := range mdEmphasisExps {
+		text = exp.re.ReplaceAllString(text, exp.replacement)
+	}
+	return text
+}
+
+// protect stores the HTML and returns a placeholder that is replaced with it after the rest of the
+// line is formatted
+func (md *markdownRenderer) protect(str string) string {
+	md.protected = append(md.protected, str)
+	return markdownPlaceholder + strconv.Itoa(len(md.protected)-1) + markdownPlaceholder
+}
+
+// sanitizeMarkdownURL returns the (escaped) URL to be used in a link's href attribute and true if it is an
+// http(s) or mailto URL or a relative URL, or false if it is something like a javascript: URL.
+// escapedURL has already been escaped by html.EscapeString
+func sanitizeMarkdownURL(escapedURL string) (string, bool) {
+	rawURL := html.UnescapeString(escapedURL)
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https", "mailto":
+	case "":
+		// relative URL
+	default:
+		return "", false
+	}
+	return html.EscapeString(u.String()), true
+}
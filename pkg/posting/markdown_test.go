@@ -0,0 +1,157 @@
+package posting
+
+import (
+	"testing"
+)
+
+func TestRenderMarkdown(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		message  string
+		expected string
+	}{
+		{
+			desc:     "raw HTML is escaped",
+			message:  "<script>alert(1)</script>",
+			expected: "&lt;script&gt;alert(1)&lt;/script&gt;",
+		},
+		{
+			desc:     "raw HTML attributes are escaped",
+			message:  `<img src=x onerror="alert(1)">`,
+			expected: "&lt;img src=x onerror=&#34;alert(1)&#34;&gt;",
+		},
+		{
+			desc:     "raw HTML in a code block is escaped",
+			message:  "```html\n<b>**not bold**</b>\n```",
+			expected: "<pre><code>&lt;b&gt;**not bold**&lt;/b&gt;</code></pre>",
+		},
+		{
+			desc:     "http link",
+			message:  "[gochan](https://gochan.org/?a=1&b=2)",
+			expected: `<a href="https://gochan.org/?a=1&amp;b=2" rel="nofollow noopener">gochan</a>`,
+		},
+		{
+			desc:     "relative link",
+			message:  "[rules](/rules.html)",
+			expected: `<a href="/rules.html" rel="nofollow noopener">rules</a>`,
+		},
+		{
+			desc:     "javascript link",
+			message:  "[click](javascript:alert%281%29)",
+			expected: "[click](javascript:alert%281%29)",
+		},
+		{
+			desc:     "mixed case javascript link",
+			message:  "[click](JaVaScRiPt:alert%281%29)",
+			expected: "[click](JaVaScRiPt:alert%281%29)",
+		},
+		{
+			desc:     "data link",
+			message:  "[click](data:text/html;base64,PHNjcmlwdD5hbGVydCgxKTwvc2NyaXB0Pg==)",
+			expected: "[click](data:text/html;base64,PHNjcmlwdD5hbGVydCgxKTwvc2NyaXB0Pg==)",
+		},
+		{
+			desc:     "javascript link with a code span",
+			message:  "[click](javascript:alert`1`)",
+			expected: "[click](javascript:alert<code>1</code>)",
+		},
+		{
+			desc:     "HTML in link text",
+			message:  "[<b onclick=alert(1)>click</b>](https://gochan.org)",
+			expected: `<a href="https://gochan.org" rel="nofollow noopener">&lt;b onclick=alert(1)&gt;click&lt;/b&gt;</a>`,
+		},
+		{
+			desc:     "quotes in link URL",
+			message:  `[click](https://gochan.org/"onmouseover="alert%281%29)`,
+			expected: `<a href="https://gochan.org/%22onmouseover=%22alert%281%29" rel="nofollow noopener">click</a>`,
+		},
+		{
+			desc:     "link title",
+			message:  `[click](https://gochan.org "onmouseover=alert(1)")`,
+			expected: "[click](https://gochan.org &#34;onmouseover=alert(1)&#34;)",
+		},
+		{
+			desc:     "emphasis characters in link URL",
+			message:  "[init](https://gochan.org/__init__*x*)",
+			expected: `<a href="https://gochan.org/__init__*x*" rel="nofollow noopener">init</a>`,
+		},
+		{
+			desc:     "italic in bold",
+			message:  "**bold *italic* text**",
+			expected: "<strong>bold <em>italic</em> text</strong>",
+		},
+		{
+			desc:     "bold in italic",
+			message:  "*italic **bold** text*",
+			expected: "<em>italic <strong>bold</strong> text</em>",
+		},
+		{
+			desc:     "bold italic",
+			message:  "***both***",
+			expected: "<strong><em>both</em></strong>",
+		},
+		{
+			desc:     "bold in strikethrough",
+			message:  "~~**struck bold**~~",
+			expected: "<del><strong>struck bold</strong></del>",
+		},
+		{
+			desc:     "underscores in words",
+			message:  "snake_case_name and __bold__",
+			expected: "snake_case_name and <strong>bold</strong>",
+		},
+		{
+			desc:     "emphasis in code span",
+			message:  "`**not bold**`",
+			expected: "<code>**not bold**</code>",
+		},
+		{
+			desc:     "HTML in code span",
+			message:  "`<b>` and **`code`**",
+			expected: "<code>&lt;b&gt;</code> and <strong><code>code</code></strong>",
+		},
+		{
+			desc:     "code span in link text",
+			message:  "[`code`](https://gochan.org)",
+			expected: `<a href="https://gochan.org" rel="nofollow noopener"><code>code</code></a>`,
+		},
+		{
+			desc:     "link in bold",
+			message:  "**[link](https://gochan.org)**",
+			expected: `<strong><a href="https://gochan.org" rel="nofollow noopener">link</a></strong>`,
+		},
+		{
+			desc:     "emphasis around text and link",
+			message:  "*foo [x](https://gochan.org) bar*",
+			expected: `*foo <a href="https://gochan.org" rel="nofollow noopener">x</a> bar*`,
+		},
+		{
+			desc:     "emphasis starting in link text",
+			message:  "[*x](https://gochan.org) bar*",
+			expected: `<a href="https://gochan.org" rel="nofollow noopener">*x</a> bar*`,
+		},
+		{
+			desc:     "emphasis marker in code span",
+			message:  "_a `code_` b_",
+			expected: "_a <code>code_</code> b_",
+		},
+		{
+			desc:     "forged placeholder",
+			message:  "a\x000\x00b `c`",
+			expected: "a0b <code>c</code>",
+		},
+		{
+			desc:     "multiple lines",
+			message:  "*one*\r\n>greentext",
+			expected: "<em>one</em><br>&gt;greentext",
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			rendered := RenderMarkdown(tC.message)
+			if rendered != tC.expected {
+				t.Errorf("expected %q, got %q", tC.expected, rendered)
+			}
+		})
+	}
+}
@@ -12,6 +12,7 @@ import (
 
 	"github.com/gochan-org/gochan/pkg/building"
 	"github.com/gochan-org/gochan/pkg/config"
+	"github.com/gochan-org/gochan/pkg/events"
 	"github.com/gochan-org/gochan/pkg/gcsql"
 	"github.com/gochan-org/gochan/pkg/gcutil"
 	"github.com/gochan-org/gochan/pkg/server"
@@ -21,12 +22,23 @@ import (
 const (
 	yearInSeconds = 31536000
 	maxFormBytes  = 50000000
+	// newThreadBuildTimeout is how long to wait for a new thread's page to be built before redirecting to it
+	newThreadBuildTimeout = 10 * time.Second
 )
 
 var (
 	ErrorPostTooLong = errors.New("post is too long")
 )
 
+// eventRejectionMessage returns the message shown to the poster when an event handler rejects their post.
+// Handlers that panicked get a generic message so that the details of the panic aren't shown
+func eventRejectionMessage(err error) string {
+	if errors.Is(err, events.ErrHandlerPanic) {
+		return "Unable to process post, please try again later"
+	}
+	return err.Error()
+}
+
 // MakePost is called when a user accesses /post. Parse form data, then insert and build
 func MakePost(writer http.ResponseWriter, request *http.Request) {
 	request.ParseMultipartForm(maxFormBytes)
@@ -55,6 +67,10 @@ func MakePost(writer http.ResponseWriter, request *http.Request) {
 		handleAppeal(writer, request, errEv)
 		return
 	}
+	if request.FormValue("ackwarning") != "" {
+		handleWarningAcknowledgement(writer, request, errEv)
+		return
+	}
 
 	wantsJSON := serverutil.IsRequestingJSON(request)
 	post.IP = gcutil.GetRealIP(request)
@@ -145,6 +161,15 @@ func MakePost(writer http.ResponseWriter, request *http.Request) {
 		return
 	}
 
+	// plugins can change the name, subject, and message before it is formatted, or reject the post
+	if _, err = events.TriggerEvent("message-pre-format", &post, postBoard); err != nil {
+		errEv.Err(err).Caller().
+			Str("event", "message-pre-format").
+			Msg("Post rejected by event handler")
+		server.ServeError(writer, eventRejectionMessage(err), wantsJSON, nil)
+		return
+	}
+
 	if post.MessageRaw, err = ApplyWordFilters(post.MessageRaw, postBoard.Dir); err != nil {
 		errEv.Err(err).Caller().Msg("Error formatting post")
 		server.ServeError(writer, "Error formatting post: "+err.Error(), wantsJSON, map[string]interface{}{
@@ -153,7 +178,8 @@ func MakePost(writer http.ResponseWriter, request *http.Request) {
 		return
 	}
 
-	post.Message = FormatMessage(post.MessageRaw, postBoard.Dir)
+	var references []gcsql.LinkedPost
+	post.Message, references = FormatMessage(post.MessageRaw, postBoard.Dir)
 	password := request.FormValue("postpassword")
 	if password == "" {
 		password = gcutil.RandomString(8)
@@ -234,6 +260,9 @@ func MakePost(writer http.ResponseWriter, request *http.Request) {
 	if checkIpBan(&post, postBoard, writer, request) {
 		return
 	}
+	if checkWarning(&post, postBoard, writer) {
+		return
+	}
 	if checkUsernameBan(&post, postBoard, writer, request) {
 		return
 	}
@@ -263,43 +292,49 @@ func MakePost(writer http.ResponseWriter, request *http.Request) {
 		return
 	}
 
-	upload, gotErr := AttachUploadFromRequest(request, writer, &post, postBoard)
+	uploads, gotErr := AttachUploadsFromRequest(request, writer, &post, postBoard)
 	if gotErr {
-		// got an error receiving the upload, stop here (assuming an error page was actually shown)
+		// got an error receiving the uploads, stop here (assuming an error page was actually shown)
 		return
 	}
-	documentRoot := config.GetSystemCriticalConfig().DocumentRoot
-	var filePath, thumbPath, catalogThumbPath string
-	if upload != nil {
-		filePath = path.Join(documentRoot, postBoard.Dir, "src", upload.Filename)
-		thumbPath = path.Join(documentRoot, postBoard.Dir, "thumb", upload.ThumbnailPath("thumb"))
-		catalogThumbPath = path.Join(documentRoot, postBoard.Dir, "thumb", upload.ThumbnailPath("catalog"))
+
+	messageRaw := post.MessageRaw
+	if _, err = events.TriggerEvent("post-pre-insert", &post, postBoard, uploads); err != nil {
+		errEv.Err(err).Caller().
+			Str("event", "post-pre-insert").
+			Msg("Post rejected by event handler")
+		deleteUploadFiles(postBoard.Dir, uploads...)
+		server.ServeError(writer, eventRejectionMessage(err), wantsJSON, nil)
+		return
+	}
+	if post.MessageRaw != messageRaw {
+		// the raw message was changed by a post-pre-insert handler, so it needs to be formatted again
+		post.Message, references = FormatMessage(post.MessageRaw, postBoard.Dir)
 	}
 
 	if err = post.Insert(emailCommand != "sage", postBoard.ID, false, false, false, false); err != nil {
 		errEv.Err(err).Caller().
 			Str("sql", "postInsertion").
 			Msg("Unable to insert post")
-		if upload != nil {
-			os.Remove(filePath)
-			os.Remove(thumbPath)
-			os.Remove(catalogThumbPath)
-		}
+		deleteUploadFiles(postBoard.Dir, uploads...)
 		server.ServeErrorPage(writer, "Unable to insert post: "+err.Error())
 		return
 	}
 
-	if err = post.AttachFile(upload); err != nil {
-		errEv.Err(err).Caller().
-			Str("sql", "postInsertion").
-			Msg("Unable to attach upload to post")
-		os.Remove(filePath)
-		os.Remove(thumbPath)
-		os.Remove(catalogThumbPath)
-		server.ServeErrorPage(writer, "Unable to attach upload: "+err.Error())
-		return
-	}
-	if upload != nil {
+	documentRoot := config.GetSystemCriticalConfig().DocumentRoot
+	for _, upload := range uploads {
+		if err = post.AttachFile(upload); err != nil {
+			errEv.Err(err).Caller().
+				Str("sql", "postInsertion").
+				Str("filename", upload.Filename).
+				Msg("Unable to attach upload to post")
+			deleteUploadFiles(postBoard.Dir, uploads...)
+			server.ServeErrorPage(writer, "Unable to attach upload: "+err.Error())
+			return
+		}
+		filePath := path.Join(documentRoot, postBoard.Dir, "src", upload.Filename)
+		thumbPath := path.Join(documentRoot, postBoard.Dir, "thumb", upload.ThumbnailPath("thumb"))
+		catalogThumbPath := path.Join(documentRoot, postBoard.Dir, "thumb", upload.ThumbnailPath("catalog"))
 		if err = config.TakeOwnership(filePath); err != nil {
 			errEv.Err(err).Caller().
 				Str("file", filePath).Send()
@@ -314,23 +349,43 @@ func MakePost(writer http.ResponseWriter, request *http.Request) {
 		}
 	}
 
-	// rebuild the board page
-	if err = building.BuildBoards(false, postBoard.ID); err != nil {
-		server.ServeErrorPage(writer, "Error building boards: "+err.Error())
-		return
+	if err = post.SetReferences(references); err != nil {
+		// not fatal, the post's links still work but its replies won't be shown on the linked posts
+		errEv.Err(err).Caller().
+			Int("postID", post.ID).
+			Msg("Unable to store post references")
 	}
 
-	if err = building.BuildFrontPage(); err != nil {
-		server.ServeErrorPage(writer, "Error building front page: "+err.Error())
-		return
+	events.TriggerEvent("post-inserted", &post, postBoard)
+	if post.IsTopPost {
+		events.TriggerEvent("thread-created", &post, postBoard)
+	}
+
+	topPostID := post.ID
+	if !post.IsTopPost {
+		if topPostID, err = post.TopPostID(); err != nil {
+			errEv.Err(err).Caller().
+				Int("postID", post.ID).
+				Msg("Unable to get top post ID")
+			server.ServeErrorPage(writer, "Unable to get thread information: "+err.Error())
+			return
+		}
 	}
 
+	// rebuild the thread, board pages, catalog, and front page in the background
+	threadJob := building.QueueThreadPages(postBoard, topPostID)
+	building.QueueBoardPages(postBoard)
+	building.QueueCatalog(postBoard)
+	building.QueueFrontPage()
+	QueueReferencedThreads(references, topPostID)
+
 	if emailCommand == "noko" {
 		if post.IsTopPost {
+			// the new thread's page doesn't exist until its job is finished
+			threadJob.Wait(newThreadBuildTimeout)
 			http.Redirect(writer, request, systemCritical.WebRoot+postBoard.Dir+"/res/"+strconv.Itoa(post.ID)+".html", http.StatusFound)
 		} else {
-			topPost, _ := post.TopPostID()
-			http.Redirect(writer, request, systemCritical.WebRoot+postBoard.Dir+"/res/"+strconv.Itoa(topPost)+".html#"+strconv.Itoa(post.ID), http.StatusFound)
+			http.Redirect(writer, request, systemCritical.WebRoot+postBoard.Dir+"/res/"+strconv.Itoa(topPostID)+".html#"+strconv.Itoa(post.ID), http.StatusFound)
 		}
 	} else {
 		http.Redirect(writer, request, systemCritical.WebRoot+postBoard.Dir+"/", http.StatusFound)
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/gochan-org/gochan/pkg/gcsql"
@@ -16,19 +17,20 @@ var (
 	ErrNoReportedPosts = errors.New("no posts selected")
 	ErrNoReportReason  = errors.New("no report reason given")
 	ErrDuplicateReport = errors.New("post already reported")
+	ErrInvalidCategory = errors.New("invalid report category")
 )
 
 func HandleReport(request *http.Request) error {
-	board := request.FormValue("board")
+	boardDir := request.FormValue("board")
 	if request.Method != "POST" {
 		return ErrInvalidReport
 	}
 	reportedPosts := []int{}
 	var id int
-	if !gcsql.DoesBoardExistByDir(board) {
+	board, err := gcsql.GetBoardFromDir(boardDir)
+	if err != nil {
 		return gcsql.ErrBoardDoesNotExist
 	}
-	var err error
 	for key, val := range request.Form {
 		if _, err = fmt.Sscanf(key, "check%d", &id); err != nil || val[0] != "on" {
 			err = nil
@@ -41,13 +43,20 @@ func HandleReport(request *http.Request) error {
 	}
 	ip := gcutil.GetRealIP(request)
 	reason := strings.TrimSpace(request.PostFormValue("reason"))
-	if reason == "" {
+	categoryID, _ := strconv.Atoi(request.PostFormValue("category"))
+	if categoryID > 0 {
+		category, err := gcsql.GetReportCategory(categoryID)
+		if err != nil || !category.CanUseOnBoard(board.ID) {
+			return ErrInvalidCategory
+		}
+	} else if reason == "" {
 		return ErrNoReportReason
 	}
 
 	for _, postID := range reportedPosts {
-		// check to see if the post has already been reported with this report string or if it can't be reported
-		isDuplicate, isBlocked, err := gcsql.CheckPostReports(postID, reason)
+		// check to see if the post has already been reported with this category and report string or if it
+		// can't be reported
+		isDuplicate, isBlocked, err := gcsql.CheckPostReports(postID, categoryID, reason)
 		if err != nil {
 			return err
 		}
@@ -56,7 +65,7 @@ func HandleReport(request *http.Request) error {
 			continue
 		}
 
-		if _, err = gcsql.CreateReport(postID, ip, reason); err != nil {
+		if _, err = gcsql.CreateReport(postID, categoryID, ip, reason); err != nil {
 			return err
 		}
 	}
@@ -2,12 +2,15 @@ package posting
 
 import (
 	"crypto/md5"
+	"crypto/rand"
+	"errors"
 	"fmt"
 	"html"
 	"image"
 	"image/gif"
 	"io"
-	"math/rand"
+	"math/big"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"os/exec"
@@ -27,10 +30,79 @@ import (
 	"github.com/gochan-org/gochan/pkg/server/serverutil"
 )
 
-// AttachUploadFromRequest reads an incoming HTTP request and processes any incoming files.
-// It returns the upload (if there was one) and whether or not any errors were served (meaning
-// that it should stop processing the post
-func AttachUploadFromRequest(request *http.Request, writer http.ResponseWriter, post *gcsql.Post, postBoard *gcsql.Board) (*gcsql.Upload, bool) {
+const (
+	// maxFilenameAttempts is the number of names tried for an uploaded file before giving up
+	maxFilenameAttempts = 10
+)
+
+var (
+	ErrNoAvailableFilename = errors.New("unable to find an unused filename for the upload")
+)
+
+// AttachUploadsFromRequest reads an incoming HTTP request and processes any incoming files, up to the
+// board's MaxUploadsPerPost limit. It returns the uploads (if there were any) and whether or not any errors
+// were served (meaning that it should stop processing the post)
+func AttachUploadsFromRequest(request *http.Request, writer http.ResponseWriter, post *gcsql.Post, postBoard *gcsql.Board) ([]*gcsql.Upload, bool) {
+	wantsJSON := serverutil.IsRequestingJSON(request)
+	if request.MultipartForm == nil {
+		err := request.ParseMultipartForm(maxFormBytes)
+		if err == http.ErrNotMultipart {
+			// no files could have been submitted with the form
+			return nil, false
+		} else if err != nil {
+			gcutil.LogError(err).Caller().
+				Str("IP", post.IP).Send()
+			server.ServeError(writer, err.Error(), wantsJSON, nil)
+			return nil, true
+		}
+	}
+	fileHeaders := request.MultipartForm.File["imagefile"]
+	if len(fileHeaders) == 0 {
+		// no file was submitted with the form
+		return nil, false
+	}
+	boardConfig := config.GetBoardConfig(postBoard.Dir)
+	if len(fileHeaders) > boardConfig.MaxUploadsPerPost {
+		gcutil.LogWarning().
+			Str("IP", post.IP).
+			Int("numUploads", len(fileHeaders)).
+			Int("maxUploads", boardConfig.MaxUploadsPerPost).
+			Msg("Rejected post with too many uploads")
+		server.ServeError(writer, fmt.Sprintf("Too many files (max %d per post)", boardConfig.MaxUploadsPerPost), wantsJSON, map[string]interface{}{
+			"numUploads": len(fileHeaders),
+			"maxUploads": boardConfig.MaxUploadsPerPost,
+		})
+		return nil, true
+	}
+
+	var uploads []*gcsql.Upload
+	for f, fileHeader := range fileHeaders {
+		upload, gotErr := processUpload(fileHeader, f, request, writer, post, postBoard)
+		if gotErr {
+			// remove the files that were already saved since the post won't be made
+			deleteUploadFiles(postBoard.Dir, uploads...)
+			return nil, true
+		}
+		uploads = append(uploads, upload)
+	}
+	return uploads, false
+}
+
+// deleteUploadFiles removes the files and thumbnails of the given uploads from the board directory, used
+// for cleaning up if something goes wrong after they were written to the disk
+func deleteUploadFiles(boardDir string, uploads ...*gcsql.Upload) {
+	documentRoot := config.GetSystemCriticalConfig().DocumentRoot
+	for _, upload := range uploads {
+		os.Remove(path.Join(documentRoot, boardDir, "src", upload.Filename))
+		os.Remove(path.Join(documentRoot, boardDir, "thumb", upload.ThumbnailPath("thumb")))
+		os.Remove(path.Join(documentRoot, boardDir, "thumb", upload.ThumbnailPath("catalog")))
+	}
+}
+
+// processUpload checks the given file against filename and checksum bans, saves it, and creates its thumbnail(s).
+// fileOrder is the position of the file in the post, and only the first file of a new thread gets a catalog
+// thumbnail. It returns the upload and whether or not any errors were served
+func processUpload(fileHeader *multipart.FileHeader, fileOrder int, request *http.Request, writer http.ResponseWriter, post *gcsql.Post, postBoard *gcsql.Board) (*gcsql.Upload, bool) {
 	errEv := gcutil.LogError(nil).
 		Str("IP", post.IP)
 	infoEv := gcutil.LogInfo().
@@ -39,19 +111,17 @@ func AttachUploadFromRequest(request *http.Request, writer http.ResponseWriter,
 		infoEv.Discard()
 		errEv.Discard()
 	}()
-	file, handler, err := request.FormFile("imagefile")
-	if err == http.ErrMissingFile {
-		// no file was submitted with the form
-		return nil, false
-	}
 	wantsJSON := serverutil.IsRequestingJSON(request)
+	file, err := fileHeader.Open()
 	if err != nil {
 		errEv.Err(err).Caller().Send()
 		server.ServeError(writer, err.Error(), wantsJSON, nil)
 		return nil, true
 	}
+	defer file.Close()
 	upload := &gcsql.Upload{
-		OriginalFilename: html.EscapeString(handler.Filename),
+		FileOrder:        fileOrder,
+		OriginalFilename: html.EscapeString(fileHeader.Filename),
 	}
 	if checkFilenameBan(upload, post, postBoard, writer, request) {
 		// If checkFilenameBan returns true, an error occured or the file was
@@ -64,7 +134,6 @@ func AttachUploadFromRequest(request *http.Request, writer http.ResponseWriter,
 		server.ServeErrorPage(writer, "Error while trying to read file: "+err.Error())
 		return nil, true
 	}
-	defer file.Close()
 
 	// Calculate image checksum
 	upload.Checksum = fmt.Sprintf("%x", md5.Sum(data)) // skipcq: GSC-G401
@@ -75,23 +144,38 @@ func AttachUploadFromRequest(request *http.Request, writer http.ResponseWriter,
 	}
 
 	ext := strings.ToLower(filepath.Ext(upload.OriginalFilename))
-	upload.Filename = getNewFilename() + ext
-
 	documentRoot := config.GetSystemCriticalConfig().DocumentRoot
-	filePath := path.Join(documentRoot, postBoard.Dir, "src", upload.Filename)
+	srcFile, filename, err := createUploadFile(path.Join(documentRoot, postBoard.Dir, "src"), ext)
+	if err != nil {
+		errEv.Err(err).Caller().
+			Str("originalFilename", upload.OriginalFilename).Send()
+		server.ServeError(writer, fmt.Sprintf("Couldn't write file %q", upload.OriginalFilename), wantsJSON, map[string]interface{}{
+			"originalFilename": upload.OriginalFilename,
+		})
+		return nil, true
+	}
+	upload.Filename = filename
+	filePath := srcFile.Name()
 	thumbPath := path.Join(documentRoot, postBoard.Dir, "thumb", upload.ThumbnailPath("thumb"))
 	catalogThumbPath := path.Join(documentRoot, postBoard.Dir, "thumb", upload.ThumbnailPath("catalog"))
+	// only the first file in a new thread is shown in the catalog
+	isCatalogUpload := post.ThreadID == 0 && fileOrder == 0
 
 	boardConfig := config.GetBoardConfig(postBoard.Dir)
 	errEv.
 		Str("originalFilename", upload.OriginalFilename).
 		Str("filePath", filePath)
-	if post.ThreadID == 0 {
+	if isCatalogUpload {
 		errEv.Str("catalogThumbPath", catalogThumbPath)
 	}
 
-	if err = os.WriteFile(filePath, data, config.GC_FILE_MODE); err != nil {
+	_, err = srcFile.Write(data)
+	if closeErr := srcFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
 		errEv.Err(err).Caller().Send()
+		os.Remove(filePath)
 		server.ServeError(writer, fmt.Sprintf("Couldn't write file %q", upload.OriginalFilename), wantsJSON, map[string]interface{}{
 			"filename":         upload.Filename,
 			"originalFilename": upload.OriginalFilename,
@@ -107,16 +191,15 @@ func AttachUploadFromRequest(request *http.Request, writer http.ResponseWriter,
 		})
 		return nil, true
 	}
-	_, recovered := events.TriggerEvent("upload-saved", filePath)
-	if recovered {
-		gcutil.LogWarning().Caller().
+	if _, err = events.TriggerEvent("upload-saved", filePath); err != nil {
+		gcutil.LogWarning().Err(err).Caller().
 			Str("filePath", filePath).Str("triggeredEvent", "upload-saved").
-			Msg("Recovered from a panic in event handler")
+			Msg("Error in event handler")
 	}
 
 	if ext == ".webm" || ext == ".mp4" {
 		infoEv.Str("post", "withVideo").
-			Str("filename", handler.Filename).
+			Str("filename", fileHeader.Filename).
 			Str("referer", request.Referer()).Send()
 		if post.ThreadID == 0 {
 			if err := createVideoThumbnail(filePath, thumbPath, boardConfig.ThumbWidth); err != nil {
@@ -137,13 +220,15 @@ func AttachUploadFromRequest(request *http.Request, writer http.ResponseWriter,
 			}
 		}
 
-		if err := createVideoThumbnail(filePath, catalogThumbPath, boardConfig.ThumbWidthCatalog); err != nil {
-			errEv.Err(err).Caller().
-				Str("thumbPath", thumbPath).
-				Int("thumbWidth", boardConfig.ThumbWidthCatalog).
-				Msg("Error creating video thumbnail for catalog")
-			server.ServeErrorPage(writer, "Error creating video thumbnail: "+err.Error())
-			return nil, true
+		if isCatalogUpload {
+			if err := createVideoThumbnail(filePath, catalogThumbPath, boardConfig.ThumbWidthCatalog); err != nil {
+				errEv.Err(err).Caller().
+					Str("thumbPath", thumbPath).
+					Int("thumbWidth", boardConfig.ThumbWidthCatalog).
+					Msg("Error creating video thumbnail for catalog")
+				server.ServeErrorPage(writer, "Error creating video thumbnail: "+err.Error())
+				return nil, true
+			}
 		}
 
 		outputBytes, err := exec.Command("ffprobe", "-v", "quiet", "-show_format", "-show_streams", filePath).CombinedOutput()
@@ -208,7 +293,7 @@ func AttachUploadFromRequest(request *http.Request, writer http.ResponseWriter,
 
 		gcutil.LogAccess(request).
 			Bool("withFile", true).
-			Str("filename", handler.Filename).
+			Str("filename", fileHeader.Filename).
 			Str("referer", request.Referer()).Send()
 
 		if request.FormValue("spoiler") == "on" {
@@ -231,7 +316,7 @@ func AttachUploadFromRequest(request *http.Request, writer http.ResponseWriter,
 		if shouldThumb {
 			var thumbnail image.Image
 			var catalogThumbnail image.Image
-			if post.ThreadID == 0 {
+			if isCatalogUpload {
 				// If this is a new thread, generate thumbnail and catalog thumbnail
 				thumbnail = createImageThumbnail(img, postBoard.Dir, "op")
 				catalogThumbnail = createImageThumbnail(img, postBoard.Dir, "catalog")
@@ -263,7 +348,7 @@ func AttachUploadFromRequest(request *http.Request, writer http.ResponseWriter,
 				server.ServeErrorPage(writer, "Couldn't create thumbnail: "+err.Error())
 				return nil, true
 			}
-			if post.ThreadID == 0 {
+			if isCatalogUpload {
 				// Generate catalog thumbnail
 				catalogThumbnail := createImageThumbnail(img, postBoard.Dir, "catalog")
 				if err = imaging.Save(catalogThumbnail, catalogThumbPath); err != nil {
@@ -317,10 +402,34 @@ func getVideoInfo(path string) (map[string]int, error) {
 	return vidInfo, err
 }
 
-func getNewFilename() string {
-	now := time.Now().Unix()
-	rand.Seed(now)
-	return strconv.Itoa(int(now)) + strconv.Itoa(rand.Intn(98)+1)
+// getNewFilename returns a name for an uploaded file (without the extension) made up of the current time and a
+// random number
+func getNewFilename() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d%06d", time.Now().Unix(), n.Int64()), nil
+}
+
+// createUploadFile creates a file with a new name in dir, failing instead of overwriting an existing file so that
+// files uploaded at the same time don't overwrite each other. It gives up after maxFilenameAttempts names are taken
+func createUploadFile(dir string, ext string) (*os.File, string, error) {
+	for attempt := 0; attempt < maxFilenameAttempts; attempt++ {
+		filename, err := getNewFilename()
+		if err != nil {
+			return nil, "", err
+		}
+		filename += ext
+		file, err := os.OpenFile(path.Join(dir, filename), os.O_WRONLY|os.O_CREATE|os.O_EXCL, config.GC_FILE_MODE)
+		if err == nil {
+			return file, filename, nil
+		}
+		if !os.IsExist(err) {
+			return nil, "", err
+		}
+	}
+	return nil, "", ErrNoAvailableFilename
 }
 
 func numImageFrames(imgPath string) (int, error) {
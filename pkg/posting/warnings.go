@@ -0,0 +1,97 @@
+package posting
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gochan-org/gochan/pkg/config"
+	"github.com/gochan-org/gochan/pkg/gcsql"
+	"github.com/gochan-org/gochan/pkg/gctemplates"
+	"github.com/gochan-org/gochan/pkg/gcutil"
+	"github.com/gochan-org/gochan/pkg/server"
+	"github.com/gochan-org/gochan/pkg/server/serverutil"
+	"github.com/rs/zerolog"
+)
+
+func showWarningPage(warning *gcsql.WarningListing, post *gcsql.Post, postBoard *gcsql.Board, writer http.ResponseWriter) {
+	warningPageBuffer := bytes.NewBufferString("")
+	err := serverutil.MinifyTemplate(gctemplates.WarningPage, map[string]interface{}{
+		"systemCritical": config.GetSystemCriticalConfig(),
+		"siteConfig":     config.GetSiteConfig(),
+		"boardConfig":    config.GetBoardConfig(postBoard.Dir),
+		"warning":        warning,
+		"board":          postBoard,
+	}, warningPageBuffer, "text/html")
+	if err != nil {
+		gcutil.LogError(err).
+			Str("IP", post.IP).
+			Str("building", "minifier").
+			Str("template", "warning.html").Send()
+		server.ServeErrorPage(writer, "Error minifying page: "+err.Error())
+		return
+	}
+	writer.Write(warningPageBuffer.Bytes())
+	gcutil.LogInfo().
+		Str("IP", post.IP).
+		Str("boardDir", postBoard.Dir).
+		Int("warningID", warning.ID).
+		Msg("Rejected post from IP with an unacknowledged warning")
+}
+
+// checkWarning shows the oldest warning given to the poster's IP that they haven't acknowledged yet. It returns
+// true if the warning page or an error page was served (causing MakePost() to return)
+func checkWarning(post *gcsql.Post, postBoard *gcsql.Board, writer http.ResponseWriter) bool {
+	warning, err := gcsql.GetUnacknowledgedWarning(post.IP)
+	if err != nil {
+		gcutil.LogError(err).
+			Str("IP", post.IP).
+			Str("boardDir", postBoard.Dir).
+			Msg("Error getting unacknowledged warnings")
+		server.ServeErrorPage(writer, "Error getting warning info: "+err.Error())
+		return true
+	}
+	if warning == nil {
+		return false
+	}
+	showWarningPage(warning, post, postBoard, writer)
+	return true
+}
+
+func handleWarningAcknowledgement(writer http.ResponseWriter, request *http.Request, errEv *zerolog.Event) {
+	warningIDstr := request.FormValue("warningid")
+	warningID, err := strconv.Atoi(warningIDstr)
+	if err != nil {
+		errEv.Err(err).
+			Str("warningIDstr", warningIDstr).Caller().Send()
+		server.ServeErrorPage(writer, fmt.Sprintf("Invalid warningid value %q", warningIDstr))
+		return
+	}
+	errEv.Int("warningID", warningID)
+	warning, err := gcsql.GetWarning(warningID)
+	if err != nil {
+		errEv.Err(err).Caller().Send()
+		server.ServeErrorPage(writer, "Error getting warning info: "+err.Error())
+		return
+	}
+	if warning.IP != gcutil.GetRealIP(request) {
+		errEv.Caller().
+			Str("warningIP", warning.IP).
+			Msg("User tried to acknowledge a warning given to a different IP")
+		server.ServeErrorPage(writer, fmt.Sprintf("Invalid warningid %d", warningID))
+		return
+	}
+	if !warning.IsAcknowledged {
+		if err = warning.Acknowledge(); err != nil {
+			errEv.Err(err).Caller().Msg("Unable to acknowledge warning")
+			server.ServeErrorPage(writer, "Unable to acknowledge warning")
+			return
+		}
+		gcutil.LogInfo().
+			Str("IP", warning.IP).
+			Int("warningID", warningID).
+			Msg("Warning acknowledged")
+	}
+	http.Redirect(writer, request, config.WebPath(request.FormValue("board")), http.StatusFound)
+}
@@ -1,7 +1,6 @@
 package serverutil
 
 import (
-	"html/template"
 	"io"
 
 	"github.com/gochan-org/gochan/pkg/config"
@@ -13,6 +12,11 @@ import (
 
 var minifier *minify.M
 
+// ExecutableTemplate is a parsed template, either a *template.Template or a template loaded by gctemplates
+type ExecutableTemplate interface {
+	Execute(writer io.Writer, data interface{}) error
+}
+
 // InitMinifier sets up the HTML/JS/JSON minifier if enabled in gochan.json
 func InitMinifier() {
 	siteConfig := config.GetSiteConfig()
@@ -41,7 +45,7 @@ func canMinify(mediaType string) bool {
 }
 
 // MinifyTemplate minifies the given template/data (if enabled) and returns any errors
-func MinifyTemplate(tmpl *template.Template, data interface{}, writer io.Writer, mediaType string) error {
+func MinifyTemplate(tmpl ExecutableTemplate, data interface{}, writer io.Writer, mediaType string) error {
 	if !canMinify(mediaType) {
 		return tmpl.Execute(writer, data)
 	}
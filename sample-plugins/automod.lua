@@ -0,0 +1,30 @@
+-- a simple demonstration of auto-moderation using the gochan module. The module's functions return nil and an
+-- error message if something goes wrong, and posts, boards, threads, reports, and bans are read-only tables.
+--   get_post(id[, include_deleted]), get_posts_from_ip(ip[, limit]), get_board(dir), get_board_from_id(id),
+--   get_thread(id), get_reports([include_cleared]), check_ip_ban(ip[, board_id])
+--   create_report(post_id, reason[, category_id])
+--   ban_ip({ip, staff, board, post, duration, permanent, appeal_wait, no_appeals, thread_ban, reason, staff_note})
+--   set_thread_attribute(thread_id, attribute, value, staff), build_threads(thread_id, ...),
+--   build_boards([board_id, ...]) (rebuilds are queued, and all boards are rebuilt if none are given)
+local gochan = require("gochan")
+
+local watched_words = {"buy cheap", "free followers"}
+
+event_register({"post-inserted"}, function(tr, post, board)
+	local message = string.lower(post.MessageRaw)
+	for _, word in ipairs(watched_words) do
+		if string.find(message, word, 1, true) ~= nil then
+			local _, err = gochan.create_report(post.ID, string.format("Automatically reported for %q", word))
+			if err ~= nil then
+				error_log(err):Msg("Unable to report post")
+			end
+			return
+		end
+	end
+
+	-- report IPs that have made a lot of recent posts
+	local posts, err = gochan.get_posts_from_ip(post.IP, 10)
+	if err == nil and #posts >= 10 and os.time() - posts[10].CreatedOn:Unix() < 60 then
+		gochan.create_report(post.ID, "Automatically reported for flooding")
+	end
+end)
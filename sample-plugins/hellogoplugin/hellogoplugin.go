@@ -0,0 +1,54 @@
+// Package hellogoplugin is a demonstration of a Go plugin. It is enabled by importing it in cmd/gochan:
+//
+//	import _ "github.com/gochan-org/gochan/sample-plugins/hellogoplugin"
+package hellogoplugin
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/gochan-org/gochan/pkg/events"
+	"github.com/gochan-org/gochan/pkg/gcplugin"
+	"github.com/gochan-org/gochan/pkg/gcsql"
+	"github.com/uptrace/bunrouter"
+)
+
+func init() {
+	gcplugin.RegisterPlugin(&helloPlugin{})
+}
+
+// helloPlugin counts the posts made since gochan started and shows the count at /plugin/hello/
+type helloPlugin struct {
+	posts int64
+}
+
+func (*helloPlugin) Name() string {
+	return "hello"
+}
+
+func (*helloPlugin) Init() error {
+	return nil
+}
+
+func (*helloPlugin) Close() error {
+	return nil
+}
+
+func (hp *helloPlugin) RegisterRoutes(group *bunrouter.Group) {
+	group.GET("/", bunrouter.HTTPHandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		fmt.Fprintf(writer, "Hello from a Go plugin! %d post(s) have been made since gochan started\n",
+			atomic.LoadInt64(&hp.posts))
+	}))
+}
+
+func (hp *helloPlugin) EventHandlers() map[string]events.EventHandler {
+	return map[string]events.EventHandler{
+		"post-inserted": func(trigger string, data ...interface{}) error {
+			if _, ok := data[0].(*gcsql.Post); ok {
+				atomic.AddInt64(&hp.posts, 1)
+			}
+			return nil
+		},
+	}
+}
@@ -1,4 +1,5 @@
--- testing manage page registering from Lua plugins
+-- testing manage page registering from Lua plugins. The optional last argument is the role capability
+-- needed to access the page
 local strings = require("strings")
 
 register_manage_page("mgmtplugintest",
@@ -7,7 +8,8 @@ register_manage_page("mgmtplugintest",
 	function(writer, request, staff, wantsJSON, infoEv, errEv)
 		out = string.format("Hello %s from Lua!<br/>'param' url parameter value: %q", staff.Username, request.FormValue(request,"param"))
 		return out, ""
-	end
+	end,
+	"plugin.mgmttest"
 )
 
 
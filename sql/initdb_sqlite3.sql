@@ -58,6 +58,8 @@ CREATE TABLE DBPREFIXthreads(
 	last_bump TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
 	deleted_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
 	is_deleted BOOL NOT NULL DEFAULT FALSE,
+	archived_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	is_archived BOOL NOT NULL DEFAULT FALSE,
 	CONSTRAINT threads_board_id_fk FOREIGN KEY(board_id) REFERENCES DBPREFIXboards(id) ON DELETE CASCADE
 );
 
@@ -68,7 +70,9 @@ CREATE TABLE DBPREFIXposts(
 	thread_id BIGINT NOT NULL,
 	is_top_post BOOL NOT NULL DEFAULT FALSE,
 	ip VARCHAR(45) NOT NULL,
+	ip_key VARCHAR(32) NOT NULL DEFAULT '',
 	created_on TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	last_modified TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
 	name VARCHAR(50) NOT NULL DEFAULT '',
 	tripcode VARCHAR(10) NOT NULL DEFAULT '',
 	is_role_signature BOOL NOT NULL DEFAULT FALSE,
@@ -84,6 +88,7 @@ CREATE TABLE DBPREFIXposts(
 );
 
 CREATE INDEX top_post_index ON DBPREFIXposts(is_top_post);
+CREATE INDEX posts_ip_key_index ON DBPREFIXposts(ip_key);
 
 CREATE TABLE DBPREFIXfiles(
 	id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
@@ -102,15 +107,51 @@ CREATE TABLE DBPREFIXfiles(
 	CONSTRAINT files_post_id_file_order_unique UNIQUE(post_id, file_order)
 );
 
+CREATE TABLE DBPREFIXpost_references(
+	post_id BIGINT NOT NULL,
+	referenced_post_id BIGINT NOT NULL,
+	CONSTRAINT post_references_post_id_fk FOREIGN KEY(post_id) REFERENCES DBPREFIXposts(id) ON DELETE CASCADE,
+	CONSTRAINT post_references_referenced_post_id_fk FOREIGN KEY(referenced_post_id) REFERENCES DBPREFIXposts(id) ON DELETE CASCADE,
+	CONSTRAINT post_references_unique UNIQUE(post_id, referenced_post_id)
+);
+
+CREATE INDEX post_references_referenced_index ON DBPREFIXpost_references(referenced_post_id);
+
+CREATE TABLE DBPREFIXstaff_roles(
+	id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+	name VARCHAR(45) NOT NULL,
+	require_totp BOOL NOT NULL DEFAULT FALSE,
+	CONSTRAINT staff_roles_name_unique UNIQUE(name)
+);
+
+CREATE TABLE DBPREFIXstaff_role_capabilities(
+	role_id BIGINT NOT NULL,
+	capability VARCHAR(45) NOT NULL,
+	CONSTRAINT staff_role_capabilities_role_id_fk FOREIGN KEY(role_id) REFERENCES DBPREFIXstaff_roles(id) ON DELETE CASCADE,
+	CONSTRAINT staff_role_capabilities_unique UNIQUE(role_id, capability)
+);
+
 CREATE TABLE DBPREFIXstaff(
 	id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
 	username VARCHAR(45) NOT NULL,
 	password_checksum VARCHAR(120) NOT NULL,
-	global_rank INT,
+	role_id BIGINT,
 	added_on TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
 	last_login TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
 	is_active BOOL NOT NULL DEFAULT TRUE,
-	CONSTRAINT staff_username_unique UNIQUE(username)
+	totp_secret VARCHAR(64) NOT NULL DEFAULT '',
+	totp_enabled BOOL NOT NULL DEFAULT FALSE,
+	totp_last_step BIGINT NOT NULL DEFAULT 0,
+	all_boards BOOL NOT NULL DEFAULT FALSE,
+	CONSTRAINT staff_username_unique UNIQUE(username),
+	CONSTRAINT staff_role_id_fk FOREIGN KEY(role_id) REFERENCES DBPREFIXstaff_roles(id)
+);
+
+CREATE TABLE DBPREFIXstaff_recovery_codes(
+	id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+	staff_id BIGINT NOT NULL,
+	code_checksum VARCHAR(120) NOT NULL,
+	CONSTRAINT staff_recovery_codes_staff_id_fk FOREIGN KEY(staff_id) REFERENCES DBPREFIXstaff(id) ON DELETE CASCADE
 );
 
 CREATE TABLE DBPREFIXsessions(
@@ -118,9 +159,40 @@ CREATE TABLE DBPREFIXsessions(
 	staff_id BIGINT NOT NULL,
 	expires TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
 	data VARCHAR(45) NOT NULL,
+	created_on TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	ip VARCHAR(45) NOT NULL DEFAULT '',
+	user_agent VARCHAR(255) NOT NULL DEFAULT '',
+	csrf_token VARCHAR(64) NOT NULL DEFAULT '',
 	CONSTRAINT sessions_staff_id_fk FOREIGN KEY(staff_id) REFERENCES DBPREFIXstaff(id) ON DELETE CASCADE
 );
 
+CREATE TABLE DBPREFIXlogin_attempts(
+	id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+	ip VARCHAR(45) NOT NULL,
+	username VARCHAR(45) NOT NULL,
+	attempted_on TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX login_attempts_ip_index ON DBPREFIXlogin_attempts(ip);
+CREATE INDEX login_attempts_username_index ON DBPREFIXlogin_attempts(username);
+
+CREATE TABLE DBPREFIXstaff_actions(
+	id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+	staff_id BIGINT,
+	staff_username VARCHAR(45) NOT NULL,
+	action VARCHAR(45) NOT NULL,
+	target_type VARCHAR(20) NOT NULL,
+	target_id BIGINT NOT NULL DEFAULT 0,
+	board_id BIGINT,
+	before_details TEXT NOT NULL,
+	after_details TEXT NOT NULL,
+	performed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	CONSTRAINT staff_actions_staff_id_fk FOREIGN KEY(staff_id) REFERENCES DBPREFIXstaff(id) ON DELETE SET NULL,
+	CONSTRAINT staff_actions_board_id_fk FOREIGN KEY(board_id) REFERENCES DBPREFIXboards(id) ON DELETE SET NULL
+);
+
+CREATE INDEX staff_actions_board_id_index ON DBPREFIXstaff_actions(board_id);
+
 CREATE TABLE DBPREFIXboard_staff(
 	board_id BIGINT NOT NULL,
 	staff_id BIGINT NOT NULL,
@@ -146,7 +218,9 @@ CREATE TABLE DBPREFIXip_ban(
 	copy_post_text TEXT NOT NULL,
 	is_thread_ban BOOL NOT NULL,
 	is_active BOOL NOT NULL,
-	ip VARCHAR(45) NOT NULL,
+	ip VARCHAR(90) NOT NULL,
+	range_start VARCHAR(32) NOT NULL DEFAULT '',
+	range_end VARCHAR(32) NOT NULL DEFAULT '',
 	issued_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
 	appeal_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
 	expires_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
@@ -159,6 +233,8 @@ CREATE TABLE DBPREFIXip_ban(
 	CONSTRAINT ip_ban_banned_for_post_id_fk FOREIGN KEY(banned_for_post_id) REFERENCES DBPREFIXposts(id) ON DELETE SET NULL
 );
 
+CREATE INDEX ip_ban_range_index ON DBPREFIXip_ban(range_start, range_end);
+
 CREATE TABLE DBPREFIXip_ban_audit(
 	ip_ban_id BIGINT NOT NULL,
 	timestamp TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
@@ -176,6 +252,21 @@ CREATE TABLE DBPREFIXip_ban_audit(
 	CONSTRAINT ip_ban_audit_staff_id_fk FOREIGN KEY(staff_id) REFERENCES DBPREFIXstaff(id)
 );
 
+CREATE TABLE DBPREFIXban_presets(
+	id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+	name VARCHAR(45) NOT NULL,
+	duration VARCHAR(45) NOT NULL DEFAULT '',
+	permanent BOOL NOT NULL DEFAULT FALSE,
+	message TEXT NOT NULL,
+	is_thread_ban BOOL NOT NULL DEFAULT FALSE,
+	can_appeal BOOL NOT NULL DEFAULT TRUE,
+	appeal_wait VARCHAR(45) NOT NULL DEFAULT '',
+	is_global BOOL NOT NULL DEFAULT FALSE,
+	escalate_to_id BIGINT,
+	CONSTRAINT ban_presets_name_unique UNIQUE(name),
+	CONSTRAINT ban_presets_escalate_to_id_fk FOREIGN KEY(escalate_to_id) REFERENCES DBPREFIXban_presets(id) ON DELETE SET NULL
+);
+
 CREATE TABLE DBPREFIXip_ban_appeals(
 	id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
 	staff_id BIGINT,
@@ -199,15 +290,26 @@ CREATE TABLE DBPREFIXip_ban_appeals_audit(
 	CONSTRAINT ip_ban_appeals_audit_appeal_id_fk FOREIGN KEY(appeal_id) REFERENCES DBPREFIXip_ban_appeals(id) ON DELETE CASCADE
 );
 
+CREATE TABLE DBPREFIXreport_categories(
+	id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+	board_id BIGINT,
+	name VARCHAR(45) NOT NULL,
+	description VARCHAR(255) NOT NULL DEFAULT '',
+	position SMALLINT NOT NULL DEFAULT 0,
+	CONSTRAINT report_categories_board_id_fk FOREIGN KEY(board_id) REFERENCES DBPREFIXboards(id) ON DELETE CASCADE
+);
+
 CREATE TABLE DBPREFIXreports(
 	id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL, 
 	handled_by_staff_id BIGINT,
 	post_id BIGINT NOT NULL,
+	category_id BIGINT,
 	ip VARCHAR(45) NOT NULL,
 	reason TEXT NOT NULL,
 	is_cleared BOOL NOT NULL,
 	CONSTRAINT reports_handled_by_staff_id_fk FOREIGN KEY(handled_by_staff_id) REFERENCES DBPREFIXstaff(id),
-	CONSTRAINT reports_post_id_fk FOREIGN KEY(post_id) REFERENCES DBPREFIXposts(id) ON DELETE CASCADE
+	CONSTRAINT reports_post_id_fk FOREIGN KEY(post_id) REFERENCES DBPREFIXposts(id) ON DELETE CASCADE,
+	CONSTRAINT reports_category_id_fk FOREIGN KEY(category_id) REFERENCES DBPREFIXreport_categories(id) ON DELETE SET NULL
 );
 
 CREATE TABLE DBPREFIXreports_audit(
@@ -254,6 +356,25 @@ CREATE TABLE DBPREFIXfile_ban(
 	CONSTRAINT file_ban_staff_id_fk FOREIGN KEY(staff_id) REFERENCES DBPREFIXstaff(id)
 );
 
+CREATE TABLE DBPREFIXwarnings(
+	id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+	staff_id BIGINT NOT NULL,
+	board_id BIGINT,
+	post_id BIGINT,
+	copy_post_text TEXT NOT NULL,
+	ip VARCHAR(45) NOT NULL,
+	message TEXT NOT NULL,
+	staff_note VARCHAR(255) NOT NULL,
+	issued_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	is_acknowledged BOOL NOT NULL DEFAULT FALSE,
+	acknowledged_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	CONSTRAINT warnings_staff_id_fk FOREIGN KEY(staff_id) REFERENCES DBPREFIXstaff(id),
+	CONSTRAINT warnings_board_id_fk FOREIGN KEY(board_id) REFERENCES DBPREFIXboards(id) ON DELETE CASCADE,
+	CONSTRAINT warnings_post_id_fk FOREIGN KEY(post_id) REFERENCES DBPREFIXposts(id) ON DELETE SET NULL
+);
+
+CREATE INDEX warnings_ip_index ON DBPREFIXwarnings(ip);
+
 CREATE TABLE DBPREFIXwordfilters(
 	id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
 	board_dirs VARCHAR(255) DEFAULT '*',
@@ -268,4 +389,4 @@ CREATE TABLE DBPREFIXwordfilters(
 );
 
 INSERT INTO DBPREFIXdatabase_version(component, version)
-VALUES('gochan', 2);
+VALUES('gochan', 15);